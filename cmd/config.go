@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/feed"
+	"github.com/gamis65/twitch-points/internal/logging"
+	"github.com/gamis65/twitch-points/internal/metrics"
+	"github.com/gamis65/twitch-points/internal/notify"
+	"github.com/gamis65/twitch-points/internal/telemetry"
+	"github.com/gamis65/twitch-points/internal/twitch"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/oauth2"
+)
+
+// Events every streamer is subscribed to
+var eventSubEvents = []string{
+	"stream.online",
+	"stream.offline",
+	"channel.update",
+	"channel.channel_points_custom_reward_redemption.add",
+	"channel.channel_points_custom_reward.update",
+	"channel.chat.message",
+}
+
+// runConfig handles `config`, printing the effective configuration with the secrets hidden
+func runConfig(cfg *config.Config, args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: config")
+		return 2
+	}
+
+	if err := cfg.WriteRedacted(os.Stdout); err != nil {
+		return 1
+	}
+
+	if err := cfg.Validate(config.SectionServer, config.SectionTwitch, config.SectionDatabase); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// validate reports the configuration errors for a command, returning false when it can't run
+func validate(cfg *config.Config, sections ...config.Section) bool {
+	if err := cfg.Validate(sections...); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return false
+	}
+	return true
+}
+
+// setupLogger installs the JSON logger as the default, commands that print results to stdout log to stderr
+func setupLogger(cfg *config.Config, w io.Writer) {
+	level := slog.LevelInfo
+	if cfg.IsDev() {
+		level = slog.LevelDebug
+	}
+
+	jsonHandler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		AddSource:   true,
+		ReplaceAttr: logging.Redact,
+	})
+
+	slog.SetDefault(slog.New(jsonHandler))
+}
+
+func connectDB(ctx context.Context, cfg *config.Config) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.Database.URL())
+	if err != nil {
+		return nil, fmt.Errorf("invalid database settings: %w", err)
+	}
+	poolConfig.ConnConfig.Tracer = multitracer.New(metrics.QueryTracer{}, telemetry.QueryTracer{})
+	if cfg.Database.MaxConns > 0 {
+		poolConfig.MaxConns = int32(cfg.Database.MaxConns)
+	}
+
+	conn, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the database: %w", err)
+	}
+	return conn, nil
+}
+
+// newTwitchClient creates the EventSub client, entries are published to entryFeed when it isn't nil
+func newTwitchClient(cfg *config.Config, twitchAPI *twitch.API, dbStore twitch.Store, entryFeed *feed.Broker) (*twitch.TwitchWebhookClient, error) {
+	return twitch.NewTwitchClient(cfg.Twitch, twitchAPI, dbStore, eventSubEvents, notify.New(cfg.DiscordWebhookURL), entryFeed)
+}
+
+// oauthEndpoint points the oauth2 library at TWITCH_AUTH_URL
+func oauthEndpoint(cfg config.Twitch) oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:   cfg.AuthURL + "/authorize",
+		TokenURL:  cfg.AuthURL + "/token",
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/export"
+)
+
+// runExport handles `export <entries|winners> [flags]` and returns the exit code
+func runExport(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	formatFlag := flags.String("format", "csv", "output format: csv, ndjson or parquet")
+	output := flags.String("o", "", "file to write to, stdout when empty")
+	giveawayID := flags.Int64("giveaway", 0, "only export this giveaway")
+	streamer := flags.String("streamer", "", "only export entries taken by, or draws run by, this streamer login")
+	from := flags.String("from", "", "only export rows from this date or RFC 3339 time")
+	to := flags.String("to", "", "only export rows before this date or RFC 3339 time")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: export <entries|winners> [flags]")
+		flags.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	dataset, err := export.ParseDataset(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	format, err := export.ParseFormat(*formatFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	filter := export.Filter{
+		GiveawayID: *giveawayID,
+		Streamer:   *streamer,
+	}
+
+	if *from != "" {
+		if filter.From, err = export.ParseDate(*from); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -from:", err)
+			return 2
+		}
+	}
+
+	if *to != "" {
+		if filter.To, err = export.ParseDate(*to); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -to:", err)
+			return 2
+		}
+	}
+
+	if !validate(cfg, config.SectionDatabase) {
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	conn, err := connectDB(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error creating output file:", err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	buffered := bufio.NewWriter(out)
+	count, err := export.NewExporter(conn).Export(ctx, buffered, dataset, format, filter)
+	if err == nil {
+		err = buffered.Flush()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export failed:", err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d %s\n", count, dataset)
+	return 0
+}
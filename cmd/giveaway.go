@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/giveaway"
+	"github.com/gamis65/twitch-points/internal/notify"
+	"github.com/gamis65/twitch-points/internal/twitch"
+)
+
+type drawOutput struct {
+	DrawID        int64             `json:"draw_id"`
+	GiveawayID    int64             `json:"giveaway_id"`
+	PrizeID       int64             `json:"prize_id"`
+	Prize         string            `json:"prize"`
+	TotalEntries  int64             `json:"total_entries"`
+	Winners       []drawnWinner     `json:"winners"`
+	Announcements []db.Announcement `json:"announcements,omitempty"`
+}
+
+type drawnWinner struct {
+	ID       int64  `json:"id"`
+	ViewerID string `json:"viewer_id"`
+	Username string `json:"username"`
+	Entries  int64  `json:"entries"`
+}
+
+// runGiveaway handles `giveaway draw -giveaway <id> -prize <id> [flags]` and returns the exit code
+func runGiveaway(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("giveaway", flag.ContinueOnError)
+	giveawayID := flags.Int64("giveaway", 0, "giveaway to draw from")
+	prizeID := flags.Int64("prize", 0, "prize to draw winners for")
+	count := flags.Int("count", 0, "number of winners, the remaining quantity of the prize when 0")
+	excludePrevious := flags.Bool("exclude-previous", false, "skip viewers who already won in this giveaway")
+	announce := flags.Bool("announce", false, "announce the winners in the chat of the participating streamers")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: giveaway draw -giveaway <id> -prize <id> [flags]")
+		flags.PrintDefaults()
+	}
+
+	if len(args) == 0 || args[0] != "draw" {
+		flags.Usage()
+		return 2
+	}
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if *giveawayID == 0 || *prizeID == 0 {
+		fmt.Fprintln(os.Stderr, "-giveaway and -prize are required")
+		return 2
+	}
+
+	if !validate(cfg, config.SectionTwitch, config.SectionDatabase) {
+		return 1
+	}
+
+	setupLogger(cfg, os.Stderr)
+
+	// The key was checked by validate
+	claimKey, _ := cfg.ClaimKey()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	conn, err := connectDB(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	service := giveaway.NewService(db.NewStore(conn), twitch.NewAPI(cfg.Twitch), claimKey, notify.New(cfg.DiscordWebhookURL))
+
+	result, err := service.Draw(ctx, giveaway.DrawParams{
+		GiveawayID:             *giveawayID,
+		PrizeID:                *prizeID,
+		Count:                  *count,
+		ExcludePreviousWinners: *excludePrevious,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "draw failed:", err)
+		return 1
+	}
+
+	output := drawOutput{
+		DrawID:       result.Draw.ID,
+		GiveawayID:   result.Draw.GiveawayID,
+		PrizeID:      result.Prize.ID,
+		Prize:        result.Prize.Name,
+		TotalEntries: result.Draw.TotalEntries,
+		Winners:      make([]drawnWinner, 0, len(result.Winners)),
+	}
+
+	for _, winner := range result.Winners {
+		output.Winners = append(output.Winners, drawnWinner{
+			ID:       winner.ID,
+			ViewerID: winner.ViewerID,
+			Username: winner.Username,
+			Entries:  winner.Entries,
+		})
+	}
+
+	if *announce {
+		output.Announcements = service.Announce(ctx, result, giveaway.AnnounceParams{})
+	}
+
+	printJSON(output)
+	return 0
+}
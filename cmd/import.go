@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/importer"
+)
+
+// runImport handles `import <file|-> -giveaway <id> [flags]` and returns the exit code
+func runImport(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	formatFlag := flags.String("format", "", "input format: csv or ndjson, guessed from the file extension when empty")
+	giveawayID := flags.Int64("giveaway", 0, "giveaway to add the entries to")
+	dryRun := flags.Bool("dry-run", false, "validate and report without writing anything")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: import <file|-> -giveaway <id> [flags]")
+		flags.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	path := args[0]
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if *giveawayID == 0 {
+		fmt.Fprintln(os.Stderr, "-giveaway is required")
+		return 2
+	}
+
+	formatName := *formatFlag
+	if formatName == "" {
+		formatName = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		if formatName == "jsonl" {
+			formatName = string(importer.FormatNDJSON)
+		}
+	}
+
+	format, err := importer.ParseFormat(formatName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error opening input file:", err)
+			return 1
+		}
+		defer file.Close()
+		in = file
+	}
+
+	if !validate(cfg, config.SectionDatabase) {
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	conn, err := connectDB(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	result, err := importer.New(db.NewStore(conn)).Import(ctx, in, format, importer.Options{
+		GiveawayID: *giveawayID,
+		DryRun:     *dryRun,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import failed:", err)
+		return 1
+	}
+
+	printJSON(result)
+
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -1,144 +1,75 @@
 package main
 
 import (
-	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"log/slog"
-	"net/http"
 	"os"
 
-	"github.com/gamis65/twitch-points/internal/api"
-	"github.com/gamis65/twitch-points/internal/db"
-	"github.com/gamis65/twitch-points/internal/twitch"
-	"github.com/gamis65/twitch-points/internal/util"
-	"github.com/gorilla/sessions"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/joho/godotenv"
-	"golang.org/x/oauth2"
-	twitchOauth "golang.org/x/oauth2/twitch"
+	"github.com/gamis65/twitch-points/internal/config"
 )
 
-func main() {
-	err := godotenv.Load()
-	if err != nil {
-		slog.Error("Error loading .env file")
-		os.Exit(1)
-	}
-
-	frontendURL := os.Getenv("FRONTEND_URL")
-	host := os.Getenv("HOST")
-	sessionKey := os.Getenv("SESSION_KEY")
-	backendDomainName := os.Getenv("BACKEND_DOMAIN_NAME")
-	cookieDomain := os.Getenv("COOKIE_DOMAIN")
-
-	// twitch
-	clientID := os.Getenv("TWITCH_CLIENT_ID")
-	clientSecret := os.Getenv("TWITCH_CLIENT_SECRET")
-	twitchWebhookSecret := os.Getenv("TWITCH_WEBHOOK_SECRET")
-	twitchWebhookURL := os.Getenv("TWITCH_WEBHOOK_URL")
-
-	// DB
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbName := os.Getenv("DB_NAME")
-	dbSSLMode := os.Getenv("DB_SSLMODE")
-	dbURL := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s", dbUser, dbPassword, dbHost, dbPort, dbName, dbSSLMode)
+const usage = `Usage: twitch-points [settings] <command> [arguments]
 
-	level := slog.LevelInfo
-	if util.IsDev() {
-		level = slog.LevelDebug
-	}
-
-	jsonHandler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level:     level,
-		AddSource: true,
-	})
-
-	logger := slog.New(jsonHandler)
-	slog.SetDefault(logger)
+Commands:
+  serve [-memory]           run the API server and background workers (default)
+  migrate <up|down|status>  manage the database schema
+  subscriptions reconcile   repair the EventSub subscriptions of every streamer
+  tokens refresh            refresh the Twitch tokens of every streamer
+  giveaway draw             draw winners for a prize
+  export                    export entries or winners
+  import                    import entries from a file
+  config                    print the effective configuration with secrets hidden
 
-	oauthConfig := &oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURL:  backendDomainName + "/auth/twitch/callback",
-		Scopes:       []string{"channel:read:redemptions", "channel:manage:redemptions"},
-		Endpoint:     twitchOauth.Endpoint,
-	}
+Settings come from the defaults, a config file, the environment and flags, in increasing priority.
+Run with -h to list the settings and a command with -h for its flags.`
 
-	ctx := context.Background()
-	conn, err := pgxpool.New(ctx, dbURL)
+func main() {
+	cfg, args, err := config.Load(os.Args[1:])
 	if err != nil {
-		slog.Error("Error connecting to the database", "error", err)
-		os.Exit(1)
-	}
-	defer conn.Close()
-
-	dbStore := db.NewStore(conn)
-	if dbStore == nil {
-		slog.Info("db url", "url", dbURL)
-		log.Fatal("dbStore is nil")
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	// Define the events to subscribe to
-	events := []string{
-		"stream.online",
-		"stream.offline",
-		"channel.update",
-		"channel.channel_points_custom_reward_redemption.add",
-		"channel.channel_points_custom_reward.update",
+	command := "serve"
+	if len(args) > 0 {
+		command = args[0]
+		args = args[1:]
 	}
 
-	// Initialize the Twitch webhook client
-	twitchWebhookClient, err := twitch.NewTwitchClient(
-		clientID,
-		clientSecret,
-		twitchWebhookSecret,
-		twitchWebhookURL,
-		dbStore,
-		events,
-	)
-
-	if err != nil {
-		slog.Error("Failed to create Twitch webhook client", "error", err)
-		os.Exit(1)
+	switch command {
+	case "serve":
+		os.Exit(runServe(cfg, args))
+	case "migrate":
+		os.Exit(runMigrate(cfg, args))
+	case "subscriptions":
+		os.Exit(runSubscriptions(cfg, args))
+	case "tokens":
+		os.Exit(runTokens(cfg, args))
+	case "giveaway":
+		os.Exit(runGiveaway(cfg, args))
+	case "export":
+		os.Exit(runExport(cfg, args))
+	case "import":
+		os.Exit(runImport(cfg, args))
+	case "config":
+		os.Exit(runConfig(cfg, args))
+	case "help":
+		fmt.Println(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", command, usage)
+		os.Exit(2)
 	}
+}
 
-	// Initialize the client and set up event handlers
-	go func() {
-		twitchWebhookClient.Initialize()
-	}()
-
-	sessionStore := sessions.NewCookieStore([]byte(sessionKey))
-	if util.IsDev() {
-		sessionStore.Options = &sessions.Options{
-			Path:     "/",
-			Domain:   "localhost",
-			HttpOnly: true,
-			Secure:   false,
-			SameSite: http.SameSiteLaxMode,
-		}
-	} else {
-		sessionStore.Options = &sessions.Options{
-			Path:     "/",
-			Domain:   cookieDomain,
-			HttpOnly: true,
-			Secure:   true,
-			SameSite: http.SameSiteNoneMode,
-		}
-	}
-
-	server := api.NewServer(&api.ServerConfig{
-		Host:          host,
-		FrontendURL:   frontendURL,
-		OAuthConfig:   oauthConfig,
-		SessionStore:  sessionStore,
-		DBStore:       dbStore,
-		TwitchWebhook: twitchWebhookClient,
-	})
-
-	slog.Info("Server listening", "host", host)
-	log.Fatal(server.Start())
+// printJSON writes a command's result to stdout
+func printJSON(v any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/migrate"
+	"github.com/gamis65/twitch-points/internal/sql/migrations"
+)
+
+// runMigrate handles `migrate <up|down [n]|status>` and returns the exit code
+func runMigrate(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: migrate <up|down [n]|status>")
+		fmt.Fprintln(flags.Output(), "  up       apply every pending migration")
+		fmt.Fprintln(flags.Output(), "  down n   roll back the last n migrations, 1 by default")
+		fmt.Fprintln(flags.Output(), "  status   list migrations and whether they were applied")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	if !validate(cfg, config.SectionDatabase) {
+		return 1
+	}
+
+	setupLogger(cfg, os.Stderr)
+
+	loaded, err := migrate.Load(migrations.FS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error loading migrations:", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	conn, err := connectDB(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	migrator := migrate.New(conn, loaded)
+
+	switch flags.Arg(0) {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migration failed:", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+
+	case "down":
+		steps := 1
+		if flags.NArg() > 1 {
+			steps, err = strconv.Atoi(flags.Arg(1))
+			if err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, "the number of migrations to roll back must be a positive integer")
+				return 2
+			}
+		}
+
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("rolled back %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "rollback failed:", err)
+			return 1
+		}
+
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error getting migration status:", err)
+			return 1
+		}
+
+		fmt.Printf("version %d", status.Version)
+		if status.Dirty {
+			fmt.Print(" (dirty)")
+		}
+		fmt.Println()
+
+		for _, migration := range status.Migrations {
+			state := "pending"
+			if migration.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%-8s %d_%s\n", state, migration.Version, migration.Name)
+		}
+
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	return 0
+}
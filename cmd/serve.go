@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/api"
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/db/memstore"
+	"github.com/gamis65/twitch-points/internal/export"
+	"github.com/gamis65/twitch-points/internal/feed"
+	"github.com/gamis65/twitch-points/internal/giveaway"
+	"github.com/gamis65/twitch-points/internal/importer"
+	"github.com/gamis65/twitch-points/internal/leader"
+	"github.com/gamis65/twitch-points/internal/lifecycle"
+	"github.com/gamis65/twitch-points/internal/metrics"
+	"github.com/gamis65/twitch-points/internal/notify"
+	"github.com/gamis65/twitch-points/internal/telemetry"
+	"github.com/gamis65/twitch-points/internal/twitch"
+	"github.com/gorilla/sessions"
+	"golang.org/x/oauth2"
+)
+
+// leaderInterval is how often a replica tries to take over the work of a leader that went away,
+// and how often the leader checks it still holds its lock
+const leaderInterval = 15 * time.Second
+
+// runServe handles `serve [-memory]`, starting the API server and the background workers
+func runServe(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	memory := flags.Bool("memory", false, "keep the data in memory instead of the database, for demos, it's gone when the server stops")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: serve [-memory]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return 2
+	}
+
+	sections := []config.Section{config.SectionServer, config.SectionTwitch}
+	if !*memory {
+		sections = append(sections, config.SectionDatabase)
+	}
+	if !validate(cfg, sections...) {
+		return 1
+	}
+
+	setupLogger(cfg, os.Stdout)
+	slog.Info("Loaded configuration", "config", cfg)
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Error("Error setting up tracing", "error", err)
+		return 1
+	}
+
+	oauthConfig := &oauth2.Config{
+		ClientID:     cfg.Twitch.ClientID,
+		ClientSecret: cfg.Twitch.ClientSecret,
+		RedirectURL:  cfg.Server.BackendDomainName + "/auth/twitch/callback",
+		Scopes:       []string{"channel:read:redemptions", "channel:manage:redemptions", "user:read:chat", "user:bot", "channel:bot", "user:write:chat", "user:manage:whispers"},
+		Endpoint:     oauthEndpoint(cfg.Twitch),
+	}
+
+	// Viewers only sign in to prove they won, so no scopes are requested
+	viewerOAuthConfig := &oauth2.Config{
+		ClientID:     cfg.Twitch.ClientID,
+		ClientSecret: cfg.Twitch.ClientSecret,
+		RedirectURL:  cfg.Server.BackendDomainName + "/auth/viewer/twitch/callback",
+		Scopes:       []string{},
+		Endpoint:     oauthEndpoint(cfg.Twitch),
+	}
+
+	// The key was checked by validate
+	claimKey, _ := cfg.ClaimKey()
+	if claimKey == nil {
+		slog.Warn("CLAIM_ENCRYPTION_KEY is not set, winners won't be able to claim prizes")
+	}
+
+	lc := lifecycle.New(cfg.Server.DrainPeriod, cfg.Server.ShutdownTimeout)
+
+	var dbStore db.Store
+	// Exports stream straight from the database, there are none in memory
+	var exporter *export.Exporter
+	if *memory {
+		slog.Warn("Keeping the data in memory, it will be lost when the server stops")
+		dbStore, err = newMemoryStore(context.Background())
+		if err != nil {
+			slog.Error("Error setting up the memory store", "error", err)
+			return 1
+		}
+	} else {
+		conn, err := connectDB(context.Background(), cfg)
+		if err != nil {
+			slog.Error("Error connecting to the database", "error", err)
+			return 1
+		}
+		defer conn.Close()
+
+		dbStore = db.NewStore(conn)
+		exporter = export.NewExporter(conn)
+	}
+
+	notifier := notify.New(cfg.DiscordWebhookURL)
+	entryFeed := feed.NewBroker()
+	twitchAPI := twitch.NewAPI(cfg.Twitch)
+
+	if err := metrics.RegisterEntryTotals(dbStore); err != nil {
+		slog.Error("Failed to register the entry metrics", "error", err)
+		return 1
+	}
+
+	// Initialize the Twitch webhook client
+	twitchWebhookClient, err := newTwitchClient(cfg, twitchAPI, dbStore, entryFeed)
+	if err != nil {
+		slog.Error("Failed to create Twitch webhook client", "error", err)
+		return 1
+	}
+
+	// Every replica handles the webhook notifications Twitch sends it, but only the leader refreshes the tokens,
+	// manages the subscriptions and holds the WebSocket session. Another replica takes over when it goes away.
+	lc.Go("twitch", func(ctx context.Context) error {
+		return leader.Run(ctx, dbStore, "eventsub", leaderInterval, twitchWebhookClient.Run)
+	})
+
+	// Pause the rewards while the giveaway doesn't take entries and resume them when it does
+	lc.Go("reward-schedule", func(ctx context.Context) error {
+		return leader.Run(ctx, dbStore, "reward-schedule", leaderInterval, func(ctx context.Context) {
+			twitchWebhookClient.RunRewardSchedule(ctx, time.Minute)
+		})
+	})
+
+	// Entries reach the live feed clients of every replica
+	lc.Go("entry-feed", func(ctx context.Context) error {
+		return entryFeed.Relay(ctx, dbStore)
+	})
+
+	sessionStore := sessions.NewCookieStore([]byte(cfg.Server.SessionKey))
+	if cfg.IsDev() {
+		sessionStore.Options = &sessions.Options{
+			Path:     "/",
+			Domain:   "localhost",
+			HttpOnly: true,
+			Secure:   false,
+			SameSite: http.SameSiteLaxMode,
+		}
+	} else {
+		sessionStore.Options = &sessions.Options{
+			Path:     "/",
+			Domain:   cfg.Server.CookieDomain,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteNoneMode,
+		}
+	}
+
+	giveawayService := giveaway.NewService(dbStore, twitchAPI, claimKey, notifier)
+
+	// Forfeit winners who missed their claim deadline and draw replacements
+	lc.Go("claim-expiry", func(ctx context.Context) error {
+		return leader.Run(ctx, dbStore, "claim-expiry", leaderInterval, func(ctx context.Context) {
+			giveawayService.RunClaimExpiry(ctx, time.Minute)
+		})
+	})
+
+	// Open and close recurring giveaways, on one replica at a time
+	lc.Go("giveaway-schedules", func(ctx context.Context) error {
+		return leader.Run(ctx, dbStore, "giveaway-schedules", leaderInterval, func(ctx context.Context) {
+			giveawayService.RunSchedules(ctx, time.Minute, twitchWebhookClient.WakeRewardSchedule)
+		})
+	})
+
+	server := api.NewServer(&api.ServerConfig{
+		Config:            cfg.Server,
+		OAuthConfig:       oauthConfig,
+		ViewerOAuthConfig: viewerOAuthConfig,
+		SessionStore:      sessionStore,
+		Store:             dbStore,
+		TwitchWebhook:     twitchWebhookClient,
+		TwitchAPI:         twitchAPI,
+		Giveaway:          giveawayService,
+		Exporter:          exporter,
+		Importer:          importer.New(dbStore),
+		Lifecycle:         lc,
+		Feed:              entryFeed,
+	})
+
+	lc.Go("http", func(ctx context.Context) error {
+		slog.Info("Server listening", "host", cfg.Server.Host)
+		return server.Start()
+	})
+
+	// In-flight requests finish before the event handlers they started are waited for
+	lc.OnShutdown("http", server.Shutdown)
+	lc.OnShutdown("eventsub-handlers", twitchWebhookClient.Shutdown)
+	lc.OnShutdown("announcements", giveawayService.Shutdown)
+	// Last, so the spans of everything above are flushed
+	lc.OnShutdown("tracing", shutdownTracing)
+
+	if err := lc.Wait(); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// newMemoryStore returns an empty memory store with the open giveaway the migrations create
+func newMemoryStore(ctx context.Context) (*memstore.Store, error) {
+	store := memstore.New()
+	if _, err := store.CreateGiveaway(ctx, db.CreateGiveawayParams{Name: "Giveaway", Status: giveaway.StatusOpen}); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/twitch"
+)
+
+// runSubscriptions handles `subscriptions reconcile [-dry-run]` and returns the exit code
+func runSubscriptions(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("subscriptions", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report the changes without making them")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: subscriptions reconcile [flags]")
+		fmt.Fprintln(flags.Output(), "With TWITCH_EVENTSUB_CONDUIT_SHARDS set it moves the per-streamer subscriptions to the conduit.")
+		flags.PrintDefaults()
+	}
+
+	if len(args) == 0 || args[0] != "reconcile" {
+		flags.Usage()
+		return 2
+	}
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if !validate(cfg, config.SectionTwitch, config.SectionDatabase) {
+		return 1
+	}
+
+	setupLogger(cfg, os.Stderr)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	conn, err := connectDB(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	twitchClient, err := newTwitchClient(cfg, twitch.NewAPI(cfg.Twitch), db.NewStore(conn), nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error creating the Twitch client:", err)
+		return 1
+	}
+
+	result, err := twitchClient.ReconcileSubscriptions(ctx, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reconcile failed:", err)
+		return 1
+	}
+
+	printJSON(result)
+
+	if result.Errors > 0 {
+		return 1
+	}
+	return 0
+}
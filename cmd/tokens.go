@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/twitch"
+)
+
+// runTokens handles `tokens refresh` and returns the exit code
+func runTokens(cfg *config.Config, args []string) int {
+	if len(args) != 1 || args[0] != "refresh" {
+		fmt.Fprintln(os.Stderr, "Usage: tokens refresh")
+		return 2
+	}
+
+	if !validate(cfg, config.SectionTwitch, config.SectionDatabase) {
+		return 1
+	}
+
+	setupLogger(cfg, os.Stderr)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	conn, err := connectDB(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	twitchClient, err := newTwitchClient(cfg, twitch.NewAPI(cfg.Twitch), db.NewStore(conn), nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error creating the Twitch client:", err)
+		return 1
+	}
+
+	// Failures for single streamers are logged, only a database error stops the refresh
+	streamers, err := twitchClient.RefreshStreamerTokens(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error refreshing tokens:", err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "refreshed tokens of %d streamers, failures are logged above\n", len(streamers))
+	return 0
+}
@@ -8,6 +8,8 @@ import { SWRProvider } from "@/providers/SWRProvider";
 import AddRewardPage from "@/pages/AddReward.tsx";
 import { Layout } from "@/components/dashboard/layout.tsx";
 import WheelPage from "@/pages/Wheel.tsx";
+import ClaimPage from "@/pages/Claim.tsx";
+import ViewerPage from "@/pages/Viewer.tsx";
 
 createRoot(document.getElementById("root")!).render(
   <StrictMode>
@@ -19,6 +21,8 @@ createRoot(document.getElementById("root")!).render(
             <Route path="/sign-in" element={<SignInPage />} />
             <Route path="/AddReward" element={<AddRewardPage />} />
             <Route path="/wheel" element={<WheelPage />} />
+            <Route path="/claim" element={<ClaimPage />} />
+            <Route path="/viewer" element={<ViewerPage />} />
           </Route>
         </Routes>
       </BrowserRouter>
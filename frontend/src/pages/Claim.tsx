@@ -0,0 +1,221 @@
+import { useCallback, useEffect, useState } from "react";
+import { Link } from "react-router";
+import { Gift, TwitchIcon } from "lucide-react";
+import { Button } from "@/components/ui/button";
+import { Badge } from "@/components/ui/badge";
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+import { Card, CardContent, CardHeader, CardTitle } from "@/components/ui/card";
+
+type Win = {
+  id: number;
+  giveaway_id: number;
+  giveaway_name: string;
+  prize_name: string | null;
+  status: string;
+  claim_deadline: string | null;
+  claimed_at: string | null;
+  created_at: string;
+};
+
+type ClaimForm = {
+  name: string;
+  email: string;
+  address: string;
+  notes: string;
+};
+
+const emptyForm: ClaimForm = { name: "", email: "", address: "", notes: "" };
+
+function isExpired(win: Win) {
+  return (
+    win.claim_deadline !== null && new Date(win.claim_deadline) < new Date()
+  );
+}
+
+function ClaimCard({ win, onClaimed }: { win: Win; onClaimed: () => void }) {
+  const baseUrl = import.meta.env.VITE_BACKEND_URL || "";
+  const [form, setForm] = useState<ClaimForm>(emptyForm);
+  const [error, setError] = useState<string | null>(null);
+  const [submitting, setSubmitting] = useState(false);
+
+  const canClaim = win.status === "pending_claim" && !isExpired(win);
+
+  const update = (field: keyof ClaimForm) => (value: string) =>
+    setForm((current) => ({ ...current, [field]: value }));
+
+  async function handleSubmit(e: React.FormEvent) {
+    e.preventDefault();
+    setSubmitting(true);
+    setError(null);
+
+    try {
+      const response = await fetch(baseUrl + "/claims/" + win.id, {
+        method: "POST",
+        credentials: "include",
+        mode: "cors",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(form),
+      });
+
+      if (!response.ok) {
+        throw new Error(await response.text());
+      }
+
+      setForm(emptyForm);
+      onClaimed();
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "Failed to claim prize");
+    } finally {
+      setSubmitting(false);
+    }
+  }
+
+  return (
+    <Card>
+      <CardHeader>
+        <CardTitle className="flex items-center justify-between gap-2">
+          <span className="flex items-center gap-2">
+            <Gift className="h-5 w-5 text-purple-500" />
+            {win.prize_name ?? "Prize"} - {win.giveaway_name}
+          </span>
+          <Badge variant={win.status === "claimed" ? "default" : "secondary"}>
+            {win.status.replace("_", " ")}
+          </Badge>
+        </CardTitle>
+      </CardHeader>
+
+      <CardContent className="space-y-4">
+        {win.claim_deadline && win.status === "pending_claim" && (
+          <p className="text-sm text-muted-foreground">
+            {isExpired(win) ? "The deadline passed on " : "Claim before "}
+            {new Date(win.claim_deadline).toLocaleString()}
+          </p>
+        )}
+
+        {win.claimed_at && (
+          <p className="text-sm text-muted-foreground">
+            Claimed on {new Date(win.claimed_at).toLocaleString()}
+          </p>
+        )}
+
+        {canClaim && (
+          <form className="space-y-4" onSubmit={handleSubmit}>
+            <div className="space-y-2">
+              <Label htmlFor={`name-${win.id}`}>Full name</Label>
+              <Input
+                id={`name-${win.id}`}
+                required
+                value={form.name}
+                onChange={(e) => update("name")(e.target.value)}
+              />
+            </div>
+            <div className="space-y-2">
+              <Label htmlFor={`email-${win.id}`}>Email</Label>
+              <Input
+                id={`email-${win.id}`}
+                type="email"
+                required
+                value={form.email}
+                onChange={(e) => update("email")(e.target.value)}
+              />
+            </div>
+            <div className="space-y-2">
+              <Label htmlFor={`address-${win.id}`}>Delivery address</Label>
+              <Input
+                id={`address-${win.id}`}
+                required
+                value={form.address}
+                onChange={(e) => update("address")(e.target.value)}
+              />
+            </div>
+            <div className="space-y-2">
+              <Label htmlFor={`notes-${win.id}`}>Notes</Label>
+              <Input
+                id={`notes-${win.id}`}
+                value={form.notes}
+                onChange={(e) => update("notes")(e.target.value)}
+              />
+            </div>
+
+            {error && <p className="text-sm text-red-500">{error}</p>}
+
+            <Button
+              className="w-full bg-purple-600 hover:bg-purple-700"
+              disabled={submitting}
+              type="submit"
+            >
+              Claim prize
+            </Button>
+          </form>
+        )}
+      </CardContent>
+    </Card>
+  );
+}
+
+export default function ClaimPage() {
+  const baseUrl = import.meta.env.VITE_BACKEND_URL || "";
+  const [wins, setWins] = useState<Win[] | null>(null);
+  const [signedIn, setSignedIn] = useState(true);
+
+  const loadWins = useCallback(async () => {
+    const response = await fetch(baseUrl + "/claims", {
+      credentials: "include",
+      mode: "cors",
+    });
+
+    if (response.status === 401) {
+      setSignedIn(false);
+      return;
+    }
+
+    if (response.ok) {
+      setWins(await response.json());
+    }
+  }, [baseUrl]);
+
+  useEffect(() => {
+    loadWins();
+  }, [loadWins]);
+
+  return (
+    <div className="flex min-h-screen flex-col bg-background">
+      <main className="flex-1">
+        <div className="container mx-auto max-w-3xl py-8 space-y-6">
+          <h1 className="text-2xl font-bold">Claim your prize</h1>
+
+          {!signedIn && (
+            <Card className="text-center">
+              <CardContent className="space-y-4 pt-6">
+                <p>
+                  Sign in with the Twitch account that won so we know the prize
+                  is yours. We don't get access to anything on your account.
+                </p>
+                <Link to={baseUrl + "/auth/viewer/twitch"}>
+                  <Button
+                    className="bg-purple-600 hover:bg-purple-700"
+                    size="lg"
+                  >
+                    <TwitchIcon className="mr-2 h-5 w-5" />
+                    Sign in with Twitch
+                  </Button>
+                </Link>
+              </CardContent>
+            </Card>
+          )}
+
+          {signedIn && wins !== null && wins.length === 0 && (
+            <p className="text-muted-foreground">
+              You haven't won anything yet. Good luck in the next giveaway!
+            </p>
+          )}
+
+          {wins?.map((win) => (
+            <ClaimCard key={win.id} win={win} onClaimed={loadWins} />
+          ))}
+        </div>
+      </main>
+    </div>
+  );
+}
@@ -0,0 +1,209 @@
+import { useEffect, useState } from "react";
+import { Link } from "react-router";
+import { Search, TwitchIcon } from "lucide-react";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+import { Card, CardContent, CardHeader, CardTitle } from "@/components/ui/card";
+import {
+  Table,
+  TableBody,
+  TableCell,
+  TableHead,
+  TableHeader,
+  TableRow,
+} from "@/components/ui/table";
+
+type ViewerStats = {
+  login: string;
+  giveaway_id?: number;
+  giveaway_name?: string;
+  entries: number;
+  total_entries: number;
+  total_participants: number;
+  win_probability: number;
+  streamers: { streamer_id: string; streamer_username: string; entries: number }[];
+  history: {
+    redeemed_at: string;
+    entry_method: string;
+    giveaway_id: number;
+    giveaway_name: string;
+    streamer_username: string;
+  }[];
+};
+
+function StatsCard({ stats }: { stats: ViewerStats }) {
+  return (
+    <Card>
+      <CardHeader>
+        <CardTitle>
+          {stats.login}
+          {stats.giveaway_name && ` in ${stats.giveaway_name}`}
+        </CardTitle>
+      </CardHeader>
+      <CardContent className="space-y-6">
+        <div className="grid grid-cols-3 gap-4 text-center">
+          <div>
+            <p className="text-2xl font-bold">{stats.entries}</p>
+            <p className="text-sm text-muted-foreground">Your entries</p>
+          </div>
+          <div>
+            <p className="text-2xl font-bold">{stats.total_entries}</p>
+            <p className="text-sm text-muted-foreground">Total entries</p>
+          </div>
+          <div>
+            <p className="text-2xl font-bold">
+              {(stats.win_probability * 100).toFixed(2)}%
+            </p>
+            <p className="text-sm text-muted-foreground">Chance per draw</p>
+          </div>
+        </div>
+
+        {stats.streamers.length > 0 && (
+          <Table>
+            <TableHeader>
+              <TableRow>
+                <TableHead>Streamer</TableHead>
+                <TableHead className="text-right">Entries</TableHead>
+              </TableRow>
+            </TableHeader>
+            <TableBody>
+              {stats.streamers.map((streamer) => (
+                <TableRow key={streamer.streamer_id}>
+                  <TableCell>{streamer.streamer_username}</TableCell>
+                  <TableCell className="text-right">{streamer.entries}</TableCell>
+                </TableRow>
+              ))}
+            </TableBody>
+          </Table>
+        )}
+
+        {stats.history.length > 0 && (
+          <Table>
+            <TableHeader>
+              <TableRow>
+                <TableHead>When</TableHead>
+                <TableHead>Streamer</TableHead>
+                <TableHead>Giveaway</TableHead>
+                <TableHead>Method</TableHead>
+              </TableRow>
+            </TableHeader>
+            <TableBody>
+              {stats.history.map((entry, index) => (
+                <TableRow key={index}>
+                  <TableCell>
+                    {new Date(entry.redeemed_at).toLocaleString()}
+                  </TableCell>
+                  <TableCell>{entry.streamer_username}</TableCell>
+                  <TableCell>{entry.giveaway_name}</TableCell>
+                  <TableCell>{entry.entry_method.replace("_", " ")}</TableCell>
+                </TableRow>
+              ))}
+            </TableBody>
+          </Table>
+        )}
+      </CardContent>
+    </Card>
+  );
+}
+
+export default function ViewerPage() {
+  const baseUrl = import.meta.env.VITE_BACKEND_URL || "";
+  const [login, setLogin] = useState("");
+  const [stats, setStats] = useState<ViewerStats | null>(null);
+  const [signedIn, setSignedIn] = useState(false);
+  const [error, setError] = useState<string | null>(null);
+
+  useEffect(() => {
+    fetch(baseUrl + "/viewer", { credentials: "include", mode: "cors" })
+      .then(async (response) => {
+        if (response.ok) {
+          setSignedIn(true);
+          setStats(await response.json());
+        }
+      })
+      .catch(console.error);
+  }, [baseUrl]);
+
+  async function lookup(e: React.FormEvent) {
+    e.preventDefault();
+    setError(null);
+
+    const response = await fetch(
+      baseUrl + "/giveaway/viewers/" + encodeURIComponent(login.trim()),
+    );
+
+    if (response.status === 404) {
+      setStats(null);
+      setError("No entries found for " + login);
+      return;
+    }
+
+    if (response.ok) {
+      setStats(await response.json());
+    }
+  }
+
+  async function optOut() {
+    if (
+      !confirm(
+        "This deletes all your entries and wins and you won't be able to enter again. Continue?",
+      )
+    ) {
+      return;
+    }
+
+    const response = await fetch(baseUrl + "/viewer/opt-out", {
+      method: "POST",
+      credentials: "include",
+      mode: "cors",
+    });
+
+    if (response.ok) {
+      setSignedIn(false);
+      setStats(null);
+      alert("Your data was deleted.");
+    } else {
+      alert("Failed to delete your data: " + (await response.text()));
+    }
+  }
+
+  return (
+    <div className="flex min-h-screen flex-col bg-background">
+      <main className="flex-1">
+        <div className="container mx-auto max-w-3xl py-8 space-y-6">
+          <h1 className="text-2xl font-bold">My entries</h1>
+
+          <form className="flex gap-2" onSubmit={lookup}>
+            <Input
+              placeholder="Twitch username"
+              value={login}
+              onChange={(e) => setLogin(e.target.value)}
+            />
+            <Button type="submit" variant="outline">
+              <Search className="h-4 w-4" />
+            </Button>
+          </form>
+
+          {!signedIn && (
+            <Link to={baseUrl + "/auth/viewer/twitch"}>
+              <Button className="bg-purple-600 hover:bg-purple-700">
+                <TwitchIcon className="mr-2 h-5 w-5" />
+                Sign in with Twitch
+              </Button>
+            </Link>
+          )}
+
+          {error && <p className="text-sm text-red-500">{error}</p>}
+
+          {stats && <StatsCard stats={stats} />}
+
+          {signedIn && (
+            <Button variant="destructive" onClick={optOut}>
+              Delete my data and stop my entries
+            </Button>
+          )}
+        </div>
+      </main>
+    </div>
+  );
+}
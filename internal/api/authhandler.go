@@ -4,16 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/logging"
 	"github.com/gamis65/twitch-points/internal/util"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"golang.org/x/oauth2"
+
+	eventSub "github.com/gamis65/twitch-points/internal/twitch"
 )
 
 type AuthResponse struct {
@@ -57,34 +59,25 @@ func (s *Server) callbackHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	code := r.URL.Query().Get("code")
-	token, err := s.oauthConfig.Exchange(context.Background(), code)
+	token, err := s.oauthConfig.Exchange(s.oauthContext(r), code)
 	if err != nil {
-		slog.Error("Failed to exchange token", "error", err)
+		s.log(r).Error("Failed to exchange token", "error", err)
 		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
 		return
 	}
 
-	userData, err := s.getUserData(token.AccessToken)
+	userData, err := s.getUserData(r.Context(), token.AccessToken)
 	if err != nil {
-		slog.Error("Error getting user data", "error", err)
+		s.log(r).Error("Error getting user data", "error", err)
 		http.Error(w, "Error getting user data", http.StatusInternalServerError)
 		return
 	}
 
-	logger := s.logger.With(
+	logger := s.log(r).With(
 		slog.String("user_id", userData.ID),
 		slog.String("username", userData.Login),
 	)
 
-	if !util.IsDev() {
-		// Sign in should be only available to channels with channel points
-		if userData.BroadcasterType == "" {
-			logger.Info("A user who is not an affiliate or a partner tried to sign in")
-			http.Error(w, "You must be an affiliate to log in", http.StatusUnauthorized)
-			return
-		}
-	}
-
 	session.Values["access_token"] = token.AccessToken
 	session.Values["refresh_token"] = token.RefreshToken
 	session.Values["expiry"] = token.Expiry.Unix()
@@ -109,6 +102,7 @@ func (s *Server) callbackHandler(w http.ResponseWriter, r *http.Request) {
 			ProfileImageUrl: pgtype.Text{String: userData.ProfileImageURL, Valid: true},
 			Verified:        pgtype.Bool{Bool: false, Valid: true},
 			IsLive:          pgtype.Bool{Bool: userData.IsLive, Valid: true},
+			BroadcasterType: pgtype.Text{String: userData.BroadcasterType, Valid: true},
 		})
 
 		if err != nil {
@@ -117,9 +111,24 @@ func (s *Server) callbackHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		s.twitchWebhook.SubscribeToEvents([]db.Streamer{newUser})
+		// Channels without channel points can only take entries through chat
+		if userData.BroadcasterType == "" {
+			_, err := s.db.UpsertChatCommand(r.Context(), db.UpsertChatCommandParams{
+				StreamerID:          newUser.TwitchID,
+				Command:             defaultChatCommand,
+				Enabled:             true,
+				CooldownSeconds:     defaultChatCommandCooldown,
+				MaxEntriesPerViewer: defaultChatCommandMaxEntries,
+			})
 
-		logger.Info("Created a new user")
+			if err != nil {
+				logger.Error("Error enabling the chat command for a new user", "error", err)
+			}
+		}
+
+		s.twitchWebhook.SubscribeToEvents(r.Context(), []db.Streamer{newUser})
+
+		logger.Info("Created a new user", "broadcaster_type", userData.BroadcasterType)
 	} else {
 		_, err := s.db.UpdateStreamerTokens(r.Context(), db.UpdateStreamerTokensParams{
 			TwitchID:     userData.ID,
@@ -131,7 +140,21 @@ func (s *Server) callbackHandler(w http.ResponseWriter, r *http.Request) {
 			logger.Error("Error updating user tokens", "error", err)
 		}
 
-		logger.Info("User logged in")
+		// Channels get channel points when they become affiliates, the channel point events need a subscription then
+		hadChannelPoints := eventSub.HasChannelPoints(existingUser)
+		existingUser.BroadcasterType = pgtype.Text{String: userData.BroadcasterType, Valid: true}
+
+		err = s.db.SetStreamerBroadcasterType(r.Context(), db.SetStreamerBroadcasterTypeParams{
+			TwitchID:        userData.ID,
+			BroadcasterType: existingUser.BroadcasterType,
+		})
+		if err != nil {
+			logger.Error("Error updating the broadcaster type", "error", err)
+		} else if !hadChannelPoints && eventSub.HasChannelPoints(existingUser) {
+			s.twitchWebhook.SubscribeToEvents(r.Context(), []db.Streamer{existingUser})
+		}
+
+		logger.Info("User logged in", "broadcaster_type", userData.BroadcasterType)
 	}
 
 	session.Save(r, w)
@@ -143,6 +166,9 @@ func (s *Server) callbackHandler(w http.ResponseWriter, r *http.Request) {
 func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
 	session, _ := s.sessionStore.Get(r, "twitch-oauth-session")
 
+	if accessToken, ok := session.Values["access_token"].(string); ok {
+		s.tokens.Forget(accessToken)
+	}
 	session.Options.MaxAge = -1
 
 	session.Save(r, w)
@@ -158,7 +184,7 @@ func (s *Server) refreshAccessToken(r *http.Request, w http.ResponseWriter) (*oa
 		return nil, fmt.Errorf("no refresh token available")
 	}
 
-	tokenSource := s.oauthConfig.TokenSource(context.Background(), &oauth2.Token{
+	tokenSource := s.oauthConfig.TokenSource(s.oauthContext(r), &oauth2.Token{
 		RefreshToken: refreshToken,
 	})
 
@@ -170,15 +196,18 @@ func (s *Server) refreshAccessToken(r *http.Request, w http.ResponseWriter) (*oa
 	session.Values["access_token"] = newToken.AccessToken
 	session.Values["refresh_token"] = newToken.RefreshToken
 	session.Values["expiry"] = newToken.Expiry.Unix()
+	s.tokens.Remember(newToken.AccessToken)
 
 	err = session.Save(r, w)
 	if err != nil {
-		fmt.Printf("Error saving session: %v", err)
+		s.log(r).Error("Error saving session", "error", err)
 	}
 
 	return newToken, nil
 }
 
+// authMiddleware signs the streamer in from the session cookie. The access token is checked with Twitch at most
+// once an hour and refreshed when it expired, then the streamer and the token are stored in the request context.
 func (s *Server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		session, _ := s.sessionStore.Get(r, "twitch-oauth-session")
@@ -188,49 +217,94 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 		userId, userIdOk := session.Values["user_id"].(string)
 
 		if !ok || !expiryOk || !userIdOk || accessToken == "" {
-			slog.Error("Failed to authenticate user", "accessTokenOk", ok, "expiry", expiry, "expiryOk", expiryOk, "userId", userId, "userIdOk", userIdOk)
+			s.log(r).Error("Failed to authenticate user", "accessTokenOk", ok, "expiry", expiry, "expiryOk", expiryOk, "userId", userId, "userIdOk", userIdOk)
 			http.Redirect(w, r, s.frontendURL, http.StatusSeeOther)
 			return
 		}
 
-		// Check if the token is valid with Twitch API
-		tokenValidity, err := isTokenValid(accessToken)
-		if err != nil {
-			fmt.Println(err)
+		// Everything logged further down the request belongs to this user
+		r = r.WithContext(logging.With(r.Context(), slog.String("user_id", userId)))
+
+		tokenValidity := false
+		if time.Now().Unix() <= expiry {
+			var err error
+			tokenValidity, err = s.tokens.Valid(r.Context(), accessToken)
+			if err != nil {
+				s.log(r).Error("Error validating the access token", "error", err)
+			}
 		}
 
-		if time.Now().Unix() > expiry || !tokenValidity {
-			_, err := s.refreshAccessToken(r, w)
+		if !tokenValidity {
+			newToken, err := s.refreshAccessToken(r, w)
 			if err != nil {
+				s.log(r).Warn("Error refreshing the access token", "error", err)
 				http.Redirect(w, r, s.frontendURL, http.StatusSeeOther)
 				return
 			}
+			accessToken = newToken.AccessToken
 		}
 
-		next.ServeHTTP(w, r)
+		streamer, err := s.db.GetStreamerByID(r.Context(), userId)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			s.log(r).Error("Error getting streamer", "error", err)
+			http.Error(w, "Error getting streamer", http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), requestAuthKey{}, requestAuth{Streamer: streamer, AccessToken: accessToken})
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func isTokenValid(token string) (bool, error) {
-	client := http.Client{}
+// oauthContext makes the oauth2 library send its requests through the Twitch API client
+func (s *Server) oauthContext(r *http.Request) context.Context {
+	return context.WithValue(r.Context(), oauth2.HTTPClient, s.twitchAPI.HTTPClient())
+}
 
-	req, err := http.NewRequest("GET", "https://id.twitch.tv/oauth2/validate", nil)
-	if err != nil {
-		return false, fmt.Errorf("couldn't make a request: %w", err)
-	}
+type requestAuthKey struct{}
 
-	req.Header.Set("Authorization", "Bearer "+token)
+// requestAuth is the streamer authMiddleware signed in
+type requestAuth struct {
+	Streamer    db.Streamer
+	AccessToken string // from the session, Twitch accepted it within the last hour
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return false, fmt.Errorf("request failed: %w", err)
+// getRequestAuth returns the streamer signed in on this request, it has to run behind authMiddleware
+func getRequestAuth(r *http.Request) (requestAuth, error) {
+	auth, ok := r.Context().Value(requestAuthKey{}).(requestAuth)
+	if !ok {
+		return requestAuth{}, errors.New("no streamer is signed in on this request")
 	}
-	defer resp.Body.Close()
+	return auth, nil
+}
 
-	_, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return false, fmt.Errorf("failed to read response body: %w", err)
-	}
+// getStreamerID returns the Twitch ID of the streamer signed in on this request
+func getStreamerID(r *http.Request) (string, error) {
+	auth, err := getRequestAuth(r)
+	return auth.Streamer.TwitchID, err
+}
+
+// verifiedMiddleware only lets verified streamers through, it has to run after authMiddleware
+func (s *Server) verifiedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, err := getRequestAuth(r)
+		if err != nil {
+			s.log(r).Error("Failed to get the signed in streamer", "error", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !auth.Streamer.Verified.Bool {
+			s.log(r).Warn("A streamer who is not verified tried to manage giveaways")
+			http.Error(w, "Only verified streamers can manage giveaways", http.StatusForbidden)
+			return
+		}
 
-	return resp.StatusCode == http.StatusOK, nil
+		next.ServeHTTP(w, r)
+	})
 }
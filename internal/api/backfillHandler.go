@@ -0,0 +1,35 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gamis65/twitch-points/internal/util"
+
+	eventSub "github.com/gamis65/twitch-points/internal/twitch"
+)
+
+// backfillHandler adds redemptions that are in the signed in streamer's Twitch queues but never reached us
+func (s *Server) backfillHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getStreamerID(r)
+	if err != nil {
+		s.log(r).Error("Failed to get the signed in streamer", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := s.twitchWebhook.Backfill(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, eventSub.ErrBackfillRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		s.log(r).Error("Error backfilling redemptions", "error", err)
+		http.Error(w, "Error backfilling redemptions", http.StatusInternalServerError)
+		return
+	}
+
+	s.log(r).Info("Backfill requested", "added", result.Added)
+	util.SendJSON(w, result)
+}
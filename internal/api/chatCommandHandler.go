@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/util"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultChatCommand           = "!enter"
+	defaultChatCommandCooldown   = 30
+	defaultChatCommandMaxEntries = 1
+)
+
+type ChatCommandRequest struct {
+	Command             string `json:"command"`
+	Enabled             bool   `json:"enabled"`
+	CooldownSeconds     int32  `json:"cooldown_seconds"`
+	MaxEntriesPerViewer int32  `json:"max_entries_per_viewer"`
+}
+
+type ChatCommandResponse struct {
+	Command             string `json:"command"`
+	Enabled             bool   `json:"enabled"`
+	CooldownSeconds     int32  `json:"cooldown_seconds"`
+	MaxEntriesPerViewer int32  `json:"max_entries_per_viewer"`
+}
+
+func (req ChatCommandRequest) validate() error {
+	if !strings.HasPrefix(req.Command, "!") || len(req.Command) < 2 || len(req.Command) > 25 {
+		return errors.New("command must start with ! and be between 2 and 25 characters long")
+	}
+
+	if strings.ContainsAny(req.Command, " \t\n") {
+		return errors.New("command must be a single word")
+	}
+
+	if req.CooldownSeconds < 0 || req.CooldownSeconds > 3600 {
+		return errors.New("cooldown_seconds must be between 0 and 3600")
+	}
+
+	if req.MaxEntriesPerViewer < 0 {
+		return errors.New("max_entries_per_viewer can't be negative, use 0 for no limit")
+	}
+
+	return nil
+}
+
+func (s *Server) getChatCommandHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getStreamerID(r)
+	if err != nil {
+		s.log(r).Error("Failed to get the signed in streamer", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chatCommand, err := s.db.GetChatCommandByStreamer(r.Context(), userID)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			s.log(r).Error("Error getting chat command", "error", err)
+			http.Error(w, "Error getting chat command", http.StatusInternalServerError)
+			return
+		}
+
+		// Nothing configured yet, show the defaults the streamer would start with
+		util.SendJSON(w, &ChatCommandResponse{
+			Command:             defaultChatCommand,
+			Enabled:             false,
+			CooldownSeconds:     defaultChatCommandCooldown,
+			MaxEntriesPerViewer: defaultChatCommandMaxEntries,
+		})
+		return
+	}
+
+	util.SendJSON(w, &ChatCommandResponse{
+		Command:             chatCommand.Command,
+		Enabled:             chatCommand.Enabled,
+		CooldownSeconds:     chatCommand.CooldownSeconds,
+		MaxEntriesPerViewer: chatCommand.MaxEntriesPerViewer,
+	})
+}
+
+func (s *Server) updateChatCommandHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getStreamerID(r)
+	if err != nil {
+		s.log(r).Error("Failed to get the signed in streamer", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	logger := s.log(r)
+
+	var req ChatCommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Command = strings.ToLower(strings.TrimSpace(req.Command))
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	chatCommand, err := s.db.UpsertChatCommand(r.Context(), db.UpsertChatCommandParams{
+		StreamerID:          userID,
+		Command:             req.Command,
+		Enabled:             req.Enabled,
+		CooldownSeconds:     req.CooldownSeconds,
+		MaxEntriesPerViewer: req.MaxEntriesPerViewer,
+	})
+
+	if err != nil {
+		logger.Error("Error saving chat command", "error", err)
+		http.Error(w, "Error saving chat command", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("Chat command updated", "command", chatCommand.Command, "enabled", chatCommand.Enabled)
+
+	util.SendJSON(w, &ChatCommandResponse{
+		Command:             chatCommand.Command,
+		Enabled:             chatCommand.Enabled,
+		CooldownSeconds:     chatCommand.CooldownSeconds,
+		MaxEntriesPerViewer: chatCommand.MaxEntriesPerViewer,
+	})
+}
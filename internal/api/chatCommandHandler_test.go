@@ -0,0 +1,35 @@
+package api
+
+import "testing"
+
+func TestChatCommandRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ChatCommandRequest
+		wantErr bool
+	}{
+		{"defaults", ChatCommandRequest{Command: "!enter", CooldownSeconds: 30, MaxEntriesPerViewer: 1}, false},
+		{"no limits", ChatCommandRequest{Command: "!enter", CooldownSeconds: 0, MaxEntriesPerViewer: 0}, false},
+		{"longest command", ChatCommandRequest{Command: "!" + "abcdefghijklmnopqrstuvwx", CooldownSeconds: 3600}, false},
+		{"no prefix", ChatCommandRequest{Command: "enter"}, true},
+		{"only the prefix", ChatCommandRequest{Command: "!"}, true},
+		{"too long", ChatCommandRequest{Command: "!" + "abcdefghijklmnopqrstuvwxy"}, true},
+		{"several words", ChatCommandRequest{Command: "!enter now"}, true},
+		{"tab", ChatCommandRequest{Command: "!enter\tnow"}, true},
+		{"negative cooldown", ChatCommandRequest{Command: "!enter", CooldownSeconds: -1}, true},
+		{"cooldown over an hour", ChatCommandRequest{Command: "!enter", CooldownSeconds: 3601}, true},
+		{"negative max entries", ChatCommandRequest{Command: "!enter", MaxEntriesPerViewer: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.validate()
+			if tt.wantErr && err == nil {
+				t.Fatalf("%+v was accepted", tt.req)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
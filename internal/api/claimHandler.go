@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gamis65/twitch-points/internal/giveaway"
+	"github.com/gamis65/twitch-points/internal/util"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type ClaimResponse struct {
+	ID        int64              `json:"id"`
+	Status    string             `json:"status"`
+	ClaimedAt pgtype.Timestamptz `json:"claimed_at"`
+}
+
+// writeClaimError maps claim errors from the giveaway service to a response
+func (s *Server) writeClaimError(w http.ResponseWriter, logger *slog.Logger, err error, message string) {
+	switch {
+	case errors.Is(err, giveaway.ErrClaimExpired), errors.Is(err, giveaway.ErrWinnerAlreadyClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, giveaway.ErrClaimNotSubmitted):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, giveaway.ErrClaimsDisabled):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		s.writeGiveawayError(w, logger, err, message)
+	}
+}
+
+// getViewerClaimsHandler lists every prize the signed in viewer won, claimed or not
+func (s *Server) getViewerClaimsHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, _ := s.getSessionViewerID(r)
+
+	winners, err := s.db.GetWinnersByViewer(r.Context(), viewerID)
+	if err != nil {
+		s.log(r).Error("Error getting viewer wins", "error", err, "viewer_id", viewerID)
+		http.Error(w, "Error getting wins", http.StatusInternalServerError)
+		return
+	}
+
+	util.SendJSON(w, winners)
+}
+
+func (s *Server) claimHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, _ := s.getSessionViewerID(r)
+
+	winnerID, err := urlParamInt64(r, "winnerID")
+	if err != nil {
+		http.Error(w, "Invalid winner ID", http.StatusBadRequest)
+		return
+	}
+
+	logger := s.log(r).With(
+		slog.String("viewer_id", viewerID),
+		slog.Int64("winner_id", winnerID),
+	)
+
+	var details giveaway.ClaimDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	winner, err := s.giveaway.Claim(r.Context(), winnerID, viewerID, details)
+	if err != nil {
+		if errors.Is(err, giveaway.ErrInvalidClaim) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.writeClaimError(w, logger, err, "Error claiming prize")
+		return
+	}
+
+	util.SendJSON(w, &ClaimResponse{
+		ID:        winner.ID,
+		Status:    winner.Status,
+		ClaimedAt: winner.ClaimedAt,
+	})
+}
+
+// getClaimDetailsHandler shows the decrypted delivery details of a winner to the streamer
+func (s *Server) getClaimDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	giveawayID, err := urlParamInt64(r, "giveawayID")
+	if err != nil {
+		http.Error(w, "Invalid giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	winnerID, err := urlParamInt64(r, "winnerID")
+	if err != nil {
+		http.Error(w, "Invalid winner ID", http.StatusBadRequest)
+		return
+	}
+
+	logger := s.log(r).With(
+		slog.Int64("giveaway_id", giveawayID),
+		slog.Int64("winner_id", winnerID),
+	)
+
+	details, err := s.giveaway.GetClaimDetails(r.Context(), giveawayID, winnerID)
+	if err != nil {
+		s.writeClaimError(w, logger, err, "Error getting claim details")
+		return
+	}
+
+	// Reading personal data is logged so access can be audited
+	logger.Info("Streamer viewed claim details")
+
+	util.SendJSON(w, details)
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gamis65/twitch-points/internal/giveaway"
+	"github.com/gamis65/twitch-points/internal/util"
+)
+
+type DrawRequest struct {
+	PrizeID                int64  `json:"prize_id"`
+	Count                  int    `json:"count"`
+	ExcludePreviousWinners bool   `json:"exclude_previous_winners"`
+	Announce               bool   `json:"announce"`
+	ChatMessage            string `json:"chat_message"`
+	Whisper                bool   `json:"whisper"`
+	WhisperMessage         string `json:"whisper_message"`
+}
+
+type RedrawRequest struct {
+	Announce       bool   `json:"announce"`
+	ChatMessage    string `json:"chat_message"`
+	Whisper        bool   `json:"whisper"`
+	WhisperMessage string `json:"whisper_message"`
+}
+
+type WinnerStatusRequest struct {
+	Status string `json:"status"`
+}
+
+type DrawnWinnerResponse struct {
+	ID       int64  `json:"id"`
+	ViewerID string `json:"viewer_id"`
+	Username string `json:"username"`
+	Entries  int64  `json:"entries"`
+	Status   string `json:"status"`
+}
+
+type DrawResponse struct {
+	DrawID       int64                 `json:"draw_id"`
+	GiveawayID   int64                 `json:"giveaway_id"`
+	PrizeID      int64                 `json:"prize_id"`
+	Prize        string                `json:"prize"`
+	TotalEntries int64                 `json:"total_entries"`
+	Winners      []DrawnWinnerResponse `json:"winners"`
+	Announcing   bool                  `json:"announcing"` // the winners are being announced in the background
+}
+
+func newDrawResponse(result *giveaway.DrawResult, announcing bool) *DrawResponse {
+	winners := make([]DrawnWinnerResponse, 0, len(result.Winners))
+	for _, winner := range result.Winners {
+		winners = append(winners, DrawnWinnerResponse{
+			ID:       winner.ID,
+			ViewerID: winner.ViewerID,
+			Username: winner.Username,
+			Entries:  winner.Entries,
+			Status:   winner.Status,
+		})
+	}
+
+	return &DrawResponse{
+		DrawID:       result.Draw.ID,
+		GiveawayID:   result.Draw.GiveawayID,
+		PrizeID:      result.Prize.ID,
+		Prize:        result.Prize.Name,
+		TotalEntries: result.Draw.TotalEntries,
+		Winners:      winners,
+		Announcing:   announcing,
+	}
+}
+
+// writeGiveawayError maps errors from the giveaway service to a response
+func (s *Server) writeGiveawayError(w http.ResponseWriter, logger *slog.Logger, err error, message string) {
+	switch {
+	case errors.Is(err, giveaway.ErrPrizeNotFound), errors.Is(err, giveaway.ErrWinnerNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, giveaway.ErrNoEntries),
+		errors.Is(err, giveaway.ErrPrizeAwarded),
+		errors.Is(err, giveaway.ErrWinnerNotRedrawable),
+		errors.Is(err, giveaway.ErrWinnerStatusConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, giveaway.ErrInvalidWinnerStatus):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		logger.Error(message, "error", err)
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) drawHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getStreamerID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	giveawayID, err := urlParamInt64(r, "giveawayID")
+	if err != nil {
+		http.Error(w, "Invalid giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	logger := s.log(r).With(
+		slog.Int64("giveaway_id", giveawayID),
+	)
+
+	var req DrawRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Count < 0 {
+		http.Error(w, "count can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.giveaway.Draw(r.Context(), giveaway.DrawParams{
+		GiveawayID:             giveawayID,
+		PrizeID:                req.PrizeID,
+		Count:                  req.Count,
+		ExcludePreviousWinners: req.ExcludePreviousWinners,
+		DrawnBy:                userID,
+	})
+
+	if err != nil {
+		s.writeGiveawayError(w, logger, err, "Error drawing winners")
+		return
+	}
+
+	// Sending to every channel can take a while, the winners are drawn whatever happens to the announcement
+	if req.Announce {
+		s.giveaway.AnnounceInBackground(r.Context(), result, giveaway.AnnounceParams{
+			ChatTemplate:    req.ChatMessage,
+			Whisper:         req.Whisper,
+			WhisperTemplate: req.WhisperMessage,
+			WhisperFrom:     userID,
+		})
+	}
+
+	util.SendJSON(w, newDrawResponse(result, req.Announce))
+}
+
+func (s *Server) redrawHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getStreamerID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	giveawayID, err := urlParamInt64(r, "giveawayID")
+	if err != nil {
+		http.Error(w, "Invalid giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	winnerID, err := urlParamInt64(r, "winnerID")
+	if err != nil {
+		http.Error(w, "Invalid winner ID", http.StatusBadRequest)
+		return
+	}
+
+	logger := s.log(r).With(
+		slog.Int64("giveaway_id", giveawayID),
+		slog.Int64("winner_id", winnerID),
+	)
+
+	var req RedrawRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.giveaway.Redraw(r.Context(), giveawayID, winnerID, userID)
+	if err != nil {
+		s.writeGiveawayError(w, logger, err, "Error redrawing winner")
+		return
+	}
+
+	logger.Info("Redrew a winner", "new_winner_id", result.Winners[0].ID)
+
+	if req.Announce {
+		s.giveaway.AnnounceInBackground(r.Context(), result, giveaway.AnnounceParams{
+			ChatTemplate:    req.ChatMessage,
+			Whisper:         req.Whisper,
+			WhisperTemplate: req.WhisperMessage,
+			WhisperFrom:     userID,
+		})
+	}
+
+	util.SendJSON(w, newDrawResponse(result, req.Announce))
+}
+
+func (s *Server) getWinnersHandler(w http.ResponseWriter, r *http.Request) {
+	giveawayID, err := urlParamInt64(r, "giveawayID")
+	if err != nil {
+		http.Error(w, "Invalid giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	winners, err := s.db.GetWinnersByGiveaway(r.Context(), giveawayID)
+	if err != nil {
+		s.log(r).Error("Error getting winners", "error", err, "giveaway_id", giveawayID)
+		http.Error(w, "Error getting winners", http.StatusInternalServerError)
+		return
+	}
+
+	util.SendJSON(w, winners)
+}
+
+func (s *Server) setWinnerStatusHandler(w http.ResponseWriter, r *http.Request) {
+	giveawayID, err := urlParamInt64(r, "giveawayID")
+	if err != nil {
+		http.Error(w, "Invalid giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	winnerID, err := urlParamInt64(r, "winnerID")
+	if err != nil {
+		http.Error(w, "Invalid winner ID", http.StatusBadRequest)
+		return
+	}
+
+	logger := s.log(r).With(
+		slog.Int64("giveaway_id", giveawayID),
+		slog.Int64("winner_id", winnerID),
+	)
+
+	var req WinnerStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	winner, err := s.giveaway.UpdateWinnerStatus(r.Context(), giveawayID, winnerID, req.Status)
+	if err != nil {
+		s.writeGiveawayError(w, logger, err, "Error updating winner status")
+		return
+	}
+
+	logger.Info("Winner status updated", "status", winner.Status)
+
+	// Delivery details are only readable through the claim endpoint
+	winner.ClaimDetails = nil
+	util.SendJSON(w, winner)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/export"
+	"github.com/go-chi/chi/v5"
+)
+
+// parseExportFilter reads the giveaway_id, streamer, from and to query parameters
+func parseExportFilter(r *http.Request) (export.Filter, error) {
+	query := r.URL.Query()
+	filter := export.Filter{
+		Streamer: query.Get("streamer"),
+	}
+
+	if giveawayID := query.Get("giveaway_id"); giveawayID != "" {
+		id, err := strconv.ParseInt(giveawayID, 10, 64)
+		if err != nil {
+			return export.Filter{}, fmt.Errorf("invalid giveaway_id")
+		}
+		filter.GiveawayID = id
+	}
+
+	if from := query.Get("from"); from != "" {
+		t, err := export.ParseDate(from)
+		if err != nil {
+			return export.Filter{}, fmt.Errorf("invalid from: %w", err)
+		}
+		filter.From = t
+	}
+
+	if to := query.Get("to"); to != "" {
+		t, err := export.ParseDate(to)
+		if err != nil {
+			return export.Filter{}, fmt.Errorf("invalid to: %w", err)
+		}
+		filter.To = t
+	}
+
+	return filter, nil
+}
+
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	// Running on the memory store
+	if s.exporter == nil {
+		http.Error(w, "Exports need the database", http.StatusNotImplemented)
+		return
+	}
+
+	dataset, err := export.ParseDataset(chi.URLParam(r, "dataset"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	format, err := export.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseExportFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger := s.log(r).With(
+		slog.String("dataset", string(dataset)),
+		slog.String("format", string(format)),
+	)
+
+	filename := fmt.Sprintf("%s-%s.%s", dataset, time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Large exports take longer than the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// Rows are written as they are read, so once the first one is out the status can't change anymore
+	count, err := s.exporter.Export(r.Context(), w, dataset, format, filter)
+	if err != nil {
+		logger.Error("Export failed", "error", err, "rows", count)
+		if count == 0 {
+			http.Error(w, "Export failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.Info("Exported data", "rows", count)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/metrics"
+)
+
+const feedHeartbeatInterval = 30 * time.Second
+
+// entriesFeedHandler streams new entries as server-sent events, optionally only those of one giveaway
+func (s *Server) entriesFeedHandler(w http.ResponseWriter, r *http.Request) {
+	var giveawayID int64
+	if param := r.URL.Query().Get("giveaway_id"); param != "" {
+		id, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid giveaway_id", http.StatusBadRequest)
+			return
+		}
+		giveawayID = id
+	}
+
+	// The stream stays open far longer than the server's write timeout
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	entries, unsubscribe := s.feed.Subscribe()
+	defer unsubscribe()
+
+	metrics.SSEClients.Inc()
+	defer metrics.SSEClients.Dec()
+
+	fmt.Fprint(w, ": connected\n\n")
+	if err := controller.Flush(); err != nil {
+		s.log(r).Error("Streaming is not supported by the response writer", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(feedHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case entry := <-entries:
+			if giveawayID != 0 && entry.GiveawayID != giveawayID {
+				continue
+			}
+
+			data, err := json.Marshal(entry)
+			if err != nil {
+				s.log(r).Error("Error encoding feed entry", "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: entry\ndata: %s\n\n", data)
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
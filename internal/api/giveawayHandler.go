@@ -1,13 +1,20 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
-	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/giveaway"
 	"github.com/gamis65/twitch-points/internal/util"
+	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type TotalParticipantsResponse struct {
@@ -22,7 +29,7 @@ func (s *Server) GetStreamersHandler(w http.ResponseWriter, r *http.Request) {
 	streamers, err := s.db.GetAllStreamers(r.Context())
 	if err != nil {
 		if !errors.Is(err, pgx.ErrNoRows) {
-			slog.Error("Error getting streamers", "error", err)
+			s.log(r).Error("Error getting streamers", "error", err)
 			http.Error(w, "Error getting streamers", http.StatusInternalServerError)
 			return
 		}
@@ -41,7 +48,7 @@ func (s *Server) GetRecentEntriesHandler(w http.ResponseWriter, r *http.Request)
 	recentEntries, err := s.db.GetRecentRedemptionsWithUsernames(r.Context(), 10)
 	if err != nil {
 		if !errors.Is(err, pgx.ErrNoRows) {
-			slog.Error("Error getting recent redemptions", "error", err)
+			s.log(r).Error("Error getting recent redemptions", "error", err)
 			http.Error(w, "Error getting recent redemptions", http.StatusInternalServerError)
 			return
 		}
@@ -60,7 +67,7 @@ func (s *Server) GetTotalParticipantsHandler(w http.ResponseWriter, r *http.Requ
 	totalParticipants, err := s.db.GetTotalParticipantsCount(r.Context())
 	if err != nil {
 		if !errors.Is(err, pgx.ErrNoRows) {
-			slog.Error("Error getting total participants count", "error", err)
+			s.log(r).Error("Error getting total participants count", "error", err)
 			http.Error(w, "Error getting total participants count", http.StatusInternalServerError)
 			return
 		}
@@ -77,7 +84,7 @@ func (s *Server) GetTotalEntriesHandler(w http.ResponseWriter, r *http.Request)
 	totalEntries, err := s.db.GetTotalRedemptionsCount(r.Context())
 	if err != nil {
 		if !errors.Is(err, pgx.ErrNoRows) {
-			slog.Error("Error getting total entries count", "error", err)
+			s.log(r).Error("Error getting total entries count", "error", err)
 			http.Error(w, "Error getting total entries count", http.StatusInternalServerError)
 			return
 		}
@@ -94,7 +101,7 @@ func (s *Server) GetLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
 	leaderboard, err := s.db.GetViewerLeaderboard(r.Context())
 	if err != nil {
 		if !errors.Is(err, pgx.ErrNoRows) {
-			slog.Error("Error getting leaderboard data", "error", err)
+			s.log(r).Error("Error getting leaderboard data", "error", err)
 			http.Error(w, "Error getting leaderboard data", http.StatusInternalServerError)
 			return
 		}
@@ -108,3 +115,180 @@ func (s *Server) GetLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
 
 	util.SendJSON(w, leaderboard)
 }
+
+// Postgres error codes that are turned into client errors
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+type CreateGiveawayRequest struct {
+	Name string `json:"name"`
+	GiveawayScheduleRequest
+}
+
+// GiveawayScheduleRequest is when the rewards can be redeemed for the giveaway, either end can be left out
+type GiveawayScheduleRequest struct {
+	OpensAt  *time.Time `json:"opens_at"`
+	ClosesAt *time.Time `json:"closes_at"`
+}
+
+func (req GiveawayScheduleRequest) validate() error {
+	if req.OpensAt != nil && req.ClosesAt != nil && !req.ClosesAt.After(*req.OpensAt) {
+		return errors.New("closes_at must be after opens_at")
+	}
+	return nil
+}
+
+func optionalTimestamp(t *time.Time) pgtype.Timestamptz {
+	if t == nil {
+		return pgtype.Timestamptz{}
+	}
+	return pgtype.Timestamptz{Time: *t, Valid: true}
+}
+
+type GiveawayStatusRequest struct {
+	Status string `json:"status"`
+}
+
+func urlParamInt64(r *http.Request, name string) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, name), 10, 64)
+}
+
+func (s *Server) getGiveawaysHandler(w http.ResponseWriter, r *http.Request) {
+	giveaways, err := s.db.GetGiveaways(r.Context())
+	if err != nil {
+		s.log(r).Error("Error getting giveaways", "error", err)
+		http.Error(w, "Error getting giveaways", http.StatusInternalServerError)
+		return
+	}
+
+	util.SendJSON(w, giveaways)
+}
+
+func (s *Server) createGiveawayHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getStreamerID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateGiveawayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.GiveawayScheduleRequest.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	newGiveaway, err := s.db.CreateGiveaway(r.Context(), db.CreateGiveawayParams{
+		Name:      req.Name,
+		Status:    giveaway.StatusDraft,
+		CreatedBy: pgtype.Text{String: userID, Valid: true},
+		OpensAt:   optionalTimestamp(req.OpensAt),
+		ClosesAt:  optionalTimestamp(req.ClosesAt),
+	})
+
+	if err != nil {
+		s.log(r).Error("Error creating giveaway", "error", err)
+		http.Error(w, "Error creating giveaway", http.StatusInternalServerError)
+		return
+	}
+
+	s.log(r).Info("Giveaway created", "giveaway_id", newGiveaway.ID)
+	util.SendJSON(w, newGiveaway)
+}
+
+func (s *Server) setGiveawayStatusHandler(w http.ResponseWriter, r *http.Request) {
+	giveawayID, err := urlParamInt64(r, "giveawayID")
+	if err != nil {
+		http.Error(w, "Invalid giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	var req GiveawayStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Status != giveaway.StatusOpen && req.Status != giveaway.StatusClosed {
+		http.Error(w, "status must be open or closed", http.StatusBadRequest)
+		return
+	}
+
+	updatedGiveaway, err := s.db.SetGiveawayStatus(r.Context(), db.SetGiveawayStatusParams{
+		ID:     giveawayID,
+		Status: req.Status,
+	})
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		http.Error(w, "Another giveaway is already open", http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Giveaway not found", http.StatusNotFound)
+			return
+		}
+
+		s.log(r).Error("Error updating giveaway status", "error", err, "giveaway_id", giveawayID)
+		http.Error(w, "Error updating giveaway status", http.StatusInternalServerError)
+		return
+	}
+
+	s.log(r).Info("Giveaway status updated", "giveaway_id", giveawayID, "status", updatedGiveaway.Status)
+	s.twitchWebhook.WakeRewardSchedule(r.Context())
+	util.SendJSON(w, updatedGiveaway)
+}
+
+func (s *Server) setGiveawayScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	giveawayID, err := urlParamInt64(r, "giveawayID")
+	if err != nil {
+		http.Error(w, "Invalid giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	var req GiveawayScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updatedGiveaway, err := s.db.SetGiveawaySchedule(r.Context(), db.SetGiveawayScheduleParams{
+		ID:       giveawayID,
+		OpensAt:  optionalTimestamp(req.OpensAt),
+		ClosesAt: optionalTimestamp(req.ClosesAt),
+	})
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Giveaway not found", http.StatusNotFound)
+			return
+		}
+
+		s.log(r).Error("Error updating giveaway schedule", "error", err, "giveaway_id", giveawayID)
+		http.Error(w, "Error updating giveaway schedule", http.StatusInternalServerError)
+		return
+	}
+
+	s.log(r).Info("Giveaway schedule updated", "giveaway_id", giveawayID)
+	s.twitchWebhook.WakeRewardSchedule(r.Context())
+	util.SendJSON(w, updatedGiveaway)
+}
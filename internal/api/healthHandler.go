@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/util"
+
+	eventSub "github.com/gamis65/twitch-points/internal/twitch"
+)
+
+type HealthResponse struct {
+	Status        string `json:"status"`
+	Database      string `json:"database,omitempty"`
+	Subscriptions string `json:"subscriptions,omitempty"`
+	Draining      bool   `json:"draining,omitempty"`
+}
+
+// livenessHandler only tells the orchestrator that the process still answers, restarting won't fix the database
+func (s *Server) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	util.SendJSON(w, HealthResponse{Status: "ok"})
+}
+
+// readinessHandler fails while draining, when the database is unreachable or when subscribing the streamers failed.
+// Pending subscriptions don't fail it, Twitch has to reach us to verify them.
+func (s *Server) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	response := HealthResponse{
+		Status:        "ok",
+		Database:      "ok",
+		Subscriptions: s.twitchWebhook.SubscriptionState(),
+		Draining:      s.lifecycle != nil && s.lifecycle.Draining(),
+	}
+
+	if err := s.db.Ping(ctx); err != nil {
+		s.log(r).Error("Readiness check failed to reach the database", "error", err)
+		response.Database = "unreachable"
+	}
+
+	if response.Draining || response.Database != "ok" || response.Subscriptions == eventSub.SubscriptionsFailed {
+		response.Status = "unavailable"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	util.SendJSON(w, response)
+}
+
+// metricsAuthMiddleware requires the metrics token as a bearer token when one is configured
+func (s *Server) metricsAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.metricsToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.metricsToken)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/importer"
+	"github.com/gamis65/twitch-points/internal/util"
+)
+
+const maxImportSize = 50 << 20
+
+// importHandler adds entries from a CSV or NDJSON body to a giveaway.
+// With dry_run=true nothing is written and the response shows what would happen.
+func (s *Server) importHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format, err := importer.ParseFormat(query.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	giveawayID, err := strconv.ParseInt(query.Get("giveaway_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "giveaway_id is required", http.StatusBadRequest)
+		return
+	}
+
+	dryRun := query.Get("dry_run") == "true"
+
+	logger := s.log(r).With(
+		slog.Int64("giveaway_id", giveawayID),
+		slog.Bool("dry_run", dryRun),
+	)
+
+	// Uploads up to maxImportSize take longer than the server's read and write timeouts
+	controller := http.NewResponseController(w)
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	result, err := s.importer.Import(r.Context(), body, format, importer.Options{
+		GiveawayID: giveawayID,
+		DryRun:     dryRun,
+	})
+
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.Is(err, importer.ErrGiveawayNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.As(err, &maxBytesErr):
+			http.Error(w, "Import is too large", http.StatusRequestEntityTooLarge)
+		default:
+			logger.Error("Error importing entries", "error", err)
+			http.Error(w, "Error importing entries: "+err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	logger.Info("Imported entries", "imported", result.Imported, "failed", result.Failed)
+	util.SendJSON(w, result)
+}
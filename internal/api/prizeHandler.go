@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/util"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type PrizeRequest struct {
+	Name     string `json:"name"`
+	Quantity int32  `json:"quantity"`
+	Tier     int32  `json:"tier"`
+}
+
+func (s *Server) getPrizesHandler(w http.ResponseWriter, r *http.Request) {
+	giveawayID, err := urlParamInt64(r, "giveawayID")
+	if err != nil {
+		http.Error(w, "Invalid giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	prizes, err := s.db.GetPrizesByGiveaway(r.Context(), giveawayID)
+	if err != nil {
+		s.log(r).Error("Error getting prizes", "error", err, "giveaway_id", giveawayID)
+		http.Error(w, "Error getting prizes", http.StatusInternalServerError)
+		return
+	}
+
+	util.SendJSON(w, prizes)
+}
+
+func (s *Server) createPrizeHandler(w http.ResponseWriter, r *http.Request) {
+	giveawayID, err := urlParamInt64(r, "giveawayID")
+	if err != nil {
+		http.Error(w, "Invalid giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	var req PrizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Quantity <= 0 {
+		http.Error(w, "quantity must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	if req.Tier <= 0 {
+		req.Tier = 1
+	}
+
+	_, err = s.db.GetGiveawayByID(r.Context(), giveawayID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Giveaway not found", http.StatusNotFound)
+			return
+		}
+
+		s.log(r).Error("Error getting giveaway", "error", err, "giveaway_id", giveawayID)
+		http.Error(w, "Error getting giveaway", http.StatusInternalServerError)
+		return
+	}
+
+	prize, err := s.db.CreatePrize(r.Context(), db.CreatePrizeParams{
+		GiveawayID: giveawayID,
+		Name:       req.Name,
+		Quantity:   req.Quantity,
+		Tier:       req.Tier,
+	})
+
+	if err != nil {
+		s.log(r).Error("Error creating prize", "error", err, "giveaway_id", giveawayID)
+		http.Error(w, "Error creating prize", http.StatusInternalServerError)
+		return
+	}
+
+	s.log(r).Info("Prize created", "giveaway_id", giveawayID, "prize_id", prize.ID, "prize", prize.Name)
+
+	util.SendJSON(w, prize)
+}
+
+func (s *Server) deletePrizeHandler(w http.ResponseWriter, r *http.Request) {
+	giveawayID, err := urlParamInt64(r, "giveawayID")
+	if err != nil {
+		http.Error(w, "Invalid giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	prizeID, err := urlParamInt64(r, "prizeID")
+	if err != nil {
+		http.Error(w, "Invalid prize ID", http.StatusBadRequest)
+		return
+	}
+
+	err = s.db.DeletePrize(r.Context(), db.DeletePrizeParams{
+		ID:         prizeID,
+		GiveawayID: giveawayID,
+	})
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+		http.Error(w, "Prizes that have been drawn can't be deleted", http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		s.log(r).Error("Error deleting prize", "error", err, "prize_id", prizeID)
+		http.Error(w, "Error deleting prize", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/giveaway"
+	"github.com/gamis65/twitch-points/internal/util"
+	"github.com/jackc/pgx/v5"
+)
+
+type CreateRecurringGiveawayRequest struct {
+	Name            string    `json:"name"`
+	Rrule           string    `json:"rrule"`
+	Timezone        string    `json:"timezone"`
+	StartsAt        time.Time `json:"starts_at"`
+	DurationSeconds int64     `json:"duration_seconds"`
+	PrizeName       string    `json:"prize_name"`
+	PrizeQuantity   int32     `json:"prize_quantity"`
+	AutoDraw        bool      `json:"auto_draw"`
+}
+
+type RecurringGiveawayEnabledRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type RecurringGiveawayResponse struct {
+	db.RecurringGiveaway
+	NextOccurrence *time.Time `json:"next_occurrence"`
+}
+
+func newRecurringGiveawayResponse(recurring db.RecurringGiveaway) RecurringGiveawayResponse {
+	response := RecurringGiveawayResponse{RecurringGiveaway: recurring}
+	if next, ok := giveaway.NextOccurrence(recurring, time.Now()); ok && recurring.Enabled {
+		response.NextOccurrence = &next
+	}
+	return response
+}
+
+func (s *Server) getRecurringGiveawaysHandler(w http.ResponseWriter, r *http.Request) {
+	recurring, err := s.db.GetRecurringGiveaways(r.Context())
+	if err != nil {
+		s.log(r).Error("Error getting recurring giveaways", "error", err)
+		http.Error(w, "Error getting recurring giveaways", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]RecurringGiveawayResponse, 0, len(recurring))
+	for _, r := range recurring {
+		response = append(response, newRecurringGiveawayResponse(r))
+	}
+
+	util.SendJSON(w, response)
+}
+
+func (s *Server) createRecurringGiveawayHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getStreamerID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateRecurringGiveawayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	recurring, err := s.giveaway.CreateRecurringGiveaway(r.Context(), giveaway.RecurringParams{
+		Name:          req.Name,
+		Rule:          req.Rrule,
+		Timezone:      req.Timezone,
+		StartsAt:      req.StartsAt,
+		Duration:      time.Duration(req.DurationSeconds) * time.Second,
+		PrizeName:     req.PrizeName,
+		PrizeQuantity: req.PrizeQuantity,
+		AutoDraw:      req.AutoDraw,
+		CreatedBy:     userID,
+	})
+
+	if err != nil {
+		if errors.Is(err, giveaway.ErrInvalidSchedule) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.log(r).Error("Error creating recurring giveaway", "error", err)
+		http.Error(w, "Error creating recurring giveaway", http.StatusInternalServerError)
+		return
+	}
+
+	s.log(r).Info("Recurring giveaway created", "recurring_giveaway_id", recurring.ID, "rrule", recurring.Rrule, "timezone", recurring.Timezone)
+	util.SendJSON(w, newRecurringGiveawayResponse(recurring))
+}
+
+func (s *Server) setRecurringGiveawayEnabledHandler(w http.ResponseWriter, r *http.Request) {
+	recurringID, err := urlParamInt64(r, "recurringID")
+	if err != nil {
+		http.Error(w, "Invalid recurring giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	var req RecurringGiveawayEnabledRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	recurring, err := s.db.SetRecurringGiveawayEnabled(r.Context(), db.SetRecurringGiveawayEnabledParams{
+		ID:      recurringID,
+		Enabled: req.Enabled,
+	})
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Recurring giveaway not found", http.StatusNotFound)
+			return
+		}
+
+		s.log(r).Error("Error updating recurring giveaway", "error", err, "recurring_giveaway_id", recurringID)
+		http.Error(w, "Error updating recurring giveaway", http.StatusInternalServerError)
+		return
+	}
+
+	s.log(r).Info("Recurring giveaway updated", "recurring_giveaway_id", recurringID, "enabled", recurring.Enabled)
+	util.SendJSON(w, newRecurringGiveawayResponse(recurring))
+}
+
+// deleteRecurringGiveawayHandler stops the recurrence, giveaways it already created are kept
+func (s *Server) deleteRecurringGiveawayHandler(w http.ResponseWriter, r *http.Request) {
+	recurringID, err := urlParamInt64(r, "recurringID")
+	if err != nil {
+		http.Error(w, "Invalid recurring giveaway ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := s.db.DeleteRecurringGiveaway(r.Context(), recurringID)
+	if err != nil {
+		s.log(r).Error("Error deleting recurring giveaway", "error", err, "recurring_giveaway_id", recurringID)
+		http.Error(w, "Error deleting recurring giveaway", http.StatusInternalServerError)
+		return
+	}
+
+	if deleted == 0 {
+		http.Error(w, "Recurring giveaway not found", http.StatusNotFound)
+		return
+	}
+
+	s.log(r).Info("Recurring giveaway deleted", "recurring_giveaway_id", recurringID)
+	w.WriteHeader(http.StatusNoContent)
+}
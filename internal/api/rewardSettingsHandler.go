@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/util"
+)
+
+type RewardSettingsRequest struct {
+	LiveOnly bool `json:"live_only"`
+}
+
+type RewardSettingsResponse struct {
+	LiveOnly bool `json:"live_only"`
+}
+
+func (s *Server) getRewardSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := getRequestAuth(r)
+	if err != nil {
+		s.log(r).Error("Failed to get the signed in streamer", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	util.SendJSON(w, &RewardSettingsResponse{LiveOnly: auth.Streamer.RewardLiveOnly})
+}
+
+// updateRewardSettingsHandler saves when the streamer's reward can be redeemed, the reward schedule applies it on Twitch
+func (s *Server) updateRewardSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getStreamerID(r)
+	if err != nil {
+		s.log(r).Error("Failed to get the signed in streamer", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req RewardSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	streamer, err := s.db.SetStreamerRewardLiveOnly(r.Context(), db.SetStreamerRewardLiveOnlyParams{
+		TwitchID:       userID,
+		RewardLiveOnly: req.LiveOnly,
+	})
+	if err != nil {
+		s.log(r).Error("Error saving reward settings", "error", err)
+		http.Error(w, "Error saving reward settings", http.StatusInternalServerError)
+		return
+	}
+
+	s.log(r).Info("Reward settings updated", "live_only", streamer.RewardLiveOnly)
+	s.twitchWebhook.WakeRewardSchedule(r.Context())
+
+	util.SendJSON(w, &RewardSettingsResponse{LiveOnly: streamer.RewardLiveOnly})
+}
@@ -1,10 +1,21 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 
+	"github.com/gamis65/twitch-points/internal/config"
 	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/export"
+	"github.com/gamis65/twitch-points/internal/feed"
+	"github.com/gamis65/twitch-points/internal/giveaway"
+	"github.com/gamis65/twitch-points/internal/importer"
+	"github.com/gamis65/twitch-points/internal/lifecycle"
+	"github.com/gamis65/twitch-points/internal/logging"
+	"github.com/gamis65/twitch-points/internal/metrics"
+	"github.com/gamis65/twitch-points/internal/telemetry"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -14,24 +25,54 @@ import (
 	eventSub "github.com/gamis65/twitch-points/internal/twitch"
 )
 
+// Store is the data the handlers read and write
+type Store interface {
+	db.StreamerStore
+	db.RewardStore
+	db.ChatCommandStore
+	db.ViewerStore
+	db.GiveawayStore
+	db.ScheduleStore
+	db.WinnerStore
+	db.StatsStore
+	Ping(ctx context.Context) error
+}
+
 type Server struct {
-	host          string
-	frontendURL   string
-	sessionStore  *sessions.CookieStore
-	oauthConfig   *oauth2.Config
-	db            *db.DBStore
-	twitchWebhook *eventSub.TwitchWebhookClient
-	logger        *slog.Logger
+	host              string
+	httpServer        *http.Server
+	closing           chan struct{} // closed on shutdown so long-lived streams let go
+	lifecycle         *lifecycle.Manager
+	feed              *feed.Broker
+	metricsToken      string
+	frontendURL       string
+	sessionStore      *sessions.CookieStore
+	oauthConfig       *oauth2.Config
+	viewerOAuthConfig *oauth2.Config
+	db                Store
+	twitchWebhook     *eventSub.TwitchWebhookClient
+	twitchAPI         *eventSub.API
+	tokens            *tokenCache
+	giveaway          *giveaway.Service
+	exporter          *export.Exporter
+	importer          *importer.Importer
+	logger            *slog.Logger
 }
 
 type ServerConfig struct {
-	Host          string
-	FrontendURL   string
-	OAuthConfig   *oauth2.Config
-	SessionStore  *sessions.CookieStore
-	DBStore       *db.DBStore
-	TwitchWebhook *eventSub.TwitchWebhookClient
-	Logger        *slog.Logger
+	Config            config.Server
+	OAuthConfig       *oauth2.Config
+	ViewerOAuthConfig *oauth2.Config // Used to sign in winners claiming a prize
+	SessionStore      *sessions.CookieStore
+	Store             Store
+	TwitchWebhook     *eventSub.TwitchWebhookClient
+	TwitchAPI         *eventSub.API
+	Giveaway          *giveaway.Service
+	Exporter          *export.Exporter
+	Importer          *importer.Importer
+	Lifecycle         *lifecycle.Manager // Readiness fails once it starts draining
+	Feed              *feed.Broker
+	Logger            *slog.Logger
 }
 
 func NewServer(cfg *ServerConfig) *Server {
@@ -40,19 +81,48 @@ func NewServer(cfg *ServerConfig) *Server {
 		logger = slog.Default()
 	}
 
-	return &Server{
-		host:          cfg.Host,
-		frontendURL:   cfg.FrontendURL,
-		sessionStore:  cfg.SessionStore,
-		oauthConfig:   cfg.OAuthConfig,
-		db:            cfg.DBStore,
-		twitchWebhook: cfg.TwitchWebhook,
-		logger:        logger,
+	s := &Server{
+		host:              cfg.Config.Host,
+		closing:           make(chan struct{}),
+		lifecycle:         cfg.Lifecycle,
+		feed:              cfg.Feed,
+		metricsToken:      cfg.Config.MetricsToken,
+		frontendURL:       cfg.Config.FrontendURL,
+		sessionStore:      cfg.SessionStore,
+		oauthConfig:       cfg.OAuthConfig,
+		viewerOAuthConfig: cfg.ViewerOAuthConfig,
+		db:                cfg.Store,
+		twitchWebhook:     cfg.TwitchWebhook,
+		twitchAPI:         cfg.TwitchAPI,
+		tokens:            newTokenCache(cfg.TwitchAPI.ValidateToken),
+		giveaway:          cfg.Giveaway,
+		exporter:          cfg.Exporter,
+		importer:          cfg.Importer,
+		logger:            logger,
+	}
+
+	s.httpServer = &http.Server{
+		Addr:         cfg.Config.Host,
+		Handler:      s.SetupRoutes(),
+		ReadTimeout:  cfg.Config.ReadTimeout,
+		WriteTimeout: cfg.Config.WriteTimeout,
+		IdleTimeout:  cfg.Config.IdleTimeout,
 	}
+	s.httpServer.RegisterOnShutdown(func() { close(s.closing) })
+
+	return s
+}
+
+// log returns the logger of the request, tagged with its ID
+func (s *Server) log(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context())
 }
 
 func (s *Server) SetupRoutes() http.Handler {
 	r := chi.NewRouter()
+	r.Use(telemetry.Middleware)
+	r.Use(middleware.RequestID)
+	r.Use(logging.Middleware(s.logger))
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{s.frontendURL},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
@@ -62,17 +132,29 @@ func (s *Server) SetupRoutes() http.Handler {
 
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Heartbeat("/health"))
+	r.Get("/livez", s.livenessHandler)
+	r.Get("/readyz", s.readinessHandler)
+	r.With(s.metricsAuthMiddleware).Handle("/metrics", metrics.Handler())
 
 	r.Get("/auth/twitch", s.beginAuthHandler)
 	r.Get("/auth/twitch/callback", s.callbackHandler)
 	r.Get("/logout/twitch", s.logoutHandler)
 	r.With(s.authMiddleware).Post("/add-reward", s.addRewardHandler)
 
+	r.Get("/auth/viewer/twitch", s.beginViewerAuthHandler)
+	r.Get("/auth/viewer/twitch/callback", s.viewerCallbackHandler)
+	r.Get("/logout/viewer", s.viewerLogoutHandler)
+
 	// Protected routes
 	r.Group(func(r chi.Router) {
 		r.Use(s.authMiddleware)
 		r.Post("/add-reward", s.addRewardHandler)
+		r.Get("/rewards", s.getRewardsHandler)
+		r.Get("/reward-settings", s.getRewardSettingsHandler)
+		r.Put("/reward-settings", s.updateRewardSettingsHandler)
 		r.Get("/me", s.meHandler)
+		r.Get("/chat-command", s.getChatCommandHandler)
+		r.Put("/chat-command", s.updateChatCommandHandler)
 	})
 
 	r.HandleFunc("/eventsub", s.twitchWebhook.GetHandler())
@@ -83,11 +165,71 @@ func (s *Server) SetupRoutes() http.Handler {
 		r.Get("/participants-count", s.GetTotalParticipantsHandler)
 		r.Get("/entries-count", s.GetTotalEntriesHandler)
 		r.Get("/leaderboard", s.GetLeaderboardHandler)
+		r.Get("/viewers/{login}", s.GetViewerHandler)
+		r.Get("/entries/stream", s.entriesFeedHandler)
+	})
+
+	r.Route("/giveaways", func(r chi.Router) {
+		r.Use(s.authMiddleware)
+		r.Use(s.verifiedMiddleware)
+		r.Get("/", s.getGiveawaysHandler)
+		r.Post("/", s.createGiveawayHandler)
+
+		r.Route("/recurring", func(r chi.Router) {
+			r.Get("/", s.getRecurringGiveawaysHandler)
+			r.Post("/", s.createRecurringGiveawayHandler)
+			r.Put("/{recurringID}/enabled", s.setRecurringGiveawayEnabledHandler)
+			r.Delete("/{recurringID}", s.deleteRecurringGiveawayHandler)
+		})
+
+		r.Route("/{giveawayID}", func(r chi.Router) {
+			r.Put("/status", s.setGiveawayStatusHandler)
+			r.Put("/schedule", s.setGiveawayScheduleHandler)
+			r.Get("/prizes", s.getPrizesHandler)
+			r.Post("/prizes", s.createPrizeHandler)
+			r.Delete("/prizes/{prizeID}", s.deletePrizeHandler)
+			r.Post("/draw", s.drawHandler)
+			r.Get("/winners", s.getWinnersHandler)
+			r.Put("/winners/{winnerID}/status", s.setWinnerStatusHandler)
+			r.Post("/winners/{winnerID}/redraw", s.redrawHandler)
+			r.Get("/winners/{winnerID}/claim", s.getClaimDetailsHandler)
+		})
+	})
+
+	r.Route("/export", func(r chi.Router) {
+		r.Use(s.authMiddleware)
+		r.Use(s.verifiedMiddleware)
+		r.Get("/{dataset}", s.exportHandler)
+	})
+
+	r.With(s.authMiddleware, s.verifiedMiddleware).Post("/import", s.importHandler)
+	r.With(s.authMiddleware, s.verifiedMiddleware).Post("/backfill", s.backfillHandler)
+
+	r.Route("/viewer", func(r chi.Router) {
+		r.Use(s.viewerAuthMiddleware)
+		r.Get("/", s.getSignedInViewerHandler)
+		r.Post("/opt-out", s.optOutHandler)
+	})
+
+	r.Route("/claims", func(r chi.Router) {
+		r.Use(s.viewerAuthMiddleware)
+		r.Get("/", s.getViewerClaimsHandler)
+		r.Post("/{winnerID}", s.claimHandler)
 	})
 	return r
 }
 
+// Start serves until Shutdown is called, which makes it return nil
 func (s *Server) Start() error {
 	slog.Info("Starting server", "address", s.host)
-	return http.ListenAndServe(s.host, s.SetupRoutes())
+	err := s.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
 }
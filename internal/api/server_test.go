@@ -0,0 +1,1681 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/api"
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/db/memstore"
+	"github.com/gamis65/twitch-points/internal/export"
+	"github.com/gamis65/twitch-points/internal/feed"
+	"github.com/gamis65/twitch-points/internal/giveaway"
+	"github.com/gamis65/twitch-points/internal/importer"
+	"github.com/gamis65/twitch-points/internal/leader"
+	"github.com/gamis65/twitch-points/internal/logging"
+	"github.com/gamis65/twitch-points/internal/metrics"
+	"github.com/gamis65/twitch-points/internal/notify"
+	"github.com/gamis65/twitch-points/internal/testutil/faketwitch"
+	"github.com/gamis65/twitch-points/internal/testutil/pgtest"
+	"github.com/gamis65/twitch-points/internal/twitch"
+	"github.com/gorilla/sessions"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/oauth2"
+)
+
+const frontendURL = "http://frontend.test"
+
+// claimKey encrypts the delivery details winners submit
+var claimKey = bytes.Repeat([]byte{7}, 32)
+
+// The events serve subscribes every streamer to
+var events = []string{
+	"stream.online",
+	"stream.offline",
+	"channel.update",
+	"channel.channel_points_custom_reward_redemption.add",
+	"channel.channel_points_custom_reward.update",
+	"channel.chat.message",
+}
+
+var streamer = faketwitch.User{
+	ID:              "1001",
+	Login:           "streamer",
+	DisplayName:     "Streamer",
+	BroadcasterType: "affiliate",
+	ProfileImageURL: "https://static-cdn.jtvnw.net/streamer.png",
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(pgtest.Run(m))
+}
+
+// appStore is everything the server, the EventSub client, the services and the leader election need from a store
+type appStore interface {
+	api.Store
+	twitch.Store
+	giveaway.Store
+	importer.Store
+	db.Locker
+}
+
+// backend opens a store for one test, the exporter is nil where there's no database to export from
+type backend struct {
+	name string
+	open func(t *testing.T) (appStore, *export.Exporter)
+}
+
+var backends = []backend{
+	{"memory", func(t *testing.T) (appStore, *export.Exporter) {
+		return memstore.New(), nil
+	}},
+	{"postgres", func(t *testing.T) (appStore, *export.Exporter) {
+		pool := pgtest.New(t)
+		return db.NewStore(pool), export.NewExporter(pool)
+	}},
+}
+
+// forEachStore runs the test once with every store, notifications are delivered to the webhook
+func forEachStore(t *testing.T, test func(t *testing.T, app *testApp)) {
+	forEachStoreWith(t, nil, test)
+}
+
+// forEachStoreWith runs the test once with every store, configure changes the Twitch settings of the app
+func forEachStoreWith(t *testing.T, configure func(cfg *config.Twitch), test func(t *testing.T, app *testApp)) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			store, exporter := b.open(t)
+			test(t, newTestApp(t, store, exporter, configure))
+		})
+	}
+}
+
+// testApp is the whole server wired up like serve does it, talking to a fake Twitch and a fresh store
+type testApp struct {
+	URL     string
+	Twitch  *faketwitch.Server
+	Store   appStore
+	webhook *twitch.TwitchWebhookClient
+	service *giveaway.Service
+	feed    *feed.Broker
+	client  *http.Client
+}
+
+func newTestApp(t *testing.T, store appStore, exporter *export.Exporter, configure func(cfg *config.Twitch)) *testApp {
+	t.Helper()
+
+	fake := faketwitch.New(t)
+
+	// The webhook URL has to be known before the server exists
+	var handler http.Handler
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	twitchConfig := fake.Config(ts.URL + "/eventsub")
+	if configure != nil {
+		configure(&twitchConfig)
+	}
+	// Lost sessions are noticed in a few seconds instead of twelve
+	fake.SetKeepaliveTimeout(time.Second)
+	twitchAPI := twitch.NewAPI(twitchConfig)
+	notifier := notify.New("")
+	entryFeed := feed.NewBroker()
+	service := giveaway.NewService(store, twitchAPI, claimKey, notifier)
+
+	webhook, err := twitch.NewTwitchClient(twitchConfig, twitchAPI, store, events, notifier, entryFeed)
+	if err != nil {
+		t.Fatalf("error creating the EventSub client: %v", err)
+	}
+
+	sessionStore := sessions.NewCookieStore([]byte("test-session-key"))
+	sessionStore.Options = &sessions.Options{Path: "/", HttpOnly: true}
+
+	oauthEndpoint := oauth2.Endpoint{
+		AuthURL:   twitchConfig.AuthURL + "/authorize",
+		TokenURL:  twitchConfig.AuthURL + "/token",
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+
+	server := api.NewServer(&api.ServerConfig{
+		Config: config.Server{FrontendURL: frontendURL},
+		OAuthConfig: &oauth2.Config{
+			ClientID:     twitchConfig.ClientID,
+			ClientSecret: twitchConfig.ClientSecret,
+			RedirectURL:  ts.URL + "/auth/twitch/callback",
+			Endpoint:     oauthEndpoint,
+		},
+		ViewerOAuthConfig: &oauth2.Config{
+			ClientID:     twitchConfig.ClientID,
+			ClientSecret: twitchConfig.ClientSecret,
+			RedirectURL:  ts.URL + "/auth/viewer/twitch/callback",
+			Endpoint:     oauthEndpoint,
+		},
+		SessionStore:  sessionStore,
+		Store:         store,
+		TwitchWebhook: webhook,
+		TwitchAPI:     twitchAPI,
+		Giveaway:      service,
+		Exporter:      exporter,
+		Importer:      importer.New(store),
+		Feed:          entryFeed,
+		Logger:        logger,
+	})
+	handler = server.SetupRoutes()
+
+	backgroundCtx, stopBackground := context.WithCancel(logging.WithLogger(context.Background(), logger))
+	var background sync.WaitGroup
+	background.Add(3)
+	go func() {
+		defer background.Done()
+		webhook.Run(backgroundCtx)
+	}()
+	go func() {
+		defer background.Done()
+		webhook.RunRewardSchedule(backgroundCtx, time.Minute)
+	}()
+	go func() {
+		defer background.Done()
+		entryFeed.Relay(backgroundCtx, store)
+	}()
+
+	// Handlers still writing would fail once the test database is dropped
+	t.Cleanup(func() {
+		stopBackground()
+		background.Wait()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		webhook.Shutdown(ctx)
+		service.Shutdown(ctx)
+	})
+
+	// WebSocket sessions and conduits are set up in the background
+	eventually(t, "the EventSub transport", func() bool { return webhook.SubscriptionState() == twitch.SubscriptionsReady })
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar:     jar,
+		Timeout: 30 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return &testApp{URL: ts.URL, Twitch: fake, Store: store, webhook: webhook, service: service, feed: entryFeed, client: client}
+}
+
+func (a *testApp) do(t *testing.T, method, path string) *http.Response {
+	t.Helper()
+	return a.doJSON(t, method, path, nil)
+}
+
+// doJSON sends the body encoded as JSON, none when it's nil
+func (a *testApp) doJSON(t *testing.T, method, path string, body any) *http.Response {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, a.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// signIn goes through the Twitch OAuth flow as the user, leaving the session cookie in the client
+func (a *testApp) signIn(t *testing.T, user faketwitch.User) {
+	t.Helper()
+
+	a.Twitch.AddUser(user)
+
+	resp := a.do(t, http.MethodGet, "/auth/twitch")
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("GET /auth/twitch: status %d", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect to Twitch: %v", err)
+	}
+
+	// Twitch sends the user back with a code once they approved the app
+	query := url.Values{
+		"state": {location.Query().Get("state")},
+		"code":  {a.Twitch.Code(user.ID)},
+	}
+	resp = a.do(t, http.MethodGet, "/auth/twitch/callback?"+query.Encode())
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != frontendURL+"/addreward" {
+		t.Fatalf("GET /auth/twitch/callback: status %d, redirect to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+// signInVerified signs the user in as a verified streamer, only they manage giveaways and verifying is done by hand
+func (a *testApp) signInVerified(t *testing.T, user faketwitch.User) {
+	t.Helper()
+
+	// Without channel points so far, signing in subscribes the channel point events
+	_, err := a.Store.CreateStreamer(context.Background(), db.CreateStreamerParams{
+		TwitchID:        user.ID,
+		Username:        user.Login,
+		Verified:        pgtype.Bool{Bool: true, Valid: true},
+		BroadcasterType: pgtype.Text{String: "", Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("error creating the streamer: %v", err)
+	}
+	a.signIn(t, user)
+}
+
+// addReward creates the giveaway reward on the signed in streamer's channel and returns its ID
+func (a *testApp) addReward(t *testing.T, user faketwitch.User) string {
+	t.Helper()
+
+	resp := a.do(t, http.MethodPost, "/add-reward")
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("POST /add-reward: status %d: %s", resp.StatusCode, body)
+	}
+
+	// Replaced rewards may stay on the channel disabled
+	var enabled []faketwitch.Reward
+	for _, reward := range a.Twitch.Rewards(user.ID) {
+		if reward.IsEnabled {
+			enabled = append(enabled, reward)
+		}
+	}
+	if len(enabled) != 1 {
+		t.Fatalf("got %d enabled rewards on Twitch, want 1", len(enabled))
+	}
+	return enabled[0].ID
+}
+
+// openGiveaway returns the open giveaway, the migrations already open one in Postgres
+func (a *testApp) openGiveaway(t *testing.T) db.Giveaway {
+	t.Helper()
+
+	if open, err := a.Store.GetOpenGiveaway(context.Background()); err == nil {
+		return open
+	}
+
+	giveaway, err := a.Store.CreateGiveaway(context.Background(), db.CreateGiveawayParams{
+		Name:   "Test giveaway",
+		Status: giveaway.StatusOpen,
+	})
+	if err != nil {
+		t.Fatalf("error creating the giveaway: %v", err)
+	}
+	return giveaway
+}
+
+// deliver sends the notification and waits until its handler finished
+func (a *testApp) deliver(t *testing.T, messageID, eventType string, event any) {
+	t.Helper()
+
+	before := handled(eventType)
+
+	status, err := a.Twitch.Deliver(messageID, eventType, event)
+	if err != nil {
+		t.Fatalf("error delivering %s: %v", eventType, err)
+	}
+	if status != http.StatusNoContent {
+		t.Fatalf("delivering %s: status %d", eventType, status)
+	}
+
+	eventually(t, "the "+eventType+" handler to finish", func() bool {
+		return handled(eventType) > before
+	})
+}
+
+// handled counts the notifications of a type whose handler returned
+func handled(eventType string) float64 {
+	var total float64
+	for _, outcome := range []string{"success", "error", "invalid"} {
+		total += promtest.ToFloat64(metrics.EventSubNotifications.WithLabelValues(eventType, outcome))
+	}
+	return total
+}
+
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func redemptionEvent(redemptionID, rewardID string, viewer faketwitch.User) map[string]any {
+	return map[string]any{
+		"id":                     redemptionID,
+		"broadcaster_user_id":    streamer.ID,
+		"broadcaster_user_login": streamer.Login,
+		"broadcaster_user_name":  streamer.DisplayName,
+		"user_id":                viewer.ID,
+		"user_login":             viewer.Login,
+		"user_name":              viewer.DisplayName,
+		"status":                 "unfulfilled",
+		"reward":                 map[string]any{"id": rewardID, "title": "1 Giveaway Entry", "cost": 100},
+		"redeemed_at":            time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+func (a *testApp) entryCount(t *testing.T, giveawayID int64, viewer faketwitch.User) int64 {
+	t.Helper()
+
+	stats, err := a.Store.GetViewerEntryStats(context.Background(), db.GetViewerEntryStatsParams{
+		StreamerID:  pgtype.Text{String: streamer.ID, Valid: true},
+		ViewerID:    pgtype.Text{String: viewer.ID, Valid: true},
+		EntryMethod: twitch.EntryMethodChannelPoints,
+		GiveawayID:  giveawayID,
+	})
+	if err != nil {
+		t.Fatalf("error counting entries: %v", err)
+	}
+	return stats.TotalEntries
+}
+
+func TestSignIn(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+
+		live := streamer
+		live.Live = true
+		app.signIn(t, live)
+
+		saved, err := app.Store.GetStreamerByID(context.Background(), streamer.ID)
+		if err != nil {
+			t.Fatalf("the streamer wasn't saved: %v", err)
+		}
+		if saved.Username != streamer.Login || !saved.IsLive.Bool || saved.Verified.Bool {
+			t.Errorf("saved streamer %+v, want %s live and not verified", saved, streamer.Login)
+		}
+
+		// Subscribing doesn't wait for the challenge, Twitch enables the subscription when it reads the answer
+		eventually(t, "the subscriptions to be enabled", func() bool {
+			subscribed := app.subscribedEvents(streamer.ID)
+			for _, event := range events {
+				if !subscribed[event] {
+					return false
+				}
+			}
+			return true
+		})
+
+		resp := app.do(t, http.MethodGet, "/me")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /me: status %d", resp.StatusCode)
+		}
+
+		var me api.MeResponse
+		if err := json.NewDecoder(resp.Body).Decode(&me); err != nil {
+			t.Fatalf("error decoding /me: %v", err)
+		}
+		if me.TwitchID != streamer.ID || me.Username != streamer.Login || me.ProfileImageUrl != streamer.ProfileImageURL {
+			t.Errorf("GET /me = %+v", me)
+		}
+	})
+}
+
+func TestMeRequiresSignIn(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+
+		resp := app.do(t, http.MethodGet, "/me")
+		if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != frontendURL {
+			t.Errorf("GET /me without a session: status %d, redirect to %q", resp.StatusCode, resp.Header.Get("Location"))
+		}
+	})
+}
+
+func TestAddReward(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		app.signIn(t, streamer)
+
+		rewardID := app.addReward(t, streamer)
+
+		rewards, err := app.Store.GetRewardsByStreamer(context.Background(), pgtype.Text{String: streamer.ID, Valid: true})
+		if err != nil {
+			t.Fatalf("error getting the rewards: %v", err)
+		}
+		if len(rewards) != 1 || rewards[0].RewardID != rewardID {
+			t.Errorf("saved rewards %+v, want only %s", rewards, rewardID)
+		}
+	})
+}
+
+// subscribedEvents returns the enabled subscriptions of the broadcaster by event
+func (a *testApp) subscribedEvents(broadcasterID string) map[string]bool {
+	subscribed := map[string]bool{}
+	for _, sub := range a.Twitch.Subscriptions() {
+		if sub.Condition["broadcaster_user_id"] == broadcasterID && sub.Status == "enabled" {
+			subscribed[sub.Type] = true
+		}
+	}
+	return subscribed
+}
+
+func TestStreamerWithoutChannelPoints(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		regular := streamer
+		regular.BroadcasterType = ""
+		app.signIn(t, regular)
+
+		// Twitch rejects the channel point subscriptions, the others are still made
+		eventually(t, "the chat subscription to be enabled", func() bool {
+			return app.subscribedEvents(regular.ID)["channel.chat.message"]
+		})
+		if subscribed := app.subscribedEvents(regular.ID); subscribed["channel.channel_points_custom_reward_redemption.add"] {
+			t.Errorf("a streamer without channel points was subscribed to redemptions")
+		}
+
+		var me api.MeResponse
+		if err := json.NewDecoder(app.do(t, http.MethodGet, "/me").Body).Decode(&me); err != nil {
+			t.Fatalf("error decoding /me: %v", err)
+		}
+		if me.ChannelPoints {
+			t.Errorf("GET /me reports channel points for a streamer without them")
+		}
+
+		resp := app.do(t, http.MethodPost, "/add-reward")
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("POST /add-reward without channel points: status %d, want %d", resp.StatusCode, http.StatusForbidden)
+		}
+		if rewards := app.Twitch.Rewards(regular.ID); len(rewards) != 0 {
+			t.Errorf("got %d rewards on Twitch, want none", len(rewards))
+		}
+
+		// Becoming an affiliate takes effect on the next sign in
+		app.signIn(t, streamer)
+		eventually(t, "the redemption subscription to be enabled", func() bool {
+			return app.subscribedEvents(streamer.ID)["channel.channel_points_custom_reward_redemption.add"]
+		})
+		app.addReward(t, streamer)
+	})
+}
+
+func TestRewardRotation(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		app.signIn(t, streamer)
+		giveaway := app.openGiveaway(t)
+
+		oldID := app.addReward(t, streamer)
+		// addReward checks the old one was disabled, it keeps its queue until the backfill checked it
+		newID := app.addReward(t, streamer)
+		if newID == oldID {
+			t.Fatalf("the reward wasn't replaced")
+		}
+
+		onTwitch := map[string]faketwitch.Reward{}
+		for _, reward := range app.Twitch.Rewards(streamer.ID) {
+			onTwitch[reward.ID] = reward
+		}
+		if len(onTwitch) != 2 || onTwitch[oldID].Title == onTwitch[newID].Title {
+			t.Fatalf("rewards on Twitch %+v, want the retired one kept under another title", onTwitch)
+		}
+
+		rewards, err := app.Store.GetRewardsByStreamer(context.Background(), pgtype.Text{String: streamer.ID, Valid: true})
+		if err != nil {
+			t.Fatalf("error getting the rewards: %v", err)
+		}
+		status := map[string]db.Reward{}
+		for _, reward := range rewards {
+			status[reward.RewardID] = reward
+		}
+		if len(rewards) != 2 || status[newID].Status != twitch.RewardActive || status[oldID].Status != twitch.RewardRetired {
+			t.Fatalf("saved rewards %+v, want %s active and %s retired", rewards, newID, oldID)
+		}
+		if status[oldID].GiveawayID.Int64 != giveaway.ID || !status[oldID].RetiredAt.Valid {
+			t.Errorf("retired reward %+v, want it linked to giveaway %d", status[oldID], giveaway.ID)
+		}
+
+		// Redemptions of the retired reward still reaching us count in the giveaway it was retired in
+		first := faketwitch.User{ID: "2001", Login: "first", DisplayName: "First"}
+		second := faketwitch.User{ID: "2002", Login: "second", DisplayName: "Second"}
+		app.deliver(t, "message-1", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-1", oldID, first))
+		app.deliver(t, "message-2", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-2", newID, second))
+
+		if count := app.entryCount(t, giveaway.ID, first); count != 1 {
+			t.Errorf("got %d entries from the retired reward, want 1", count)
+		}
+		if count := app.entryCount(t, giveaway.ID, second); count != 1 {
+			t.Errorf("got %d entries from the active reward, want 1", count)
+		}
+
+		// Once its queue was backfilled the retired reward is deleted
+		result, err := app.webhook.Backfill(context.Background(), "")
+		if err != nil {
+			t.Fatalf("error backfilling: %v", err)
+		}
+		if result.Errors != 0 {
+			t.Errorf("backfill had %d errors", result.Errors)
+		}
+		if rewards := app.Twitch.Rewards(streamer.ID); len(rewards) != 1 || rewards[0].ID != newID {
+			t.Errorf("rewards on Twitch after the backfill %+v, want only %s", rewards, newID)
+		}
+	})
+}
+
+// failingRewardStore loses the connection at the end of the transaction replacing a reward, so its writes are rolled back
+type failingRewardStore struct {
+	appStore
+	fail atomic.Bool
+}
+
+func (s *failingRewardStore) InRewardTx(ctx context.Context, fn func(tx db.RewardTx) error) error {
+	return s.appStore.InRewardTx(ctx, func(tx db.RewardTx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if s.fail.Load() {
+			return errors.New("connection lost")
+		}
+		return nil
+	})
+}
+
+// A reward that couldn't be saved is taken off the channel again and the old one keeps taking entries
+func TestRewardRotationRollback(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			opened, exporter := b.open(t)
+			store := &failingRewardStore{appStore: opened}
+			app := newTestApp(t, store, exporter, nil)
+
+			app.signIn(t, streamer)
+			open := app.openGiveaway(t)
+			oldID := app.addReward(t, streamer)
+
+			store.fail.Store(true)
+			resp := app.do(t, http.MethodPost, "/add-reward")
+			if resp.StatusCode != http.StatusInternalServerError {
+				t.Fatalf("POST /add-reward: status %d, want 500", resp.StatusCode)
+			}
+
+			rewards := app.Twitch.Rewards(streamer.ID)
+			if len(rewards) != 1 || rewards[0].ID != oldID || rewards[0].Title != "1 Giveaway Entry" || !rewards[0].IsEnabled {
+				t.Fatalf("rewards on Twitch %+v, want only %s with its title back", rewards, oldID)
+			}
+
+			saved, err := app.Store.GetRewardsByStreamer(context.Background(), pgtype.Text{String: streamer.ID, Valid: true})
+			if err != nil {
+				t.Fatalf("error getting the rewards: %v", err)
+			}
+			if len(saved) != 1 || saved[0].RewardID != oldID || saved[0].Status != twitch.RewardActive {
+				t.Fatalf("saved rewards %+v, want %s still active", saved, oldID)
+			}
+
+			viewer := faketwitch.User{ID: "2001", Login: "viewer", DisplayName: "Viewer"}
+			app.deliver(t, "message-1", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-1", oldID, viewer))
+			if count := app.entryCount(t, open.ID, viewer); count != 1 {
+				t.Errorf("got %d entries from the old reward, want 1", count)
+			}
+		})
+	}
+}
+
+func TestRewardSchedule(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		app.signIn(t, streamer)
+		giveaway := app.openGiveaway(t)
+		rewardID := app.addReward(t, streamer)
+
+		waitForPaused := func(what string, paused bool) {
+			t.Helper()
+			eventually(t, what, func() bool {
+				reward := app.Twitch.Rewards(streamer.ID)[0]
+				return reward.IsPaused == paused && reward.IsEnabled
+			})
+		}
+		setSchedule := func(opensAt, closesAt time.Time) {
+			t.Helper()
+			_, err := app.Store.SetGiveawaySchedule(context.Background(), db.SetGiveawayScheduleParams{
+				ID:       giveaway.ID,
+				OpensAt:  pgtype.Timestamptz{Time: opensAt, Valid: !opensAt.IsZero()},
+				ClosesAt: pgtype.Timestamptz{Time: closesAt, Valid: !closesAt.IsZero()},
+			})
+			if err != nil {
+				t.Fatalf("error setting the schedule: %v", err)
+			}
+			app.webhook.WakeRewardSchedule(context.Background())
+		}
+
+		waitForPaused("the reward to be redeemable in the open giveaway", false)
+
+		setSchedule(time.Now().Add(time.Hour), time.Time{})
+		waitForPaused("the reward to be paused before the giveaway opens", true)
+
+		// Resumed now and paused again at the closing time, well before the next periodic reconcile
+		setSchedule(time.Time{}, time.Now().Add(500*time.Millisecond))
+		waitForPaused("the reward to be resumed", false)
+		waitForPaused("the reward to be paused when the giveaway closes", true)
+
+		setSchedule(time.Time{}, time.Time{})
+		waitForPaused("the reward to be resumed without a schedule", false)
+
+		// Only while live, the streamer is offline
+		resp := app.doJSON(t, http.MethodPut, "/reward-settings", map[string]any{"live_only": true})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT /reward-settings: status %d", resp.StatusCode)
+		}
+		waitForPaused("the reward to be paused while offline", true)
+
+		stream := map[string]any{
+			"id":                     "stream-1",
+			"broadcaster_user_id":    streamer.ID,
+			"broadcaster_user_login": streamer.Login,
+			"broadcaster_user_name":  streamer.DisplayName,
+			"type":                   "live",
+			"started_at":             time.Now().UTC().Format(time.RFC3339),
+		}
+		app.deliver(t, "message-1", "stream.online", stream)
+		waitForPaused("the reward to be resumed when the stream starts", false)
+
+		// Paused by hand on the dashboard, it drifted from the schedule
+		app.Twitch.PauseReward(rewardID, true)
+		app.deliver(t, "message-2", "channel.channel_points_custom_reward.update", map[string]any{
+			"id":                     rewardID,
+			"broadcaster_user_id":    streamer.ID,
+			"broadcaster_user_login": streamer.Login,
+			"title":                  "1 Giveaway Entry",
+			"cost":                   100,
+			"is_paused":              true,
+		})
+		waitForPaused("the paused reward to be resumed", false)
+
+		app.deliver(t, "message-3", "stream.offline", map[string]any{
+			"broadcaster_user_id":    streamer.ID,
+			"broadcaster_user_login": streamer.Login,
+			"broadcaster_user_name":  streamer.DisplayName,
+		})
+		waitForPaused("the reward to be paused when the stream ends", true)
+	})
+}
+
+func TestRecurringGiveaway(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		ctx := context.Background()
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+
+		// The occurrences can only open once nothing else is
+		if _, err := app.Store.SetGiveawayStatus(ctx, db.SetGiveawayStatusParams{
+			ID:     app.openGiveaway(t).ID,
+			Status: giveaway.StatusClosed,
+		}); err != nil {
+			t.Fatalf("error closing the giveaway: %v", err)
+		}
+
+		// Fridays at 20:00 in Berlin, Europe leaves summer time between the first two
+		berlin, err := time.LoadLocation("Europe/Berlin")
+		if err != nil {
+			t.Fatalf("error loading the timezone: %v", err)
+		}
+		recurring, err := app.service.CreateRecurringGiveaway(ctx, giveaway.RecurringParams{
+			Name:      "Friday giveaway",
+			Rule:      "RRULE:FREQ=WEEKLY;BYDAY=FR",
+			Timezone:  "Europe/Berlin",
+			StartsAt:  time.Date(2026, time.October, 23, 20, 0, 0, 0, berlin),
+			Duration:  2 * time.Hour,
+			PrizeName: "Game key",
+			AutoDraw:  true,
+			CreatedBy: streamer.ID,
+		})
+		if err != nil {
+			t.Fatalf("error creating the recurring giveaway: %v", err)
+		}
+
+		process := func(now time.Time) {
+			t.Helper()
+			if _, err := app.service.ProcessSchedules(ctx, now); err != nil {
+				t.Fatalf("error processing the schedules: %v", err)
+			}
+		}
+		occurrence := func(opensAt time.Time) db.Giveaway {
+			t.Helper()
+			giveaways, err := app.Store.GetGiveaways(ctx)
+			if err != nil {
+				t.Fatalf("error getting the giveaways: %v", err)
+			}
+			for _, g := range giveaways {
+				if g.RecurringGiveawayID.Int64 == recurring.ID && g.OpensAt.Time.Equal(opensAt) {
+					return g
+				}
+			}
+			t.Fatalf("no occurrence opening at %s", opensAt)
+			return db.Giveaway{}
+		}
+
+		firstOpens := time.Date(2026, time.October, 23, 18, 0, 0, 0, time.UTC)
+		process(firstOpens.Add(-time.Hour))
+		process(firstOpens.Add(-time.Hour)) // once per occurrence
+		first := occurrence(firstOpens)
+		if first.Status != giveaway.StatusDraft || !first.ClosesAt.Time.Equal(firstOpens.Add(2*time.Hour)) {
+			t.Fatalf("got %s closing at %s, want a draft closing two hours later", first.Status, first.ClosesAt.Time)
+		}
+		if prizes, err := app.Store.GetPrizesByGiveaway(ctx, first.ID); err != nil || len(prizes) != 1 {
+			t.Fatalf("got %d prizes (%v), want 1", len(prizes), err)
+		}
+
+		process(firstOpens)
+		if open, err := app.Store.GetOpenGiveaway(ctx); err != nil || open.ID != first.ID {
+			t.Fatalf("got open giveaway %d (%v), want %d", open.ID, err, first.ID)
+		}
+
+		// The schedules run on a made up clock but entries are taken by the real one, so the window is moved around it meanwhile
+		setSchedule := func(opensAt, closesAt time.Time) {
+			t.Helper()
+			_, err := app.Store.SetGiveawaySchedule(ctx, db.SetGiveawayScheduleParams{
+				ID:       first.ID,
+				OpensAt:  pgtype.Timestamptz{Time: opensAt, Valid: true},
+				ClosesAt: pgtype.Timestamptz{Time: closesAt, Valid: true},
+			})
+			if err != nil {
+				t.Fatalf("error setting the schedule: %v", err)
+			}
+		}
+		setSchedule(time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+
+		viewer := faketwitch.User{ID: "2001", Login: "viewer", DisplayName: "Viewer"}
+		app.deliver(t, "message-1", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-1", rewardID, viewer))
+		if count := app.entryCount(t, first.ID, viewer); count != 1 {
+			t.Fatalf("got %d entries, want 1", count)
+		}
+		setSchedule(firstOpens, firstOpens.Add(2*time.Hour))
+
+		process(firstOpens.Add(2 * time.Hour))
+		if closed := occurrence(firstOpens); closed.Status != giveaway.StatusClosed {
+			t.Fatalf("got status %s, want closed", closed.Status)
+		}
+		winners, err := app.Store.GetWinnersByGiveaway(ctx, first.ID)
+		if err != nil || len(winners) != 1 || winners[0].ViewerID != viewer.ID {
+			t.Fatalf("got winners %+v (%v), want the viewer", winners, err)
+		}
+
+		// 20:00 is an hour later in UTC once summer time ended
+		secondOpens := time.Date(2026, time.October, 30, 19, 0, 0, 0, time.UTC)
+		process(secondOpens.Add(-time.Hour))
+		if second := occurrence(secondOpens); second.Status != giveaway.StatusDraft {
+			t.Fatalf("got status %s, want draft", second.Status)
+		}
+	})
+}
+
+func TestInterruptedAutoDraw(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		ctx := context.Background()
+		app.signIn(t, streamer)
+
+		if _, err := app.Store.SetGiveawayStatus(ctx, db.SetGiveawayStatusParams{
+			ID:     app.openGiveaway(t).ID,
+			Status: giveaway.StatusClosed,
+		}); err != nil {
+			t.Fatalf("error closing the giveaway: %v", err)
+		}
+
+		now := time.Now()
+		scheduled, err := app.Store.CreateScheduledGiveaway(ctx, db.CreateScheduledGiveawayParams{
+			Name:         "Scheduled giveaway",
+			CreatedBy:    pgtype.Text{String: streamer.ID, Valid: true},
+			OpensAt:      pgtype.Timestamptz{Time: now.Add(-time.Hour), Valid: true},
+			ClosesAt:     pgtype.Timestamptz{Time: now.Add(-time.Minute), Valid: true},
+			ScheduledFor: pgtype.Timestamptz{Time: now.Add(-time.Hour), Valid: true},
+			AutoDraw:     true,
+		})
+		if err != nil {
+			t.Fatalf("error creating the giveaway: %v", err)
+		}
+		if _, err := app.Store.CreatePrize(ctx, db.CreatePrizeParams{GiveawayID: scheduled.ID, Name: "Game key", Quantity: 1, Tier: 1}); err != nil {
+			t.Fatalf("error creating the prize: %v", err)
+		}
+		if _, err := app.Store.SetGiveawayStatus(ctx, db.SetGiveawayStatusParams{ID: scheduled.ID, Status: giveaway.StatusOpen}); err != nil {
+			t.Fatalf("error opening the giveaway: %v", err)
+		}
+
+		viewer := faketwitch.User{ID: "2001", Login: "viewer", DisplayName: "Viewer"}
+		if _, err := app.Store.CreateViewer(ctx, db.CreateViewerParams{TwitchID: viewer.ID, Username: viewer.Login}); err != nil {
+			t.Fatalf("error creating the viewer: %v", err)
+		}
+		if _, err := app.Store.ImportRedemption(ctx, db.ImportRedemptionParams{
+			MessageID:   "redemption-1",
+			StreamerID:  pgtype.Text{String: streamer.ID, Valid: true},
+			ViewerID:    pgtype.Text{String: viewer.ID, Valid: true},
+			EntryMethod: twitch.EntryMethodChannelPoints,
+			GiveawayID:  scheduled.ID,
+		}); err != nil {
+			t.Fatalf("error adding the entry: %v", err)
+		}
+
+		// The replica closing it stopped before it could draw
+		if _, err := app.Store.CloseScheduledGiveaway(ctx, scheduled.ID); err != nil {
+			t.Fatalf("error closing the giveaway: %v", err)
+		}
+
+		for range 2 {
+			if _, err := app.service.ProcessSchedules(ctx, now); err != nil {
+				t.Fatalf("error processing the schedules: %v", err)
+			}
+		}
+
+		winners, err := app.Store.GetWinnersByGiveaway(ctx, scheduled.ID)
+		if err != nil || len(winners) != 1 || winners[0].ViewerID != viewer.ID {
+			t.Fatalf("got winners %+v (%v), want the viewer once", winners, err)
+		}
+		if pending, err := app.Store.GetGiveawaysPendingAutoDraw(ctx); err != nil || len(pending) != 0 {
+			t.Errorf("got pending auto draws %+v (%v), want none", pending, err)
+		}
+	})
+}
+
+func TestEntriesOutsideSchedule(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		giveaway := app.openGiveaway(t)
+		viewer := faketwitch.User{ID: "2001", Login: "viewer", DisplayName: "Viewer"}
+
+		setSchedule := func(opensAt, closesAt time.Time) {
+			t.Helper()
+			_, err := app.Store.SetGiveawaySchedule(context.Background(), db.SetGiveawayScheduleParams{
+				ID:       giveaway.ID,
+				OpensAt:  pgtype.Timestamptz{Time: opensAt, Valid: !opensAt.IsZero()},
+				ClosesAt: pgtype.Timestamptz{Time: closesAt, Valid: !closesAt.IsZero()},
+			})
+			if err != nil {
+				t.Fatalf("error setting the schedule: %v", err)
+			}
+		}
+
+		// Redeemed before the reward schedule paused the reward
+		setSchedule(time.Now().Add(time.Hour), time.Time{})
+		app.deliver(t, "message-1", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-1", rewardID, viewer))
+		if count := app.entryCount(t, giveaway.ID, viewer); count != 0 {
+			t.Fatalf("got %d entries before the giveaway opens, want 0", count)
+		}
+
+		setSchedule(time.Time{}, time.Now().Add(-time.Minute))
+		app.deliver(t, "message-2", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-2", rewardID, viewer))
+		if count := app.entryCount(t, giveaway.ID, viewer); count != 0 {
+			t.Fatalf("got %d entries after the giveaway closes, want 0", count)
+		}
+
+		setSchedule(time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+		app.deliver(t, "message-3", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-3", rewardID, viewer))
+		if count := app.entryCount(t, giveaway.ID, viewer); count != 1 {
+			t.Fatalf("got %d entries inside the schedule, want 1", count)
+		}
+	})
+}
+
+func TestRedemptionIngestion(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		giveaway := app.openGiveaway(t)
+
+		viewer := faketwitch.User{ID: "2001", Login: "viewer", DisplayName: "Viewer"}
+		app.deliver(t, "message-1", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-1", rewardID, viewer))
+
+		if count := app.entryCount(t, giveaway.ID, viewer); count != 1 {
+			t.Fatalf("got %d entries, want 1", count)
+		}
+
+		saved, err := app.Store.GetViewerByID(context.Background(), viewer.ID)
+		if err != nil || saved.Username != viewer.Login {
+			t.Errorf("viewer %+v, %v, want %s to be created", saved, err, viewer.Login)
+		}
+
+		// Redemptions of other rewards on the channel aren't entries
+		app.deliver(t, "message-2", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-2", "another-reward", viewer))
+
+		if count := app.entryCount(t, giveaway.ID, viewer); count != 1 {
+			t.Errorf("got %d entries after redeeming another reward, want 1", count)
+		}
+	})
+}
+
+func TestDuplicateRedemptions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		giveaway := app.openGiveaway(t)
+
+		viewer := faketwitch.User{ID: "2001", Login: "viewer", DisplayName: "Viewer"}
+		event := redemptionEvent("redemption-1", rewardID, viewer)
+		app.deliver(t, "message-1", "channel.channel_points_custom_reward_redemption.add", event)
+
+		// Twitch retrying the notification, it's acknowledged without running the handler again
+		status, err := app.Twitch.Deliver("message-1", "channel.channel_points_custom_reward_redemption.add", event)
+		if err != nil || status != http.StatusNoContent {
+			t.Fatalf("redelivering the notification: status %d, %v", status, err)
+		}
+
+		// The same redemption in a new notification, from a second subscription or the backfill racing the webhook
+		app.deliver(t, "message-2", "channel.channel_points_custom_reward_redemption.add", event)
+
+		if count := app.entryCount(t, giveaway.ID, viewer); count != 1 {
+			t.Errorf("got %d entries, want 1", count)
+		}
+	})
+}
+
+func TestConcurrentFirstRedemptions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		giveaway := app.openGiveaway(t)
+
+		// A new viewer redeeming several times at once, each entry creating the viewer if it doesn't exist yet
+		viewer := faketwitch.User{ID: "2001", Login: "viewer", DisplayName: "Viewer"}
+		const redemptions = 5
+
+		var wg sync.WaitGroup
+		for i := range redemptions {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				id := strconv.Itoa(i)
+				status, err := app.Twitch.Deliver("message-"+id, "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-"+id, rewardID, viewer))
+				if err != nil || status != http.StatusNoContent {
+					t.Errorf("delivering redemption %s: status %d, %v", id, status, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		eventually(t, "every entry to be added", func() bool {
+			return app.entryCount(t, giveaway.ID, viewer) == redemptions
+		})
+	})
+}
+
+func TestConcurrentDraws(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		ctx := context.Background()
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		open := app.openGiveaway(t)
+
+		for i := range 4 {
+			id := strconv.Itoa(i)
+			viewer := faketwitch.User{ID: "200" + id, Login: "viewer" + id}
+			app.deliver(t, "message-"+id, "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-"+id, rewardID, viewer))
+		}
+
+		prize, err := app.Store.CreatePrize(ctx, db.CreatePrizeParams{GiveawayID: open.ID, Name: "Keyboard", Quantity: 2})
+		if err != nil {
+			t.Fatalf("error creating the prize: %v", err)
+		}
+
+		// Draws of the same prize at once can't award more than its quantity
+		var drawn, awarded atomic.Int32
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, err := app.service.Draw(ctx, giveaway.DrawParams{GiveawayID: open.ID, PrizeID: prize.ID, Count: 1})
+				switch {
+				case err == nil:
+					drawn.Add(1)
+				case errors.Is(err, giveaway.ErrPrizeAwarded):
+					awarded.Add(1)
+				default:
+					t.Errorf("error drawing: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if drawn.Load() != 2 || awarded.Load() != 3 {
+			t.Fatalf("%d draws succeeded and %d found the prize awarded, want 2 and 3", drawn.Load(), awarded.Load())
+		}
+
+		winners, err := app.Store.GetWinnersByGiveaway(ctx, open.ID)
+		if err != nil || len(winners) != 2 {
+			t.Fatalf("got %d winners (%v), want 2", len(winners), err)
+		}
+
+		// A winner is replaced once however many redraws race for it
+		var redrawn atomic.Int32
+		for range 3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, err := app.service.Redraw(ctx, open.ID, winners[0].ID, "")
+				switch {
+				case err == nil:
+					redrawn.Add(1)
+				case !errors.Is(err, giveaway.ErrWinnerNotRedrawable):
+					t.Errorf("error redrawing: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if redrawn.Load() != 1 {
+			t.Errorf("the winner was redrawn %d times, want once", redrawn.Load())
+		}
+		if count, err := app.Store.CountActiveWinnersByPrize(ctx, pgtype.Int8{Int64: prize.ID, Valid: true}); err != nil || count != 2 {
+			t.Errorf("got %d active winners (%v), want 2", count, err)
+		}
+	})
+}
+
+func TestClaimDeadline(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		ctx := context.Background()
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		open := app.openGiveaway(t)
+
+		for i := range 3 {
+			id := strconv.Itoa(i)
+			viewer := faketwitch.User{ID: "200" + id, Login: "viewer" + id}
+			app.deliver(t, "message-"+id, "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-"+id, rewardID, viewer))
+		}
+
+		prize, err := app.Store.CreatePrize(ctx, db.CreatePrizeParams{GiveawayID: open.ID, Name: "Keyboard", Quantity: 2})
+		if err != nil {
+			t.Fatalf("error creating the prize: %v", err)
+		}
+
+		details := giveaway.ClaimDetails{Name: "Viewer", Email: "viewer@example.com", Address: "1 Main Street"}
+
+		onTime, err := app.service.Draw(ctx, giveaway.DrawParams{GiveawayID: open.ID, PrizeID: prize.ID, Count: 1})
+		if err != nil {
+			t.Fatalf("error drawing: %v", err)
+		}
+		late, err := app.service.Draw(ctx, giveaway.DrawParams{GiveawayID: open.ID, PrizeID: prize.ID, Count: 1, ClaimWindow: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("error drawing: %v", err)
+		}
+
+		if _, err := app.service.Claim(ctx, onTime.Winners[0].ID, onTime.Winners[0].ViewerID, details); err != nil {
+			t.Fatalf("error claiming before the deadline: %v", err)
+		}
+
+		// Claims after the deadline fail before the expiry job got to them
+		time.Sleep(100 * time.Millisecond)
+		if _, err := app.service.Claim(ctx, late.Winners[0].ID, late.Winners[0].ViewerID, details); !errors.Is(err, giveaway.ErrClaimExpired) {
+			t.Fatalf("claiming after the deadline: %v, want %v", err, giveaway.ErrClaimExpired)
+		}
+
+		// Running the expiry again doesn't replace the winner a second time
+		for range 2 {
+			if err := app.service.ExpireClaims(ctx); err != nil {
+				t.Fatalf("error expiring claims: %v", err)
+			}
+		}
+
+		winners, err := app.Store.GetWinnersByGiveaway(ctx, open.ID)
+		if err != nil {
+			t.Fatalf("error getting the winners: %v", err)
+		}
+
+		statuses := map[string]int{}
+		for _, winner := range winners {
+			statuses[winner.Status]++
+		}
+		want := map[string]int{giveaway.WinnerClaimed: 1, giveaway.WinnerRedrawn: 1, giveaway.WinnerPendingClaim: 1}
+		if !maps.Equal(statuses, want) {
+			t.Errorf("winner statuses %v, want %v", statuses, want)
+		}
+	})
+}
+
+// Opting out deletes everything stored about the viewer, without taking the redraw of their win with it
+func TestOptOut(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		ctx := context.Background()
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		open := app.openGiveaway(t)
+
+		leaving := faketwitch.User{ID: "2001", Login: "leaving"}
+		staying := faketwitch.User{ID: "2002", Login: "staying"}
+		app.deliver(t, "message-1", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-1", rewardID, leaving))
+
+		prize, err := app.Store.CreatePrize(ctx, db.CreatePrizeParams{GiveawayID: open.ID, Name: "Keyboard", Quantity: 1})
+		if err != nil {
+			t.Fatalf("error creating the prize: %v", err)
+		}
+		won, err := app.service.Draw(ctx, giveaway.DrawParams{GiveawayID: open.ID, PrizeID: prize.ID, Count: 1})
+		if err != nil {
+			t.Fatalf("error drawing: %v", err)
+		}
+
+		// The only other entry comes in after the draw, so the redraw goes to it
+		app.deliver(t, "message-2", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-2", rewardID, staying))
+		redrawn, err := app.service.Redraw(ctx, open.ID, won.Winners[0].ID, "")
+		if err != nil {
+			t.Fatalf("error redrawing: %v", err)
+		}
+		if redrawn.Winners[0].ViewerID != staying.ID || !redrawn.Winners[0].RedrawnFrom.Valid {
+			t.Fatalf("got redraw %+v, want %s linked to the first win", redrawn.Winners[0], staying.Login)
+		}
+
+		// Opting out twice is the same as once
+		for range 2 {
+			if err := app.Store.OptOutViewer(ctx, leaving.ID); err != nil {
+				t.Fatalf("error opting out: %v", err)
+			}
+		}
+
+		if optedOut, err := app.Store.IsViewerOptedOut(ctx, leaving.ID); err != nil || !optedOut {
+			t.Errorf("IsViewerOptedOut = %t, %v, want true", optedOut, err)
+		}
+		if _, err := app.Store.GetViewerByID(ctx, leaving.ID); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("getting the viewer: %v, want them deleted", err)
+		}
+		if count := app.entryCount(t, open.ID, leaving); count != 0 {
+			t.Errorf("got %d entries of the viewer who opted out, want them deleted", count)
+		}
+
+		winners, err := app.Store.GetWinnersByGiveaway(ctx, open.ID)
+		if err != nil {
+			t.Fatalf("error getting the winners: %v", err)
+		}
+		if len(winners) != 1 || winners[0].ViewerID != staying.ID || winners[0].RedrawnFrom.Valid {
+			t.Errorf("got winners %+v, want only the redraw without its link to the deleted win", winners)
+		}
+		if count := app.entryCount(t, open.ID, staying); count != 1 {
+			t.Errorf("got %d entries of the other viewer, want 1", count)
+		}
+
+		// Later entries of the viewer are ignored
+		app.deliver(t, "message-3", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-3", rewardID, leaving))
+		if count := app.entryCount(t, open.ID, leaving); count != 0 {
+			t.Errorf("got %d entries after opting out, want 0", count)
+		}
+	})
+}
+
+// A prize that went to a viewer who opted out since stays awarded
+// The backfill endpoint only goes through the queues of the streamer asking, and never runs next to another backfill
+func TestBackfillEndpoint(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		other := faketwitch.User{ID: "1002", Login: "other", DisplayName: "Other", BroadcasterType: "affiliate"}
+		app.signIn(t, other)
+		app.addReward(t, other)
+		app.signInVerified(t, streamer)
+		app.addReward(t, streamer)
+		app.openGiveaway(t)
+
+		resp := app.do(t, http.MethodPost, "/backfill")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d, want 200", resp.StatusCode)
+		}
+		var result twitch.BackfillResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Streamers != 1 || result.Errors != 0 {
+			t.Errorf("got %+v, want only the signed in streamer backfilled", result)
+		}
+
+		lock, acquired, err := app.Store.TryLock(context.Background(), "backfill")
+		if err != nil || !acquired {
+			t.Fatalf("error taking the backfill lock: %v", err)
+		}
+		if resp := app.do(t, http.MethodPost, "/backfill"); resp.StatusCode != http.StatusConflict {
+			t.Errorf("got status %d while another replica backfills, want 409", resp.StatusCode)
+		}
+		lock.Release()
+	})
+}
+
+// The draw is answered while the winners are still being announced, a slow chat doesn't hold it up
+func TestDrawAnnouncesInBackground(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		ctx := context.Background()
+		app.signInVerified(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		open := app.openGiveaway(t)
+
+		viewer := faketwitch.User{ID: "2001", Login: "viewer"}
+		app.deliver(t, "message-1", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-1", rewardID, viewer))
+
+		prize, err := app.Store.CreatePrize(ctx, db.CreatePrizeParams{GiveawayID: open.ID, Name: "Keyboard", Quantity: 1})
+		if err != nil {
+			t.Fatalf("error creating the prize: %v", err)
+		}
+
+		release := app.Twitch.HoldChat()
+		defer release()
+
+		resp := app.doJSON(t, http.MethodPost, fmt.Sprintf("/giveaways/%d/draw", open.ID), api.DrawRequest{PrizeID: prize.ID, Count: 1, Announce: true})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d, want 200", resp.StatusCode)
+		}
+		var draw api.DrawResponse
+		if err := json.NewDecoder(resp.Body).Decode(&draw); err != nil {
+			t.Fatal(err)
+		}
+		if !draw.Announcing || len(draw.Winners) != 1 || draw.Winners[0].ViewerID != viewer.ID {
+			t.Fatalf("got %+v, want the viewer drawn and being announced", draw)
+		}
+		if messages := app.Twitch.ChatMessages(streamer.ID); len(messages) != 0 {
+			t.Fatalf("got %v sent while the chat was held", messages)
+		}
+
+		release()
+		eventually(t, "the announcement", func() bool { return len(app.Twitch.ChatMessages(streamer.ID)) == 1 })
+	})
+}
+
+func TestOptOutKeepsPrizeAwarded(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		ctx := context.Background()
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		open := app.openGiveaway(t)
+
+		leaving := faketwitch.User{ID: "2001", Login: "leaving"}
+		app.deliver(t, "message-1", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-1", rewardID, leaving))
+
+		prize, err := app.Store.CreatePrize(ctx, db.CreatePrizeParams{GiveawayID: open.ID, Name: "Keyboard", Quantity: 1})
+		if err != nil {
+			t.Fatalf("error creating the prize: %v", err)
+		}
+		if _, err := app.service.Draw(ctx, giveaway.DrawParams{GiveawayID: open.ID, PrizeID: prize.ID, Count: 1}); err != nil {
+			t.Fatalf("error drawing: %v", err)
+		}
+
+		staying := faketwitch.User{ID: "2002", Login: "staying"}
+		app.deliver(t, "message-2", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-2", rewardID, staying))
+
+		if err := app.Store.OptOutViewer(ctx, leaving.ID); err != nil {
+			t.Fatalf("error opting out: %v", err)
+		}
+
+		if count, err := app.Store.CountActiveWinnersByPrize(ctx, pgtype.Int8{Int64: prize.ID, Valid: true}); err != nil || count != 1 {
+			t.Errorf("got %d active winners (%v), want the viewer who opted out counted", count, err)
+		}
+		if _, err := app.service.Draw(ctx, giveaway.DrawParams{GiveawayID: open.ID, PrizeID: prize.ID, Count: 1}); !errors.Is(err, giveaway.ErrPrizeAwarded) {
+			t.Fatalf("drawing the prize again: %v, want %v", err, giveaway.ErrPrizeAwarded)
+		}
+	})
+}
+
+func TestLiveStatus(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		app.signIn(t, streamer)
+
+		event := map[string]any{
+			"id":                     "stream-1",
+			"broadcaster_user_id":    streamer.ID,
+			"broadcaster_user_login": streamer.Login,
+			"broadcaster_user_name":  streamer.DisplayName,
+			"type":                   "live",
+			"started_at":             time.Now().UTC().Format(time.RFC3339),
+		}
+
+		isLive := func() bool {
+			saved, err := app.Store.GetStreamerByID(context.Background(), streamer.ID)
+			if err != nil {
+				t.Fatalf("error getting the streamer: %v", err)
+			}
+			return saved.IsLive.Bool
+		}
+
+		app.deliver(t, "message-1", "stream.online", event)
+		if !isLive() {
+			t.Errorf("the streamer isn't live after stream.online")
+		}
+
+		delete(event, "type")
+		delete(event, "started_at")
+		app.deliver(t, "message-2", "stream.offline", event)
+		if isLive() {
+			t.Errorf("the streamer is still live after stream.offline")
+		}
+	})
+}
+
+// TestReplicas runs what a second replica would next to the app, sharing its store
+func TestReplicas(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		app.openGiveaway(t)
+
+		ctx, cancel := context.WithCancel(logging.WithLogger(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil))))
+		var background sync.WaitGroup
+		t.Cleanup(func() {
+			cancel()
+			background.Wait()
+		})
+
+		other := feed.NewBroker()
+		otherEntries, unsubscribeOther := other.Subscribe()
+		defer unsubscribeOther()
+		entries, unsubscribe := app.feed.Subscribe()
+		defer unsubscribe()
+
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			other.Relay(ctx, app.Store)
+		}()
+
+		// The relay starts listening in the background, entries before that don't reach it
+		viewer := faketwitch.User{ID: "2001", Login: "viewer", DisplayName: "Viewer"}
+		attempt := 0
+		eventually(t, "an entry to reach the other replica's feed", func() bool {
+			attempt++
+			id := strconv.Itoa(attempt)
+			app.deliver(t, "message-"+id, "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-"+id, rewardID, viewer))
+
+			select {
+			case entry := <-otherEntries:
+				return entry.ViewerUsername == viewer.Login
+			case <-time.After(100 * time.Millisecond):
+				return false
+			}
+		})
+
+		// The app's own clients got every entry once, not again through the relay
+		time.Sleep(100 * time.Millisecond)
+		if len(entries) != attempt {
+			t.Fatalf("got %d entries in the app's feed, want %d", len(entries), attempt)
+		}
+
+		// One replica leads at a time and the other takes over once it stops
+		var leading atomic.Int32
+		var overlapped atomic.Bool
+		var led [2]atomic.Bool
+		var stop [2]context.CancelFunc
+		for i := range 2 {
+			replicaCtx, stopReplica := context.WithCancel(ctx)
+			stop[i] = stopReplica
+
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				leader.Run(replicaCtx, app.Store, "test", 20*time.Millisecond, func(ctx context.Context) {
+					if leading.Add(1) > 1 {
+						overlapped.Store(true)
+					}
+					led[i].Store(true)
+					<-ctx.Done()
+					leading.Add(-1)
+				})
+			}()
+		}
+
+		eventually(t, "a leader", func() bool { return led[0].Load() || led[1].Load() })
+		first := 0
+		if led[1].Load() {
+			first = 1
+		}
+
+		time.Sleep(200 * time.Millisecond)
+		if led[1-first].Load() {
+			t.Fatal("both replicas became leader")
+		}
+
+		stop[first]()
+		eventually(t, "the other replica to take over", func() bool { return led[1-first].Load() })
+		if overlapped.Load() {
+			t.Fatal("both replicas led at the same time")
+		}
+	})
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	webSocket := func(cfg *config.Twitch) { cfg.EventSubTransport = config.TransportWebSocket }
+
+	forEachStoreWith(t, webSocket, func(t *testing.T, app *testApp) {
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		giveaway := app.openGiveaway(t)
+		viewer := faketwitch.User{ID: "2001", Login: "viewer", DisplayName: "Viewer"}
+
+		// subscribed checks every event is subscribed to on one connected session and returns it
+		subscribed := func() (string, bool) {
+			sessions := app.Twitch.Sessions()
+			if len(sessions) != 1 {
+				return "", false
+			}
+
+			enabled := 0
+			for _, sub := range app.Twitch.Subscriptions() {
+				if sub.Status == "enabled" && sub.Transport.Method == "websocket" && sub.Transport.SessionID == sessions[0] {
+					enabled++
+				}
+			}
+			return sessions[0], enabled == len(events)
+		}
+
+		session, ok := subscribed()
+		if !ok {
+			t.Fatalf("the streamer isn't subscribed on the session: %+v", app.Twitch.Subscriptions())
+		}
+
+		resp := app.do(t, http.MethodPost, "/eventsub")
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("POST /eventsub with the WebSocket transport: status %d, want 404", resp.StatusCode)
+		}
+
+		app.deliver(t, "message-1", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-1", rewardID, viewer))
+		if got := app.entryCount(t, giveaway.ID, viewer); got != 1 {
+			t.Fatalf("got %d entries after the first redemption, want 1", got)
+		}
+
+		// A reconnect moves the session and its subscriptions to a new connection
+		if err := app.Twitch.Reconnect(); err != nil {
+			t.Fatal(err)
+		}
+		app.deliver(t, "message-2", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-2", rewardID, viewer))
+		if got := app.entryCount(t, giveaway.ID, viewer); got != 2 {
+			t.Fatalf("got %d entries after the reconnect, want 2", got)
+		}
+		if current, ok := subscribed(); !ok || current != session {
+			t.Fatalf("the subscriptions didn't stay on session %s: %+v", session, app.Twitch.Subscriptions())
+		}
+
+		// Without keepalives the session counts as lost and a new one is subscribed again
+		app.Twitch.Silence()
+		eventually(t, "a new subscribed session", func() bool {
+			current, ok := subscribed()
+			return ok && current != session
+		})
+		app.deliver(t, "message-3", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-3", rewardID, viewer))
+		if got := app.entryCount(t, giveaway.ID, viewer); got != 3 {
+			t.Fatalf("got %d entries after the new session, want 3", got)
+		}
+	})
+}
+
+// TestConduitMigration moves the per-streamer webhook subscriptions of an earlier deployment to a conduit
+func TestConduitMigration(t *testing.T) {
+	conduit := func(cfg *config.Twitch) { cfg.ConduitShards = 1 }
+
+	forEachStoreWith(t, conduit, func(t *testing.T, app *testApp) {
+		eventually(t, "the shard to be verified", func() bool {
+			conduits := app.Twitch.Conduits()
+			return len(conduits) == 1 && conduits[0].Shards[0].Status == "enabled"
+		})
+		conduitID := app.Twitch.Conduits()[0].ID
+
+		twitchConfig := app.Twitch.Config(app.URL + "/eventsub")
+		for _, event := range events {
+			app.Twitch.AddWebhookSubscription(event, streamer.ID, twitchConfig.WebhookURL, twitchConfig.WebhookSecret)
+		}
+
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		giveaway := app.openGiveaway(t)
+
+		ctx := logging.WithLogger(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+		dryRun, err := app.webhook.ReconcileSubscriptions(ctx, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := twitch.ReconcileResult{Expected: len(events), Existing: 2 * len(events), Removed: len(events)}
+		if *dryRun != want {
+			t.Fatalf("dry run: got %+v, want %+v", *dryRun, want)
+		}
+		if got := len(app.Twitch.Subscriptions()); got != 2*len(events) {
+			t.Fatalf("the dry run changed the subscriptions, %d left", got)
+		}
+
+		result, err := app.webhook.ReconcileSubscriptions(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *result != want {
+			t.Fatalf("got %+v, want %+v", *result, want)
+		}
+
+		subscriptions := app.Twitch.Subscriptions()
+		if len(subscriptions) != len(events) {
+			t.Fatalf("got %d subscriptions after the migration, want %d", len(subscriptions), len(events))
+		}
+		for _, sub := range subscriptions {
+			if sub.Transport.Method != "conduit" || sub.Transport.ConduitID != conduitID {
+				t.Errorf("%s subscription isn't on the conduit: %+v", sub.Type, sub.Transport)
+			}
+		}
+
+		viewer := faketwitch.User{ID: "2001", Login: "viewer", DisplayName: "Viewer"}
+		app.deliver(t, "message-1", "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-1", rewardID, viewer))
+		if got := app.entryCount(t, giveaway.ID, viewer); got != 1 {
+			t.Fatalf("got %d entries through the conduit, want 1", got)
+		}
+	})
+}
+
+// TestConduitWebSocketShards keeps the conduit subscriptions while the shards' sessions are replaced
+func TestConduitWebSocketShards(t *testing.T) {
+	conduit := func(cfg *config.Twitch) {
+		cfg.EventSubTransport = config.TransportWebSocket
+		cfg.ConduitShards = 2
+	}
+
+	forEachStoreWith(t, conduit, func(t *testing.T, app *testApp) {
+		// assigned returns the sessions of the shards once every shard is enabled
+		assigned := func() ([]string, bool) {
+			conduits := app.Twitch.Conduits()
+			if len(conduits) != 1 || len(conduits[0].Shards) != 2 {
+				return nil, false
+			}
+
+			var sessions []string
+			for _, shard := range conduits[0].Shards {
+				if shard.Status != "enabled" || shard.Transport.Method != "websocket" {
+					return nil, false
+				}
+				sessions = append(sessions, shard.Transport.SessionID)
+			}
+			return sessions, sessions[0] != sessions[1]
+		}
+
+		eventually(t, "both shards to be assigned", func() bool {
+			_, ok := assigned()
+			return ok
+		})
+		sessions, _ := assigned()
+
+		app.signIn(t, streamer)
+		rewardID := app.addReward(t, streamer)
+		giveaway := app.openGiveaway(t)
+		viewer := faketwitch.User{ID: "2001", Login: "viewer", DisplayName: "Viewer"}
+
+		subscriptions := app.Twitch.Subscriptions()
+		if len(subscriptions) != len(events) {
+			t.Fatalf("got %d subscriptions, want %d", len(subscriptions), len(events))
+		}
+		for _, sub := range subscriptions {
+			if sub.Transport.Method != "conduit" {
+				t.Errorf("%s subscription isn't on the conduit: %+v", sub.Type, sub.Transport)
+			}
+		}
+
+		// The shards take turns
+		redeem := func(n int) {
+			id := strconv.Itoa(n)
+			app.deliver(t, "message-"+id, "channel.channel_points_custom_reward_redemption.add", redemptionEvent("redemption-"+id, rewardID, viewer))
+			if got := app.entryCount(t, giveaway.ID, viewer); got != int64(n) {
+				t.Fatalf("got %d entries after redemption %d, want %d", got, n, n)
+			}
+		}
+		redeem(1)
+		redeem(2)
+
+		// Lost sessions are replaced and assigned to their shards, the subscriptions stay on the conduit
+		app.Twitch.Silence()
+		eventually(t, "the shards to be assigned to new sessions", func() bool {
+			current, ok := assigned()
+			return ok && !slices.Contains(sessions, current[0]) && !slices.Contains(sessions, current[1])
+		})
+		if got := len(app.Twitch.Subscriptions()); got != len(events) {
+			t.Fatalf("got %d subscriptions after the new sessions, want %d", got, len(events))
+		}
+		redeem(3)
+		redeem(4)
+	})
+}
+
+func TestInvalidSignature(t *testing.T) {
+	forEachStore(t, func(t *testing.T, app *testApp) {
+
+		req, _ := http.NewRequest(http.MethodPost, app.URL+"/eventsub", strings.NewReader(`{"subscription":{"type":"stream.online"},"event":{}}`))
+		req.Header.Set("Twitch-Eventsub-Message-Id", "message-1")
+		req.Header.Set("Twitch-Eventsub-Message-Timestamp", time.Now().UTC().Format(time.RFC3339))
+		req.Header.Set("Twitch-Eventsub-Message-Signature", "sha256=0000")
+		req.Header.Set("Twitch-Eventsub-Message-Type", "notification")
+
+		resp, err := app.client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("notification with a bad signature: status %d, want 403", resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/logging"
+)
+
+const (
+	// Twitch asks apps to validate the tokens they use once an hour
+	tokenValidationTTL = time.Hour
+	// Requests past this age get the cached answer while the token is revalidated in the background
+	tokenRevalidateAfter   = 45 * time.Minute
+	tokenRevalidateTimeout = 10 * time.Second
+	maxCachedTokens        = 10000
+)
+
+type tokenEntry struct {
+	valid        bool
+	checkedAt    time.Time
+	revalidating bool
+}
+
+// tokenCache remembers what Twitch said about an access token so authMiddleware doesn't ask on every request.
+// Tokens are only kept as hashes, a background revalidation uses the token of the request that triggered it.
+type tokenCache struct {
+	validate func(ctx context.Context, token string) (bool, error)
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*tokenEntry
+}
+
+func newTokenCache(validate func(ctx context.Context, token string) (bool, error)) *tokenCache {
+	return &tokenCache{
+		validate: validate,
+		entries:  make(map[[sha256.Size]byte]*tokenEntry),
+	}
+}
+
+// Valid reports whether Twitch accepted the token, asking it only when the last answer is over an hour old
+func (c *tokenCache) Valid(ctx context.Context, token string) (bool, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && now.Sub(entry.checkedAt) < tokenValidationTTL {
+		if entry.valid && !entry.revalidating && now.Sub(entry.checkedAt) >= tokenRevalidateAfter {
+			entry.revalidating = true
+			go c.revalidate(context.WithoutCancel(ctx), key, token)
+		}
+		valid := entry.valid
+		c.mu.Unlock()
+		return valid, nil
+	}
+	c.mu.Unlock()
+
+	valid, err := c.validate(ctx, token)
+	if err != nil {
+		return false, err
+	}
+
+	c.store(key, valid)
+	return valid, nil
+}
+
+// Remember caches a token Twitch just issued as valid
+func (c *tokenCache) Remember(token string) {
+	c.store(sha256.Sum256([]byte(token)), true)
+}
+
+// Forget drops a token, for example on logout
+func (c *tokenCache) Forget(token string) {
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+func (c *tokenCache) revalidate(ctx context.Context, key [sha256.Size]byte, token string) {
+	ctx, cancel := context.WithTimeout(ctx, tokenRevalidateTimeout)
+	defer cancel()
+
+	valid, err := c.validate(ctx, token)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Error revalidating an access token", "error", err)
+
+		c.mu.Lock()
+		if entry, ok := c.entries[key]; ok {
+			entry.revalidating = false
+		}
+		c.mu.Unlock()
+		return
+	}
+
+	c.store(key, valid)
+}
+
+func (c *tokenCache) store(key [sha256.Size]byte, valid bool) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCachedTokens {
+		c.evict(now)
+	}
+
+	c.entries[key] = &tokenEntry{valid: valid, checkedAt: now}
+}
+
+// evict drops the expired entries, or the one checked longest ago when none expired, so the cache stays under
+// maxCachedTokens however many tokens are used within an hour
+func (c *tokenCache) evict(now time.Time) {
+	var oldestKey [sha256.Size]byte
+	var oldest *tokenEntry
+	for k, entry := range c.entries {
+		if now.Sub(entry.checkedAt) >= tokenValidationTTL {
+			delete(c.entries, k)
+			continue
+		}
+		if oldest == nil || entry.checkedAt.Before(oldest.checkedAt) {
+			oldestKey, oldest = k, entry
+		}
+	}
+
+	if len(c.entries) >= maxCachedTokens {
+		delete(c.entries, oldestKey)
+	}
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeValidator stands in for Twitch's validate endpoint, counting the calls
+type fakeValidator struct {
+	mu    sync.Mutex
+	calls int
+	valid bool
+	err   error
+}
+
+func (v *fakeValidator) validate(ctx context.Context, token string) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.calls++
+	return v.valid, v.err
+}
+
+func (v *fakeValidator) answer(valid bool, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.valid, v.err = valid, err
+}
+
+func (v *fakeValidator) count() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.calls
+}
+
+// age makes the cached answer about token older
+func (c *tokenCache) age(token string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[sha256.Sum256([]byte(token))].checkedAt = time.Now().Add(-d)
+}
+
+func (c *tokenCache) isRevalidating(token string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[sha256.Sum256([]byte(token))].revalidating
+}
+
+func checkValid(t *testing.T, cache *tokenCache, token string, want bool) {
+	t.Helper()
+	valid, err := cache.Valid(context.Background(), token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if valid != want {
+		t.Fatalf("Valid(%s) = %t, want %t", token, valid, want)
+	}
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTokenCacheCachesAnswers(t *testing.T) {
+	for _, valid := range []bool{true, false} {
+		validator := &fakeValidator{valid: valid}
+		cache := newTokenCache(validator.validate)
+
+		checkValid(t, cache, "token", valid)
+		checkValid(t, cache, "token", valid)
+		if got := validator.count(); got != 1 {
+			t.Errorf("asked Twitch %d times about a token it said is valid=%t, want once", got, valid)
+		}
+
+		checkValid(t, cache, "other-token", valid)
+		if got := validator.count(); got != 2 {
+			t.Errorf("asked Twitch %d times after a second token, want twice", got)
+		}
+	}
+}
+
+func TestTokenCacheDoesntCacheErrors(t *testing.T) {
+	validator := &fakeValidator{err: errors.New("twitch is down")}
+	cache := newTokenCache(validator.validate)
+
+	if _, err := cache.Valid(context.Background(), "token"); err == nil {
+		t.Fatal("the error was swallowed")
+	}
+
+	validator.answer(true, nil)
+	checkValid(t, cache, "token", true)
+	if got := validator.count(); got != 2 {
+		t.Errorf("asked Twitch %d times, want the token validated again after the error", got)
+	}
+}
+
+func TestTokenCacheRememberAndForget(t *testing.T) {
+	validator := &fakeValidator{valid: false}
+	cache := newTokenCache(validator.validate)
+
+	cache.Remember("token")
+	checkValid(t, cache, "token", true)
+	if got := validator.count(); got != 0 {
+		t.Errorf("asked Twitch %d times about a token it just issued", got)
+	}
+
+	cache.Forget("token")
+	checkValid(t, cache, "token", false)
+	if got := validator.count(); got != 1 {
+		t.Errorf("asked Twitch %d times about a forgotten token, want once", got)
+	}
+}
+
+func TestTokenCacheExpires(t *testing.T) {
+	validator := &fakeValidator{valid: true}
+	cache := newTokenCache(validator.validate)
+
+	checkValid(t, cache, "token", true)
+	cache.age("token", tokenValidationTTL)
+
+	// An answer over an hour old isn't trusted, the request waits for Twitch
+	validator.answer(false, nil)
+	checkValid(t, cache, "token", false)
+	if got := validator.count(); got != 2 {
+		t.Errorf("asked Twitch %d times, want the expired token validated again", got)
+	}
+}
+
+func TestTokenCacheRevalidatesInBackground(t *testing.T) {
+	validator := &fakeValidator{valid: true}
+	cache := newTokenCache(validator.validate)
+
+	checkValid(t, cache, "token", true)
+	cache.age("token", tokenRevalidateAfter)
+
+	// The requests get the cached answer while a single revalidation runs
+	validator.answer(false, nil)
+	for range 5 {
+		checkValid(t, cache, "token", true)
+	}
+
+	waitFor(t, "the revalidation", func() bool { return !cache.isRevalidating("token") })
+	if got := validator.count(); got != 2 {
+		t.Errorf("asked Twitch %d times, want one revalidation", got)
+	}
+
+	// Twitch revoked the token in the meantime
+	checkValid(t, cache, "token", false)
+}
+
+func TestTokenCacheRevalidationError(t *testing.T) {
+	validator := &fakeValidator{valid: true}
+	cache := newTokenCache(validator.validate)
+
+	checkValid(t, cache, "token", true)
+	cache.age("token", tokenRevalidateAfter)
+
+	validator.answer(false, errors.New("twitch is down"))
+	checkValid(t, cache, "token", true)
+	waitFor(t, "the failed revalidation", func() bool { return !cache.isRevalidating("token") })
+
+	// The cached answer stands and the next request tries again
+	validator.answer(true, nil)
+	checkValid(t, cache, "token", true)
+	waitFor(t, "the second revalidation", func() bool { return validator.count() == 3 })
+}
+
+func TestTokenCacheInvalidNotRevalidated(t *testing.T) {
+	validator := &fakeValidator{valid: false}
+	cache := newTokenCache(validator.validate)
+
+	checkValid(t, cache, "token", false)
+	cache.age("token", tokenRevalidateAfter)
+
+	// Twitch doesn't make a token valid again, there's nothing to refresh until the answer expires
+	checkValid(t, cache, "token", false)
+	if cache.isRevalidating("token") || validator.count() != 1 {
+		t.Errorf("an invalid token was revalidated")
+	}
+}
+
+func TestTokenCacheCapped(t *testing.T) {
+	cache := newTokenCache((&fakeValidator{valid: true}).validate)
+
+	for i := range maxCachedTokens {
+		cache.Remember(strconv.Itoa(i))
+	}
+	// None expired, the one checked longest ago makes room
+	cache.age("0", time.Minute)
+
+	cache.Remember("one-more")
+	if len(cache.entries) != maxCachedTokens {
+		t.Fatalf("got %d cached tokens, want at most %d", len(cache.entries), maxCachedTokens)
+	}
+	if _, ok := cache.entries[sha256.Sum256([]byte("0"))]; ok {
+		t.Error("the oldest token is still cached")
+	}
+	if _, ok := cache.entries[sha256.Sum256([]byte("one-more"))]; !ok {
+		t.Error("the new token wasn't cached")
+	}
+
+	// Remembering a cached token again doesn't push another one out
+	cache.Remember("one-more")
+	if _, ok := cache.entries[sha256.Sum256([]byte("1"))]; !ok {
+		t.Error("a token was dropped for one already cached")
+	}
+}
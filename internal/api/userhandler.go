@@ -1,9 +1,9 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log/slog"
 	"net/http"
 
 	"github.com/gamis65/twitch-points/internal/db"
@@ -11,6 +11,8 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/nicklaw5/helix/v2"
+
+	eventSub "github.com/gamis65/twitch-points/internal/twitch"
 )
 
 type ChannelCustomRewardsParams struct {
@@ -45,6 +47,7 @@ type MeResponse struct {
 	TwitchID        string `json:"twitch_id"`
 	Username        string `json:"username"`
 	ProfileImageUrl string `json:"profile_image_url"`
+	ChannelPoints   bool   `json:"channel_points"` // Only affiliates and partners can add a reward
 }
 
 type UserData struct {
@@ -52,25 +55,19 @@ type UserData struct {
 	IsLive bool `json:"is_live"`
 }
 
-func (s *Server) getUserData(accessToken string) (*UserData, error) {
-	client, err := helix.NewClient(&helix.Options{
-		ClientID:     s.oauthConfig.ClientID,
-		ClientSecret: s.oauthConfig.ClientSecret,
-	})
-
+func (s *Server) getUserData(ctx context.Context, accessToken string) (*UserData, error) {
+	client, err := s.twitchAPI.UserClient(ctx, accessToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Twitch client: %w", err)
+		return nil, err
 	}
 
-	client.SetUserAccessToken(accessToken)
-
 	resp, err := client.GetUsers(&helix.UsersParams{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user info: %w", err)
 	}
 
-	if resp.Error != "" {
-		return nil, fmt.Errorf("twitch API error: %s", resp.Error)
+	if err := eventSub.HelixError("/users", resp.ResponseCommon); err != nil {
+		return nil, err
 	}
 
 	if len(resp.Data.Users) == 0 {
@@ -82,6 +79,10 @@ func (s *Server) getUserData(accessToken string) (*UserData, error) {
 	})
 
 	if err != nil {
+		return nil, fmt.Errorf("failed to get stream info: %w", err)
+	}
+
+	if err := eventSub.HelixError("/streams", stream.ResponseCommon); err != nil {
 		return nil, err
 	}
 
@@ -93,105 +94,197 @@ func (s *Server) getUserData(accessToken string) (*UserData, error) {
 }
 
 func (s *Server) meHandler(w http.ResponseWriter, r *http.Request) {
-	session, err := s.sessionStore.Get(r, "twitch-oauth-session")
+	auth, err := getRequestAuth(r)
 	if err != nil {
-		slog.Error("Error getting session", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		s.log(r).Error("Failed to get the signed in streamer", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	accessToken := session.Values["access_token"].(string)
-
-	userData, err := s.getUserData(accessToken)
-	if err != nil {
-		slog.Error("Error getting user data", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-	}
-
 	util.SendJSON(w, &MeResponse{
-		TwitchID:        userData.ID,
-		Username:        userData.Login,
-		ProfileImageUrl: userData.ProfileImageURL,
+		TwitchID:        auth.Streamer.TwitchID,
+		Username:        auth.Streamer.Username,
+		ProfileImageUrl: auth.Streamer.ProfileImageUrl.String,
+		ChannelPoints:   eventSub.HasChannelPoints(auth.Streamer),
 	})
-
 }
 
 func (s *Server) addRewardHandler(w http.ResponseWriter, r *http.Request) {
-	client, err := helix.NewClient(&helix.Options{
-		ClientID:     s.oauthConfig.ClientID,
-		ClientSecret: s.oauthConfig.ClientSecret,
-	})
-
-	session, err := s.sessionStore.Get(r, "twitch-oauth-session")
+	auth, err := getRequestAuth(r)
 	if err != nil {
-		slog.Error("Error getting session", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		s.log(r).Error("Failed to get the signed in streamer", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	userID, ok := session.Values["user_id"].(string)
-	if !ok || userID == "" {
-		slog.Error("User ID not found in session")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	userID := auth.Streamer.TwitchID
+	streamerID := pgtype.Text{String: userID, Valid: true}
+	logger := s.log(r)
+
+	// Twitch only lets affiliates and partners create rewards, the others take entries through the chat command
+	if !eventSub.HasChannelPoints(auth.Streamer) {
+		logger.Info("A streamer without channel points tried to add a reward")
+		http.Error(w, "Channel points are only available to affiliates and partners, use the chat command instead", http.StatusForbidden)
 		return
 	}
 
-	logger := s.logger.With(
-		slog.String("user_id", userID),
-	)
-
-	accessToken, ok := session.Values["access_token"].(string)
-	if !ok || accessToken == "" {
-		logger.Error("Access token not found in session")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// New and retired rewards are linked to the open giveaway
+	var giveawayID pgtype.Int8
+	giveaway, err := s.db.GetOpenGiveaway(r.Context())
+	if err == nil {
+		giveawayID = pgtype.Int8{Int64: giveaway.ID, Valid: true}
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		logger.Error("Error getting the open giveaway", "error", err)
+		http.Error(w, "Error getting the open giveaway", http.StatusInternalServerError)
 		return
 	}
 
-	existingReward, err := s.db.GetRewardsByStreamer(r.Context(), pgtype.Text{String: userID, Valid: true})
+	existingReward, err := s.db.GetActiveRewardByStreamer(r.Context(), streamerID)
 	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		logger.Error("Error getting streamers rewards from the database", "error", err)
 		http.Error(w, "Error getting streamers rewards from the database", http.StatusInternalServerError)
 		return
 	}
 
-	if len(existingReward) > 0 {
-		err := s.db.DeleteRewardsByStreamerID(r.Context(), pgtype.Text{String: userID, Valid: true})
-		if err != nil {
-			logger.Error("Error while trying to delete existing rewards", "error", err)
-			http.Error(w, "Error while trying to delete existing rewards", http.StatusInternalServerError)
+	hasExisting := err == nil
+
+	// Twitch wants the rewards of a channel to have different titles, so the old one gives up its title.
+	// It stays redeemable until the new one exists.
+	if hasExisting {
+		err := s.twitchAPI.RenameCustomReward(r.Context(), auth.AccessToken, userID, existingReward.RewardID, retiredRewardTitle(existingReward.RewardID))
+		if err != nil && !eventSub.IsNotFound(err) {
+			logger.Error("Failed to rename the old channel point reward", "error", err, "reward_id", existingReward.RewardID)
+			http.Error(w, "Failed to rename the old channel point reward", http.StatusInternalServerError)
 			return
 		}
-		logger.Info("Existing rewards deleted for user")
+	}
+
+	rewardID, err := s.createEntryReward(r.Context(), auth.AccessToken, userID)
+	if err != nil {
+		logger.Error("Failed to create a channel point reward", "error", err)
+		if hasExisting {
+			err := s.twitchAPI.RenameCustomReward(r.Context(), auth.AccessToken, userID, existingReward.RewardID, entryRewardTitle)
+			if err != nil && !eventSub.IsNotFound(err) {
+				logger.Error("Failed to restore the title of the old channel point reward", "error", err, "reward_id", existingReward.RewardID)
+			}
+		}
+		http.Error(w, "Failed to create a channel point reward", http.StatusInternalServerError)
+		return
+	}
+
+	// Without an active reward in the database every redemption would be ignored, so both writes happen or neither
+	err = s.db.InRewardTx(r.Context(), func(tx db.RewardTx) error {
+		if hasExisting {
+			_, err := tx.RetireReward(r.Context(), db.RetireRewardParams{
+				RewardID:   existingReward.RewardID,
+				GiveawayID: giveawayID,
+			})
+			if err != nil {
+				return fmt.Errorf("error retiring the old reward: %w", err)
+			}
+		}
+
+		_, err := tx.CreateReward(r.Context(), db.CreateRewardParams{
+			RewardID:   rewardID,
+			StreamerID: streamerID,
+			GiveawayID: giveawayID,
+		})
+		return err
+	})
+	if err != nil {
+		logger.Error("Error saving the new reward", "error", err, "reward_id", rewardID)
+
+		// Put the channel back the way it was, the old reward is still the active one
+		ctx := context.WithoutCancel(r.Context())
+		if err := s.twitchAPI.RemoveCustomReward(ctx, auth.AccessToken, userID, rewardID); err != nil && !eventSub.IsNotFound(err) {
+			logger.Error("Failed to delete the new channel point reward", "error", err, "reward_id", rewardID)
+		}
+		if hasExisting {
+			err := s.twitchAPI.RenameCustomReward(ctx, auth.AccessToken, userID, existingReward.RewardID, entryRewardTitle)
+			if err != nil && !eventSub.IsNotFound(err) {
+				logger.Error("Failed to restore the title of the old channel point reward", "error", err, "reward_id", existingReward.RewardID)
+			}
+		}
+
+		http.Error(w, "Error saving the new reward", http.StatusInternalServerError)
+		return
+	}
+
+	if hasExisting {
+		logger.Info("Retired the old reward", "reward_id", existingReward.RewardID)
+	}
+
+	logger.Info("Channel point reward created successfully", "reward_id", rewardID)
+
+	// Deleting the old reward would mark its queue as fulfilled, so while a giveaway takes its redemptions
+	// it's only disabled and the backfill deletes it once the queue was checked
+	if hasExisting {
+		if giveawayID.Valid {
+			err = s.twitchAPI.DisableCustomReward(r.Context(), auth.AccessToken, userID, existingReward.RewardID)
+		} else {
+			err = s.twitchAPI.RemoveCustomReward(r.Context(), auth.AccessToken, userID, existingReward.RewardID)
+		}
+		if err != nil && !eventSub.IsNotFound(err) {
+			logger.Error("Failed to take the old channel point reward off the channel", "error", err, "reward_id", existingReward.RewardID)
+		}
+	}
+
+	// Rewards are created redeemable, pause it if the giveaway doesn't take entries right now
+	s.twitchWebhook.WakeRewardSchedule(r.Context())
+	w.WriteHeader(http.StatusOK)
+}
+
+// entryRewardTitle is the title of the reward viewers redeem for an entry
+const entryRewardTitle = "1 Giveaway Entry"
+
+// retiredRewardTitle is the title a replaced reward gets, unique since a channel may keep several disabled ones
+func retiredRewardTitle(rewardID string) string {
+	return fmt.Sprintf("%s (retired %.8s)", entryRewardTitle, rewardID)
+}
+
+// createEntryReward creates the giveaway reward on the broadcaster's channel and returns its ID
+func (s *Server) createEntryReward(ctx context.Context, accessToken, broadcasterID string) (string, error) {
+	client, err := s.twitchAPI.UserClient(ctx, accessToken)
+	if err != nil {
+		return "", fmt.Errorf("error creating a Twitch client: %w", err)
 	}
 
 	response, err := client.CreateCustomReward(&helix.ChannelCustomRewardsParams{
-		BroadcasterID:                userID,
-		Title:                        "1 Giveaway Entry",
+		BroadcasterID:                broadcasterID,
+		Title:                        entryRewardTitle,
 		Cost:                         100,
 		IsEnabled:                    true,
 		IsMaxPerUserPerStreamEnabled: true,
 		MaxPerUserPerStream:          1,
 	})
+	if err == nil {
+		err = eventSub.HelixError("/channel_points/custom_rewards", response.ResponseCommon)
+	}
+	if err == nil && len(response.Data.ChannelCustomRewards) == 0 {
+		err = errors.New("twitch returned no reward")
+	}
+	if err != nil {
+		return "", err
+	}
 
-	rewardID := response.Data.ChannelCustomRewards[0].ID
+	return response.Data.ChannelCustomRewards[0].ID, nil
+}
 
+// getRewardsHandler lists the signed in streamer's rewards, newest first, with the retired ones
+func (s *Server) getRewardsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getStreamerID(r)
 	if err != nil {
-		logger.Error("Failed to create a channel point reward", "error", err)
-		http.Error(w, "Failed to create a channel point reward", http.StatusInternalServerError)
+		s.log(r).Error("Failed to get the signed in streamer", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	_, err = s.db.CreateReward(r.Context(), db.CreateRewardParams{
-		RewardID:   rewardID,
-		StreamerID: pgtype.Text{String: userID, Valid: true},
-	})
-
+	rewards, err := s.db.GetRewardsByStreamer(r.Context(), pgtype.Text{String: userID, Valid: true})
 	if err != nil {
-		logger.Error("Error adding new reward to database", "error", err, "reward_id", rewardID)
-		http.Error(w, "Error adding new reward to database", http.StatusInternalServerError)
+		s.log(r).Error("Error getting streamers rewards from the database", "error", err)
+		http.Error(w, "Error getting streamers rewards from the database", http.StatusInternalServerError)
 		return
 	}
 
-	logger.Info("Channel point reward created successfully", "reward_id", rewardID)
-	w.WriteHeader(http.StatusOK)
+	util.SendJSON(w, rewards)
 }
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/util"
+)
+
+const viewerSessionName = "twitch-viewer-session"
+
+// beginViewerAuthHandler signs viewers in with Twitch so they can prove who they are.
+// It uses its own OAuth config without scopes, the token is only used to look up the viewer's ID.
+func (s *Server) beginViewerAuthHandler(w http.ResponseWriter, r *http.Request) {
+	state := util.GenerateRandomState()
+
+	session, _ := s.sessionStore.Get(r, viewerSessionName)
+	session.Values["state"] = state
+	session.Save(r, w)
+
+	url := s.viewerOAuthConfig.AuthCodeURL(state)
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+func (s *Server) viewerCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := s.sessionStore.Get(r, viewerSessionName)
+
+	sessionState, ok := session.Values["state"].(string)
+	if !ok || sessionState == "" {
+		http.Error(w, "Missing state parameter in session", http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("state") != sessionState {
+		http.Error(w, "Invalid state parameter", http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.viewerOAuthConfig.Exchange(s.oauthContext(r), r.URL.Query().Get("code"))
+	if err != nil {
+		s.log(r).Error("Failed to exchange viewer token", "error", err)
+		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
+		return
+	}
+
+	userData, err := s.getUserData(r.Context(), token.AccessToken)
+	if err != nil {
+		s.log(r).Error("Error getting viewer data", "error", err)
+		http.Error(w, "Error getting user data", http.StatusInternalServerError)
+		return
+	}
+
+	// The token isn't needed past this point, only the verified identity is kept
+	delete(session.Values, "state")
+	session.Values["viewer_id"] = userData.ID
+	session.Values["viewer_login"] = userData.Login
+	session.Options.MaxAge = int(24 * time.Hour / time.Second)
+	session.Save(r, w)
+
+	s.log(r).Info("Viewer logged in", slog.String("viewer_id", userData.ID), slog.String("viewer_login", userData.Login))
+
+	http.Redirect(w, r, s.frontendURL+"/claim", http.StatusTemporaryRedirect)
+}
+
+func (s *Server) viewerLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := s.sessionStore.Get(r, viewerSessionName)
+
+	session.Options.MaxAge = -1
+	session.Save(r, w)
+
+	http.Redirect(w, r, s.frontendURL, http.StatusTemporaryRedirect)
+}
+
+// getSessionViewerID returns the Twitch ID of the viewer signed in on this request
+func (s *Server) getSessionViewerID(r *http.Request) (string, bool) {
+	session, err := s.sessionStore.Get(r, viewerSessionName)
+	if err != nil {
+		return "", false
+	}
+
+	viewerID, ok := session.Values["viewer_id"].(string)
+	return viewerID, ok && viewerID != ""
+}
+
+func (s *Server) viewerAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := s.getSessionViewerID(r); !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const viewerHistoryLimit = 100
+
+var errInvalidGiveawayID = errors.New("invalid giveaway ID")
+
+type ViewerStatsResponse struct {
+	Login             string                             `json:"login"`
+	GiveawayID        int64                              `json:"giveaway_id,omitempty"`
+	GiveawayName      string                             `json:"giveaway_name,omitempty"`
+	Entries           int64                              `json:"entries"`
+	TotalEntries      int64                              `json:"total_entries"`
+	TotalParticipants int64                              `json:"total_participants"`
+	WinProbability    float64                            `json:"win_probability"` // Chance to win a single draw, between 0 and 1
+	Streamers         []db.GetViewerEntriesByStreamerRow `json:"streamers"`
+	History           []db.GetViewerEntryHistoryRow      `json:"history"`
+}
+
+// getViewerStats builds the entry overview of a viewer.
+// The stats are for the giveaway in giveawayParam, or the open giveaway if it's empty.
+func (s *Server) getViewerStats(ctx context.Context, viewer db.Viewer, giveawayParam string) (*ViewerStatsResponse, error) {
+	response := &ViewerStatsResponse{
+		Login:     viewer.Username,
+		Streamers: make([]db.GetViewerEntriesByStreamerRow, 0),
+		History:   make([]db.GetViewerEntryHistoryRow, 0),
+	}
+
+	var giveaway db.Giveaway
+	var err error
+	if giveawayParam != "" {
+		giveawayID, parseErr := strconv.ParseInt(giveawayParam, 10, 64)
+		if parseErr != nil {
+			return nil, errInvalidGiveawayID
+		}
+		giveaway, err = s.db.GetGiveawayByID(ctx, giveawayID)
+	} else {
+		giveaway, err = s.db.GetOpenGiveaway(ctx)
+	}
+
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("error getting giveaway: %w", err)
+	}
+
+	viewerID := pgtype.Text{String: viewer.TwitchID, Valid: true}
+
+	if giveaway.ID != 0 {
+		response.GiveawayID = giveaway.ID
+		response.GiveawayName = giveaway.Name
+
+		streamers, err := s.db.GetViewerEntriesByStreamer(ctx, db.GetViewerEntriesByStreamerParams{
+			ViewerID:   viewerID,
+			GiveawayID: giveaway.ID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error getting entries per streamer: %w", err)
+		}
+
+		totals, err := s.db.GetGiveawayEntryTotals(ctx, giveaway.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting giveaway totals: %w", err)
+		}
+
+		for _, streamer := range streamers {
+			response.Entries += streamer.Entries
+		}
+
+		if streamers != nil {
+			response.Streamers = streamers
+		}
+		response.TotalEntries = totals.TotalEntries
+		response.TotalParticipants = totals.TotalParticipants
+
+		// Draws are weighted by entries, so one draw is won with the viewer's share of all entries
+		if totals.TotalEntries > 0 {
+			response.WinProbability = float64(response.Entries) / float64(totals.TotalEntries)
+		}
+	}
+
+	history, err := s.db.GetViewerEntryHistory(ctx, db.GetViewerEntryHistoryParams{
+		ViewerID: viewerID,
+		Limit:    viewerHistoryLimit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting entry history: %w", err)
+	}
+
+	if history != nil {
+		response.History = history
+	}
+
+	return response, nil
+}
+
+func (s *Server) writeViewerStats(w http.ResponseWriter, r *http.Request, viewer db.Viewer) {
+	stats, err := s.getViewerStats(r.Context(), viewer, r.URL.Query().Get("giveaway_id"))
+	if err != nil {
+		if errors.Is(err, errInvalidGiveawayID) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.log(r).Error("Error getting viewer stats", "error", err, "viewer_id", viewer.TwitchID)
+		http.Error(w, "Error getting viewer stats", http.StatusInternalServerError)
+		return
+	}
+
+	util.SendJSON(w, stats)
+}
+
+// GetViewerHandler shows the entries of any viewer by their Twitch login
+func (s *Server) GetViewerHandler(w http.ResponseWriter, r *http.Request) {
+	login := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "login")))
+
+	viewer, err := s.db.GetViewerByUsername(r.Context(), login)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Viewer not found", http.StatusNotFound)
+			return
+		}
+
+		s.log(r).Error("Error getting viewer", "error", err, "login", login)
+		http.Error(w, "Error getting viewer", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeViewerStats(w, r, viewer)
+}
+
+// getSignedInViewerHandler shows the entries of the viewer signed in with Twitch
+func (s *Server) getSignedInViewerHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, _ := s.getSessionViewerID(r)
+
+	viewer, err := s.db.GetViewerByID(r.Context(), viewerID)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			s.log(r).Error("Error getting viewer", "error", err, "viewer_id", viewerID)
+			http.Error(w, "Error getting viewer", http.StatusInternalServerError)
+			return
+		}
+
+		// Viewers who never entered have no row yet, show them empty stats
+		session, _ := s.sessionStore.Get(r, viewerSessionName)
+		login, _ := session.Values["viewer_login"].(string)
+		viewer = db.Viewer{TwitchID: viewerID, Username: login}
+	}
+
+	s.writeViewerStats(w, r, viewer)
+}
+
+// optOutHandler deletes everything stored about the signed in viewer and refuses their future entries
+func (s *Server) optOutHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, _ := s.getSessionViewerID(r)
+	logger := s.log(r).With(slog.String("viewer_id", viewerID))
+
+	if err := s.db.OptOutViewer(r.Context(), viewerID); err != nil {
+		logger.Error("Error opting out viewer", "error", err)
+		http.Error(w, "Error deleting your data", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("Viewer opted out and their data was deleted")
+
+	session, _ := s.sessionStore.Get(r, viewerSessionName)
+	session.Options.MaxAge = -1
+	session.Save(r, w)
+
+	w.WriteHeader(http.StatusNoContent)
+}
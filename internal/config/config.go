@@ -0,0 +1,411 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/util"
+	"github.com/joho/godotenv"
+)
+
+// Environments, stored in ENVIRONMENT
+const (
+	EnvironmentDevelopment = "DEVELOPMENT"
+	EnvironmentProduction  = "PRODUCTION"
+)
+
+// Sections group the settings a command needs, so a migration doesn't ask for Twitch credentials
+type Section string
+
+const (
+	SectionServer   Section = "server"
+	SectionTwitch   Section = "twitch"
+	SectionDatabase Section = "database"
+)
+
+type Config struct {
+	Environment        string
+	ClaimEncryptionKey string // base64 AES-256 key, claims are disabled without it
+	DiscordWebhookURL  string // notifications are dropped without it
+
+	Server   Server
+	Twitch   Twitch
+	Database Database
+	Tracing  Tracing
+}
+
+type Server struct {
+	Host              string
+	FrontendURL       string
+	BackendDomainName string
+	CookieDomain      string
+	SessionKey        string
+	MetricsToken      string // /metrics is public without it
+
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration // streaming responses lift it for themselves
+	IdleTimeout     time.Duration
+	DrainPeriod     time.Duration // time between failing readiness and closing the listener
+	ShutdownTimeout time.Duration // time in-flight requests and workers get to finish
+}
+
+type Twitch struct {
+	ClientID          string
+	ClientSecret      string
+	EventSubTransport string // webhook or websocket
+	WebhookSecret     string // only used by the webhook transport
+	WebhookURL        string
+	WebSocketURL      string // EventSub WebSocket server, only used by the websocket transport
+	ConduitShards     int    // subscriptions go through a conduit with this many shards, per streamer when 0
+	ConduitID         string // conduit to use, the app's first one or a new one when empty
+	APIURL            string // Helix base URL, point it at the Twitch CLI mock API in tests
+	AuthURL           string // OAuth base URL
+	APITimeout        time.Duration
+}
+
+// EventSub transports, stored in TWITCH_EVENTSUB_TRANSPORT
+const (
+	TransportWebhook   = "webhook"
+	TransportWebSocket = "websocket" // for hosts Twitch can't reach, like a development machine behind NAT
+)
+
+type Database struct {
+	User     string
+	Password string
+	Host     string
+	Port     string
+	Name     string
+	SSLMode  string
+	MaxConns int // size of the connection pool, the pgx default when 0
+}
+
+// value is a typed config field settings are parsed into
+type value interface {
+	Set(string) error
+	String() string
+}
+
+type stringValue struct{ target *string }
+
+func (v stringValue) Set(s string) error {
+	*v.target = s
+	return nil
+}
+
+func (v stringValue) String() string { return *v.target }
+
+type durationValue struct{ target *time.Duration }
+
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v.target = d
+	return nil
+}
+
+func (v durationValue) String() string { return v.target.String() }
+
+type floatValue struct{ target *float64 }
+
+func (v floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*v.target = f
+	return nil
+}
+
+func (v floatValue) String() string { return strconv.FormatFloat(*v.target, 'g', -1, 64) }
+
+type intValue struct{ target *int }
+
+func (v intValue) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v.target = i
+	return nil
+}
+
+func (v intValue) String() string { return strconv.Itoa(*v.target) }
+
+type Tracing struct {
+	Endpoint    string // OTLP/HTTP traces URL, tracing is off without it
+	ServiceName string
+	SampleRatio float64 // share of traces started here that are recorded
+}
+
+// setting binds one value to its environment variable and flag
+type setting struct {
+	env     string
+	usage   string
+	def     string
+	section Section // required for this section, optional when empty
+	secret  bool    // redacted when printed, can be read from <env>_FILE
+	target  value
+}
+
+// flagName turns DB_SSLMODE into db-sslmode
+func (s *setting) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(s.env), "_", "-")
+}
+
+func (c *Config) settings() []*setting {
+	return []*setting{
+		{env: "ENVIRONMENT", usage: "DEVELOPMENT or PRODUCTION", def: EnvironmentProduction, target: stringValue{&c.Environment}},
+		{env: "CLAIM_ENCRYPTION_KEY", usage: "base64 encoded 32 byte key for prize delivery details", secret: true, target: stringValue{&c.ClaimEncryptionKey}},
+		{env: "DISCORD_WEBHOOK_URL", usage: "Discord webhook that receives notifications", secret: true, target: stringValue{&c.DiscordWebhookURL}},
+
+		{env: "HOST", usage: "address the server listens on", def: "0.0.0.0:8080", section: SectionServer, target: stringValue{&c.Server.Host}},
+		{env: "FRONTEND_URL", usage: "URL of the frontend, allowed by CORS", section: SectionServer, target: stringValue{&c.Server.FrontendURL}},
+		{env: "BACKEND_DOMAIN_NAME", usage: "public URL of this server, used for OAuth redirects", section: SectionServer, target: stringValue{&c.Server.BackendDomainName}},
+		{env: "COOKIE_DOMAIN", usage: "domain of the session cookie in production", target: stringValue{&c.Server.CookieDomain}},
+		{env: "SESSION_KEY", usage: "key signing the session cookies", section: SectionServer, secret: true, target: stringValue{&c.Server.SessionKey}},
+		{env: "METRICS_TOKEN", usage: "bearer token required to read /metrics", secret: true, target: stringValue{&c.Server.MetricsToken}},
+		{env: "HTTP_READ_TIMEOUT", usage: "maximum time to read a request", def: "15s", target: durationValue{&c.Server.ReadTimeout}},
+		{env: "HTTP_WRITE_TIMEOUT", usage: "maximum time to write a response", def: "30s", target: durationValue{&c.Server.WriteTimeout}},
+		{env: "HTTP_IDLE_TIMEOUT", usage: "how long idle keep-alive connections stay open", def: "120s", target: durationValue{&c.Server.IdleTimeout}},
+		{env: "SHUTDOWN_DRAIN_PERIOD", usage: "how long readiness fails before the server stops accepting requests", def: "5s", target: durationValue{&c.Server.DrainPeriod}},
+		{env: "SHUTDOWN_TIMEOUT", usage: "how long in-flight requests and workers get to finish on shutdown", def: "30s", target: durationValue{&c.Server.ShutdownTimeout}},
+
+		{env: "TWITCH_CLIENT_ID", usage: "Twitch application client ID", section: SectionTwitch, target: stringValue{&c.Twitch.ClientID}},
+		{env: "TWITCH_CLIENT_SECRET", usage: "Twitch application client secret", section: SectionTwitch, secret: true, target: stringValue{&c.Twitch.ClientSecret}},
+		{env: "TWITCH_EVENTSUB_TRANSPORT", usage: "how EventSub notifications arrive: webhook or websocket", def: TransportWebhook, section: SectionTwitch, target: stringValue{&c.Twitch.EventSubTransport}},
+		{env: "TWITCH_WEBHOOK_SECRET", usage: "secret Twitch signs EventSub notifications with, required for the webhook transport", secret: true, target: stringValue{&c.Twitch.WebhookSecret}},
+		{env: "TWITCH_WEBHOOK_URL", usage: "public URL of the EventSub callback, required for the webhook transport", target: stringValue{&c.Twitch.WebhookURL}},
+		{env: "TWITCH_EVENTSUB_WEBSOCKET_URL", usage: "URL of the EventSub WebSocket server", def: "wss://eventsub.wss.twitch.tv/ws", target: stringValue{&c.Twitch.WebSocketURL}},
+		{env: "TWITCH_EVENTSUB_CONDUIT_SHARDS", usage: "shards of the EventSub conduit subscriptions go through, 0 subscribes per streamer", def: "0", target: intValue{&c.Twitch.ConduitShards}},
+		{env: "TWITCH_EVENTSUB_CONDUIT_ID", usage: "EventSub conduit to use, the app's first one or a new one when empty", target: stringValue{&c.Twitch.ConduitID}},
+		{env: "TWITCH_API_URL", usage: "base URL of the Helix API", def: "https://api.twitch.tv/helix", target: stringValue{&c.Twitch.APIURL}},
+		{env: "TWITCH_AUTH_URL", usage: "base URL of the Twitch OAuth API", def: "https://id.twitch.tv/oauth2", target: stringValue{&c.Twitch.AuthURL}},
+		{env: "TWITCH_API_TIMEOUT", usage: "maximum time of one attempt at a Twitch API request", def: "10s", target: durationValue{&c.Twitch.APITimeout}},
+
+		{env: "DB_USER", usage: "database user", def: "postgres", section: SectionDatabase, target: stringValue{&c.Database.User}},
+		{env: "DB_PASSWORD", usage: "database password", secret: true, target: stringValue{&c.Database.Password}},
+		{env: "DB_HOST", usage: "database host", def: "localhost", section: SectionDatabase, target: stringValue{&c.Database.Host}},
+		{env: "DB_PORT", usage: "database port", def: "5432", section: SectionDatabase, target: stringValue{&c.Database.Port}},
+		{env: "DB_NAME", usage: "database name", def: "giveaway", section: SectionDatabase, target: stringValue{&c.Database.Name}},
+		{env: "DB_SSLMODE", usage: "database sslmode", def: "prefer", section: SectionDatabase, target: stringValue{&c.Database.SSLMode}},
+		{env: "DB_MAX_CONNS", usage: "size of the database connection pool, 0 for the larger of 4 and the number of CPUs", def: "0", section: SectionDatabase, target: intValue{&c.Database.MaxConns}},
+
+		{env: "TRACING_ENDPOINT", usage: "OTLP/HTTP endpoint traces are exported to, such as http://collector:4318", target: stringValue{&c.Tracing.Endpoint}},
+		{env: "TRACING_SERVICE_NAME", usage: "service name traces are reported under", def: "twitch-points", target: stringValue{&c.Tracing.ServiceName}},
+		{env: "TRACING_SAMPLE_RATIO", usage: "share of traces to record, between 0 and 1", def: "1", target: floatValue{&c.Tracing.SampleRatio}},
+	}
+}
+
+// Load reads the settings from, in increasing priority, the defaults, the file given by -config or CONFIG_FILE
+// (.env when neither is set and it exists), the environment and the flags before the command.
+// It returns the arguments left after the flags.
+func Load(args []string) (*Config, []string, error) {
+	cfg := &Config{}
+	settings := cfg.settings()
+
+	flags := flag.NewFlagSet("twitch-points", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "file with KEY=value settings, .env by default")
+
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.env] = flags.String(s.flagName(), s.def, s.usage+" ("+s.env+")")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	fileValues, err := readFile(*configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	setFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	var errs []error
+	for _, s := range settings {
+		raw := s.def
+
+		if value, ok := fileValues[s.env]; ok {
+			raw = value
+		}
+
+		if s.secret {
+			if path := os.Getenv(s.env + "_FILE"); path != "" {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					return nil, nil, fmt.Errorf("error reading %s_FILE: %w", s.env, err)
+				}
+				raw = strings.TrimSpace(string(content))
+			}
+		}
+
+		if value, ok := os.LookupEnv(s.env); ok {
+			raw = value
+		}
+
+		if setFlags[s.flagName()] {
+			raw = *flagValues[s.env]
+		}
+
+		if err := s.target.Set(raw); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", s.env, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, flags.Args(), nil
+}
+
+// readFile parses a KEY=value file, a missing file is only an error when it was asked for explicitly
+func readFile(path string) (map[string]string, error) {
+	explicit := path != ""
+	if !explicit {
+		path = ".env"
+	}
+
+	values, err := godotenv.Read(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading config file %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+// Validate checks that the settings the sections need are set and well formed
+func (c *Config) Validate(sections ...Section) error {
+	var errs []error
+
+	for _, s := range c.settings() {
+		for _, section := range sections {
+			if s.section == section && s.target.String() == "" {
+				errs = append(errs, fmt.Errorf("%s is required", s.env))
+			}
+		}
+	}
+
+	if c.Environment != EnvironmentDevelopment && c.Environment != EnvironmentProduction {
+		errs = append(errs, fmt.Errorf("ENVIRONMENT must be %s or %s", EnvironmentDevelopment, EnvironmentProduction))
+	}
+
+	if c.ClaimEncryptionKey != "" {
+		if _, err := util.ParseEncryptionKey(c.ClaimEncryptionKey); err != nil {
+			errs = append(errs, fmt.Errorf("CLAIM_ENCRYPTION_KEY: %w", err))
+		}
+	}
+
+	if slices.Contains(sections, SectionTwitch) {
+		switch c.Twitch.EventSubTransport {
+		case TransportWebhook:
+			if c.Twitch.WebhookSecret == "" {
+				errs = append(errs, errors.New("TWITCH_WEBHOOK_SECRET is required for the webhook transport"))
+			}
+			if c.Twitch.WebhookURL == "" {
+				errs = append(errs, errors.New("TWITCH_WEBHOOK_URL is required for the webhook transport"))
+			}
+		case TransportWebSocket:
+		default:
+			errs = append(errs, fmt.Errorf("TWITCH_EVENTSUB_TRANSPORT must be %s or %s", TransportWebhook, TransportWebSocket))
+		}
+	}
+
+	// Twitch allows up to 20,000 shards per conduit
+	if c.Twitch.ConduitShards < 0 || c.Twitch.ConduitShards > 20000 {
+		errs = append(errs, errors.New("TWITCH_EVENTSUB_CONDUIT_SHARDS must be between 0 and 20000"))
+	}
+
+	if c.Database.MaxConns < 0 {
+		errs = append(errs, errors.New("DB_MAX_CONNS can't be negative"))
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("TRACING_SAMPLE_RATIO must be between 0 and 1"))
+	}
+
+	for name, value := range map[string]string{
+		"FRONTEND_URL":                  c.Server.FrontendURL,
+		"BACKEND_DOMAIN_NAME":           c.Server.BackendDomainName,
+		"DISCORD_WEBHOOK_URL":           c.DiscordWebhookURL,
+		"TRACING_ENDPOINT":              c.Tracing.Endpoint,
+		"TWITCH_API_URL":                c.Twitch.APIURL,
+		"TWITCH_AUTH_URL":               c.Twitch.AuthURL,
+		"TWITCH_EVENTSUB_WEBSOCKET_URL": c.Twitch.WebSocketURL,
+	} {
+		if value == "" {
+			continue
+		}
+		if parsed, err := url.Parse(value); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("%s must be an absolute URL", name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) IsDev() bool {
+	return c.Environment == EnvironmentDevelopment
+}
+
+// ClaimKey decodes the claim encryption key, nil when claims are disabled
+func (c *Config) ClaimKey() ([]byte, error) {
+	if c.ClaimEncryptionKey == "" {
+		return nil, nil
+	}
+	return util.ParseEncryptionKey(c.ClaimEncryptionKey)
+}
+
+func (d Database) URL() string {
+	return (&url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     d.Host + ":" + d.Port,
+		Path:     "/" + d.Name,
+		RawQuery: url.Values{"sslmode": {d.SSLMode}}.Encode(),
+	}).String()
+}
+
+func redact(s *setting) string {
+	if s.secret && s.target.String() != "" {
+		return "[redacted]"
+	}
+	return s.target.String()
+}
+
+// WriteRedacted prints the effective settings as KEY=value lines with the secrets hidden
+func (c *Config) WriteRedacted(w io.Writer) error {
+	for _, s := range c.settings() {
+		if _, err := fmt.Fprintf(w, "%s=%q\n", s.env, redact(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogValue logs the effective settings with the secrets hidden
+func (c *Config) LogValue() slog.Value {
+	settings := c.settings()
+	attrs := make([]slog.Attr, 0, len(settings))
+	for _, s := range settings {
+		attrs = append(attrs, slog.String(s.env, redact(s)))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cleanEnv unsets every setting for the duration of the test, so the environment of the machine can't leak in
+func cleanEnv(t *testing.T) {
+	t.Helper()
+	unset := func(key string) {
+		t.Setenv(key, "") // restores the original value when the test ends
+		os.Unsetenv(key)
+	}
+
+	unset("CONFIG_FILE")
+	for _, s := range (&Config{}).settings() {
+		unset(s.env)
+		unset(s.env + "_FILE")
+	}
+	t.Chdir(t.TempDir())
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("error writing %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cleanEnv(t)
+
+	cfg, args, err := Load(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(args) != 0 {
+		t.Errorf("got arguments %v, want none", args)
+	}
+
+	if cfg.Environment != EnvironmentProduction || cfg.Server.Host != "0.0.0.0:8080" || cfg.Database.Port != "5432" {
+		t.Errorf("got %+v, want the defaults", cfg)
+	}
+	if cfg.Server.ReadTimeout != 15*time.Second || cfg.Tracing.SampleRatio != 1 || cfg.Database.MaxConns != 0 {
+		t.Errorf("got %+v, want the typed defaults", cfg)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	cleanEnv(t)
+	file := writeFile(t, "settings.env", "DB_HOST=file\nDB_PORT=5433\nDB_NAME=file\nDB_USER=file\n")
+	t.Setenv("DB_PORT", "5434")
+	t.Setenv("DB_NAME", "env")
+	t.Setenv("DB_USER", "env")
+
+	cfg, args, err := Load([]string{"-config", file, "-db-user", "flag", "migrate", "-db-name", "after"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		setting string
+		got     string
+		want    string
+	}{
+		{"DB_SSLMODE", cfg.Database.SSLMode, "prefer"},
+		{"DB_HOST", cfg.Database.Host, "file"},
+		{"DB_PORT", cfg.Database.Port, "5434"},
+		{"DB_NAME", cfg.Database.Name, "env"},
+		{"DB_USER", cfg.Database.User, "flag"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.setting, tt.got, tt.want)
+		}
+	}
+
+	// Flags after the command belong to the command
+	if want := []string{"migrate", "-db-name", "after"}; !slices.Equal(args, want) {
+		t.Errorf("got arguments %v, want %v", args, want)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Run("from CONFIG_FILE", func(t *testing.T) {
+		cleanEnv(t)
+		t.Setenv("CONFIG_FILE", writeFile(t, "settings.env", "DB_NAME=from-config-file\n"))
+
+		cfg, _, err := Load(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Database.Name != "from-config-file" {
+			t.Errorf("DB_NAME = %q, want the value of the file", cfg.Database.Name)
+		}
+	})
+
+	t.Run(".env in the working directory", func(t *testing.T) {
+		cleanEnv(t)
+		if err := os.WriteFile(".env", []byte("DB_NAME=from-dotenv\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, _, err := Load(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Database.Name != "from-dotenv" {
+			t.Errorf("DB_NAME = %q, want the value of .env", cfg.Database.Name)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		cleanEnv(t)
+
+		if _, _, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.env")}); err == nil {
+			t.Fatal("a missing config file asked for was ignored")
+		}
+	})
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	t.Run("file over the config file", func(t *testing.T) {
+		cleanEnv(t)
+		t.Setenv("DB_PASSWORD_FILE", writeFile(t, "password", "from-secret-file\n"))
+		configFile := writeFile(t, "settings.env", "DB_PASSWORD=from-config-file\n")
+
+		cfg, _, err := Load([]string{"-config", configFile})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// The trailing newline secret files tend to end with is trimmed
+		if cfg.Database.Password != "from-secret-file" {
+			t.Errorf("DB_PASSWORD = %q, want the content of DB_PASSWORD_FILE", cfg.Database.Password)
+		}
+	})
+
+	t.Run("environment over the file", func(t *testing.T) {
+		cleanEnv(t)
+		t.Setenv("DB_PASSWORD_FILE", writeFile(t, "password", "from-secret-file"))
+		t.Setenv("DB_PASSWORD", "from-env")
+
+		cfg, _, err := Load(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Database.Password != "from-env" {
+			t.Errorf("DB_PASSWORD = %q, want the environment variable", cfg.Database.Password)
+		}
+	})
+
+	t.Run("only for secrets", func(t *testing.T) {
+		cleanEnv(t)
+		t.Setenv("DB_HOST_FILE", writeFile(t, "host", "from-file"))
+
+		cfg, _, err := Load(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Database.Host != "localhost" {
+			t.Errorf("DB_HOST = %q, want the default", cfg.Database.Host)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		cleanEnv(t)
+		t.Setenv("SESSION_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+
+		_, _, err := Load(nil)
+		if err == nil || !strings.Contains(err.Error(), "SESSION_KEY_FILE") {
+			t.Fatalf("got %v, want an error about SESSION_KEY_FILE", err)
+		}
+	})
+}
+
+func TestLoadInvalidValues(t *testing.T) {
+	cleanEnv(t)
+	t.Setenv("HTTP_READ_TIMEOUT", "soon")
+	t.Setenv("DB_MAX_CONNS", "many")
+
+	_, _, err := Load(nil)
+	if err == nil {
+		t.Fatal("the invalid values were accepted")
+	}
+	// Every invalid setting is reported at once
+	for _, env := range []string{"HTTP_READ_TIMEOUT", "DB_MAX_CONNS"} {
+		if !strings.Contains(err.Error(), env) {
+			t.Errorf("got %v, want %s reported", err, env)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"database only", map[string]string{}, ""},
+		{"unknown environment", map[string]string{"ENVIRONMENT": "STAGING"}, "ENVIRONMENT"},
+		{"required setting empty", map[string]string{"DB_HOST": ""}, "DB_HOST is required"},
+		{"negative pool size", map[string]string{"DB_MAX_CONNS": "-1"}, "DB_MAX_CONNS"},
+		{"sample ratio over 1", map[string]string{"TRACING_SAMPLE_RATIO": "1.5"}, "TRACING_SAMPLE_RATIO"},
+		{"relative URL", map[string]string{"TRACING_ENDPOINT": "collector:4318"}, "TRACING_ENDPOINT"},
+		{"short claim key", map[string]string{"CLAIM_ENCRYPTION_KEY": "c2hvcnQ="}, "CLAIM_ENCRYPTION_KEY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleanEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, _, err := Load(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = cfg.Validate(SectionDatabase)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateWebhookTransport(t *testing.T) {
+	cleanEnv(t)
+	t.Setenv("TWITCH_CLIENT_ID", "client-id")
+	t.Setenv("TWITCH_CLIENT_SECRET", "client-secret")
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = cfg.Validate(SectionTwitch)
+	for _, env := range []string{"TWITCH_WEBHOOK_SECRET", "TWITCH_WEBHOOK_URL"} {
+		if err == nil || !strings.Contains(err.Error(), env) {
+			t.Errorf("got %v, want %s required by the webhook transport", err, env)
+		}
+	}
+
+	cfg.Twitch.EventSubTransport = TransportWebSocket
+	if err := cfg.Validate(SectionTwitch); err != nil {
+		t.Errorf("the WebSocket transport needs no callback, got %v", err)
+	}
+}
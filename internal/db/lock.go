@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Lock is a named lock held by one session until it is released or the session ends
+type Lock interface {
+	// Check returns an error when the lock can't be trusted anymore, because its session is gone
+	Check(ctx context.Context) error
+	Release()
+}
+
+// Locker hands out named locks, only one session at a time holds a name across every replica.
+// TryLock doesn't wait, ok is false when another session holds the lock.
+type Locker interface {
+	TryLock(ctx context.Context, name string) (lock Lock, ok bool, err error)
+}
+
+// lockKey turns a lock name into the key of a Postgres advisory lock
+func lockKey(name string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return int64(hash.Sum64())
+}
+
+// advisoryLock is a session level advisory lock held on a connection of its own
+type advisoryLock struct {
+	conn *pgx.Conn
+}
+
+// TryLock takes a Postgres advisory lock on a connection of its own, opened with the settings of the pool.
+// Held locks don't take connections from the pool, so leading any number of loops can't starve the queries.
+// The lock goes away with the connection, so a replica that dies or loses the database lets go of it.
+func (s *DBStore) TryLock(ctx context.Context, name string) (Lock, bool, error) {
+	conn, err := pgx.ConnectConfig(ctx, s.connPool.Config().ConnConfig.Copy())
+	if err != nil {
+		return nil, false, fmt.Errorf("error connecting for the advisory lock: %w", err)
+	}
+
+	var ok bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", lockKey(name)).Scan(&ok); err != nil {
+		closeConn(conn)
+		return nil, false, fmt.Errorf("error taking the advisory lock: %w", err)
+	}
+
+	if !ok {
+		closeConn(conn)
+		return nil, false, nil
+	}
+	return &advisoryLock{conn: conn}, true, nil
+}
+
+func (l *advisoryLock) Check(ctx context.Context) error {
+	return l.conn.Ping(ctx)
+}
+
+// Release closes the connection, ending the session releases its advisory locks
+func (l *advisoryLock) Release() {
+	closeConn(l.conn)
+}
+
+func closeConn(conn *pgx.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn.Close(ctx)
+}
@@ -0,0 +1,1771 @@
+// Package memstore keeps the app's data in memory, for tests and for running the app without Postgres.
+// It answers every query like the SQL in internal/sql/queries.sql, missing rows are pgx.ErrNoRows
+// and broken constraints are *pgconn.PgError with the code and constraint name Postgres would use.
+package memstore
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Postgres error codes of the constraints the schema has
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+	checkViolation      = "23514"
+)
+
+// Store implements db.Store. Rows are kept in insertion order, which is the order Postgres returns them in
+// when a query doesn't sort, so results match as long as the tests don't depend on unsorted order.
+type Store struct {
+	mu sync.Mutex
+	tables
+	locks     map[string]bool // names held through TryLock, they aren't data so transactions leave them alone
+	listeners map[string]map[chan string]struct{}
+
+	now func() time.Time
+}
+
+type tables struct {
+	streamers     []db.Streamer
+	viewers       []db.Viewer
+	optOuts       []db.ViewerOptOut
+	rewards       []db.Reward
+	redemptions   []db.Redemption
+	chatCommands  []db.ChatCommand
+	giveaways     []db.Giveaway
+	recurring     []db.RecurringGiveaway
+	prizes        []db.Prize
+	draws         []db.Draw
+	winners       []db.Winner
+	announcements []db.Announcement
+
+	// BIGSERIAL sequences
+	nextGiveawayID     int64
+	nextRecurringID    int64
+	nextPrizeID        int64
+	nextDrawID         int64
+	nextWinnerID       int64
+	nextAnnouncementID int64
+}
+
+// snapshot copies the tables, rows are values so changing the copy leaves the original alone
+func (t tables) snapshot() tables {
+	t.streamers = slices.Clone(t.streamers)
+	t.viewers = slices.Clone(t.viewers)
+	t.optOuts = slices.Clone(t.optOuts)
+	t.rewards = slices.Clone(t.rewards)
+	t.redemptions = slices.Clone(t.redemptions)
+	t.chatCommands = slices.Clone(t.chatCommands)
+	t.giveaways = slices.Clone(t.giveaways)
+	t.recurring = slices.Clone(t.recurring)
+	t.prizes = slices.Clone(t.prizes)
+	t.draws = slices.Clone(t.draws)
+	t.winners = slices.Clone(t.winners)
+	t.announcements = slices.Clone(t.announcements)
+	return t
+}
+
+var _ db.Store = (*Store)(nil)
+
+// New returns an empty store, without the open giveaway the migrations create
+func New() *Store {
+	return &Store{
+		locks:     make(map[string]bool),
+		listeners: make(map[string]map[chan string]struct{}),
+		now:       time.Now,
+	}
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *Store) timestamp() pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: s.now(), Valid: true}
+}
+
+func constraintError(code, table, constraint, message string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           code,
+		Message:        message,
+		TableName:      table,
+		ConstraintName: constraint,
+	}
+}
+
+func duplicateKey(table, constraint string) error {
+	return constraintError(uniqueViolation, table, constraint, `duplicate key value violates unique constraint "`+constraint+`"`)
+}
+
+func missingReference(table, constraint string) error {
+	return constraintError(foreignKeyViolation, table, constraint,
+		`insert or update on table "`+table+`" violates foreign key constraint "`+constraint+`"`)
+}
+
+func stillReferenced(table, constraint, from string) error {
+	return constraintError(foreignKeyViolation, table, constraint,
+		`update or delete on table "`+table+`" violates foreign key constraint "`+constraint+`" on table "`+from+`"`)
+}
+
+// find returns the index of the first row matching, -1 when none does
+func find[T any](rows []T, match func(T) bool) int {
+	return slices.IndexFunc(rows, match)
+}
+
+func filter[T any](rows []T, match func(T) bool) []T {
+	var matching []T
+	for _, row := range rows {
+		if match(row) {
+			matching = append(matching, row)
+		}
+	}
+	return matching
+}
+
+// byTimeDesc sorts newest first, rows inserted later win ties like they do with a serial key
+func byTimeDesc[T any](rows []T, at func(T) time.Time) {
+	slices.Reverse(rows)
+	slices.SortStableFunc(rows, func(a, b T) int {
+		return at(b).Compare(at(a))
+	})
+}
+
+func (s *Store) streamerIndex(twitchID string) int {
+	return find(s.streamers, func(st db.Streamer) bool { return st.TwitchID == twitchID })
+}
+
+func (s *Store) viewerIndex(twitchID string) int {
+	return find(s.viewers, func(v db.Viewer) bool { return v.TwitchID == twitchID })
+}
+
+func (s *Store) giveawayIndex(id int64) int {
+	return find(s.giveaways, func(g db.Giveaway) bool { return g.ID == id })
+}
+
+func (s *Store) prizeIndex(id int64) int {
+	return find(s.prizes, func(p db.Prize) bool { return p.ID == id })
+}
+
+func (s *Store) drawIndex(id int64) int {
+	return find(s.draws, func(d db.Draw) bool { return d.ID == id })
+}
+
+func (s *Store) winnerIndex(id int64) int {
+	return find(s.winners, func(w db.Winner) bool { return w.ID == id })
+}
+
+// references reports whether a nullable foreign key points at an existing row
+func (s *Store) referencesStreamer(id pgtype.Text) bool {
+	return !id.Valid || s.streamerIndex(id.String) >= 0
+}
+
+func (s *Store) referencesViewer(id pgtype.Text) bool {
+	return !id.Valid || s.viewerIndex(id.String) >= 0
+}
+
+// Streamers
+
+func (s *Store) CreateStreamer(ctx context.Context, arg db.CreateStreamerParams) (db.Streamer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.streamerIndex(arg.TwitchID) >= 0 {
+		return db.Streamer{}, duplicateKey("streamers", "streamers_pkey")
+	}
+	if find(s.streamers, func(st db.Streamer) bool { return st.Username == arg.Username }) >= 0 {
+		return db.Streamer{}, duplicateKey("streamers", "streamers_username_key")
+	}
+
+	streamer := db.Streamer{
+		TwitchID:        arg.TwitchID,
+		Username:        arg.Username,
+		Verified:        arg.Verified,
+		ProfileImageUrl: arg.ProfileImageUrl,
+		AccessToken:     arg.AccessToken,
+		RefreshToken:    arg.RefreshToken,
+		CreatedAt:       s.timestamp(),
+		UpdatedAt:       s.timestamp(),
+		IsLive:          arg.IsLive,
+		BroadcasterType: arg.BroadcasterType,
+	}
+	s.streamers = append(s.streamers, streamer)
+	return streamer, nil
+}
+
+func (s *Store) GetStreamerByID(ctx context.Context, twitchID string) (db.Streamer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.streamerIndex(twitchID)
+	if i < 0 {
+		return db.Streamer{}, pgx.ErrNoRows
+	}
+	return s.streamers[i], nil
+}
+
+func (s *Store) GetStreamerByUsername(ctx context.Context, username string) (db.Streamer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := find(s.streamers, func(st db.Streamer) bool { return st.Username == username })
+	if i < 0 {
+		return db.Streamer{}, pgx.ErrNoRows
+	}
+	return s.streamers[i], nil
+}
+
+func (s *Store) GetAllStreamers(ctx context.Context) ([]db.GetAllStreamersRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []db.GetAllStreamersRow
+	for _, streamer := range s.streamers {
+		if streamer.Verified.Valid && streamer.Verified.Bool {
+			rows = append(rows, db.GetAllStreamersRow{
+				Username:        streamer.Username,
+				TwitchID:        streamer.TwitchID,
+				ProfileImageUrl: streamer.ProfileImageUrl,
+				IsLive:          streamer.IsLive,
+			})
+		}
+	}
+	return rows, nil
+}
+
+func (s *Store) GetAllStreamersWithTokens(ctx context.Context) ([]db.Streamer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.streamers), nil
+}
+
+func (s *Store) UpdateStreamerTokens(ctx context.Context, arg db.UpdateStreamerTokensParams) (db.Streamer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.streamerIndex(arg.TwitchID)
+	if i < 0 {
+		return db.Streamer{}, pgx.ErrNoRows
+	}
+
+	s.streamers[i].AccessToken = arg.AccessToken
+	s.streamers[i].RefreshToken = arg.RefreshToken
+	s.streamers[i].UpdatedAt = s.timestamp()
+	return s.streamers[i], nil
+}
+
+func (s *Store) SetStreamerBroadcasterType(ctx context.Context, arg db.SetStreamerBroadcasterTypeParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := s.streamerIndex(arg.TwitchID); i >= 0 {
+		s.streamers[i].BroadcasterType = arg.BroadcasterType
+		s.streamers[i].UpdatedAt = s.timestamp()
+	}
+	return nil
+}
+
+func (s *Store) SetStreamerLiveStatus(ctx context.Context, arg db.SetStreamerLiveStatusParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := s.streamerIndex(arg.TwitchID); i >= 0 {
+		s.streamers[i].IsLive = arg.IsLive
+		s.streamers[i].UpdatedAt = s.timestamp()
+	}
+	return nil
+}
+
+func (s *Store) SetStreamerRewardLiveOnly(ctx context.Context, arg db.SetStreamerRewardLiveOnlyParams) (db.Streamer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.streamerIndex(arg.TwitchID)
+	if i < 0 {
+		return db.Streamer{}, pgx.ErrNoRows
+	}
+
+	s.streamers[i].RewardLiveOnly = arg.RewardLiveOnly
+	s.streamers[i].UpdatedAt = s.timestamp()
+	return s.streamers[i], nil
+}
+
+// Rewards
+
+// Reward states, stored in rewards.status
+const (
+	rewardActive  = "active"
+	rewardRetired = "retired"
+)
+
+// InRewardTx holds the store for the whole transaction, so nothing else reads or writes in between.
+// The tables are put back the way they were when fn fails.
+func (s *Store) InRewardTx(ctx context.Context, fn func(tx db.RewardTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.tables.snapshot()
+	if err := fn(rewardTx{s}); err != nil {
+		s.tables = before
+		return err
+	}
+	return nil
+}
+
+// rewardTx runs the queries of a transaction on the store it holds
+type rewardTx struct {
+	s *Store
+}
+
+func (tx rewardTx) RetireReward(ctx context.Context, arg db.RetireRewardParams) (db.Reward, error) {
+	return tx.s.retireReward(arg)
+}
+
+func (tx rewardTx) CreateReward(ctx context.Context, arg db.CreateRewardParams) (db.Reward, error) {
+	return tx.s.createReward(arg)
+}
+
+func (s *Store) CreateReward(ctx context.Context, arg db.CreateRewardParams) (db.Reward, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createReward(arg)
+}
+
+func (s *Store) createReward(arg db.CreateRewardParams) (db.Reward, error) {
+	if find(s.rewards, func(r db.Reward) bool { return r.RewardID == arg.RewardID }) >= 0 {
+		return db.Reward{}, duplicateKey("rewards", "rewards_pkey")
+	}
+	if arg.StreamerID.Valid && s.activeRewardIndex(arg.StreamerID) >= 0 {
+		return db.Reward{}, duplicateKey("rewards", "idx_rewards_one_active")
+	}
+	if !s.referencesStreamer(arg.StreamerID) {
+		return db.Reward{}, missingReference("rewards", "rewards_streamer_id_fkey")
+	}
+	if arg.GiveawayID.Valid && s.giveawayIndex(arg.GiveawayID.Int64) < 0 {
+		return db.Reward{}, missingReference("rewards", "rewards_giveaway_id_fkey")
+	}
+
+	reward := db.Reward{
+		RewardID:   arg.RewardID,
+		StreamerID: arg.StreamerID,
+		CreatedAt:  s.timestamp(),
+		Status:     rewardActive,
+		GiveawayID: arg.GiveawayID,
+	}
+	s.rewards = append(s.rewards, reward)
+	return reward, nil
+}
+
+func (s *Store) GetRewardsByStreamer(ctx context.Context, streamerID pgtype.Text) ([]db.Reward, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rewards := filter(s.rewards, func(r db.Reward) bool { return r.StreamerID.Valid && r.StreamerID == streamerID })
+	byTimeDesc(rewards, func(r db.Reward) time.Time { return r.CreatedAt.Time })
+	return rewards, nil
+}
+
+func (s *Store) activeRewardIndex(streamerID pgtype.Text) int {
+	return find(s.rewards, func(r db.Reward) bool {
+		return r.StreamerID.Valid && r.StreamerID == streamerID && r.Status == rewardActive
+	})
+}
+
+func (s *Store) GetActiveRewardByStreamer(ctx context.Context, streamerID pgtype.Text) (db.Reward, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.activeRewardIndex(streamerID)
+	if i < 0 {
+		return db.Reward{}, pgx.ErrNoRows
+	}
+	return s.rewards[i], nil
+}
+
+// countsFor reports whether the reward's redemptions are entries in the giveaway
+func countsFor(r db.Reward, giveawayID pgtype.Int8) bool {
+	return r.Status == rewardActive || (giveawayID.Valid && r.GiveawayID == giveawayID)
+}
+
+func (s *Store) GetEntryRewardsByStreamer(ctx context.Context, arg db.GetEntryRewardsByStreamerParams) ([]db.Reward, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rewards := filter(s.rewards, func(r db.Reward) bool {
+		return r.StreamerID.Valid && r.StreamerID == arg.StreamerID && countsFor(r, arg.GiveawayID)
+	})
+	byTimeDesc(rewards, func(r db.Reward) time.Time { return r.CreatedAt.Time })
+	return rewards, nil
+}
+
+func (s *Store) RetireReward(ctx context.Context, arg db.RetireRewardParams) (db.Reward, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.retireReward(arg)
+}
+
+func (s *Store) retireReward(arg db.RetireRewardParams) (db.Reward, error) {
+	i := find(s.rewards, func(r db.Reward) bool { return r.RewardID == arg.RewardID })
+	if i < 0 {
+		return db.Reward{}, pgx.ErrNoRows
+	}
+	if arg.GiveawayID.Valid && s.giveawayIndex(arg.GiveawayID.Int64) < 0 {
+		return db.Reward{}, missingReference("rewards", "rewards_giveaway_id_fkey")
+	}
+
+	s.rewards[i].Status = rewardRetired
+	s.rewards[i].RetiredAt = s.timestamp()
+	if arg.GiveawayID.Valid {
+		s.rewards[i].GiveawayID = arg.GiveawayID
+	}
+	return s.rewards[i], nil
+}
+
+func (s *Store) isEntryReward(arg db.IsEntryRewardParams) bool {
+	return find(s.rewards, func(r db.Reward) bool {
+		return r.RewardID == arg.RewardID && r.StreamerID.Valid && r.StreamerID == arg.StreamerID && countsFor(r, arg.GiveawayID)
+	}) >= 0
+}
+
+// Chat commands
+
+func (s *Store) GetChatCommandByStreamer(ctx context.Context, streamerID string) (db.ChatCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := find(s.chatCommands, func(c db.ChatCommand) bool { return c.StreamerID == streamerID })
+	if i < 0 {
+		return db.ChatCommand{}, pgx.ErrNoRows
+	}
+	return s.chatCommands[i], nil
+}
+
+func (s *Store) UpsertChatCommand(ctx context.Context, arg db.UpsertChatCommandParams) (db.ChatCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := find(s.chatCommands, func(c db.ChatCommand) bool { return c.StreamerID == arg.StreamerID })
+	if i < 0 {
+		if s.streamerIndex(arg.StreamerID) < 0 {
+			return db.ChatCommand{}, missingReference("chat_commands", "chat_commands_streamer_id_fkey")
+		}
+		s.chatCommands = append(s.chatCommands, db.ChatCommand{
+			StreamerID: arg.StreamerID,
+			CreatedAt:  s.timestamp(),
+		})
+		i = len(s.chatCommands) - 1
+	}
+
+	command := &s.chatCommands[i]
+	command.Command = arg.Command
+	command.Enabled = arg.Enabled
+	command.CooldownSeconds = arg.CooldownSeconds
+	command.MaxEntriesPerViewer = arg.MaxEntriesPerViewer
+	command.UpdatedAt = s.timestamp()
+	return *command, nil
+}
+
+// Viewers
+
+func (s *Store) CreateViewer(ctx context.Context, arg db.CreateViewerParams) (db.Viewer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.viewerIndex(arg.TwitchID) >= 0 {
+		return db.Viewer{}, duplicateKey("viewers", "viewers_pkey")
+	}
+	if s.usernameTaken(arg.TwitchID, arg.Username) {
+		return db.Viewer{}, duplicateKey("viewers", "viewers_username_key")
+	}
+	if !s.referencesStreamer(arg.RegisteredIn) {
+		return db.Viewer{}, missingReference("viewers", "viewers_registered_in_fkey")
+	}
+
+	viewer := db.Viewer{
+		TwitchID:     arg.TwitchID,
+		Username:     arg.Username,
+		RegisteredIn: arg.RegisteredIn,
+		CreatedAt:    s.timestamp(),
+		UpdatedAt:    s.timestamp(),
+	}
+	s.viewers = append(s.viewers, viewer)
+	return viewer, nil
+}
+
+// upsertViewer creates the viewer or updates their login
+func (s *Store) upsertViewer(arg db.UpsertViewerParams) error {
+	if s.usernameTaken(arg.TwitchID, arg.Username) {
+		return duplicateKey("viewers", "viewers_username_key")
+	}
+
+	if i := s.viewerIndex(arg.TwitchID); i >= 0 {
+		s.viewers[i].Username = arg.Username
+		s.viewers[i].UpdatedAt = s.timestamp()
+		return nil
+	}
+
+	if !s.referencesStreamer(arg.RegisteredIn) {
+		return missingReference("viewers", "viewers_registered_in_fkey")
+	}
+
+	s.viewers = append(s.viewers, db.Viewer{
+		TwitchID:     arg.TwitchID,
+		Username:     arg.Username,
+		RegisteredIn: arg.RegisteredIn,
+		CreatedAt:    s.timestamp(),
+		UpdatedAt:    s.timestamp(),
+	})
+	return nil
+}
+
+// usernameTaken reports whether another viewer has the login
+func (s *Store) usernameTaken(twitchID, username string) bool {
+	return find(s.viewers, func(v db.Viewer) bool { return v.Username == username && v.TwitchID != twitchID }) >= 0
+}
+
+func (s *Store) GetViewerByID(ctx context.Context, twitchID string) (db.Viewer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.viewer(twitchID)
+}
+
+func (s *Store) viewer(twitchID string) (db.Viewer, error) {
+	i := s.viewerIndex(twitchID)
+	if i < 0 {
+		return db.Viewer{}, pgx.ErrNoRows
+	}
+	return s.viewers[i], nil
+}
+
+func (s *Store) GetViewerByUsername(ctx context.Context, username string) (db.Viewer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := find(s.viewers, func(v db.Viewer) bool { return v.Username == username })
+	if i < 0 {
+		return db.Viewer{}, pgx.ErrNoRows
+	}
+	return s.viewers[i], nil
+}
+
+func (s *Store) IsViewerOptedOut(ctx context.Context, twitchID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.optedOut(twitchID), nil
+}
+
+func (s *Store) optedOut(twitchID string) bool {
+	return find(s.optOuts, func(o db.ViewerOptOut) bool { return o.TwitchID == twitchID }) >= 0
+}
+
+func (s *Store) OptOutViewer(ctx context.Context, twitchID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.optedOut(twitchID) {
+		s.optOuts = append(s.optOuts, db.ViewerOptOut{TwitchID: twitchID, OptedOutAt: s.timestamp()})
+	}
+
+	var deleted []int64
+	for _, winner := range s.winners {
+		if winner.ViewerID != twitchID {
+			continue
+		}
+		deleted = append(deleted, winner.ID)
+
+		// The prize still went to the viewer
+		if winner.Status == "pending_claim" || winner.Status == "claimed" {
+			if i := find(s.prizes, func(p db.Prize) bool { return winner.PrizeID.Valid && p.ID == winner.PrizeID.Int64 }); i >= 0 {
+				s.prizes[i].OptedOutWinners++
+			}
+		}
+	}
+
+	for i := range s.winners {
+		if s.winners[i].RedrawnFrom.Valid && slices.Contains(deleted, s.winners[i].RedrawnFrom.Int64) {
+			s.winners[i].RedrawnFrom = pgtype.Int8{}
+			s.winners[i].UpdatedAt = s.timestamp()
+		}
+	}
+	s.winners = slices.DeleteFunc(s.winners, func(w db.Winner) bool { return w.ViewerID == twitchID })
+	s.announcements = slices.DeleteFunc(s.announcements, func(a db.Announcement) bool { return slices.Contains(deleted, a.WinnerID) })
+	s.redemptions = slices.DeleteFunc(s.redemptions, func(r db.Redemption) bool { return r.ViewerID.Valid && r.ViewerID.String == twitchID })
+	s.viewers = slices.DeleteFunc(s.viewers, func(v db.Viewer) bool { return v.TwitchID == twitchID })
+	return nil
+}
+
+// Entries
+
+// InEntryTx holds the store for the whole transaction, so nothing else reads or writes in between.
+// The tables are put back the way they were when fn fails.
+func (s *Store) InEntryTx(ctx context.Context, fn func(tx db.EntryTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.tables.snapshot()
+	if err := fn(entryTx{s}); err != nil {
+		s.tables = before
+		return err
+	}
+	return nil
+}
+
+// entryTx runs the queries of a transaction on the store it holds
+type entryTx struct {
+	s *Store
+}
+
+func (tx entryTx) LockOpenGiveaway(ctx context.Context) (db.Giveaway, error) {
+	giveaway, err := tx.s.openGiveaway()
+	if err != nil {
+		return db.Giveaway{}, err
+	}
+
+	now := tx.s.now()
+	if giveaway.OpensAt.Valid && giveaway.OpensAt.Time.After(now) || giveaway.ClosesAt.Valid && !giveaway.ClosesAt.Time.After(now) {
+		return db.Giveaway{}, pgx.ErrNoRows
+	}
+	return giveaway, nil
+}
+
+func (tx entryTx) IsEntryReward(ctx context.Context, arg db.IsEntryRewardParams) (bool, error) {
+	return tx.s.isEntryReward(arg), nil
+}
+
+func (tx entryTx) IsViewerOptedOut(ctx context.Context, twitchID string) (bool, error) {
+	return tx.s.optedOut(twitchID), nil
+}
+
+func (tx entryTx) GetViewerByID(ctx context.Context, twitchID string) (db.Viewer, error) {
+	return tx.s.viewer(twitchID)
+}
+
+func (tx entryTx) UpsertViewer(ctx context.Context, arg db.UpsertViewerParams) error {
+	return tx.s.upsertViewer(arg)
+}
+
+func (tx entryTx) GetViewerEntryStats(ctx context.Context, arg db.GetViewerEntryStatsParams) (db.GetViewerEntryStatsRow, error) {
+	return tx.s.viewerEntryStats(arg), nil
+}
+
+func (tx entryTx) AddRedemption(ctx context.Context, arg db.AddRedemptionParams) (int64, error) {
+	return tx.s.addRedemption(arg)
+}
+
+func (tx entryTx) ImportRedemption(ctx context.Context, arg db.ImportRedemptionParams) (int64, error) {
+	return tx.s.addRedemption(db.AddRedemptionParams(arg))
+}
+
+func (s *Store) insertRedemption(redemption db.Redemption) error {
+	if !s.referencesStreamer(redemption.StreamerID) {
+		return missingReference("redemptions", "redemptions_streamer_id_fkey")
+	}
+	if !s.referencesViewer(redemption.ViewerID) {
+		return missingReference("redemptions", "redemptions_viewer_id_fkey")
+	}
+	if s.giveawayIndex(redemption.GiveawayID) < 0 {
+		return missingReference("redemptions", "redemptions_giveaway_id_fkey")
+	}
+
+	s.redemptions = append(s.redemptions, redemption)
+	return nil
+}
+
+func (s *Store) redemptionExists(messageID string) bool {
+	return find(s.redemptions, func(r db.Redemption) bool { return r.MessageID == messageID }) >= 0
+}
+
+func (s *Store) ImportRedemption(ctx context.Context, arg db.ImportRedemptionParams) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addRedemption(db.AddRedemptionParams(arg))
+}
+
+// addRedemption inserts the redemption unless its message ID is taken, like ON CONFLICT DO NOTHING
+func (s *Store) addRedemption(arg db.AddRedemptionParams) (int64, error) {
+	if s.redemptionExists(arg.MessageID) {
+		return 0, nil
+	}
+
+	redeemedAt := arg.RedeemedAt
+	if !redeemedAt.Valid {
+		redeemedAt = s.timestamp()
+	}
+
+	err := s.insertRedemption(db.Redemption{
+		MessageID:   arg.MessageID,
+		StreamerID:  arg.StreamerID,
+		ViewerID:    arg.ViewerID,
+		RedeemedAt:  redeemedAt,
+		EntryMethod: arg.EntryMethod,
+		GiveawayID:  arg.GiveawayID,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (s *Store) RedemptionExists(ctx context.Context, messageID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.redemptionExists(messageID), nil
+}
+
+func (s *Store) GetViewerEntryStats(ctx context.Context, arg db.GetViewerEntryStatsParams) (db.GetViewerEntryStatsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.viewerEntryStats(arg), nil
+}
+
+func (s *Store) viewerEntryStats(arg db.GetViewerEntryStatsParams) db.GetViewerEntryStatsRow {
+	row := db.GetViewerEntryStatsRow{LastEntryAt: pgtype.Timestamptz{Time: time.Unix(0, 0).UTC(), Valid: true}}
+	for _, r := range s.redemptions {
+		if r.StreamerID.Valid && r.StreamerID == arg.StreamerID && r.ViewerID.Valid && r.ViewerID == arg.ViewerID &&
+			r.EntryMethod == arg.EntryMethod && r.GiveawayID == arg.GiveawayID {
+			row.TotalEntries++
+			if r.RedeemedAt.Valid && r.RedeemedAt.Time.After(row.LastEntryAt.Time) {
+				row.LastEntryAt = r.RedeemedAt
+			}
+		}
+	}
+	return row
+}
+
+// Giveaways and prizes
+
+func (s *Store) CreateGiveaway(ctx context.Context, arg db.CreateGiveawayParams) (db.Giveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if arg.Status == "open" && s.openGiveawayIndex() >= 0 {
+		return db.Giveaway{}, duplicateKey("giveaways", "idx_giveaways_one_open")
+	}
+	if !s.referencesStreamer(arg.CreatedBy) {
+		return db.Giveaway{}, missingReference("giveaways", "giveaways_created_by_fkey")
+	}
+
+	s.nextGiveawayID++
+	giveaway := db.Giveaway{
+		ID:        s.nextGiveawayID,
+		Name:      arg.Name,
+		Status:    arg.Status,
+		CreatedBy: arg.CreatedBy,
+		CreatedAt: s.timestamp(),
+		UpdatedAt: s.timestamp(),
+		OpensAt:   arg.OpensAt,
+		ClosesAt:  arg.ClosesAt,
+	}
+	s.giveaways = append(s.giveaways, giveaway)
+	return giveaway, nil
+}
+
+func (s *Store) GetGiveawayByID(ctx context.Context, id int64) (db.Giveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.giveawayIndex(id)
+	if i < 0 {
+		return db.Giveaway{}, pgx.ErrNoRows
+	}
+	return s.giveaways[i], nil
+}
+
+func (s *Store) GetGiveaways(ctx context.Context) ([]db.Giveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	giveaways := slices.Clone(s.giveaways)
+	byTimeDesc(giveaways, func(g db.Giveaway) time.Time { return g.CreatedAt.Time })
+	return giveaways, nil
+}
+
+func (s *Store) openGiveaway() (db.Giveaway, error) {
+	i := s.openGiveawayIndex()
+	if i < 0 {
+		return db.Giveaway{}, pgx.ErrNoRows
+	}
+	return s.giveaways[i], nil
+}
+
+func (s *Store) openGiveawayIndex() int {
+	return find(s.giveaways, func(g db.Giveaway) bool { return g.Status == "open" })
+}
+
+func (s *Store) GetOpenGiveaway(ctx context.Context) (db.Giveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.openGiveaway()
+}
+
+func (s *Store) SetGiveawayStatus(ctx context.Context, arg db.SetGiveawayStatusParams) (db.Giveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.giveawayIndex(arg.ID)
+	if i < 0 {
+		return db.Giveaway{}, pgx.ErrNoRows
+	}
+	if open := s.openGiveawayIndex(); arg.Status == "open" && open >= 0 && open != i {
+		return db.Giveaway{}, duplicateKey("giveaways", "idx_giveaways_one_open")
+	}
+
+	s.giveaways[i].Status = arg.Status
+	s.giveaways[i].UpdatedAt = s.timestamp()
+	return s.giveaways[i], nil
+}
+
+func (s *Store) SetGiveawaySchedule(ctx context.Context, arg db.SetGiveawayScheduleParams) (db.Giveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.giveawayIndex(arg.ID)
+	if i < 0 {
+		return db.Giveaway{}, pgx.ErrNoRows
+	}
+
+	s.giveaways[i].OpensAt = arg.OpensAt
+	s.giveaways[i].ClosesAt = arg.ClosesAt
+	s.giveaways[i].UpdatedAt = s.timestamp()
+	return s.giveaways[i], nil
+}
+
+// Recurring giveaways
+
+func (s *Store) CreateRecurringGiveaway(ctx context.Context, arg db.CreateRecurringGiveawayParams) (db.RecurringGiveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if arg.DurationSeconds <= 0 {
+		return db.RecurringGiveaway{}, constraintError(checkViolation, "recurring_giveaways", "recurring_giveaways_duration_seconds_check",
+			`new row for relation "recurring_giveaways" violates check constraint "recurring_giveaways_duration_seconds_check"`)
+	}
+	if arg.PrizeQuantity <= 0 {
+		return db.RecurringGiveaway{}, constraintError(checkViolation, "recurring_giveaways", "recurring_giveaways_prize_quantity_check",
+			`new row for relation "recurring_giveaways" violates check constraint "recurring_giveaways_prize_quantity_check"`)
+	}
+	if !s.referencesStreamer(arg.CreatedBy) {
+		return db.RecurringGiveaway{}, missingReference("recurring_giveaways", "recurring_giveaways_created_by_fkey")
+	}
+
+	s.nextRecurringID++
+	recurring := db.RecurringGiveaway{
+		ID:              s.nextRecurringID,
+		Name:            arg.Name,
+		Rrule:           arg.Rrule,
+		Timezone:        arg.Timezone,
+		StartsAt:        arg.StartsAt,
+		DurationSeconds: arg.DurationSeconds,
+		PrizeName:       arg.PrizeName,
+		PrizeQuantity:   arg.PrizeQuantity,
+		AutoDraw:        arg.AutoDraw,
+		Enabled:         true,
+		CreatedBy:       arg.CreatedBy,
+		CreatedAt:       s.timestamp(),
+		UpdatedAt:       s.timestamp(),
+	}
+	s.recurring = append(s.recurring, recurring)
+	return recurring, nil
+}
+
+func (s *Store) GetRecurringGiveaways(ctx context.Context) ([]db.RecurringGiveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recurring := slices.Clone(s.recurring)
+	byTimeDesc(recurring, func(r db.RecurringGiveaway) time.Time { return r.CreatedAt.Time })
+	return recurring, nil
+}
+
+func (s *Store) GetEnabledRecurringGiveaways(ctx context.Context) ([]db.RecurringGiveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Inserted in ID order
+	return filter(s.recurring, func(r db.RecurringGiveaway) bool { return r.Enabled }), nil
+}
+
+func (s *Store) SetRecurringGiveawayEnabled(ctx context.Context, arg db.SetRecurringGiveawayEnabledParams) (db.RecurringGiveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := find(s.recurring, func(r db.RecurringGiveaway) bool { return r.ID == arg.ID })
+	if i < 0 {
+		return db.RecurringGiveaway{}, pgx.ErrNoRows
+	}
+
+	s.recurring[i].Enabled = arg.Enabled
+	s.recurring[i].UpdatedAt = s.timestamp()
+	return s.recurring[i], nil
+}
+
+func (s *Store) DeleteRecurringGiveaway(ctx context.Context, id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := find(s.recurring, func(r db.RecurringGiveaway) bool { return r.ID == id })
+	if i < 0 {
+		return 0, nil
+	}
+
+	// ON DELETE SET NULL
+	for j := range s.giveaways {
+		if s.giveaways[j].RecurringGiveawayID.Valid && s.giveaways[j].RecurringGiveawayID.Int64 == id {
+			s.giveaways[j].RecurringGiveawayID = pgtype.Int8{}
+		}
+	}
+
+	s.recurring = slices.Delete(s.recurring, i, i+1)
+	return 1, nil
+}
+
+// InOccurrenceTx holds the store for the whole transaction, so nothing else reads or writes in between.
+// The tables are put back the way they were when fn fails.
+func (s *Store) InOccurrenceTx(ctx context.Context, fn func(tx db.OccurrenceTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.tables.snapshot()
+	if err := fn(occurrenceTx{s}); err != nil {
+		s.tables = before
+		return err
+	}
+	return nil
+}
+
+// occurrenceTx runs the queries of a transaction on the store it holds
+type occurrenceTx struct {
+	s *Store
+}
+
+func (tx occurrenceTx) CreateScheduledGiveaway(ctx context.Context, arg db.CreateScheduledGiveawayParams) (db.Giveaway, error) {
+	return tx.s.createScheduledGiveaway(arg)
+}
+
+func (tx occurrenceTx) CreatePrize(ctx context.Context, arg db.CreatePrizeParams) (db.Prize, error) {
+	return tx.s.createPrize(arg)
+}
+
+func (s *Store) CreateScheduledGiveaway(ctx context.Context, arg db.CreateScheduledGiveawayParams) (db.Giveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createScheduledGiveaway(arg)
+}
+
+func (s *Store) createScheduledGiveaway(arg db.CreateScheduledGiveawayParams) (db.Giveaway, error) {
+	if !s.referencesStreamer(arg.CreatedBy) {
+		return db.Giveaway{}, missingReference("giveaways", "giveaways_created_by_fkey")
+	}
+	if arg.RecurringGiveawayID.Valid && find(s.recurring, func(r db.RecurringGiveaway) bool { return r.ID == arg.RecurringGiveawayID.Int64 }) < 0 {
+		return db.Giveaway{}, missingReference("giveaways", "giveaways_recurring_giveaway_id_fkey")
+	}
+
+	// ON CONFLICT DO NOTHING on idx_giveaways_recurring_occurrence
+	if arg.RecurringGiveawayID.Valid && find(s.giveaways, func(g db.Giveaway) bool {
+		return g.RecurringGiveawayID == arg.RecurringGiveawayID && g.ScheduledFor.Valid == arg.ScheduledFor.Valid &&
+			g.ScheduledFor.Time.Equal(arg.ScheduledFor.Time)
+	}) >= 0 {
+		return db.Giveaway{}, pgx.ErrNoRows
+	}
+
+	s.nextGiveawayID++
+	giveaway := db.Giveaway{
+		ID:                  s.nextGiveawayID,
+		Name:                arg.Name,
+		Status:              "draft",
+		CreatedBy:           arg.CreatedBy,
+		CreatedAt:           s.timestamp(),
+		UpdatedAt:           s.timestamp(),
+		OpensAt:             arg.OpensAt,
+		ClosesAt:            arg.ClosesAt,
+		RecurringGiveawayID: arg.RecurringGiveawayID,
+		ScheduledFor:        arg.ScheduledFor,
+		AutoDraw:            arg.AutoDraw,
+	}
+	s.giveaways = append(s.giveaways, giveaway)
+	return giveaway, nil
+}
+
+func (s *Store) GetScheduledGiveawaysToOpen(ctx context.Context, opensAt pgtype.Timestamptz) ([]db.Giveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	giveaways := filter(s.giveaways, func(g db.Giveaway) bool {
+		return g.ScheduledFor.Valid && g.Status == "draft" &&
+			g.OpensAt.Valid && !g.OpensAt.Time.After(opensAt.Time) &&
+			g.ClosesAt.Valid && g.ClosesAt.Time.After(opensAt.Time)
+	})
+	slices.SortStableFunc(giveaways, func(a, b db.Giveaway) int { return a.OpensAt.Time.Compare(b.OpensAt.Time) })
+	return giveaways, nil
+}
+
+func (s *Store) GetScheduledGiveawaysToClose(ctx context.Context, closesAt pgtype.Timestamptz) ([]db.Giveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	giveaways := filter(s.giveaways, func(g db.Giveaway) bool {
+		return g.ScheduledFor.Valid && (g.Status == "draft" || g.Status == "open") &&
+			g.ClosesAt.Valid && !g.ClosesAt.Time.After(closesAt.Time)
+	})
+	slices.SortStableFunc(giveaways, func(a, b db.Giveaway) int { return a.ClosesAt.Time.Compare(b.ClosesAt.Time) })
+	return giveaways, nil
+}
+
+func (s *Store) CloseScheduledGiveaway(ctx context.Context, id int64) (db.Giveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.giveawayIndex(id)
+	if i < 0 || s.giveaways[i].Status != "draft" && s.giveaways[i].Status != "open" {
+		return db.Giveaway{}, pgx.ErrNoRows
+	}
+
+	s.giveaways[i].AutoDrawPending = s.giveaways[i].AutoDraw && s.giveaways[i].Status == "open"
+	s.giveaways[i].Status = "closed"
+	s.giveaways[i].UpdatedAt = s.timestamp()
+	return s.giveaways[i], nil
+}
+
+func (s *Store) GetGiveawaysPendingAutoDraw(ctx context.Context) ([]db.Giveaway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	giveaways := filter(s.giveaways, func(g db.Giveaway) bool { return g.AutoDrawPending })
+	slices.SortStableFunc(giveaways, func(a, b db.Giveaway) int { return a.ClosesAt.Time.Compare(b.ClosesAt.Time) })
+	return giveaways, nil
+}
+
+func (s *Store) FinishAutoDraw(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := s.giveawayIndex(id); i >= 0 {
+		s.giveaways[i].AutoDrawPending = false
+	}
+	return nil
+}
+
+func (s *Store) CreatePrize(ctx context.Context, arg db.CreatePrizeParams) (db.Prize, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createPrize(arg)
+}
+
+func (s *Store) createPrize(arg db.CreatePrizeParams) (db.Prize, error) {
+	if arg.Quantity <= 0 {
+		return db.Prize{}, constraintError(checkViolation, "prizes", "prizes_quantity_check",
+			`new row for relation "prizes" violates check constraint "prizes_quantity_check"`)
+	}
+	if s.giveawayIndex(arg.GiveawayID) < 0 {
+		return db.Prize{}, missingReference("prizes", "prizes_giveaway_id_fkey")
+	}
+
+	s.nextPrizeID++
+	prize := db.Prize{
+		ID:         s.nextPrizeID,
+		GiveawayID: arg.GiveawayID,
+		Name:       arg.Name,
+		Quantity:   arg.Quantity,
+		Tier:       arg.Tier,
+		CreatedAt:  s.timestamp(),
+	}
+	s.prizes = append(s.prizes, prize)
+	return prize, nil
+}
+
+func (s *Store) GetPrizeByID(ctx context.Context, id int64) (db.Prize, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.prize(id)
+}
+
+func (s *Store) prize(id int64) (db.Prize, error) {
+	i := s.prizeIndex(id)
+	if i < 0 {
+		return db.Prize{}, pgx.ErrNoRows
+	}
+	return s.prizes[i], nil
+}
+
+func (s *Store) GetPrizesByGiveaway(ctx context.Context, giveawayID int64) ([]db.Prize, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prizes := filter(s.prizes, func(p db.Prize) bool { return p.GiveawayID == giveawayID })
+	slices.SortFunc(prizes, func(a, b db.Prize) int {
+		return cmp.Or(cmp.Compare(a.Tier, b.Tier), cmp.Compare(a.ID, b.ID))
+	})
+	return prizes, nil
+}
+
+func (s *Store) DeletePrize(ctx context.Context, arg db.DeletePrizeParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := find(s.prizes, func(p db.Prize) bool { return p.ID == arg.ID && p.GiveawayID == arg.GiveawayID })
+	if i < 0 {
+		return nil
+	}
+	if find(s.winners, func(w db.Winner) bool { return w.PrizeID.Valid && w.PrizeID.Int64 == arg.ID }) >= 0 {
+		return stillReferenced("prizes", "winners_prize_id_fkey", "winners")
+	}
+
+	s.prizes = slices.Delete(s.prizes, i, i+1)
+	return nil
+}
+
+// Draws and winners
+
+// InDrawTx holds the store for the whole transaction, so nothing else reads or writes in between.
+// The tables are put back the way they were when fn fails.
+func (s *Store) InDrawTx(ctx context.Context, fn func(tx db.DrawTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.tables.snapshot()
+	if err := fn(drawTx{s}); err != nil {
+		s.tables = before
+		return err
+	}
+	return nil
+}
+
+// drawTx runs the queries of a transaction on the store it holds
+type drawTx struct {
+	s *Store
+}
+
+func (tx drawTx) LockPrize(ctx context.Context, id int64) (db.Prize, error) {
+	return tx.s.prize(id)
+}
+
+func (tx drawTx) GetViewerEntryCounts(ctx context.Context, giveawayID int64) ([]db.GetViewerEntryCountsRow, error) {
+	return tx.s.viewerEntryCounts(giveawayID), nil
+}
+
+func (tx drawTx) CountActiveWinnersByPrize(ctx context.Context, prizeID pgtype.Int8) (int64, error) {
+	return tx.s.countActiveWinners(prizeID), nil
+}
+
+func (tx drawTx) GetWinnerViewerIDsByGiveaway(ctx context.Context, giveawayID int64) ([]string, error) {
+	return tx.s.winnerViewerIDs(giveawayID), nil
+}
+
+func (tx drawTx) GetWinnerByID(ctx context.Context, id int64) (db.Winner, error) {
+	return tx.s.winner(id)
+}
+
+func (tx drawTx) SetWinnerStatus(ctx context.Context, arg db.SetWinnerStatusParams) (db.Winner, error) {
+	return tx.s.setWinnerStatus(arg)
+}
+
+func (tx drawTx) CreateDraw(ctx context.Context, arg db.CreateDrawParams) (db.Draw, error) {
+	return tx.s.createDraw(arg)
+}
+
+func (tx drawTx) CreateWinner(ctx context.Context, arg db.CreateWinnerParams) (db.Winner, error) {
+	return tx.s.createWinner(arg)
+}
+
+func (s *Store) GetViewerEntryCounts(ctx context.Context, giveawayID int64) ([]db.GetViewerEntryCountsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.viewerEntryCounts(giveawayID), nil
+}
+
+func (s *Store) viewerEntryCounts(giveawayID int64) []db.GetViewerEntryCountsRow {
+	var rows []db.GetViewerEntryCountsRow
+	for _, r := range s.redemptions {
+		if r.GiveawayID != giveawayID || !r.ViewerID.Valid {
+			continue
+		}
+
+		v := s.viewerIndex(r.ViewerID.String)
+		if v < 0 {
+			continue
+		}
+
+		if i := find(rows, func(row db.GetViewerEntryCountsRow) bool { return row.ViewerID == r.ViewerID.String }); i >= 0 {
+			rows[i].Entries++
+		} else {
+			rows = append(rows, db.GetViewerEntryCountsRow{ViewerID: r.ViewerID.String, Username: s.viewers[v].Username, Entries: 1})
+		}
+	}
+	return rows
+}
+
+func (s *Store) GetParticipatingStreamers(ctx context.Context, giveawayID int64) ([]db.Streamer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return filter(s.streamers, func(st db.Streamer) bool {
+		return find(s.redemptions, func(r db.Redemption) bool {
+			return r.GiveawayID == giveawayID && r.StreamerID.Valid && r.StreamerID.String == st.TwitchID
+		}) >= 0
+	}), nil
+}
+
+func (s *Store) CreateDraw(ctx context.Context, arg db.CreateDrawParams) (db.Draw, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createDraw(arg)
+}
+
+func (s *Store) createDraw(arg db.CreateDrawParams) (db.Draw, error) {
+	if s.giveawayIndex(arg.GiveawayID) < 0 {
+		return db.Draw{}, missingReference("draws", "draws_giveaway_id_fkey")
+	}
+	if !s.referencesStreamer(arg.DrawnBy) {
+		return db.Draw{}, missingReference("draws", "draws_drawn_by_fkey")
+	}
+
+	s.nextDrawID++
+	draw := db.Draw{
+		ID:           s.nextDrawID,
+		Prize:        arg.Prize,
+		DrawnBy:      arg.DrawnBy,
+		TotalEntries: arg.TotalEntries,
+		DrawnAt:      s.timestamp(),
+		GiveawayID:   arg.GiveawayID,
+	}
+	s.draws = append(s.draws, draw)
+	return draw, nil
+}
+
+func (s *Store) CreateWinner(ctx context.Context, arg db.CreateWinnerParams) (db.Winner, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createWinner(arg)
+}
+
+func (s *Store) createWinner(arg db.CreateWinnerParams) (db.Winner, error) {
+	if s.drawIndex(arg.DrawID) < 0 {
+		return db.Winner{}, missingReference("winners", "winners_draw_id_fkey")
+	}
+	if s.viewerIndex(arg.ViewerID) < 0 {
+		return db.Winner{}, missingReference("winners", "winners_viewer_id_fkey")
+	}
+	if arg.PrizeID.Valid && s.prizeIndex(arg.PrizeID.Int64) < 0 {
+		return db.Winner{}, missingReference("winners", "winners_prize_id_fkey")
+	}
+	if arg.RedrawnFrom.Valid && s.winnerIndex(arg.RedrawnFrom.Int64) < 0 {
+		return db.Winner{}, missingReference("winners", "winners_redrawn_from_fkey")
+	}
+
+	s.nextWinnerID++
+	winner := db.Winner{
+		ID:            s.nextWinnerID,
+		DrawID:        arg.DrawID,
+		ViewerID:      arg.ViewerID,
+		Entries:       arg.Entries,
+		CreatedAt:     s.timestamp(),
+		PrizeID:       arg.PrizeID,
+		Status:        arg.Status,
+		RedrawnFrom:   arg.RedrawnFrom,
+		UpdatedAt:     s.timestamp(),
+		ClaimDeadline: arg.ClaimDeadline,
+	}
+	s.winners = append(s.winners, winner)
+	return winner, nil
+}
+
+func (s *Store) CreateAnnouncement(ctx context.Context, arg db.CreateAnnouncementParams) (db.Announcement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.winnerIndex(arg.WinnerID) < 0 {
+		return db.Announcement{}, missingReference("announcements", "announcements_winner_id_fkey")
+	}
+
+	s.nextAnnouncementID++
+	announcement := db.Announcement{
+		ID:        s.nextAnnouncementID,
+		WinnerID:  arg.WinnerID,
+		SenderID:  arg.SenderID,
+		Kind:      arg.Kind,
+		Message:   arg.Message,
+		Sent:      arg.Sent,
+		Error:     arg.Error,
+		CreatedAt: s.timestamp(),
+	}
+	s.announcements = append(s.announcements, announcement)
+	return announcement, nil
+}
+
+func (s *Store) CountActiveWinnersByPrize(ctx context.Context, prizeID pgtype.Int8) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.countActiveWinners(prizeID), nil
+}
+
+func (s *Store) countActiveWinners(prizeID pgtype.Int8) int64 {
+	var count int64
+	if i := find(s.prizes, func(p db.Prize) bool { return prizeID.Valid && p.ID == prizeID.Int64 }); i >= 0 {
+		count = int64(s.prizes[i].OptedOutWinners)
+	}
+	for _, w := range s.winners {
+		if prizeID.Valid && w.PrizeID == prizeID && (w.Status == "pending_claim" || w.Status == "claimed") {
+			count++
+		}
+	}
+	return count
+}
+
+// drawGiveawayID returns the giveaway the winner was drawn for
+func (s *Store) drawGiveawayID(w db.Winner) (int64, bool) {
+	i := s.drawIndex(w.DrawID)
+	if i < 0 {
+		return 0, false
+	}
+	return s.draws[i].GiveawayID, true
+}
+
+func (s *Store) GetWinnerViewerIDsByGiveaway(ctx context.Context, giveawayID int64) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.winnerViewerIDs(giveawayID), nil
+}
+
+func (s *Store) winnerViewerIDs(giveawayID int64) []string {
+	var ids []string
+	for _, w := range s.winners {
+		if id, ok := s.drawGiveawayID(w); ok && id == giveawayID && !slices.Contains(ids, w.ViewerID) {
+			ids = append(ids, w.ViewerID)
+		}
+	}
+	return ids
+}
+
+func (s *Store) GetWinnerByID(ctx context.Context, id int64) (db.Winner, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.winner(id)
+}
+
+func (s *Store) winner(id int64) (db.Winner, error) {
+	i := s.winnerIndex(id)
+	if i < 0 {
+		return db.Winner{}, pgx.ErrNoRows
+	}
+	return s.winners[i], nil
+}
+
+func (s *Store) SetWinnerStatus(ctx context.Context, arg db.SetWinnerStatusParams) (db.Winner, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setWinnerStatus(arg)
+}
+
+// setWinnerStatus only moves a winner who still has the expected status, like the WHERE of the query
+func (s *Store) setWinnerStatus(arg db.SetWinnerStatusParams) (db.Winner, error) {
+	i := s.winnerIndex(arg.ID)
+	if i < 0 || s.winners[i].Status != arg.ExpectedStatus {
+		return db.Winner{}, pgx.ErrNoRows
+	}
+
+	s.winners[i].Status = arg.Status
+	s.winners[i].UpdatedAt = s.timestamp()
+	return s.winners[i], nil
+}
+
+func (s *Store) GetWinnersByGiveaway(ctx context.Context, giveawayID int64) ([]db.GetWinnersByGiveawayRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []db.GetWinnersByGiveawayRow
+	for _, w := range s.winners {
+		id, ok := s.drawGiveawayID(w)
+		v := s.viewerIndex(w.ViewerID)
+		if !ok || id != giveawayID || v < 0 {
+			continue
+		}
+
+		row := db.GetWinnersByGiveawayRow{
+			ID:             w.ID,
+			DrawID:         w.DrawID,
+			ViewerID:       w.ViewerID,
+			ViewerUsername: s.viewers[v].Username,
+			Entries:        w.Entries,
+			PrizeID:        w.PrizeID,
+			Status:         w.Status,
+			RedrawnFrom:    w.RedrawnFrom,
+			ClaimDeadline:  w.ClaimDeadline,
+			ClaimedAt:      w.ClaimedAt,
+			CreatedAt:      w.CreatedAt,
+		}
+		if p := s.prizeIndex(w.PrizeID.Int64); w.PrizeID.Valid && p >= 0 {
+			row.PrizeName = pgtype.Text{String: s.prizes[p].Name, Valid: true}
+			row.PrizeTier = pgtype.Int4{Int32: s.prizes[p].Tier, Valid: true}
+		}
+		rows = append(rows, row)
+	}
+
+	slices.SortStableFunc(rows, func(a, b db.GetWinnersByGiveawayRow) int {
+		return cmp.Or(a.CreatedAt.Time.Compare(b.CreatedAt.Time), cmp.Compare(a.ID, b.ID))
+	})
+	return rows, nil
+}
+
+func (s *Store) GetWinnersByViewer(ctx context.Context, viewerID string) ([]db.GetWinnersByViewerRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []db.GetWinnersByViewerRow
+	for _, w := range s.winners {
+		if w.ViewerID != viewerID {
+			continue
+		}
+
+		giveawayID, ok := s.drawGiveawayID(w)
+		g := s.giveawayIndex(giveawayID)
+		if !ok || g < 0 {
+			continue
+		}
+
+		row := db.GetWinnersByViewerRow{
+			ID:            w.ID,
+			GiveawayID:    giveawayID,
+			GiveawayName:  s.giveaways[g].Name,
+			Status:        w.Status,
+			ClaimDeadline: w.ClaimDeadline,
+			ClaimedAt:     w.ClaimedAt,
+			CreatedAt:     w.CreatedAt,
+		}
+		if p := s.prizeIndex(w.PrizeID.Int64); w.PrizeID.Valid && p >= 0 {
+			row.PrizeName = pgtype.Text{String: s.prizes[p].Name, Valid: true}
+		}
+		rows = append(rows, row)
+	}
+
+	byTimeDesc(rows, func(row db.GetWinnersByViewerRow) time.Time { return row.CreatedAt.Time })
+	return rows, nil
+}
+
+func (s *Store) ClaimWinner(ctx context.Context, arg db.ClaimWinnerParams) (db.Winner, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.winnerIndex(arg.ID)
+	if i < 0 || s.winners[i].Status != "pending_claim" {
+		return db.Winner{}, pgx.ErrNoRows
+	}
+	if deadline := s.winners[i].ClaimDeadline; deadline.Valid && !deadline.Time.After(s.now()) {
+		return db.Winner{}, pgx.ErrNoRows
+	}
+
+	s.winners[i].Status = "claimed"
+	s.winners[i].ClaimDetails = arg.ClaimDetails
+	s.winners[i].ClaimedAt = s.timestamp()
+	s.winners[i].UpdatedAt = s.timestamp()
+	return s.winners[i], nil
+}
+
+func (s *Store) GetExpiredClaims(ctx context.Context) ([]db.GetExpiredClaimsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var rows []db.GetExpiredClaimsRow
+	for _, w := range s.winners {
+		giveawayID, ok := s.drawGiveawayID(w)
+		if !ok || w.Status != "pending_claim" || !w.ClaimDeadline.Valid || !w.ClaimDeadline.Time.Before(now) {
+			continue
+		}
+
+		rows = append(rows, db.GetExpiredClaimsRow{
+			ID:            w.ID,
+			DrawID:        w.DrawID,
+			ViewerID:      w.ViewerID,
+			Entries:       w.Entries,
+			CreatedAt:     w.CreatedAt,
+			PrizeID:       w.PrizeID,
+			Status:        w.Status,
+			RedrawnFrom:   w.RedrawnFrom,
+			UpdatedAt:     w.UpdatedAt,
+			ClaimDeadline: w.ClaimDeadline,
+			ClaimDetails:  w.ClaimDetails,
+			ClaimedAt:     w.ClaimedAt,
+			GiveawayID:    giveawayID,
+		})
+	}
+
+	slices.SortStableFunc(rows, func(a, b db.GetExpiredClaimsRow) int {
+		return a.ClaimDeadline.Time.Compare(b.ClaimDeadline.Time)
+	})
+	return rows, nil
+}
+
+// Stats
+
+func (s *Store) GetViewerLeaderboard(ctx context.Context) ([]db.GetViewerLeaderboardRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []db.GetViewerLeaderboardRow
+	var ids []string
+	for _, r := range s.redemptions {
+		v := s.viewerIndex(r.ViewerID.String)
+		if !r.ViewerID.Valid || v < 0 {
+			continue
+		}
+
+		if i := slices.Index(ids, r.ViewerID.String); i >= 0 {
+			rows[i].TotalRedemptions++
+		} else {
+			ids = append(ids, r.ViewerID.String)
+			rows = append(rows, db.GetViewerLeaderboardRow{Username: s.viewers[v].Username, TotalRedemptions: 1})
+		}
+	}
+
+	slices.SortStableFunc(rows, func(a, b db.GetViewerLeaderboardRow) int {
+		return cmp.Compare(b.TotalRedemptions, a.TotalRedemptions)
+	})
+	return rows, nil
+}
+
+func (s *Store) GetRecentRedemptionsWithUsernames(ctx context.Context, limit int32) ([]db.GetRecentRedemptionsWithUsernamesRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []db.GetRecentRedemptionsWithUsernamesRow
+	for _, r := range s.redemptions {
+		st := s.streamerIndex(r.StreamerID.String)
+		v := s.viewerIndex(r.ViewerID.String)
+		if !r.StreamerID.Valid || !r.ViewerID.Valid || st < 0 || v < 0 {
+			continue
+		}
+
+		rows = append(rows, db.GetRecentRedemptionsWithUsernamesRow{
+			MessageID:        r.MessageID,
+			StreamerUsername: s.streamers[st].Username,
+			ViewerUsername:   s.viewers[v].Username,
+			RedeemedAt:       r.RedeemedAt,
+		})
+	}
+
+	byTimeDesc(rows, func(row db.GetRecentRedemptionsWithUsernamesRow) time.Time { return row.RedeemedAt.Time })
+	return limitRows(rows, limit), nil
+}
+
+func limitRows[T any](rows []T, limit int32) []T {
+	if limit >= 0 && len(rows) > int(limit) {
+		return rows[:limit]
+	}
+	return rows
+}
+
+func (s *Store) GetTotalRedemptionsCount(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int64(len(s.redemptions)), nil
+}
+
+func (s *Store) GetTotalParticipantsCount(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int64(len(s.viewers)), nil
+}
+
+func (s *Store) GetViewerEntriesByStreamer(ctx context.Context, arg db.GetViewerEntriesByStreamerParams) ([]db.GetViewerEntriesByStreamerRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []db.GetViewerEntriesByStreamerRow
+	for _, r := range s.redemptions {
+		st := s.streamerIndex(r.StreamerID.String)
+		if !arg.ViewerID.Valid || r.ViewerID != arg.ViewerID || r.GiveawayID != arg.GiveawayID || !r.StreamerID.Valid || st < 0 {
+			continue
+		}
+
+		if i := find(rows, func(row db.GetViewerEntriesByStreamerRow) bool { return row.StreamerID == r.StreamerID.String }); i >= 0 {
+			rows[i].Entries++
+		} else {
+			rows = append(rows, db.GetViewerEntriesByStreamerRow{
+				StreamerID:       r.StreamerID.String,
+				StreamerUsername: s.streamers[st].Username,
+				Entries:          1,
+			})
+		}
+	}
+
+	slices.SortStableFunc(rows, func(a, b db.GetViewerEntriesByStreamerRow) int {
+		return cmp.Compare(b.Entries, a.Entries)
+	})
+	return rows, nil
+}
+
+func (s *Store) GetViewerEntryHistory(ctx context.Context, arg db.GetViewerEntryHistoryParams) ([]db.GetViewerEntryHistoryRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []db.GetViewerEntryHistoryRow
+	for _, r := range s.redemptions {
+		g := s.giveawayIndex(r.GiveawayID)
+		st := s.streamerIndex(r.StreamerID.String)
+		if !arg.ViewerID.Valid || r.ViewerID != arg.ViewerID || g < 0 || !r.StreamerID.Valid || st < 0 {
+			continue
+		}
+
+		rows = append(rows, db.GetViewerEntryHistoryRow{
+			RedeemedAt:       r.RedeemedAt,
+			EntryMethod:      r.EntryMethod,
+			GiveawayID:       r.GiveawayID,
+			GiveawayName:     s.giveaways[g].Name,
+			StreamerUsername: s.streamers[st].Username,
+		})
+	}
+
+	byTimeDesc(rows, func(row db.GetViewerEntryHistoryRow) time.Time { return row.RedeemedAt.Time })
+	return limitRows(rows, arg.Limit), nil
+}
+
+// entryTotals counts the entries of a giveaway and the viewers who made them
+func (s *Store) entryTotals(giveawayID int64) (entries int64, participants int64) {
+	var viewers []string
+	for _, r := range s.redemptions {
+		if r.GiveawayID != giveawayID {
+			continue
+		}
+
+		entries++
+		if r.ViewerID.Valid && !slices.Contains(viewers, r.ViewerID.String) {
+			viewers = append(viewers, r.ViewerID.String)
+		}
+	}
+	return entries, int64(len(viewers))
+}
+
+func (s *Store) GetGiveawayEntryTotals(ctx context.Context, giveawayID int64) (db.GetGiveawayEntryTotalsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, participants := s.entryTotals(giveawayID)
+	return db.GetGiveawayEntryTotalsRow{TotalEntries: entries, TotalParticipants: participants}, nil
+}
+
+func (s *Store) GetOpenGiveawayEntryTotals(ctx context.Context) ([]db.GetOpenGiveawayEntryTotalsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []db.GetOpenGiveawayEntryTotalsRow
+	for _, g := range s.giveaways {
+		if g.Status != "open" {
+			continue
+		}
+
+		entries, participants := s.entryTotals(g.ID)
+		rows = append(rows, db.GetOpenGiveawayEntryTotalsRow{
+			GiveawayID:        g.ID,
+			Name:              g.Name,
+			TotalEntries:      entries,
+			TotalParticipants: participants,
+		})
+	}
+	return rows, nil
+}
+
+// Locks
+
+// lock is held until it's released, a Store lives in one process so there's no session to lose
+type lock struct {
+	s    *Store
+	name string
+	once sync.Once
+}
+
+func (s *Store) TryLock(ctx context.Context, name string) (db.Lock, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locks[name] {
+		return nil, false, nil
+	}
+	s.locks[name] = true
+	return &lock{s: s, name: name}, true, nil
+}
+
+func (l *lock) Check(ctx context.Context) error {
+	return nil
+}
+
+func (l *lock) Release() {
+	l.once.Do(func() {
+		l.s.mu.Lock()
+		defer l.s.mu.Unlock()
+		delete(l.s.locks, l.name)
+	})
+}
+
+// Messages
+
+// listenerBuffer is how many payloads a listener may fall behind before it misses some, like a slow Postgres listener would
+const listenerBuffer = 64
+
+func (s *Store) Publish(ctx context.Context, channel string, payload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.listeners[channel] {
+		select {
+		case ch <- payload:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *Store) Listen(ctx context.Context, channel string, fn func(payload string)) error {
+	ch := make(chan string, listenerBuffer)
+
+	s.mu.Lock()
+	if s.listeners[channel] == nil {
+		s.listeners[channel] = make(map[chan string]struct{})
+	}
+	s.listeners[channel][ch] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners[channel], ch)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case payload := <-ch:
+			fn(payload)
+		}
+	}
+}
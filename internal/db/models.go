@@ -8,17 +8,93 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type Announcement struct {
+	ID        int64              `json:"id"`
+	WinnerID  int64              `json:"winner_id"`
+	SenderID  string             `json:"sender_id"`
+	Kind      string             `json:"kind"`
+	Message   string             `json:"message"`
+	Sent      bool               `json:"sent"`
+	Error     pgtype.Text        `json:"error"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type ChatCommand struct {
+	StreamerID          string             `json:"streamer_id"`
+	Command             string             `json:"command"`
+	Enabled             bool               `json:"enabled"`
+	CooldownSeconds     int32              `json:"cooldown_seconds"`
+	MaxEntriesPerViewer int32              `json:"max_entries_per_viewer"`
+	CreatedAt           pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt           pgtype.Timestamptz `json:"updated_at"`
+}
+
+type Draw struct {
+	ID           int64              `json:"id"`
+	Prize        string             `json:"prize"`
+	DrawnBy      pgtype.Text        `json:"drawn_by"`
+	TotalEntries int64              `json:"total_entries"`
+	DrawnAt      pgtype.Timestamptz `json:"drawn_at"`
+	GiveawayID   int64              `json:"giveaway_id"`
+}
+
+type Giveaway struct {
+	ID                  int64              `json:"id"`
+	Name                string             `json:"name"`
+	Status              string             `json:"status"`
+	CreatedBy           pgtype.Text        `json:"created_by"`
+	CreatedAt           pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt           pgtype.Timestamptz `json:"updated_at"`
+	OpensAt             pgtype.Timestamptz `json:"opens_at"`
+	ClosesAt            pgtype.Timestamptz `json:"closes_at"`
+	RecurringGiveawayID pgtype.Int8        `json:"recurring_giveaway_id"`
+	ScheduledFor        pgtype.Timestamptz `json:"scheduled_for"`
+	AutoDraw            bool               `json:"auto_draw"`
+	AutoDrawPending     bool               `json:"auto_draw_pending"`
+}
+
+type Prize struct {
+	ID              int64              `json:"id"`
+	GiveawayID      int64              `json:"giveaway_id"`
+	Name            string             `json:"name"`
+	Quantity        int32              `json:"quantity"`
+	Tier            int32              `json:"tier"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	OptedOutWinners int32              `json:"opted_out_winners"`
+}
+
+type RecurringGiveaway struct {
+	ID              int64              `json:"id"`
+	Name            string             `json:"name"`
+	Rrule           string             `json:"rrule"`
+	Timezone        string             `json:"timezone"`
+	StartsAt        pgtype.Timestamptz `json:"starts_at"`
+	DurationSeconds int32              `json:"duration_seconds"`
+	PrizeName       pgtype.Text        `json:"prize_name"`
+	PrizeQuantity   int32              `json:"prize_quantity"`
+	AutoDraw        bool               `json:"auto_draw"`
+	Enabled         bool               `json:"enabled"`
+	CreatedBy       pgtype.Text        `json:"created_by"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt       pgtype.Timestamptz `json:"updated_at"`
+}
+
 type Redemption struct {
-	MessageID  string             `json:"message_id"`
-	StreamerID pgtype.Text        `json:"streamer_id"`
-	ViewerID   pgtype.Text        `json:"viewer_id"`
-	RedeemedAt pgtype.Timestamptz `json:"redeemed_at"`
+	MessageID   string             `json:"message_id"`
+	StreamerID  pgtype.Text        `json:"streamer_id"`
+	ViewerID    pgtype.Text        `json:"viewer_id"`
+	RedeemedAt  pgtype.Timestamptz `json:"redeemed_at"`
+	EntryMethod string             `json:"entry_method"`
+	GiveawayID  int64              `json:"giveaway_id"`
 }
 
 type Reward struct {
 	RewardID   string             `json:"reward_id"`
 	StreamerID pgtype.Text        `json:"streamer_id"`
 	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+	Status     string             `json:"status"`
+	GiveawayID pgtype.Int8        `json:"giveaway_id"`
+	RetiredAt  pgtype.Timestamptz `json:"retired_at"`
 }
 
 type Streamer struct {
@@ -31,6 +107,8 @@ type Streamer struct {
 	CreatedAt       pgtype.Timestamptz `json:"created_at"`
 	UpdatedAt       pgtype.Timestamptz `json:"updated_at"`
 	IsLive          pgtype.Bool        `json:"is_live"`
+	RewardLiveOnly  bool               `json:"reward_live_only"`
+	BroadcasterType pgtype.Text        `json:"broadcaster_type"`
 }
 
 type Viewer struct {
@@ -40,3 +118,23 @@ type Viewer struct {
 	CreatedAt    pgtype.Timestamptz `json:"created_at"`
 	UpdatedAt    pgtype.Timestamptz `json:"updated_at"`
 }
+
+type ViewerOptOut struct {
+	TwitchID   string             `json:"twitch_id"`
+	OptedOutAt pgtype.Timestamptz `json:"opted_out_at"`
+}
+
+type Winner struct {
+	ID            int64              `json:"id"`
+	DrawID        int64              `json:"draw_id"`
+	ViewerID      string             `json:"viewer_id"`
+	Entries       int64              `json:"entries"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+	PrizeID       pgtype.Int8        `json:"prize_id"`
+	Status        string             `json:"status"`
+	RedrawnFrom   pgtype.Int8        `json:"redrawn_from"`
+	UpdatedAt     pgtype.Timestamptz `json:"updated_at"`
+	ClaimDeadline pgtype.Timestamptz `json:"claim_deadline"`
+	ClaimDetails  []byte             `json:"claim_details"`
+	ClaimedAt     pgtype.Timestamptz `json:"claimed_at"`
+}
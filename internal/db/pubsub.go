@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PubSub carries small messages between the replicas, every listener of a channel gets every payload sent to it.
+// Messages sent while a listener is reconnecting are lost, so they should only ever speed up work done periodically anyway.
+type PubSub interface {
+	Publish(ctx context.Context, channel string, payload string) error
+	// Listen calls fn with every payload until ctx is cancelled or the connection is lost, which returns the error
+	Listen(ctx context.Context, channel string, fn func(payload string)) error
+}
+
+// Publish sends a Postgres notification, payloads are limited to 8000 bytes
+func (s *DBStore) Publish(ctx context.Context, channel string, payload string) error {
+	if _, err := s.connPool.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload); err != nil {
+		return fmt.Errorf("error sending the notification: %w", err)
+	}
+	return nil
+}
+
+// Listen holds a connection of its own for LISTEN, it is closed instead of going back to the pool
+func (s *DBStore) Listen(ctx context.Context, channel string, fn func(payload string)) error {
+	conn, err := s.connPool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring a connection: %w", err)
+	}
+	pgConn := conn.Hijack()
+	defer pgConn.Close(context.Background())
+
+	if _, err := pgConn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return fmt.Errorf("error listening: %w", err)
+	}
+
+	for {
+		notification, err := pgConn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		fn(notification.Payload)
+	}
+}
@@ -11,52 +11,418 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const addRedemption = `-- name: AddRedemption :execrows
+INSERT INTO redemptions (message_id, streamer_id, viewer_id, entry_method, giveaway_id, redeemed_at)
+VALUES ($1, $2, $3, $4, $5, COALESCE($6::timestamptz, NOW()))
+ON CONFLICT (message_id) DO NOTHING
+`
+
+type AddRedemptionParams struct {
+	MessageID   string             `json:"message_id"`
+	StreamerID  pgtype.Text        `json:"streamer_id"`
+	ViewerID    pgtype.Text        `json:"viewer_id"`
+	EntryMethod string             `json:"entry_method"`
+	GiveawayID  int64              `json:"giveaway_id"`
+	RedeemedAt  pgtype.Timestamptz `json:"redeemed_at"`
+}
+
+// Adds an entry unless it was added before, redeemed_at defaults to now.
+func (q *Queries) AddRedemption(ctx context.Context, arg AddRedemptionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, addRedemption,
+		arg.MessageID,
+		arg.StreamerID,
+		arg.ViewerID,
+		arg.EntryMethod,
+		arg.GiveawayID,
+		arg.RedeemedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const claimWinner = `-- name: ClaimWinner :one
+UPDATE winners
+SET status = 'claimed',
+    claim_details = $2,
+    claimed_at = NOW()
+WHERE id = $1 AND status = 'pending_claim' AND (claim_deadline IS NULL OR claim_deadline > NOW())
+RETURNING id, draw_id, viewer_id, entries, created_at, prize_id, status, redrawn_from, updated_at, claim_deadline, claim_details, claimed_at
+`
+
+type ClaimWinnerParams struct {
+	ID           int64  `json:"id"`
+	ClaimDetails []byte `json:"claim_details"`
+}
+
+// Returns no row once the winner was forfeited, claimed or redrawn, or when the deadline passed.
+func (q *Queries) ClaimWinner(ctx context.Context, arg ClaimWinnerParams) (Winner, error) {
+	row := q.db.QueryRow(ctx, claimWinner, arg.ID, arg.ClaimDetails)
+	var i Winner
+	err := row.Scan(
+		&i.ID,
+		&i.DrawID,
+		&i.ViewerID,
+		&i.Entries,
+		&i.CreatedAt,
+		&i.PrizeID,
+		&i.Status,
+		&i.RedrawnFrom,
+		&i.UpdatedAt,
+		&i.ClaimDeadline,
+		&i.ClaimDetails,
+		&i.ClaimedAt,
+	)
+	return i, err
+}
+
+const closeScheduledGiveaway = `-- name: CloseScheduledGiveaway :one
+UPDATE giveaways
+SET status = 'closed', auto_draw_pending = (auto_draw AND status = 'open')
+WHERE id = $1 AND status IN ('draft', 'open')
+RETURNING id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending
+`
+
+// Closes a scheduled giveaway, its auto draw is marked pending in the same statement so a crash can't lose it.
+// Drafts never took entries and aren't drawn.
+func (q *Queries) CloseScheduledGiveaway(ctx context.Context, id int64) (Giveaway, error) {
+	row := q.db.QueryRow(ctx, closeScheduledGiveaway, id)
+	var i Giveaway
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Status,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OpensAt,
+		&i.ClosesAt,
+		&i.RecurringGiveawayID,
+		&i.ScheduledFor,
+		&i.AutoDraw,
+		&i.AutoDrawPending,
+	)
+	return i, err
+}
+
+const countActiveWinnersByPrize = `-- name: CountActiveWinnersByPrize :one
+SELECT (
+    SELECT COUNT(*) FROM winners
+    WHERE prize_id = $1 AND status IN ('pending_claim', 'claimed')
+) + COALESCE((SELECT opted_out_winners FROM prizes WHERE id = $1), 0) AS active_winners
+`
+
+// Counts how much of the prize is awarded, including the winners who opted out since.
+func (q *Queries) CountActiveWinnersByPrize(ctx context.Context, prizeID pgtype.Int8) (int64, error) {
+	row := q.db.QueryRow(ctx, countActiveWinnersByPrize, prizeID)
+	var active_winners int64
+	err := row.Scan(&active_winners)
+	return active_winners, err
+}
+
+const createAnnouncement = `-- name: CreateAnnouncement :one
+INSERT INTO announcements (winner_id, sender_id, kind, message, sent, error)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, winner_id, sender_id, kind, message, sent, error, created_at
+`
+
+type CreateAnnouncementParams struct {
+	WinnerID int64       `json:"winner_id"`
+	SenderID string      `json:"sender_id"`
+	Kind     string      `json:"kind"`
+	Message  string      `json:"message"`
+	Sent     bool        `json:"sent"`
+	Error    pgtype.Text `json:"error"`
+}
+
+func (q *Queries) CreateAnnouncement(ctx context.Context, arg CreateAnnouncementParams) (Announcement, error) {
+	row := q.db.QueryRow(ctx, createAnnouncement,
+		arg.WinnerID,
+		arg.SenderID,
+		arg.Kind,
+		arg.Message,
+		arg.Sent,
+		arg.Error,
+	)
+	var i Announcement
+	err := row.Scan(
+		&i.ID,
+		&i.WinnerID,
+		&i.SenderID,
+		&i.Kind,
+		&i.Message,
+		&i.Sent,
+		&i.Error,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createDraw = `-- name: CreateDraw :one
+INSERT INTO draws (giveaway_id, prize, drawn_by, total_entries)
+VALUES ($1, $2, $3, $4)
+RETURNING id, prize, drawn_by, total_entries, drawn_at, giveaway_id
+`
+
+type CreateDrawParams struct {
+	GiveawayID   int64       `json:"giveaway_id"`
+	Prize        string      `json:"prize"`
+	DrawnBy      pgtype.Text `json:"drawn_by"`
+	TotalEntries int64       `json:"total_entries"`
+}
+
+func (q *Queries) CreateDraw(ctx context.Context, arg CreateDrawParams) (Draw, error) {
+	row := q.db.QueryRow(ctx, createDraw,
+		arg.GiveawayID,
+		arg.Prize,
+		arg.DrawnBy,
+		arg.TotalEntries,
+	)
+	var i Draw
+	err := row.Scan(
+		&i.ID,
+		&i.Prize,
+		&i.DrawnBy,
+		&i.TotalEntries,
+		&i.DrawnAt,
+		&i.GiveawayID,
+	)
+	return i, err
+}
+
+const createGiveaway = `-- name: CreateGiveaway :one
+INSERT INTO giveaways (name, status, created_by, opens_at, closes_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending
+`
+
+type CreateGiveawayParams struct {
+	Name      string             `json:"name"`
+	Status    string             `json:"status"`
+	CreatedBy pgtype.Text        `json:"created_by"`
+	OpensAt   pgtype.Timestamptz `json:"opens_at"`
+	ClosesAt  pgtype.Timestamptz `json:"closes_at"`
+}
+
+func (q *Queries) CreateGiveaway(ctx context.Context, arg CreateGiveawayParams) (Giveaway, error) {
+	row := q.db.QueryRow(ctx, createGiveaway,
+		arg.Name,
+		arg.Status,
+		arg.CreatedBy,
+		arg.OpensAt,
+		arg.ClosesAt,
+	)
+	var i Giveaway
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Status,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OpensAt,
+		&i.ClosesAt,
+		&i.RecurringGiveawayID,
+		&i.ScheduledFor,
+		&i.AutoDraw,
+		&i.AutoDrawPending,
+	)
+	return i, err
+}
+
+const createPrize = `-- name: CreatePrize :one
+INSERT INTO prizes (giveaway_id, name, quantity, tier)
+VALUES ($1, $2, $3, $4)
+RETURNING id, giveaway_id, name, quantity, tier, created_at, opted_out_winners
+`
+
+type CreatePrizeParams struct {
+	GiveawayID int64  `json:"giveaway_id"`
+	Name       string `json:"name"`
+	Quantity   int32  `json:"quantity"`
+	Tier       int32  `json:"tier"`
+}
+
+func (q *Queries) CreatePrize(ctx context.Context, arg CreatePrizeParams) (Prize, error) {
+	row := q.db.QueryRow(ctx, createPrize,
+		arg.GiveawayID,
+		arg.Name,
+		arg.Quantity,
+		arg.Tier,
+	)
+	var i Prize
+	err := row.Scan(
+		&i.ID,
+		&i.GiveawayID,
+		&i.Name,
+		&i.Quantity,
+		&i.Tier,
+		&i.CreatedAt,
+		&i.OptedOutWinners,
+	)
+	return i, err
+}
+
+const createRecurringGiveaway = `-- name: CreateRecurringGiveaway :one
+INSERT INTO recurring_giveaways (name, rrule, timezone, starts_at, duration_seconds, prize_name, prize_quantity, auto_draw, created_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id, name, rrule, timezone, starts_at, duration_seconds, prize_name, prize_quantity, auto_draw, enabled, created_by, created_at, updated_at
+`
+
+type CreateRecurringGiveawayParams struct {
+	Name            string             `json:"name"`
+	Rrule           string             `json:"rrule"`
+	Timezone        string             `json:"timezone"`
+	StartsAt        pgtype.Timestamptz `json:"starts_at"`
+	DurationSeconds int32              `json:"duration_seconds"`
+	PrizeName       pgtype.Text        `json:"prize_name"`
+	PrizeQuantity   int32              `json:"prize_quantity"`
+	AutoDraw        bool               `json:"auto_draw"`
+	CreatedBy       pgtype.Text        `json:"created_by"`
+}
+
+func (q *Queries) CreateRecurringGiveaway(ctx context.Context, arg CreateRecurringGiveawayParams) (RecurringGiveaway, error) {
+	row := q.db.QueryRow(ctx, createRecurringGiveaway,
+		arg.Name,
+		arg.Rrule,
+		arg.Timezone,
+		arg.StartsAt,
+		arg.DurationSeconds,
+		arg.PrizeName,
+		arg.PrizeQuantity,
+		arg.AutoDraw,
+		arg.CreatedBy,
+	)
+	var i RecurringGiveaway
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Rrule,
+		&i.Timezone,
+		&i.StartsAt,
+		&i.DurationSeconds,
+		&i.PrizeName,
+		&i.PrizeQuantity,
+		&i.AutoDraw,
+		&i.Enabled,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const createRedemption = `-- name: CreateRedemption :one
-INSERT INTO redemptions (message_id, streamer_id, viewer_id)
-VALUES ($1, $2, $3)
-RETURNING message_id, streamer_id, viewer_id, redeemed_at
+INSERT INTO redemptions (message_id, streamer_id, viewer_id, entry_method, giveaway_id)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING message_id, streamer_id, viewer_id, redeemed_at, entry_method, giveaway_id
 `
 
 type CreateRedemptionParams struct {
-	MessageID  string      `json:"message_id"`
-	StreamerID pgtype.Text `json:"streamer_id"`
-	ViewerID   pgtype.Text `json:"viewer_id"`
+	MessageID   string      `json:"message_id"`
+	StreamerID  pgtype.Text `json:"streamer_id"`
+	ViewerID    pgtype.Text `json:"viewer_id"`
+	EntryMethod string      `json:"entry_method"`
+	GiveawayID  int64       `json:"giveaway_id"`
 }
 
 func (q *Queries) CreateRedemption(ctx context.Context, arg CreateRedemptionParams) (Redemption, error) {
-	row := q.db.QueryRow(ctx, createRedemption, arg.MessageID, arg.StreamerID, arg.ViewerID)
+	row := q.db.QueryRow(ctx, createRedemption,
+		arg.MessageID,
+		arg.StreamerID,
+		arg.ViewerID,
+		arg.EntryMethod,
+		arg.GiveawayID,
+	)
 	var i Redemption
 	err := row.Scan(
 		&i.MessageID,
 		&i.StreamerID,
 		&i.ViewerID,
 		&i.RedeemedAt,
+		&i.EntryMethod,
+		&i.GiveawayID,
 	)
 	return i, err
 }
 
 const createReward = `-- name: CreateReward :one
-INSERT INTO rewards (reward_id, streamer_id)
-VALUES ($1, $2)
-RETURNING reward_id, streamer_id, created_at
+INSERT INTO rewards (reward_id, streamer_id, giveaway_id)
+VALUES ($1, $2, $3)
+RETURNING reward_id, streamer_id, created_at, status, giveaway_id, retired_at
 `
 
 type CreateRewardParams struct {
 	RewardID   string      `json:"reward_id"`
 	StreamerID pgtype.Text `json:"streamer_id"`
+	GiveawayID pgtype.Int8 `json:"giveaway_id"`
 }
 
 func (q *Queries) CreateReward(ctx context.Context, arg CreateRewardParams) (Reward, error) {
-	row := q.db.QueryRow(ctx, createReward, arg.RewardID, arg.StreamerID)
+	row := q.db.QueryRow(ctx, createReward, arg.RewardID, arg.StreamerID, arg.GiveawayID)
 	var i Reward
-	err := row.Scan(&i.RewardID, &i.StreamerID, &i.CreatedAt)
+	err := row.Scan(
+		&i.RewardID,
+		&i.StreamerID,
+		&i.CreatedAt,
+		&i.Status,
+		&i.GiveawayID,
+		&i.RetiredAt,
+	)
+	return i, err
+}
+
+const createScheduledGiveaway = `-- name: CreateScheduledGiveaway :one
+-- Returns no row when the occurrence already has its giveaway.
+INSERT INTO giveaways (name, status, created_by, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw)
+VALUES ($1, 'draft', $2, $3, $4, $5, $6, $7)
+ON CONFLICT (recurring_giveaway_id, scheduled_for) WHERE recurring_giveaway_id IS NOT NULL DO NOTHING
+RETURNING id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending
+`
+
+type CreateScheduledGiveawayParams struct {
+	Name                string             `json:"name"`
+	CreatedBy           pgtype.Text        `json:"created_by"`
+	OpensAt             pgtype.Timestamptz `json:"opens_at"`
+	ClosesAt            pgtype.Timestamptz `json:"closes_at"`
+	RecurringGiveawayID pgtype.Int8        `json:"recurring_giveaway_id"`
+	ScheduledFor        pgtype.Timestamptz `json:"scheduled_for"`
+	AutoDraw            bool               `json:"auto_draw"`
+}
+
+func (q *Queries) CreateScheduledGiveaway(ctx context.Context, arg CreateScheduledGiveawayParams) (Giveaway, error) {
+	row := q.db.QueryRow(ctx, createScheduledGiveaway,
+		arg.Name,
+		arg.CreatedBy,
+		arg.OpensAt,
+		arg.ClosesAt,
+		arg.RecurringGiveawayID,
+		arg.ScheduledFor,
+		arg.AutoDraw,
+	)
+	var i Giveaway
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Status,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OpensAt,
+		&i.ClosesAt,
+		&i.RecurringGiveawayID,
+		&i.ScheduledFor,
+		&i.AutoDraw,
+		&i.AutoDrawPending,
+	)
 	return i, err
 }
 
 const createStreamer = `-- name: CreateStreamer :one
-INSERT INTO streamers (twitch_id, username, verified, access_token, refresh_token, profile_image_url, is_live)
-VALUES ($1, $2, $3, $4, $5, $6, $7)
-RETURNING twitch_id, username, verified, profile_image_url, access_token, refresh_token, created_at, updated_at, is_live
+INSERT INTO streamers (twitch_id, username, verified, access_token, refresh_token, profile_image_url, is_live, broadcaster_type)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING twitch_id, username, verified, profile_image_url, access_token, refresh_token, created_at, updated_at, is_live, reward_live_only, broadcaster_type
 `
 
 type CreateStreamerParams struct {
@@ -67,6 +433,7 @@ type CreateStreamerParams struct {
 	RefreshToken    pgtype.Text `json:"refresh_token"`
 	ProfileImageUrl pgtype.Text `json:"profile_image_url"`
 	IsLive          pgtype.Bool `json:"is_live"`
+	BroadcasterType pgtype.Text `json:"broadcaster_type"`
 }
 
 func (q *Queries) CreateStreamer(ctx context.Context, arg CreateStreamerParams) (Streamer, error) {
@@ -78,6 +445,7 @@ func (q *Queries) CreateStreamer(ctx context.Context, arg CreateStreamerParams)
 		arg.RefreshToken,
 		arg.ProfileImageUrl,
 		arg.IsLive,
+		arg.BroadcasterType,
 	)
 	var i Streamer
 	err := row.Scan(
@@ -90,6 +458,8 @@ func (q *Queries) CreateStreamer(ctx context.Context, arg CreateStreamerParams)
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.IsLive,
+		&i.RewardLiveOnly,
+		&i.BroadcasterType,
 	)
 	return i, err
 }
@@ -119,16 +489,104 @@ func (q *Queries) CreateViewer(ctx context.Context, arg CreateViewerParams) (Vie
 	return i, err
 }
 
-const deleteRewardsByStreamerID = `-- name: DeleteRewardsByStreamerID :exec
-DELETE FROM rewards
-WHERE streamer_id = $1
+const createWinner = `-- name: CreateWinner :one
+INSERT INTO winners (draw_id, viewer_id, entries, prize_id, status, redrawn_from, claim_deadline)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, draw_id, viewer_id, entries, created_at, prize_id, status, redrawn_from, updated_at, claim_deadline, claim_details, claimed_at
+`
+
+type CreateWinnerParams struct {
+	DrawID        int64              `json:"draw_id"`
+	ViewerID      string             `json:"viewer_id"`
+	Entries       int64              `json:"entries"`
+	PrizeID       pgtype.Int8        `json:"prize_id"`
+	Status        string             `json:"status"`
+	RedrawnFrom   pgtype.Int8        `json:"redrawn_from"`
+	ClaimDeadline pgtype.Timestamptz `json:"claim_deadline"`
+}
+
+func (q *Queries) CreateWinner(ctx context.Context, arg CreateWinnerParams) (Winner, error) {
+	row := q.db.QueryRow(ctx, createWinner,
+		arg.DrawID,
+		arg.ViewerID,
+		arg.Entries,
+		arg.PrizeID,
+		arg.Status,
+		arg.RedrawnFrom,
+		arg.ClaimDeadline,
+	)
+	var i Winner
+	err := row.Scan(
+		&i.ID,
+		&i.DrawID,
+		&i.ViewerID,
+		&i.Entries,
+		&i.CreatedAt,
+		&i.PrizeID,
+		&i.Status,
+		&i.RedrawnFrom,
+		&i.UpdatedAt,
+		&i.ClaimDeadline,
+		&i.ClaimDetails,
+		&i.ClaimedAt,
+	)
+	return i, err
+}
+
+const deletePrize = `-- name: DeletePrize :exec
+DELETE FROM prizes
+WHERE id = $1 AND giveaway_id = $2
+`
+
+type DeletePrizeParams struct {
+	ID         int64 `json:"id"`
+	GiveawayID int64 `json:"giveaway_id"`
+}
+
+func (q *Queries) DeletePrize(ctx context.Context, arg DeletePrizeParams) error {
+	_, err := q.db.Exec(ctx, deletePrize, arg.ID, arg.GiveawayID)
+	return err
+}
+
+const deleteRecurringGiveaway = `-- name: DeleteRecurringGiveaway :execrows
+DELETE FROM recurring_giveaways WHERE id = $1
+`
+
+func (q *Queries) DeleteRecurringGiveaway(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteRecurringGiveaway, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const finishAutoDraw = `-- name: FinishAutoDraw :exec
+UPDATE giveaways SET auto_draw_pending = FALSE WHERE id = $1
 `
 
-func (q *Queries) DeleteRewardsByStreamerID(ctx context.Context, streamerID pgtype.Text) error {
-	_, err := q.db.Exec(ctx, deleteRewardsByStreamerID, streamerID)
+func (q *Queries) FinishAutoDraw(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, finishAutoDraw, id)
 	return err
 }
 
+const getActiveRewardByStreamer = `-- name: GetActiveRewardByStreamer :one
+SELECT reward_id, streamer_id, created_at, status, giveaway_id, retired_at FROM rewards WHERE streamer_id = $1 AND status = 'active'
+`
+
+func (q *Queries) GetActiveRewardByStreamer(ctx context.Context, streamerID pgtype.Text) (Reward, error) {
+	row := q.db.QueryRow(ctx, getActiveRewardByStreamer, streamerID)
+	var i Reward
+	err := row.Scan(
+		&i.RewardID,
+		&i.StreamerID,
+		&i.CreatedAt,
+		&i.Status,
+		&i.GiveawayID,
+		&i.RetiredAt,
+	)
+	return i, err
+}
+
 const getAllStreamers = `-- name: GetAllStreamers :many
 SELECT username, twitch_id, profile_image_url, is_live FROM streamers WHERE verified = TRUE
 `
@@ -166,7 +624,7 @@ func (q *Queries) GetAllStreamers(ctx context.Context) ([]GetAllStreamersRow, er
 }
 
 const getAllStreamersWithTokens = `-- name: GetAllStreamersWithTokens :many
-SELECT twitch_id, username, verified, profile_image_url, access_token, refresh_token, created_at, updated_at, is_live FROM streamers
+SELECT twitch_id, username, verified, profile_image_url, access_token, refresh_token, created_at, updated_at, is_live, reward_live_only, broadcaster_type FROM streamers
 `
 
 func (q *Queries) GetAllStreamersWithTokens(ctx context.Context) ([]Streamer, error) {
@@ -188,6 +646,8 @@ func (q *Queries) GetAllStreamersWithTokens(ctx context.Context) ([]Streamer, er
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.IsLive,
+			&i.RewardLiveOnly,
+			&i.BroadcasterType,
 		); err != nil {
 			return nil, err
 		}
@@ -199,44 +659,52 @@ func (q *Queries) GetAllStreamersWithTokens(ctx context.Context) ([]Streamer, er
 	return items, nil
 }
 
-const getRecentRedemptionsWithUsernames = `-- name: GetRecentRedemptionsWithUsernames :many
-SELECT
-    r.message_id,
-    s.username AS streamer_username, -- Get streamer username from streamers table
-    v.username AS viewer_username,   -- Get viewer username from viewers table
-    r.redeemed_at
-FROM
-    redemptions r
-JOIN
-    streamers s ON r.streamer_id = s.twitch_id
-JOIN
-    viewers v ON r.viewer_id = v.twitch_id
-ORDER BY
-    r.redeemed_at DESC
-LIMIT $1
+const getChatCommandByStreamer = `-- name: GetChatCommandByStreamer :one
+SELECT streamer_id, command, enabled, cooldown_seconds, max_entries_per_viewer, created_at, updated_at FROM chat_commands WHERE streamer_id = $1
 `
 
-type GetRecentRedemptionsWithUsernamesRow struct {
-	MessageID        string             `json:"message_id"`
-	StreamerUsername string             `json:"streamer_username"`
-	ViewerUsername   string             `json:"viewer_username"`
-	RedeemedAt       pgtype.Timestamptz `json:"redeemed_at"`
+func (q *Queries) GetChatCommandByStreamer(ctx context.Context, streamerID string) (ChatCommand, error) {
+	row := q.db.QueryRow(ctx, getChatCommandByStreamer, streamerID)
+	var i ChatCommand
+	err := row.Scan(
+		&i.StreamerID,
+		&i.Command,
+		&i.Enabled,
+		&i.CooldownSeconds,
+		&i.MaxEntriesPerViewer,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
 }
 
-func (q *Queries) GetRecentRedemptionsWithUsernames(ctx context.Context, limit int32) ([]GetRecentRedemptionsWithUsernamesRow, error) {
-	rows, err := q.db.Query(ctx, getRecentRedemptionsWithUsernames, limit)
+const getEnabledRecurringGiveaways = `-- name: GetEnabledRecurringGiveaways :many
+SELECT id, name, rrule, timezone, starts_at, duration_seconds, prize_name, prize_quantity, auto_draw, enabled, created_by, created_at, updated_at FROM recurring_giveaways WHERE enabled = TRUE ORDER BY id
+`
+
+func (q *Queries) GetEnabledRecurringGiveaways(ctx context.Context) ([]RecurringGiveaway, error) {
+	rows, err := q.db.Query(ctx, getEnabledRecurringGiveaways)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetRecentRedemptionsWithUsernamesRow
+	var items []RecurringGiveaway
 	for rows.Next() {
-		var i GetRecentRedemptionsWithUsernamesRow
+		var i RecurringGiveaway
 		if err := rows.Scan(
-			&i.MessageID,
-			&i.StreamerUsername,
-			&i.ViewerUsername,
-			&i.RedeemedAt,
+			&i.ID,
+			&i.Name,
+			&i.Rrule,
+			&i.Timezone,
+			&i.StartsAt,
+			&i.DurationSeconds,
+			&i.PrizeName,
+			&i.PrizeQuantity,
+			&i.AutoDraw,
+			&i.Enabled,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -248,12 +716,20 @@ func (q *Queries) GetRecentRedemptionsWithUsernames(ctx context.Context, limit i
 	return items, nil
 }
 
-const getRewardsByStreamer = `-- name: GetRewardsByStreamer :many
-SELECT reward_id, streamer_id, created_at FROM rewards WHERE streamer_id = $1 ORDER BY created_at DESC
+const getEntryRewardsByStreamer = `-- name: GetEntryRewardsByStreamer :many
+SELECT reward_id, streamer_id, created_at, status, giveaway_id, retired_at FROM rewards
+WHERE streamer_id = $1 AND (status = 'active' OR giveaway_id = $2)
+ORDER BY created_at DESC
 `
 
-func (q *Queries) GetRewardsByStreamer(ctx context.Context, streamerID pgtype.Text) ([]Reward, error) {
-	rows, err := q.db.Query(ctx, getRewardsByStreamer, streamerID)
+type GetEntryRewardsByStreamerParams struct {
+	StreamerID pgtype.Text `json:"streamer_id"`
+	GiveawayID pgtype.Int8 `json:"giveaway_id"`
+}
+
+// Rewards whose redemptions are entries in the giveaway: the active one and the ones retired while it was open.
+func (q *Queries) GetEntryRewardsByStreamer(ctx context.Context, arg GetEntryRewardsByStreamerParams) ([]Reward, error) {
+	rows, err := q.db.Query(ctx, getEntryRewardsByStreamer, arg.StreamerID, arg.GiveawayID)
 	if err != nil {
 		return nil, err
 	}
@@ -261,7 +737,14 @@ func (q *Queries) GetRewardsByStreamer(ctx context.Context, streamerID pgtype.Te
 	var items []Reward
 	for rows.Next() {
 		var i Reward
-		if err := rows.Scan(&i.RewardID, &i.StreamerID, &i.CreatedAt); err != nil {
+		if err := rows.Scan(
+			&i.RewardID,
+			&i.StreamerID,
+			&i.CreatedAt,
+			&i.Status,
+			&i.GiveawayID,
+			&i.RetiredAt,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -272,58 +755,634 @@ func (q *Queries) GetRewardsByStreamer(ctx context.Context, streamerID pgtype.Te
 	return items, nil
 }
 
-const getStreamerByID = `-- name: GetStreamerByID :one
-SELECT twitch_id, username, verified, profile_image_url, access_token, refresh_token, created_at, updated_at, is_live FROM streamers WHERE twitch_id = $1
+const getExpiredClaims = `-- name: GetExpiredClaims :many
+SELECT w.id, w.draw_id, w.viewer_id, w.entries, w.created_at, w.prize_id, w.status, w.redrawn_from, w.updated_at, w.claim_deadline, w.claim_details, w.claimed_at, d.giveaway_id
+FROM winners w
+JOIN draws d ON w.draw_id = d.id
+WHERE w.status = 'pending_claim' AND w.claim_deadline < NOW()
+ORDER BY w.claim_deadline
 `
 
-func (q *Queries) GetStreamerByID(ctx context.Context, twitchID string) (Streamer, error) {
-	row := q.db.QueryRow(ctx, getStreamerByID, twitchID)
-	var i Streamer
+type GetExpiredClaimsRow struct {
+	ID            int64              `json:"id"`
+	DrawID        int64              `json:"draw_id"`
+	ViewerID      string             `json:"viewer_id"`
+	Entries       int64              `json:"entries"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+	PrizeID       pgtype.Int8        `json:"prize_id"`
+	Status        string             `json:"status"`
+	RedrawnFrom   pgtype.Int8        `json:"redrawn_from"`
+	UpdatedAt     pgtype.Timestamptz `json:"updated_at"`
+	ClaimDeadline pgtype.Timestamptz `json:"claim_deadline"`
+	ClaimDetails  []byte             `json:"claim_details"`
+	ClaimedAt     pgtype.Timestamptz `json:"claimed_at"`
+	GiveawayID    int64              `json:"giveaway_id"`
+}
+
+func (q *Queries) GetExpiredClaims(ctx context.Context) ([]GetExpiredClaimsRow, error) {
+	rows, err := q.db.Query(ctx, getExpiredClaims)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetExpiredClaimsRow
+	for rows.Next() {
+		var i GetExpiredClaimsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.DrawID,
+			&i.ViewerID,
+			&i.Entries,
+			&i.CreatedAt,
+			&i.PrizeID,
+			&i.Status,
+			&i.RedrawnFrom,
+			&i.UpdatedAt,
+			&i.ClaimDeadline,
+			&i.ClaimDetails,
+			&i.ClaimedAt,
+			&i.GiveawayID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getGiveawayByID = `-- name: GetGiveawayByID :one
+SELECT id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending FROM giveaways WHERE id = $1
+`
+
+func (q *Queries) GetGiveawayByID(ctx context.Context, id int64) (Giveaway, error) {
+	row := q.db.QueryRow(ctx, getGiveawayByID, id)
+	var i Giveaway
 	err := row.Scan(
-		&i.TwitchID,
-		&i.Username,
-		&i.Verified,
-		&i.ProfileImageUrl,
-		&i.AccessToken,
-		&i.RefreshToken,
+		&i.ID,
+		&i.Name,
+		&i.Status,
+		&i.CreatedBy,
 		&i.CreatedAt,
 		&i.UpdatedAt,
-		&i.IsLive,
+		&i.OpensAt,
+		&i.ClosesAt,
+		&i.RecurringGiveawayID,
+		&i.ScheduledFor,
+		&i.AutoDraw,
+		&i.AutoDrawPending,
 	)
 	return i, err
 }
 
-const getTotalParticipantsCount = `-- name: GetTotalParticipantsCount :one
-SELECT COUNT(*) AS total_participants
-FROM viewers
+const getGiveawayEntryTotals = `-- name: GetGiveawayEntryTotals :one
+SELECT
+    COUNT(*) AS total_entries,
+    COUNT(DISTINCT viewer_id) AS total_participants
+FROM
+    redemptions
+WHERE
+    giveaway_id = $1
 `
 
-func (q *Queries) GetTotalParticipantsCount(ctx context.Context) (int64, error) {
-	row := q.db.QueryRow(ctx, getTotalParticipantsCount)
-	var total_participants int64
-	err := row.Scan(&total_participants)
-	return total_participants, err
+type GetGiveawayEntryTotalsRow struct {
+	TotalEntries      int64 `json:"total_entries"`
+	TotalParticipants int64 `json:"total_participants"`
 }
 
-const getTotalRedemptionsCount = `-- name: GetTotalRedemptionsCount :one
-SELECT COUNT(*) AS total_redemptions
-FROM redemptions
-`
-
-func (q *Queries) GetTotalRedemptionsCount(ctx context.Context) (int64, error) {
-	row := q.db.QueryRow(ctx, getTotalRedemptionsCount)
-	var total_redemptions int64
-	err := row.Scan(&total_redemptions)
-	return total_redemptions, err
+func (q *Queries) GetGiveawayEntryTotals(ctx context.Context, giveawayID int64) (GetGiveawayEntryTotalsRow, error) {
+	row := q.db.QueryRow(ctx, getGiveawayEntryTotals, giveawayID)
+	var i GetGiveawayEntryTotalsRow
+	err := row.Scan(&i.TotalEntries, &i.TotalParticipants)
+	return i, err
 }
 
-const getViewerByID = `-- name: GetViewerByID :one
-SELECT twitch_id, username, registered_in, created_at, updated_at FROM viewers WHERE twitch_id = $1
+const getGiveaways = `-- name: GetGiveaways :many
+SELECT id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending FROM giveaways ORDER BY created_at DESC
 `
 
-func (q *Queries) GetViewerByID(ctx context.Context, twitchID string) (Viewer, error) {
-	row := q.db.QueryRow(ctx, getViewerByID, twitchID)
-	var i Viewer
+func (q *Queries) GetGiveaways(ctx context.Context) ([]Giveaway, error) {
+	rows, err := q.db.Query(ctx, getGiveaways)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Giveaway
+	for rows.Next() {
+		var i Giveaway
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Status,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.OpensAt,
+			&i.ClosesAt,
+			&i.RecurringGiveawayID,
+			&i.ScheduledFor,
+			&i.AutoDraw,
+			&i.AutoDrawPending,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getGiveawaysPendingAutoDraw = `-- name: GetGiveawaysPendingAutoDraw :many
+SELECT id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending FROM giveaways WHERE auto_draw_pending ORDER BY closes_at
+`
+
+func (q *Queries) GetGiveawaysPendingAutoDraw(ctx context.Context) ([]Giveaway, error) {
+	rows, err := q.db.Query(ctx, getGiveawaysPendingAutoDraw)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Giveaway
+	for rows.Next() {
+		var i Giveaway
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Status,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.OpensAt,
+			&i.ClosesAt,
+			&i.RecurringGiveawayID,
+			&i.ScheduledFor,
+			&i.AutoDraw,
+			&i.AutoDrawPending,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOpenGiveaway = `-- name: GetOpenGiveaway :one
+SELECT id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending FROM giveaways WHERE status = 'open'
+`
+
+func (q *Queries) GetOpenGiveaway(ctx context.Context) (Giveaway, error) {
+	row := q.db.QueryRow(ctx, getOpenGiveaway)
+	var i Giveaway
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Status,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OpensAt,
+		&i.ClosesAt,
+		&i.RecurringGiveawayID,
+		&i.ScheduledFor,
+		&i.AutoDraw,
+		&i.AutoDrawPending,
+	)
+	return i, err
+}
+
+const getOpenGiveawayEntryTotals = `-- name: GetOpenGiveawayEntryTotals :many
+SELECT
+    g.id AS giveaway_id,
+    g.name,
+    COUNT(r.message_id) AS total_entries,
+    COUNT(DISTINCT r.viewer_id) AS total_participants
+FROM
+    giveaways g
+    LEFT JOIN redemptions r ON r.giveaway_id = g.id
+WHERE
+    g.status = 'open'
+GROUP BY
+    g.id, g.name
+`
+
+type GetOpenGiveawayEntryTotalsRow struct {
+	GiveawayID        int64  `json:"giveaway_id"`
+	Name              string `json:"name"`
+	TotalEntries      int64  `json:"total_entries"`
+	TotalParticipants int64  `json:"total_participants"`
+}
+
+func (q *Queries) GetOpenGiveawayEntryTotals(ctx context.Context) ([]GetOpenGiveawayEntryTotalsRow, error) {
+	rows, err := q.db.Query(ctx, getOpenGiveawayEntryTotals)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetOpenGiveawayEntryTotalsRow
+	for rows.Next() {
+		var i GetOpenGiveawayEntryTotalsRow
+		if err := rows.Scan(
+			&i.GiveawayID,
+			&i.Name,
+			&i.TotalEntries,
+			&i.TotalParticipants,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getParticipatingStreamers = `-- name: GetParticipatingStreamers :many
+SELECT twitch_id, username, verified, profile_image_url, access_token, refresh_token, created_at, updated_at, is_live, reward_live_only, broadcaster_type FROM streamers
+WHERE twitch_id IN (SELECT DISTINCT streamer_id FROM redemptions WHERE giveaway_id = $1)
+`
+
+func (q *Queries) GetParticipatingStreamers(ctx context.Context, giveawayID int64) ([]Streamer, error) {
+	rows, err := q.db.Query(ctx, getParticipatingStreamers, giveawayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Streamer
+	for rows.Next() {
+		var i Streamer
+		if err := rows.Scan(
+			&i.TwitchID,
+			&i.Username,
+			&i.Verified,
+			&i.ProfileImageUrl,
+			&i.AccessToken,
+			&i.RefreshToken,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.IsLive,
+			&i.RewardLiveOnly,
+			&i.BroadcasterType,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPrizeByID = `-- name: GetPrizeByID :one
+SELECT id, giveaway_id, name, quantity, tier, created_at, opted_out_winners FROM prizes WHERE id = $1
+`
+
+func (q *Queries) GetPrizeByID(ctx context.Context, id int64) (Prize, error) {
+	row := q.db.QueryRow(ctx, getPrizeByID, id)
+	var i Prize
+	err := row.Scan(
+		&i.ID,
+		&i.GiveawayID,
+		&i.Name,
+		&i.Quantity,
+		&i.Tier,
+		&i.CreatedAt,
+		&i.OptedOutWinners,
+	)
+	return i, err
+}
+
+const getPrizesByGiveaway = `-- name: GetPrizesByGiveaway :many
+SELECT id, giveaway_id, name, quantity, tier, created_at, opted_out_winners FROM prizes WHERE giveaway_id = $1 ORDER BY tier, id
+`
+
+func (q *Queries) GetPrizesByGiveaway(ctx context.Context, giveawayID int64) ([]Prize, error) {
+	rows, err := q.db.Query(ctx, getPrizesByGiveaway, giveawayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Prize
+	for rows.Next() {
+		var i Prize
+		if err := rows.Scan(
+			&i.ID,
+			&i.GiveawayID,
+			&i.Name,
+			&i.Quantity,
+			&i.Tier,
+			&i.CreatedAt,
+			&i.OptedOutWinners,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecentRedemptionsWithUsernames = `-- name: GetRecentRedemptionsWithUsernames :many
+SELECT
+    r.message_id,
+    s.username AS streamer_username, -- Get streamer username from streamers table
+    v.username AS viewer_username,   -- Get viewer username from viewers table
+    r.redeemed_at
+FROM
+    redemptions r
+JOIN
+    streamers s ON r.streamer_id = s.twitch_id
+JOIN
+    viewers v ON r.viewer_id = v.twitch_id
+ORDER BY
+    r.redeemed_at DESC
+LIMIT $1
+`
+
+type GetRecentRedemptionsWithUsernamesRow struct {
+	MessageID        string             `json:"message_id"`
+	StreamerUsername string             `json:"streamer_username"`
+	ViewerUsername   string             `json:"viewer_username"`
+	RedeemedAt       pgtype.Timestamptz `json:"redeemed_at"`
+}
+
+func (q *Queries) GetRecentRedemptionsWithUsernames(ctx context.Context, limit int32) ([]GetRecentRedemptionsWithUsernamesRow, error) {
+	rows, err := q.db.Query(ctx, getRecentRedemptionsWithUsernames, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecentRedemptionsWithUsernamesRow
+	for rows.Next() {
+		var i GetRecentRedemptionsWithUsernamesRow
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.StreamerUsername,
+			&i.ViewerUsername,
+			&i.RedeemedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecurringGiveaways = `-- name: GetRecurringGiveaways :many
+SELECT id, name, rrule, timezone, starts_at, duration_seconds, prize_name, prize_quantity, auto_draw, enabled, created_by, created_at, updated_at FROM recurring_giveaways ORDER BY created_at DESC
+`
+
+func (q *Queries) GetRecurringGiveaways(ctx context.Context) ([]RecurringGiveaway, error) {
+	rows, err := q.db.Query(ctx, getRecurringGiveaways)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RecurringGiveaway
+	for rows.Next() {
+		var i RecurringGiveaway
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Rrule,
+			&i.Timezone,
+			&i.StartsAt,
+			&i.DurationSeconds,
+			&i.PrizeName,
+			&i.PrizeQuantity,
+			&i.AutoDraw,
+			&i.Enabled,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRewardsByStreamer = `-- name: GetRewardsByStreamer :many
+SELECT reward_id, streamer_id, created_at, status, giveaway_id, retired_at FROM rewards WHERE streamer_id = $1 ORDER BY created_at DESC
+`
+
+func (q *Queries) GetRewardsByStreamer(ctx context.Context, streamerID pgtype.Text) ([]Reward, error) {
+	rows, err := q.db.Query(ctx, getRewardsByStreamer, streamerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Reward
+	for rows.Next() {
+		var i Reward
+		if err := rows.Scan(
+			&i.RewardID,
+			&i.StreamerID,
+			&i.CreatedAt,
+			&i.Status,
+			&i.GiveawayID,
+			&i.RetiredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getScheduledGiveawaysToClose = `-- name: GetScheduledGiveawaysToClose :many
+-- Draft ones too, when their time passed before they could be opened.
+SELECT id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending FROM giveaways
+WHERE scheduled_for IS NOT NULL AND status IN ('draft', 'open') AND closes_at <= $1
+ORDER BY closes_at
+`
+
+func (q *Queries) GetScheduledGiveawaysToClose(ctx context.Context, closesAt pgtype.Timestamptz) ([]Giveaway, error) {
+	rows, err := q.db.Query(ctx, getScheduledGiveawaysToClose, closesAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Giveaway
+	for rows.Next() {
+		var i Giveaway
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Status,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.OpensAt,
+			&i.ClosesAt,
+			&i.RecurringGiveawayID,
+			&i.ScheduledFor,
+			&i.AutoDraw,
+			&i.AutoDrawPending,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getScheduledGiveawaysToOpen = `-- name: GetScheduledGiveawaysToOpen :many
+SELECT id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending FROM giveaways
+WHERE scheduled_for IS NOT NULL AND status = 'draft' AND opens_at <= $1 AND closes_at > $1
+ORDER BY opens_at
+`
+
+func (q *Queries) GetScheduledGiveawaysToOpen(ctx context.Context, opensAt pgtype.Timestamptz) ([]Giveaway, error) {
+	rows, err := q.db.Query(ctx, getScheduledGiveawaysToOpen, opensAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Giveaway
+	for rows.Next() {
+		var i Giveaway
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Status,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.OpensAt,
+			&i.ClosesAt,
+			&i.RecurringGiveawayID,
+			&i.ScheduledFor,
+			&i.AutoDraw,
+			&i.AutoDrawPending,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getStreamerByID = `-- name: GetStreamerByID :one
+SELECT twitch_id, username, verified, profile_image_url, access_token, refresh_token, created_at, updated_at, is_live, reward_live_only, broadcaster_type FROM streamers WHERE twitch_id = $1
+`
+
+func (q *Queries) GetStreamerByID(ctx context.Context, twitchID string) (Streamer, error) {
+	row := q.db.QueryRow(ctx, getStreamerByID, twitchID)
+	var i Streamer
+	err := row.Scan(
+		&i.TwitchID,
+		&i.Username,
+		&i.Verified,
+		&i.ProfileImageUrl,
+		&i.AccessToken,
+		&i.RefreshToken,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.IsLive,
+		&i.RewardLiveOnly,
+		&i.BroadcasterType,
+	)
+	return i, err
+}
+
+const getStreamerByUsername = `-- name: GetStreamerByUsername :one
+SELECT twitch_id, username, verified, profile_image_url, access_token, refresh_token, created_at, updated_at, is_live, reward_live_only, broadcaster_type FROM streamers WHERE username = $1
+`
+
+func (q *Queries) GetStreamerByUsername(ctx context.Context, username string) (Streamer, error) {
+	row := q.db.QueryRow(ctx, getStreamerByUsername, username)
+	var i Streamer
+	err := row.Scan(
+		&i.TwitchID,
+		&i.Username,
+		&i.Verified,
+		&i.ProfileImageUrl,
+		&i.AccessToken,
+		&i.RefreshToken,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.IsLive,
+		&i.RewardLiveOnly,
+		&i.BroadcasterType,
+	)
+	return i, err
+}
+
+const getTotalParticipantsCount = `-- name: GetTotalParticipantsCount :one
+SELECT COUNT(*) AS total_participants
+FROM viewers
+`
+
+func (q *Queries) GetTotalParticipantsCount(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, getTotalParticipantsCount)
+	var total_participants int64
+	err := row.Scan(&total_participants)
+	return total_participants, err
+}
+
+const getTotalRedemptionsCount = `-- name: GetTotalRedemptionsCount :one
+SELECT COUNT(*) AS total_redemptions
+FROM redemptions
+`
+
+func (q *Queries) GetTotalRedemptionsCount(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, getTotalRedemptionsCount)
+	var total_redemptions int64
+	err := row.Scan(&total_redemptions)
+	return total_redemptions, err
+}
+
+const getViewerByID = `-- name: GetViewerByID :one
+SELECT twitch_id, username, registered_in, created_at, updated_at FROM viewers WHERE twitch_id = $1
+`
+
+func (q *Queries) GetViewerByID(ctx context.Context, twitchID string) (Viewer, error) {
+	row := q.db.QueryRow(ctx, getViewerByID, twitchID)
+	var i Viewer
+	err := row.Scan(
+		&i.TwitchID,
+		&i.Username,
+		&i.RegisteredIn,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getViewerByUsername = `-- name: GetViewerByUsername :one
+SELECT twitch_id, username, registered_in, created_at, updated_at FROM viewers WHERE username = $1
+`
+
+func (q *Queries) GetViewerByUsername(ctx context.Context, username string) (Viewer, error) {
+	row := q.db.QueryRow(ctx, getViewerByUsername, username)
+	var i Viewer
 	err := row.Scan(
 		&i.TwitchID,
 		&i.Username,
@@ -334,6 +1393,188 @@ func (q *Queries) GetViewerByID(ctx context.Context, twitchID string) (Viewer, e
 	return i, err
 }
 
+const getViewerEntriesByStreamer = `-- name: GetViewerEntriesByStreamer :many
+SELECT
+    s.twitch_id AS streamer_id,
+    s.username AS streamer_username,
+    COUNT(r.*) AS entries
+FROM
+    redemptions r
+JOIN
+    streamers s ON r.streamer_id = s.twitch_id
+WHERE
+    r.viewer_id = $1 AND r.giveaway_id = $2
+GROUP BY
+    s.twitch_id, s.username
+ORDER BY
+    entries DESC
+`
+
+type GetViewerEntriesByStreamerParams struct {
+	ViewerID   pgtype.Text `json:"viewer_id"`
+	GiveawayID int64       `json:"giveaway_id"`
+}
+
+type GetViewerEntriesByStreamerRow struct {
+	StreamerID       string `json:"streamer_id"`
+	StreamerUsername string `json:"streamer_username"`
+	Entries          int64  `json:"entries"`
+}
+
+func (q *Queries) GetViewerEntriesByStreamer(ctx context.Context, arg GetViewerEntriesByStreamerParams) ([]GetViewerEntriesByStreamerRow, error) {
+	rows, err := q.db.Query(ctx, getViewerEntriesByStreamer, arg.ViewerID, arg.GiveawayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetViewerEntriesByStreamerRow
+	for rows.Next() {
+		var i GetViewerEntriesByStreamerRow
+		if err := rows.Scan(&i.StreamerID, &i.StreamerUsername, &i.Entries); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getViewerEntryCounts = `-- name: GetViewerEntryCounts :many
+SELECT
+    v.twitch_id AS viewer_id,
+    v.username,
+    COUNT(r.*) AS entries
+FROM
+    redemptions r
+JOIN
+    viewers v ON r.viewer_id = v.twitch_id
+WHERE
+    r.giveaway_id = $1
+GROUP BY
+    v.twitch_id, v.username
+`
+
+type GetViewerEntryCountsRow struct {
+	ViewerID string `json:"viewer_id"`
+	Username string `json:"username"`
+	Entries  int64  `json:"entries"`
+}
+
+func (q *Queries) GetViewerEntryCounts(ctx context.Context, giveawayID int64) ([]GetViewerEntryCountsRow, error) {
+	rows, err := q.db.Query(ctx, getViewerEntryCounts, giveawayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetViewerEntryCountsRow
+	for rows.Next() {
+		var i GetViewerEntryCountsRow
+		if err := rows.Scan(&i.ViewerID, &i.Username, &i.Entries); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getViewerEntryHistory = `-- name: GetViewerEntryHistory :many
+SELECT
+    r.redeemed_at,
+    r.entry_method,
+    r.giveaway_id,
+    g.name AS giveaway_name,
+    s.username AS streamer_username
+FROM
+    redemptions r
+JOIN
+    giveaways g ON r.giveaway_id = g.id
+JOIN
+    streamers s ON r.streamer_id = s.twitch_id
+WHERE
+    r.viewer_id = $1
+ORDER BY
+    r.redeemed_at DESC
+LIMIT $2
+`
+
+type GetViewerEntryHistoryParams struct {
+	ViewerID pgtype.Text `json:"viewer_id"`
+	Limit    int32       `json:"limit"`
+}
+
+type GetViewerEntryHistoryRow struct {
+	RedeemedAt       pgtype.Timestamptz `json:"redeemed_at"`
+	EntryMethod      string             `json:"entry_method"`
+	GiveawayID       int64              `json:"giveaway_id"`
+	GiveawayName     string             `json:"giveaway_name"`
+	StreamerUsername string             `json:"streamer_username"`
+}
+
+func (q *Queries) GetViewerEntryHistory(ctx context.Context, arg GetViewerEntryHistoryParams) ([]GetViewerEntryHistoryRow, error) {
+	rows, err := q.db.Query(ctx, getViewerEntryHistory, arg.ViewerID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetViewerEntryHistoryRow
+	for rows.Next() {
+		var i GetViewerEntryHistoryRow
+		if err := rows.Scan(
+			&i.RedeemedAt,
+			&i.EntryMethod,
+			&i.GiveawayID,
+			&i.GiveawayName,
+			&i.StreamerUsername,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getViewerEntryStats = `-- name: GetViewerEntryStats :one
+SELECT
+    COUNT(*) AS total_entries,
+    COALESCE(MAX(redeemed_at), 'epoch')::timestamptz AS last_entry_at
+FROM
+    redemptions
+WHERE
+    streamer_id = $1 AND viewer_id = $2 AND entry_method = $3 AND giveaway_id = $4
+`
+
+type GetViewerEntryStatsParams struct {
+	StreamerID  pgtype.Text `json:"streamer_id"`
+	ViewerID    pgtype.Text `json:"viewer_id"`
+	EntryMethod string      `json:"entry_method"`
+	GiveawayID  int64       `json:"giveaway_id"`
+}
+
+type GetViewerEntryStatsRow struct {
+	TotalEntries int64              `json:"total_entries"`
+	LastEntryAt  pgtype.Timestamptz `json:"last_entry_at"`
+}
+
+func (q *Queries) GetViewerEntryStats(ctx context.Context, arg GetViewerEntryStatsParams) (GetViewerEntryStatsRow, error) {
+	row := q.db.QueryRow(ctx, getViewerEntryStats,
+		arg.StreamerID,
+		arg.ViewerID,
+		arg.EntryMethod,
+		arg.GiveawayID,
+	)
+	var i GetViewerEntryStatsRow
+	err := row.Scan(&i.TotalEntries, &i.LastEntryAt)
+	return i, err
+}
+
 const getViewerLeaderboard = `-- name: GetViewerLeaderboard :many
 SELECT
     v.username, -- Retrieve the username from the viewers table
@@ -373,6 +1614,493 @@ func (q *Queries) GetViewerLeaderboard(ctx context.Context) ([]GetViewerLeaderbo
 	return items, nil
 }
 
+const getWinnerByID = `-- name: GetWinnerByID :one
+SELECT id, draw_id, viewer_id, entries, created_at, prize_id, status, redrawn_from, updated_at, claim_deadline, claim_details, claimed_at FROM winners WHERE id = $1
+`
+
+func (q *Queries) GetWinnerByID(ctx context.Context, id int64) (Winner, error) {
+	row := q.db.QueryRow(ctx, getWinnerByID, id)
+	var i Winner
+	err := row.Scan(
+		&i.ID,
+		&i.DrawID,
+		&i.ViewerID,
+		&i.Entries,
+		&i.CreatedAt,
+		&i.PrizeID,
+		&i.Status,
+		&i.RedrawnFrom,
+		&i.UpdatedAt,
+		&i.ClaimDeadline,
+		&i.ClaimDetails,
+		&i.ClaimedAt,
+	)
+	return i, err
+}
+
+const getWinnersByGiveaway = `-- name: GetWinnersByGiveaway :many
+SELECT
+    w.id,
+    w.draw_id,
+    w.viewer_id,
+    v.username AS viewer_username,
+    w.entries,
+    w.prize_id,
+    p.name AS prize_name,
+    p.tier AS prize_tier,
+    w.status,
+    w.redrawn_from,
+    w.claim_deadline,
+    w.claimed_at,
+    w.created_at
+FROM
+    winners w
+JOIN
+    draws d ON w.draw_id = d.id
+JOIN
+    viewers v ON w.viewer_id = v.twitch_id
+LEFT JOIN
+    prizes p ON w.prize_id = p.id
+WHERE
+    d.giveaway_id = $1
+ORDER BY
+    w.created_at, w.id
+`
+
+type GetWinnersByGiveawayRow struct {
+	ID             int64              `json:"id"`
+	DrawID         int64              `json:"draw_id"`
+	ViewerID       string             `json:"viewer_id"`
+	ViewerUsername string             `json:"viewer_username"`
+	Entries        int64              `json:"entries"`
+	PrizeID        pgtype.Int8        `json:"prize_id"`
+	PrizeName      pgtype.Text        `json:"prize_name"`
+	PrizeTier      pgtype.Int4        `json:"prize_tier"`
+	Status         string             `json:"status"`
+	RedrawnFrom    pgtype.Int8        `json:"redrawn_from"`
+	ClaimDeadline  pgtype.Timestamptz `json:"claim_deadline"`
+	ClaimedAt      pgtype.Timestamptz `json:"claimed_at"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) GetWinnersByGiveaway(ctx context.Context, giveawayID int64) ([]GetWinnersByGiveawayRow, error) {
+	rows, err := q.db.Query(ctx, getWinnersByGiveaway, giveawayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetWinnersByGiveawayRow
+	for rows.Next() {
+		var i GetWinnersByGiveawayRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.DrawID,
+			&i.ViewerID,
+			&i.ViewerUsername,
+			&i.Entries,
+			&i.PrizeID,
+			&i.PrizeName,
+			&i.PrizeTier,
+			&i.Status,
+			&i.RedrawnFrom,
+			&i.ClaimDeadline,
+			&i.ClaimedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWinnersByViewer = `-- name: GetWinnersByViewer :many
+SELECT
+    w.id,
+    d.giveaway_id,
+    g.name AS giveaway_name,
+    p.name AS prize_name,
+    w.status,
+    w.claim_deadline,
+    w.claimed_at,
+    w.created_at
+FROM
+    winners w
+JOIN
+    draws d ON w.draw_id = d.id
+JOIN
+    giveaways g ON d.giveaway_id = g.id
+LEFT JOIN
+    prizes p ON w.prize_id = p.id
+WHERE
+    w.viewer_id = $1
+ORDER BY
+    w.created_at DESC
+`
+
+type GetWinnersByViewerRow struct {
+	ID            int64              `json:"id"`
+	GiveawayID    int64              `json:"giveaway_id"`
+	GiveawayName  string             `json:"giveaway_name"`
+	PrizeName     pgtype.Text        `json:"prize_name"`
+	Status        string             `json:"status"`
+	ClaimDeadline pgtype.Timestamptz `json:"claim_deadline"`
+	ClaimedAt     pgtype.Timestamptz `json:"claimed_at"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) GetWinnersByViewer(ctx context.Context, viewerID string) ([]GetWinnersByViewerRow, error) {
+	rows, err := q.db.Query(ctx, getWinnersByViewer, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetWinnersByViewerRow
+	for rows.Next() {
+		var i GetWinnersByViewerRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.GiveawayID,
+			&i.GiveawayName,
+			&i.PrizeName,
+			&i.Status,
+			&i.ClaimDeadline,
+			&i.ClaimedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWinnerViewerIDsByGiveaway = `-- name: GetWinnerViewerIDsByGiveaway :many
+SELECT DISTINCT w.viewer_id
+FROM winners w
+JOIN draws d ON w.draw_id = d.id
+WHERE d.giveaway_id = $1
+`
+
+func (q *Queries) GetWinnerViewerIDsByGiveaway(ctx context.Context, giveawayID int64) ([]string, error) {
+	rows, err := q.db.Query(ctx, getWinnerViewerIDsByGiveaway, giveawayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var viewer_id string
+		if err := rows.Scan(&viewer_id); err != nil {
+			return nil, err
+		}
+		items = append(items, viewer_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const importRedemption = `-- name: ImportRedemption :execrows
+INSERT INTO redemptions (message_id, streamer_id, viewer_id, entry_method, giveaway_id, redeemed_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (message_id) DO NOTHING
+`
+
+type ImportRedemptionParams struct {
+	MessageID   string             `json:"message_id"`
+	StreamerID  pgtype.Text        `json:"streamer_id"`
+	ViewerID    pgtype.Text        `json:"viewer_id"`
+	EntryMethod string             `json:"entry_method"`
+	GiveawayID  int64              `json:"giveaway_id"`
+	RedeemedAt  pgtype.Timestamptz `json:"redeemed_at"`
+}
+
+func (q *Queries) ImportRedemption(ctx context.Context, arg ImportRedemptionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, importRedemption,
+		arg.MessageID,
+		arg.StreamerID,
+		arg.ViewerID,
+		arg.EntryMethod,
+		arg.GiveawayID,
+		arg.RedeemedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const isEntryReward = `-- name: IsEntryReward :one
+SELECT EXISTS(
+    SELECT 1 FROM rewards
+    WHERE reward_id = $1 AND streamer_id = $2 AND (status = 'active' OR giveaway_id = $3)
+)
+`
+
+type IsEntryRewardParams struct {
+	RewardID   string      `json:"reward_id"`
+	StreamerID pgtype.Text `json:"streamer_id"`
+	GiveawayID pgtype.Int8 `json:"giveaway_id"`
+}
+
+func (q *Queries) IsEntryReward(ctx context.Context, arg IsEntryRewardParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isEntryReward, arg.RewardID, arg.StreamerID, arg.GiveawayID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const isViewerOptedOut = `-- name: IsViewerOptedOut :one
+SELECT EXISTS(SELECT 1 FROM viewer_opt_outs WHERE twitch_id = $1)
+`
+
+func (q *Queries) IsViewerOptedOut(ctx context.Context, twitchID string) (bool, error) {
+	row := q.db.QueryRow(ctx, isViewerOptedOut, twitchID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const lockOpenGiveaway = `-- name: LockOpenGiveaway :one
+SELECT id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending FROM giveaways
+WHERE status = 'open'
+  AND (opens_at IS NULL OR opens_at <= NOW())
+  AND (closes_at IS NULL OR closes_at > NOW())
+FOR SHARE
+`
+
+// Returns the open giveaway if it takes entries now, inside its schedule, and keeps it from being closed until the transaction ends.
+func (q *Queries) LockOpenGiveaway(ctx context.Context) (Giveaway, error) {
+	row := q.db.QueryRow(ctx, lockOpenGiveaway)
+	var i Giveaway
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Status,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OpensAt,
+		&i.ClosesAt,
+		&i.RecurringGiveawayID,
+		&i.ScheduledFor,
+		&i.AutoDraw,
+		&i.AutoDrawPending,
+	)
+	return i, err
+}
+
+const lockPrize = `-- name: LockPrize :one
+SELECT id, giveaway_id, name, quantity, tier, created_at, opted_out_winners FROM prizes WHERE id = $1 FOR UPDATE
+`
+
+// Returns the prize and keeps other draws of it waiting until the transaction ends.
+func (q *Queries) LockPrize(ctx context.Context, id int64) (Prize, error) {
+	row := q.db.QueryRow(ctx, lockPrize, id)
+	var i Prize
+	err := row.Scan(
+		&i.ID,
+		&i.GiveawayID,
+		&i.Name,
+		&i.Quantity,
+		&i.Tier,
+		&i.CreatedAt,
+		&i.OptedOutWinners,
+	)
+	return i, err
+}
+
+const optOutViewer = `-- name: OptOutViewer :exec
+WITH opted_out AS (
+    INSERT INTO viewer_opt_outs (twitch_id) VALUES ($1)
+    ON CONFLICT (twitch_id) DO NOTHING
+), counted_wins AS (
+    UPDATE prizes p SET opted_out_winners = p.opted_out_winners + won.awarded
+    FROM (
+        SELECT prize_id, COUNT(*) AS awarded FROM winners
+        WHERE viewer_id = $1 AND prize_id IS NOT NULL AND status IN ('pending_claim', 'claimed')
+        GROUP BY prize_id
+    ) won
+    WHERE p.id = won.prize_id
+), unlinked_redraws AS (
+    UPDATE winners SET redrawn_from = NULL
+    WHERE redrawn_from IN (SELECT id FROM winners WHERE viewer_id = $1)
+), deleted_winners AS (
+    DELETE FROM winners WHERE viewer_id = $1
+), deleted_redemptions AS (
+    DELETE FROM redemptions WHERE viewer_id = $1
+)
+DELETE FROM viewers WHERE twitch_id = $1
+`
+
+// Deletes everything stored about a viewer in one statement so a failure can't leave half of it behind.
+// Redraws of the viewer's wins are kept, only the link back to the deleted win is cleared.
+// The prizes the viewer won keep counting them as awarded.
+func (q *Queries) OptOutViewer(ctx context.Context, twitchID string) error {
+	_, err := q.db.Exec(ctx, optOutViewer, twitchID)
+	return err
+}
+
+const redemptionExists = `-- name: RedemptionExists :one
+SELECT EXISTS(SELECT 1 FROM redemptions WHERE message_id = $1)
+`
+
+func (q *Queries) RedemptionExists(ctx context.Context, messageID string) (bool, error) {
+	row := q.db.QueryRow(ctx, redemptionExists, messageID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const retireReward = `-- name: RetireReward :one
+UPDATE rewards
+SET status = 'retired', retired_at = NOW(), giveaway_id = COALESCE($1, giveaway_id)
+WHERE reward_id = $2
+RETURNING reward_id, streamer_id, created_at, status, giveaway_id, retired_at
+`
+
+type RetireRewardParams struct {
+	GiveawayID pgtype.Int8 `json:"giveaway_id"`
+	RewardID   string      `json:"reward_id"`
+}
+
+// Links the reward to the giveaway open now, if there is one, so its redemptions keep counting there.
+func (q *Queries) RetireReward(ctx context.Context, arg RetireRewardParams) (Reward, error) {
+	row := q.db.QueryRow(ctx, retireReward, arg.GiveawayID, arg.RewardID)
+	var i Reward
+	err := row.Scan(
+		&i.RewardID,
+		&i.StreamerID,
+		&i.CreatedAt,
+		&i.Status,
+		&i.GiveawayID,
+		&i.RetiredAt,
+	)
+	return i, err
+}
+
+const setGiveawaySchedule = `-- name: SetGiveawaySchedule :one
+UPDATE giveaways
+SET opens_at = $2, closes_at = $3
+WHERE id = $1
+RETURNING id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending
+`
+
+type SetGiveawayScheduleParams struct {
+	ID       int64              `json:"id"`
+	OpensAt  pgtype.Timestamptz `json:"opens_at"`
+	ClosesAt pgtype.Timestamptz `json:"closes_at"`
+}
+
+func (q *Queries) SetGiveawaySchedule(ctx context.Context, arg SetGiveawayScheduleParams) (Giveaway, error) {
+	row := q.db.QueryRow(ctx, setGiveawaySchedule, arg.ID, arg.OpensAt, arg.ClosesAt)
+	var i Giveaway
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Status,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OpensAt,
+		&i.ClosesAt,
+		&i.RecurringGiveawayID,
+		&i.ScheduledFor,
+		&i.AutoDraw,
+		&i.AutoDrawPending,
+	)
+	return i, err
+}
+
+const setGiveawayStatus = `-- name: SetGiveawayStatus :one
+UPDATE giveaways
+SET status = $2
+WHERE id = $1
+RETURNING id, name, status, created_by, created_at, updated_at, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw, auto_draw_pending
+`
+
+type SetGiveawayStatusParams struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+}
+
+func (q *Queries) SetGiveawayStatus(ctx context.Context, arg SetGiveawayStatusParams) (Giveaway, error) {
+	row := q.db.QueryRow(ctx, setGiveawayStatus, arg.ID, arg.Status)
+	var i Giveaway
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Status,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OpensAt,
+		&i.ClosesAt,
+		&i.RecurringGiveawayID,
+		&i.ScheduledFor,
+		&i.AutoDraw,
+		&i.AutoDrawPending,
+	)
+	return i, err
+}
+
+const setRecurringGiveawayEnabled = `-- name: SetRecurringGiveawayEnabled :one
+UPDATE recurring_giveaways
+SET enabled = $2
+WHERE id = $1
+RETURNING id, name, rrule, timezone, starts_at, duration_seconds, prize_name, prize_quantity, auto_draw, enabled, created_by, created_at, updated_at
+`
+
+type SetRecurringGiveawayEnabledParams struct {
+	ID      int64 `json:"id"`
+	Enabled bool  `json:"enabled"`
+}
+
+func (q *Queries) SetRecurringGiveawayEnabled(ctx context.Context, arg SetRecurringGiveawayEnabledParams) (RecurringGiveaway, error) {
+	row := q.db.QueryRow(ctx, setRecurringGiveawayEnabled, arg.ID, arg.Enabled)
+	var i RecurringGiveaway
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Rrule,
+		&i.Timezone,
+		&i.StartsAt,
+		&i.DurationSeconds,
+		&i.PrizeName,
+		&i.PrizeQuantity,
+		&i.AutoDraw,
+		&i.Enabled,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const setStreamerBroadcasterType = `-- name: SetStreamerBroadcasterType :exec
+UPDATE streamers
+SET broadcaster_type = $2
+WHERE twitch_id = $1
+`
+
+type SetStreamerBroadcasterTypeParams struct {
+	TwitchID        string      `json:"twitch_id"`
+	BroadcasterType pgtype.Text `json:"broadcaster_type"`
+}
+
+func (q *Queries) SetStreamerBroadcasterType(ctx context.Context, arg SetStreamerBroadcasterTypeParams) error {
+	_, err := q.db.Exec(ctx, setStreamerBroadcasterType, arg.TwitchID, arg.BroadcasterType)
+	return err
+}
+
 const setStreamerLiveStatus = `-- name: SetStreamerLiveStatus :exec
 UPDATE streamers
 SET is_live = $1
@@ -389,12 +2117,77 @@ func (q *Queries) SetStreamerLiveStatus(ctx context.Context, arg SetStreamerLive
 	return err
 }
 
+const setStreamerRewardLiveOnly = `-- name: SetStreamerRewardLiveOnly :one
+UPDATE streamers
+SET reward_live_only = $2
+WHERE twitch_id = $1
+RETURNING twitch_id, username, verified, profile_image_url, access_token, refresh_token, created_at, updated_at, is_live, reward_live_only, broadcaster_type
+`
+
+type SetStreamerRewardLiveOnlyParams struct {
+	TwitchID       string `json:"twitch_id"`
+	RewardLiveOnly bool   `json:"reward_live_only"`
+}
+
+func (q *Queries) SetStreamerRewardLiveOnly(ctx context.Context, arg SetStreamerRewardLiveOnlyParams) (Streamer, error) {
+	row := q.db.QueryRow(ctx, setStreamerRewardLiveOnly, arg.TwitchID, arg.RewardLiveOnly)
+	var i Streamer
+	err := row.Scan(
+		&i.TwitchID,
+		&i.Username,
+		&i.Verified,
+		&i.ProfileImageUrl,
+		&i.AccessToken,
+		&i.RefreshToken,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.IsLive,
+		&i.RewardLiveOnly,
+		&i.BroadcasterType,
+	)
+	return i, err
+}
+
+const setWinnerStatus = `-- name: SetWinnerStatus :one
+UPDATE winners
+SET status = $1
+WHERE id = $2 AND status = $3
+RETURNING id, draw_id, viewer_id, entries, created_at, prize_id, status, redrawn_from, updated_at, claim_deadline, claim_details, claimed_at
+`
+
+type SetWinnerStatusParams struct {
+	Status         string `json:"status"`
+	ID             int64  `json:"id"`
+	ExpectedStatus string `json:"expected_status"`
+}
+
+// Only moves a winner who still has the expected status, returns no row when someone else changed it first.
+func (q *Queries) SetWinnerStatus(ctx context.Context, arg SetWinnerStatusParams) (Winner, error) {
+	row := q.db.QueryRow(ctx, setWinnerStatus, arg.Status, arg.ID, arg.ExpectedStatus)
+	var i Winner
+	err := row.Scan(
+		&i.ID,
+		&i.DrawID,
+		&i.ViewerID,
+		&i.Entries,
+		&i.CreatedAt,
+		&i.PrizeID,
+		&i.Status,
+		&i.RedrawnFrom,
+		&i.UpdatedAt,
+		&i.ClaimDeadline,
+		&i.ClaimDetails,
+		&i.ClaimedAt,
+	)
+	return i, err
+}
+
 const updateStreamerTokens = `-- name: UpdateStreamerTokens :one
 UPDATE streamers 
 SET access_token = $2, 
     refresh_token = $3
 WHERE twitch_id = $1
-RETURNING twitch_id, username, verified, profile_image_url, access_token, refresh_token, created_at, updated_at, is_live
+RETURNING twitch_id, username, verified, profile_image_url, access_token, refresh_token, created_at, updated_at, is_live, reward_live_only, broadcaster_type
 `
 
 type UpdateStreamerTokensParams struct {
@@ -416,6 +2209,67 @@ func (q *Queries) UpdateStreamerTokens(ctx context.Context, arg UpdateStreamerTo
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.IsLive,
+		&i.RewardLiveOnly,
+		&i.BroadcasterType,
+	)
+	return i, err
+}
+
+const upsertChatCommand = `-- name: UpsertChatCommand :one
+INSERT INTO chat_commands (streamer_id, command, enabled, cooldown_seconds, max_entries_per_viewer)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (streamer_id) DO UPDATE
+SET command = EXCLUDED.command,
+    enabled = EXCLUDED.enabled,
+    cooldown_seconds = EXCLUDED.cooldown_seconds,
+    max_entries_per_viewer = EXCLUDED.max_entries_per_viewer
+RETURNING streamer_id, command, enabled, cooldown_seconds, max_entries_per_viewer, created_at, updated_at
+`
+
+type UpsertChatCommandParams struct {
+	StreamerID          string `json:"streamer_id"`
+	Command             string `json:"command"`
+	Enabled             bool   `json:"enabled"`
+	CooldownSeconds     int32  `json:"cooldown_seconds"`
+	MaxEntriesPerViewer int32  `json:"max_entries_per_viewer"`
+}
+
+func (q *Queries) UpsertChatCommand(ctx context.Context, arg UpsertChatCommandParams) (ChatCommand, error) {
+	row := q.db.QueryRow(ctx, upsertChatCommand,
+		arg.StreamerID,
+		arg.Command,
+		arg.Enabled,
+		arg.CooldownSeconds,
+		arg.MaxEntriesPerViewer,
+	)
+	var i ChatCommand
+	err := row.Scan(
+		&i.StreamerID,
+		&i.Command,
+		&i.Enabled,
+		&i.CooldownSeconds,
+		&i.MaxEntriesPerViewer,
+		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
+
+const upsertViewer = `-- name: UpsertViewer :exec
+INSERT INTO viewers (twitch_id, username, registered_in)
+VALUES ($1, $2, $3)
+ON CONFLICT (twitch_id) DO UPDATE SET username = EXCLUDED.username
+`
+
+type UpsertViewerParams struct {
+	TwitchID     string      `json:"twitch_id"`
+	Username     string      `json:"username"`
+	RegisteredIn pgtype.Text `json:"registered_in"`
+}
+
+// Keeps the viewer's login up to date. Their row stays locked until the transaction ends,
+// so concurrent entries of the same viewer are added one after the other.
+func (q *Queries) UpsertViewer(ctx context.Context, arg UpsertViewerParams) error {
+	_, err := q.db.Exec(ctx, upsertViewer, arg.TwitchID, arg.Username, arg.RegisteredIn)
+	return err
+}
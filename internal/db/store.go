@@ -1,6 +1,12 @@
 package db
 
-import "github.com/jackc/pgx/v5/pgxpool"
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
 
 type DBStore struct {
 	*Queries
@@ -13,3 +19,67 @@ func NewStore(connPool *pgxpool.Pool) *DBStore {
 		Queries:  New(connPool),
 	}
 }
+
+// Ping checks that a connection to the database can be acquired
+func (s *DBStore) Ping(ctx context.Context) error {
+	return s.connPool.Ping(ctx)
+}
+
+// ExecTx runs fn with queries in one transaction, committed when fn returns nil and rolled back otherwise
+func (s *DBStore) ExecTx(ctx context.Context, fn func(q *Queries) error) error {
+	tx, err := s.connPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting a transaction: %w", err)
+	}
+	// Does nothing once the transaction is committed
+	defer tx.Rollback(ctx)
+
+	if err := fn(s.WithTx(tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing the transaction: %w", err)
+	}
+	return nil
+}
+
+// InRewardTx runs fn in a transaction, see RewardStore
+func (s *DBStore) InRewardTx(ctx context.Context, fn func(tx RewardTx) error) error {
+	return s.ExecTx(ctx, func(q *Queries) error {
+		return fn(q)
+	})
+}
+
+// InEntryTx runs fn in a transaction, see EntryStore
+func (s *DBStore) InEntryTx(ctx context.Context, fn func(tx EntryTx) error) error {
+	return s.ExecTx(ctx, func(q *Queries) error {
+		return fn(q)
+	})
+}
+
+// InOccurrenceTx runs fn in a transaction, see ScheduleStore
+func (s *DBStore) InOccurrenceTx(ctx context.Context, fn func(tx OccurrenceTx) error) error {
+	return s.ExecTx(ctx, func(q *Queries) error {
+		return fn(q)
+	})
+}
+
+// InDrawTx runs fn in a transaction, see WinnerStore
+func (s *DBStore) InDrawTx(ctx context.Context, fn func(tx DrawTx) error) error {
+	return s.ExecTx(ctx, func(q *Queries) error {
+		return fn(q)
+	})
+}
+
+// sqlc puts "-- name: GetStreamer :one" at the start of every query it generates
+var queryNamePattern = regexp.MustCompile(`^-- name: (\w+)`)
+
+// QueryName returns the sqlc name of a query, ok is false for SQL written elsewhere
+func QueryName(sql string) (name string, ok bool) {
+	match := queryNamePattern.FindStringSubmatch(sql)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The store interfaces group the queries by what they are used for, so code can ask for just the part it needs.
+// DBStore implements all of them with Postgres and memstore.Store keeps the same data in memory.
+// Lookups of a single row return pgx.ErrNoRows when it doesn't exist.
+
+// StreamerStore keeps the streamers who signed in, their Twitch tokens and their settings
+type StreamerStore interface {
+	CreateStreamer(ctx context.Context, arg CreateStreamerParams) (Streamer, error)
+	GetStreamerByID(ctx context.Context, twitchID string) (Streamer, error)
+	GetStreamerByUsername(ctx context.Context, username string) (Streamer, error)
+	GetAllStreamers(ctx context.Context) ([]GetAllStreamersRow, error)
+	GetAllStreamersWithTokens(ctx context.Context) ([]Streamer, error)
+	UpdateStreamerTokens(ctx context.Context, arg UpdateStreamerTokensParams) (Streamer, error)
+	SetStreamerBroadcasterType(ctx context.Context, arg SetStreamerBroadcasterTypeParams) error
+	SetStreamerLiveStatus(ctx context.Context, arg SetStreamerLiveStatusParams) error
+	SetStreamerRewardLiveOnly(ctx context.Context, arg SetStreamerRewardLiveOnlyParams) (Streamer, error)
+}
+
+// RewardTx is what replacing a streamer's reward writes inside its transaction
+type RewardTx interface {
+	RetireReward(ctx context.Context, arg RetireRewardParams) (Reward, error)
+	CreateReward(ctx context.Context, arg CreateRewardParams) (Reward, error)
+}
+
+// RewardStore keeps the channel point rewards viewers redeem to enter, the active one of each streamer and the retired ones.
+// InRewardTx runs fn in one transaction, committed when fn returns nil and rolled back otherwise.
+type RewardStore interface {
+	InRewardTx(ctx context.Context, fn func(tx RewardTx) error) error
+	CreateReward(ctx context.Context, arg CreateRewardParams) (Reward, error)
+	GetRewardsByStreamer(ctx context.Context, streamerID pgtype.Text) ([]Reward, error)
+	GetActiveRewardByStreamer(ctx context.Context, streamerID pgtype.Text) (Reward, error)
+	GetEntryRewardsByStreamer(ctx context.Context, arg GetEntryRewardsByStreamerParams) ([]Reward, error)
+	RetireReward(ctx context.Context, arg RetireRewardParams) (Reward, error)
+}
+
+// ChatCommandStore keeps the chat command settings of each streamer
+type ChatCommandStore interface {
+	GetChatCommandByStreamer(ctx context.Context, streamerID string) (ChatCommand, error)
+	UpsertChatCommand(ctx context.Context, arg UpsertChatCommandParams) (ChatCommand, error)
+}
+
+// ViewerStore keeps the viewers who entered and the ones who opted out
+type ViewerStore interface {
+	CreateViewer(ctx context.Context, arg CreateViewerParams) (Viewer, error)
+	GetViewerByID(ctx context.Context, twitchID string) (Viewer, error)
+	GetViewerByUsername(ctx context.Context, username string) (Viewer, error)
+	IsViewerOptedOut(ctx context.Context, twitchID string) (bool, error)
+	OptOutViewer(ctx context.Context, twitchID string) error
+}
+
+// EntryTx is what adding or importing an entry reads and writes inside its transaction
+type EntryTx interface {
+	LockOpenGiveaway(ctx context.Context) (Giveaway, error)
+	IsEntryReward(ctx context.Context, arg IsEntryRewardParams) (bool, error)
+	IsViewerOptedOut(ctx context.Context, twitchID string) (bool, error)
+	GetViewerByID(ctx context.Context, twitchID string) (Viewer, error)
+	UpsertViewer(ctx context.Context, arg UpsertViewerParams) error
+	GetViewerEntryStats(ctx context.Context, arg GetViewerEntryStatsParams) (GetViewerEntryStatsRow, error)
+	AddRedemption(ctx context.Context, arg AddRedemptionParams) (int64, error)
+	ImportRedemption(ctx context.Context, arg ImportRedemptionParams) (int64, error)
+}
+
+// EntryStore is what adding an entry to the open giveaway takes.
+// InEntryTx runs fn in one transaction, committed when fn returns nil and rolled back otherwise.
+type EntryStore interface {
+	InEntryTx(ctx context.Context, fn func(tx EntryTx) error) error
+	GetOpenGiveaway(ctx context.Context) (Giveaway, error)
+	IsViewerOptedOut(ctx context.Context, twitchID string) (bool, error)
+	GetViewerByID(ctx context.Context, twitchID string) (Viewer, error)
+	CreateViewer(ctx context.Context, arg CreateViewerParams) (Viewer, error)
+	ImportRedemption(ctx context.Context, arg ImportRedemptionParams) (int64, error)
+	RedemptionExists(ctx context.Context, messageID string) (bool, error)
+	GetViewerEntryStats(ctx context.Context, arg GetViewerEntryStatsParams) (GetViewerEntryStatsRow, error)
+}
+
+// GiveawayStore keeps the giveaways and their prizes
+type GiveawayStore interface {
+	CreateGiveaway(ctx context.Context, arg CreateGiveawayParams) (Giveaway, error)
+	GetGiveawayByID(ctx context.Context, id int64) (Giveaway, error)
+	GetGiveaways(ctx context.Context) ([]Giveaway, error)
+	GetOpenGiveaway(ctx context.Context) (Giveaway, error)
+	SetGiveawayStatus(ctx context.Context, arg SetGiveawayStatusParams) (Giveaway, error)
+	SetGiveawaySchedule(ctx context.Context, arg SetGiveawayScheduleParams) (Giveaway, error)
+	CreatePrize(ctx context.Context, arg CreatePrizeParams) (Prize, error)
+	GetPrizeByID(ctx context.Context, id int64) (Prize, error)
+	GetPrizesByGiveaway(ctx context.Context, giveawayID int64) ([]Prize, error)
+	DeletePrize(ctx context.Context, arg DeletePrizeParams) error
+}
+
+// OccurrenceTx is what creating the giveaway of an occurrence and its prize writes inside its transaction
+type OccurrenceTx interface {
+	CreateScheduledGiveaway(ctx context.Context, arg CreateScheduledGiveawayParams) (Giveaway, error)
+	CreatePrize(ctx context.Context, arg CreatePrizeParams) (Prize, error)
+}
+
+// ScheduleStore keeps the recurring giveaways and the giveaways created for their occurrences.
+// CreateScheduledGiveaway returns pgx.ErrNoRows when the occurrence already has its giveaway,
+// CloseScheduledGiveaway when the giveaway was closed in the meantime.
+// InOccurrenceTx runs fn in one transaction, committed when fn returns nil and rolled back otherwise.
+type ScheduleStore interface {
+	InOccurrenceTx(ctx context.Context, fn func(tx OccurrenceTx) error) error
+	CreateRecurringGiveaway(ctx context.Context, arg CreateRecurringGiveawayParams) (RecurringGiveaway, error)
+	GetRecurringGiveaways(ctx context.Context) ([]RecurringGiveaway, error)
+	GetEnabledRecurringGiveaways(ctx context.Context) ([]RecurringGiveaway, error)
+	SetRecurringGiveawayEnabled(ctx context.Context, arg SetRecurringGiveawayEnabledParams) (RecurringGiveaway, error)
+	DeleteRecurringGiveaway(ctx context.Context, id int64) (int64, error)
+	CreateScheduledGiveaway(ctx context.Context, arg CreateScheduledGiveawayParams) (Giveaway, error)
+	GetScheduledGiveawaysToOpen(ctx context.Context, opensAt pgtype.Timestamptz) ([]Giveaway, error)
+	GetScheduledGiveawaysToClose(ctx context.Context, closesAt pgtype.Timestamptz) ([]Giveaway, error)
+	CloseScheduledGiveaway(ctx context.Context, id int64) (Giveaway, error)
+	GetGiveawaysPendingAutoDraw(ctx context.Context) ([]Giveaway, error)
+	FinishAutoDraw(ctx context.Context, id int64) error
+}
+
+// DrawTx is what a draw reads and writes inside its transaction
+type DrawTx interface {
+	LockPrize(ctx context.Context, id int64) (Prize, error)
+	GetViewerEntryCounts(ctx context.Context, giveawayID int64) ([]GetViewerEntryCountsRow, error)
+	CountActiveWinnersByPrize(ctx context.Context, prizeID pgtype.Int8) (int64, error)
+	GetWinnerViewerIDsByGiveaway(ctx context.Context, giveawayID int64) ([]string, error)
+	GetWinnerByID(ctx context.Context, id int64) (Winner, error)
+	SetWinnerStatus(ctx context.Context, arg SetWinnerStatusParams) (Winner, error)
+	CreateDraw(ctx context.Context, arg CreateDrawParams) (Draw, error)
+	CreateWinner(ctx context.Context, arg CreateWinnerParams) (Winner, error)
+}
+
+// WinnerStore keeps the draws, their winners, the announcements sent for them and their claims.
+// InDrawTx runs fn in one transaction, committed when fn returns nil and rolled back otherwise.
+type WinnerStore interface {
+	InDrawTx(ctx context.Context, fn func(tx DrawTx) error) error
+	GetViewerEntryCounts(ctx context.Context, giveawayID int64) ([]GetViewerEntryCountsRow, error)
+	GetParticipatingStreamers(ctx context.Context, giveawayID int64) ([]Streamer, error)
+	CreateDraw(ctx context.Context, arg CreateDrawParams) (Draw, error)
+	CreateWinner(ctx context.Context, arg CreateWinnerParams) (Winner, error)
+	CreateAnnouncement(ctx context.Context, arg CreateAnnouncementParams) (Announcement, error)
+	CountActiveWinnersByPrize(ctx context.Context, prizeID pgtype.Int8) (int64, error)
+	GetWinnerViewerIDsByGiveaway(ctx context.Context, giveawayID int64) ([]string, error)
+	GetWinnerByID(ctx context.Context, id int64) (Winner, error)
+	SetWinnerStatus(ctx context.Context, arg SetWinnerStatusParams) (Winner, error)
+	GetWinnersByGiveaway(ctx context.Context, giveawayID int64) ([]GetWinnersByGiveawayRow, error)
+	GetWinnersByViewer(ctx context.Context, viewerID string) ([]GetWinnersByViewerRow, error)
+	ClaimWinner(ctx context.Context, arg ClaimWinnerParams) (Winner, error)
+	GetExpiredClaims(ctx context.Context) ([]GetExpiredClaimsRow, error)
+}
+
+// StatsStore answers the leaderboard and entry count questions of the public pages
+type StatsStore interface {
+	GetViewerLeaderboard(ctx context.Context) ([]GetViewerLeaderboardRow, error)
+	GetRecentRedemptionsWithUsernames(ctx context.Context, limit int32) ([]GetRecentRedemptionsWithUsernamesRow, error)
+	GetTotalRedemptionsCount(ctx context.Context) (int64, error)
+	GetTotalParticipantsCount(ctx context.Context) (int64, error)
+	GetViewerEntriesByStreamer(ctx context.Context, arg GetViewerEntriesByStreamerParams) ([]GetViewerEntriesByStreamerRow, error)
+	GetViewerEntryHistory(ctx context.Context, arg GetViewerEntryHistoryParams) ([]GetViewerEntryHistoryRow, error)
+	GetGiveawayEntryTotals(ctx context.Context, giveawayID int64) (GetGiveawayEntryTotalsRow, error)
+	GetOpenGiveawayEntryTotals(ctx context.Context) ([]GetOpenGiveawayEntryTotalsRow, error)
+}
+
+// Store is every store together, with a health check and what replicas coordinate through
+type Store interface {
+	StreamerStore
+	RewardStore
+	ChatCommandStore
+	ViewerStore
+	EntryStore
+	GiveawayStore
+	ScheduleStore
+	WinnerStore
+	StatsStore
+	Locker
+	PubSub
+	Ping(ctx context.Context) error
+}
+
+var _ Store = (*DBStore)(nil)
@@ -0,0 +1,82 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+type encoder[T record] interface {
+	Write(row T) error
+	Close() error
+}
+
+func newEncoder[T record](w io.Writer, format Format) (encoder[T], error) {
+	switch format {
+	case FormatCSV:
+		return &csvEncoder[T]{w: csv.NewWriter(w)}, nil
+	case FormatNDJSON:
+		return &ndjsonEncoder[T]{enc: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		// Rows are buffered until a row group fills up, not for the whole export
+		return &parquetEncoder[T]{w: parquet.NewGenericWriter[T](w)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+type csvEncoder[T record] struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (e *csvEncoder[T]) Write(row T) error {
+	if !e.wroteHeader {
+		e.wroteHeader = true
+		if err := e.w.Write(row.csvHeader()); err != nil {
+			return err
+		}
+	}
+
+	return e.w.Write(row.csvValues())
+}
+
+// Close writes the header even when there were no rows, so empty exports still open as a table
+func (e *csvEncoder[T]) Close() error {
+	if !e.wroteHeader {
+		var row T
+		if err := e.w.Write(row.csvHeader()); err != nil {
+			return err
+		}
+	}
+
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonEncoder[T record] struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder[T]) Write(row T) error {
+	return e.enc.Encode(row)
+}
+
+func (e *ndjsonEncoder[T]) Close() error {
+	return nil
+}
+
+type parquetEncoder[T record] struct {
+	w *parquet.GenericWriter[T]
+}
+
+func (e *parquetEncoder[T]) Write(row T) error {
+	_, err := e.w.Write([]T{row})
+	return err
+}
+
+func (e *parquetEncoder[T]) Close() error {
+	return e.w.Close()
+}
@@ -0,0 +1,150 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatNDJSON  Format = "ndjson"
+	FormatParquet Format = "parquet"
+)
+
+type Dataset string
+
+const (
+	DatasetEntries Dataset = "entries"
+	DatasetWinners Dataset = "winners"
+)
+
+var (
+	ErrUnknownFormat  = errors.New("format must be csv, ndjson or parquet")
+	ErrUnknownDataset = errors.New("dataset must be entries or winners")
+)
+
+func ParseFormat(value string) (Format, error) {
+	switch format := Format(strings.ToLower(value)); format {
+	case FormatCSV, FormatNDJSON, FormatParquet:
+		return format, nil
+	case "":
+		return FormatCSV, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+func ParseDataset(value string) (Dataset, error) {
+	switch dataset := Dataset(strings.ToLower(value)); dataset {
+	case DatasetEntries, DatasetWinners:
+		return dataset, nil
+	default:
+		return "", ErrUnknownDataset
+	}
+}
+
+// ParseDate accepts a full RFC 3339 timestamp or a plain date, which is read as midnight UTC
+func ParseDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date or an RFC 3339 timestamp", value)
+	}
+
+	return t, nil
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatParquet:
+		return "application/vnd.apache.parquet"
+	default:
+		return "text/csv"
+	}
+}
+
+// Filter narrows an export down, zero values don't filter
+type Filter struct {
+	GiveawayID int64
+	Streamer   string // Streamer login, entries taken in their channel or draws they ran
+	From       time.Time
+	To         time.Time // Exclusive
+}
+
+func (f Filter) args() []any {
+	return []any{
+		f.GiveawayID,
+		strings.ToLower(f.Streamer),
+		pgtype.Timestamptz{Time: f.From, Valid: !f.From.IsZero()},
+		pgtype.Timestamptz{Time: f.To, Valid: !f.To.IsZero()},
+	}
+}
+
+// Exporter streams rows from the database straight into the output.
+// sqlc loads :many results into a slice, so these queries are run by hand to keep memory flat on large exports.
+type Exporter struct {
+	db db.DBTX
+}
+
+func NewExporter(conn db.DBTX) *Exporter {
+	return &Exporter{db: conn}
+}
+
+// Export writes every row of the dataset that matches the filter to w and returns how many rows were written
+func (e *Exporter) Export(ctx context.Context, w io.Writer, dataset Dataset, format Format, filter Filter) (int, error) {
+	switch dataset {
+	case DatasetEntries:
+		return exportRows(ctx, e.db, w, format, exportEntries, filter.args(), scanEntry)
+	case DatasetWinners:
+		return exportRows(ctx, e.db, w, format, exportWinners, filter.args(), scanWinner)
+	default:
+		return 0, ErrUnknownDataset
+	}
+}
+
+func exportRows[T record](ctx context.Context, conn db.DBTX, w io.Writer, format Format, query string, args []any, scan func(pgx.Rows) (T, error)) (int, error) {
+	enc, err := newEncoder[T](w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error querying rows: %w", err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		row, err := scan(rows)
+		if err != nil {
+			return count, fmt.Errorf("error reading row: %w", err)
+		}
+
+		if err := enc.Write(row); err != nil {
+			return count, fmt.Errorf("error writing row: %w", err)
+		}
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("error reading rows: %w", err)
+	}
+
+	return count, enc.Close()
+}
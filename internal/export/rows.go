@@ -0,0 +1,191 @@
+package export
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Entries joined with viewer and streamer usernames, like GetRecentRedemptionsWithUsernames
+const exportEntries = `
+SELECT
+    r.message_id,
+    r.giveaway_id,
+    r.streamer_id,
+    s.username AS streamer_username,
+    r.viewer_id,
+    v.username AS viewer_username,
+    r.entry_method,
+    r.redeemed_at
+FROM
+    redemptions r
+JOIN
+    streamers s ON r.streamer_id = s.twitch_id
+JOIN
+    viewers v ON r.viewer_id = v.twitch_id
+WHERE
+    ($1::bigint = 0 OR r.giveaway_id = $1)
+    AND ($2::text = '' OR s.username = $2)
+    AND ($3::timestamptz IS NULL OR r.redeemed_at >= $3)
+    AND ($4::timestamptz IS NULL OR r.redeemed_at < $4)
+ORDER BY
+    r.redeemed_at
+`
+
+const exportWinners = `
+SELECT
+    w.id,
+    w.draw_id,
+    d.giveaway_id,
+    COALESCE(p.name, d.prize) AS prize,
+    w.viewer_id,
+    v.username AS viewer_username,
+    w.entries,
+    d.total_entries,
+    w.status,
+    COALESCE(ds.username, '') AS drawn_by,
+    d.drawn_at,
+    w.claimed_at
+FROM
+    winners w
+JOIN
+    draws d ON w.draw_id = d.id
+JOIN
+    viewers v ON w.viewer_id = v.twitch_id
+LEFT JOIN
+    prizes p ON w.prize_id = p.id
+LEFT JOIN
+    streamers ds ON d.drawn_by = ds.twitch_id
+WHERE
+    ($1::bigint = 0 OR d.giveaway_id = $1)
+    AND ($2::text = '' OR ds.username = $2)
+    AND ($3::timestamptz IS NULL OR d.drawn_at >= $3)
+    AND ($4::timestamptz IS NULL OR d.drawn_at < $4)
+ORDER BY
+    d.drawn_at, w.id
+`
+
+// record is a row that can be written in every export format
+type record interface {
+	csvHeader() []string
+	csvValues() []string
+}
+
+type EntryRow struct {
+	MessageID        string    `json:"message_id" parquet:"message_id"`
+	GiveawayID       int64     `json:"giveaway_id" parquet:"giveaway_id"`
+	StreamerID       string    `json:"streamer_id" parquet:"streamer_id"`
+	StreamerUsername string    `json:"streamer_username" parquet:"streamer_username"`
+	ViewerID         string    `json:"viewer_id" parquet:"viewer_id"`
+	ViewerUsername   string    `json:"viewer_username" parquet:"viewer_username"`
+	EntryMethod      string    `json:"entry_method" parquet:"entry_method"`
+	RedeemedAt       time.Time `json:"redeemed_at" parquet:"redeemed_at"`
+}
+
+func (EntryRow) csvHeader() []string {
+	return []string{"message_id", "giveaway_id", "streamer_id", "streamer_username", "viewer_id", "viewer_username", "entry_method", "redeemed_at"}
+}
+
+func (r EntryRow) csvValues() []string {
+	return []string{
+		r.MessageID,
+		strconv.FormatInt(r.GiveawayID, 10),
+		r.StreamerID,
+		r.StreamerUsername,
+		r.ViewerID,
+		r.ViewerUsername,
+		r.EntryMethod,
+		formatTime(r.RedeemedAt),
+	}
+}
+
+func scanEntry(rows pgx.Rows) (EntryRow, error) {
+	var row EntryRow
+	var redeemedAt pgtype.Timestamptz
+	err := rows.Scan(
+		&row.MessageID,
+		&row.GiveawayID,
+		&row.StreamerID,
+		&row.StreamerUsername,
+		&row.ViewerID,
+		&row.ViewerUsername,
+		&row.EntryMethod,
+		&redeemedAt,
+	)
+	row.RedeemedAt = redeemedAt.Time
+	return row, err
+}
+
+type WinnerRow struct {
+	WinnerID       int64      `json:"winner_id" parquet:"winner_id"`
+	DrawID         int64      `json:"draw_id" parquet:"draw_id"`
+	GiveawayID     int64      `json:"giveaway_id" parquet:"giveaway_id"`
+	Prize          string     `json:"prize" parquet:"prize"`
+	ViewerID       string     `json:"viewer_id" parquet:"viewer_id"`
+	ViewerUsername string     `json:"viewer_username" parquet:"viewer_username"`
+	Entries        int64      `json:"entries" parquet:"entries"`
+	TotalEntries   int64      `json:"total_entries" parquet:"total_entries"`
+	Status         string     `json:"status" parquet:"status"`
+	DrawnBy        string     `json:"drawn_by" parquet:"drawn_by"`
+	DrawnAt        time.Time  `json:"drawn_at" parquet:"drawn_at"`
+	ClaimedAt      *time.Time `json:"claimed_at" parquet:"claimed_at,optional"`
+}
+
+func (WinnerRow) csvHeader() []string {
+	return []string{"winner_id", "draw_id", "giveaway_id", "prize", "viewer_id", "viewer_username", "entries", "total_entries", "status", "drawn_by", "drawn_at", "claimed_at"}
+}
+
+func (r WinnerRow) csvValues() []string {
+	claimedAt := ""
+	if r.ClaimedAt != nil {
+		claimedAt = formatTime(*r.ClaimedAt)
+	}
+
+	return []string{
+		strconv.FormatInt(r.WinnerID, 10),
+		strconv.FormatInt(r.DrawID, 10),
+		strconv.FormatInt(r.GiveawayID, 10),
+		r.Prize,
+		r.ViewerID,
+		r.ViewerUsername,
+		strconv.FormatInt(r.Entries, 10),
+		strconv.FormatInt(r.TotalEntries, 10),
+		r.Status,
+		r.DrawnBy,
+		formatTime(r.DrawnAt),
+		claimedAt,
+	}
+}
+
+func scanWinner(rows pgx.Rows) (WinnerRow, error) {
+	var row WinnerRow
+	var drawnAt, claimedAt pgtype.Timestamptz
+	err := rows.Scan(
+		&row.WinnerID,
+		&row.DrawID,
+		&row.GiveawayID,
+		&row.Prize,
+		&row.ViewerID,
+		&row.ViewerUsername,
+		&row.Entries,
+		&row.TotalEntries,
+		&row.Status,
+		&row.DrawnBy,
+		&drawnAt,
+		&claimedAt,
+	)
+	row.DrawnAt = drawnAt.Time
+	if claimedAt.Valid {
+		row.ClaimedAt = &claimedAt.Time
+	}
+	return row, err
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
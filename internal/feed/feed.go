@@ -0,0 +1,144 @@
+package feed
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/logging"
+)
+
+// subscriberBuffer is how many entries a slow client may fall behind before it misses some
+const subscriberBuffer = 16
+
+// relayChannel carries the entries between replicas
+const relayChannel = "entry_feed"
+
+// Entry is what the live feed shows of a new giveaway entry
+type Entry struct {
+	GiveawayID       int64     `json:"giveaway_id"`
+	StreamerID       string    `json:"streamer_id"`
+	StreamerUsername string    `json:"streamer_username"`
+	ViewerUsername   string    `json:"viewer_username"`
+	EntryMethod      string    `json:"entry_method"`
+	RedeemedAt       time.Time `json:"redeemed_at"`
+}
+
+// Relay carries entries to the brokers of the other replicas, db.PubSub is one
+type Relay interface {
+	Publish(ctx context.Context, channel string, payload string) error
+	Listen(ctx context.Context, channel string, fn func(payload string)) error
+}
+
+// relayedEntry is an entry on its way to the other replicas, origin tells a broker its own entries apart
+type relayedEntry struct {
+	Origin string `json:"origin"`
+	Entry
+}
+
+// Broker fans new entries out to the connected feed clients, and to the other replicas while Relay runs
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan Entry]struct{}
+	origin      string
+	relay       Relay
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[chan Entry]struct{}),
+		origin:      rand.Text(),
+	}
+}
+
+// Subscribe returns a channel receiving every published entry, call the returned function to stop
+func (b *Broker) Subscribe() (<-chan Entry, func()) {
+	ch := make(chan Entry, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
+
+// Publish sends the entry to every subscriber without blocking, clients that are too slow miss it.
+// The entry is relayed to the other replicas too when Relay runs.
+func (b *Broker) Publish(ctx context.Context, entry Entry) {
+	if b == nil {
+		return
+	}
+
+	b.deliver(entry)
+
+	b.mu.Lock()
+	relay := b.relay
+	b.mu.Unlock()
+
+	if relay == nil {
+		return
+	}
+
+	payload, err := json.Marshal(relayedEntry{Origin: b.origin, Entry: entry})
+	if err == nil {
+		err = relay.Publish(ctx, relayChannel, string(payload))
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("Error relaying the entry, other replicas' feeds miss it", "error", err)
+	}
+}
+
+// Relay sends published entries to the other replicas and delivers theirs until ctx is cancelled,
+// listening again when the connection is lost
+func (b *Broker) Relay(ctx context.Context, relay Relay) error {
+	b.mu.Lock()
+	b.relay = relay
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.relay = nil
+		b.mu.Unlock()
+	}()
+
+	for {
+		err := relay.Listen(ctx, relayChannel, b.receive)
+		if ctx.Err() != nil {
+			return nil
+		}
+		logging.FromContext(ctx).Warn("Lost the entry feed relay, listening again", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// receive delivers an entry another replica relayed, this broker's own were delivered when they were published
+func (b *Broker) receive(payload string) {
+	var relayed relayedEntry
+	if err := json.Unmarshal([]byte(payload), &relayed); err != nil || relayed.Origin == b.origin {
+		return
+	}
+	b.deliver(relayed.Entry)
+}
+
+func (b *Broker) deliver(entry Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package giveaway
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/logging"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Announcement kinds, stored in announcements.kind
+const (
+	AnnouncementChat    = "chat"
+	AnnouncementWhisper = "whisper"
+)
+
+// Templates support {winner}, {prize} and {entries}
+const (
+	DefaultChatTemplate    = "Congratulations @{winner}, you won {prize} with {entries} entries!"
+	DefaultWhisperTemplate = "You won {prize} in the giveaway! Sign in on the giveaway site with Twitch to claim it before the deadline."
+)
+
+type AnnounceParams struct {
+	ChatTemplate    string
+	Whisper         bool
+	WhisperTemplate string
+	WhisperFrom     string // Twitch ID of the streamer whose account sends the whisper
+}
+
+func renderTemplate(template string, prize string, winner DrawnWinner) string {
+	return strings.NewReplacer(
+		"{winner}", winner.Username,
+		"{prize}", prize,
+		"{entries}", strconv.FormatInt(winner.Entries, 10),
+	).Replace(template)
+}
+
+// AnnounceInBackground announces the winners without holding up the caller. The draw is done already,
+// so the announcement outlives the request that asked for it and only a shutdown waits for it.
+func (s *Service) AnnounceInBackground(ctx context.Context, result *DrawResult, params AnnounceParams) {
+	ctx = context.WithoutCancel(ctx)
+
+	s.announcing.Add(1)
+	go func() {
+		defer s.announcing.Done()
+		s.Announce(ctx, result, params)
+	}()
+}
+
+// Shutdown waits for the announcements running in the background, or returns the error of ctx when it is done first
+func (s *Service) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.announcing.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Announce posts every winner in the chat of each streamer that took entries and optionally whispers the winners.
+// Every send is recorded, a failure in one channel doesn't stop the others.
+func (s *Service) Announce(ctx context.Context, result *DrawResult, params AnnounceParams) []db.Announcement {
+	logger := logging.FromContext(ctx).With(
+		slog.Int64("giveaway_id", result.Draw.GiveawayID),
+		slog.Int64("draw_id", result.Draw.ID),
+	)
+
+	chatTemplate := params.ChatTemplate
+	if chatTemplate == "" {
+		chatTemplate = DefaultChatTemplate
+	}
+
+	whisperTemplate := params.WhisperTemplate
+	if whisperTemplate == "" {
+		whisperTemplate = DefaultWhisperTemplate
+	}
+
+	var announcements []db.Announcement
+
+	streamers, err := s.db.GetParticipatingStreamers(ctx, result.Draw.GiveawayID)
+	if err != nil {
+		logger.Error("Error getting participating streamers", "error", err)
+	}
+
+	for _, winner := range result.Winners {
+		winnerLogger := logger.With(slog.Int64("winner_id", winner.ID))
+		chatMessage := renderTemplate(chatTemplate, result.Draw.Prize, winner)
+
+		for _, streamer := range streamers {
+			err := s.withStreamerToken(ctx, streamer, func(accessToken string) error {
+				return s.twitch.SendChatMessage(ctx, accessToken, streamer.TwitchID, chatMessage)
+			})
+
+			if err != nil {
+				winnerLogger.Error("Error announcing the winner in chat", "error", err, "streamer_id", streamer.TwitchID)
+			}
+
+			announcements = s.recordAnnouncement(ctx, winnerLogger, announcements, winner.ID, streamer.TwitchID, AnnouncementChat, chatMessage, err)
+		}
+
+		if !params.Whisper || params.WhisperFrom == "" {
+			continue
+		}
+
+		whisperMessage := renderTemplate(whisperTemplate, result.Draw.Prize, winner)
+
+		sender, err := s.db.GetStreamerByID(ctx, params.WhisperFrom)
+		if err == nil {
+			err = s.withStreamerToken(ctx, sender, func(accessToken string) error {
+				return s.twitch.SendWhisper(ctx, accessToken, sender.TwitchID, winner.ViewerID, whisperMessage)
+			})
+		}
+
+		if err != nil {
+			winnerLogger.Error("Error whispering the winner", "error", err)
+		}
+
+		announcements = s.recordAnnouncement(ctx, winnerLogger, announcements, winner.ID, params.WhisperFrom, AnnouncementWhisper, whisperMessage, err)
+	}
+
+	return announcements
+}
+
+func (s *Service) recordAnnouncement(ctx context.Context, logger *slog.Logger, announcements []db.Announcement, winnerID int64, senderID string, kind string, message string, sendErr error) []db.Announcement {
+	params := db.CreateAnnouncementParams{
+		WinnerID: winnerID,
+		SenderID: senderID,
+		Kind:     kind,
+		Message:  message,
+		Sent:     sendErr == nil,
+	}
+
+	if sendErr != nil {
+		params.Error = pgtype.Text{String: sendErr.Error(), Valid: true}
+	}
+
+	announcement, err := s.db.CreateAnnouncement(ctx, params)
+	if err != nil {
+		logger.Error("Error saving announcement", "error", err, "kind", kind, "sender_id", senderID)
+		return announcements
+	}
+
+	return append(announcements, announcement)
+}
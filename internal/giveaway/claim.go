@@ -0,0 +1,204 @@
+package giveaway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/logging"
+	"github.com/gamis65/twitch-points/internal/util"
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultClaimWindow is how long a winner has to claim their prize
+const DefaultClaimWindow = 48 * time.Hour
+
+var (
+	ErrClaimsDisabled      = errors.New("prize claims are not configured")
+	ErrClaimExpired        = errors.New("the claim deadline has passed")
+	ErrInvalidClaim        = errors.New("name, email and address are required")
+	ErrClaimNotSubmitted   = errors.New("the winner hasn't submitted delivery details")
+	ErrWinnerAlreadyClosed = errors.New("this prize can no longer be claimed")
+)
+
+// ClaimDetails are the delivery details a winner submits, stored encrypted in winners.claim_details
+type ClaimDetails struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Address string `json:"address"`
+	Notes   string `json:"notes"`
+}
+
+func (d ClaimDetails) validate() error {
+	if strings.TrimSpace(d.Name) == "" || strings.TrimSpace(d.Email) == "" || strings.TrimSpace(d.Address) == "" {
+		return ErrInvalidClaim
+	}
+
+	if len(d.Name) > 200 || len(d.Email) > 320 || len(d.Address) > 1000 || len(d.Notes) > 1000 {
+		return errors.New("delivery details are too long")
+	}
+
+	return nil
+}
+
+// Claim stores the delivery details of a pending winner and marks the prize as claimed.
+// viewerID must be the Twitch ID the viewer signed in with, only the winner can claim their prize.
+func (s *Service) Claim(ctx context.Context, winnerID int64, viewerID string, details ClaimDetails) (db.Winner, error) {
+	if s.claimKey == nil {
+		return db.Winner{}, ErrClaimsDisabled
+	}
+
+	winner, err := s.db.GetWinnerByID(ctx, winnerID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return db.Winner{}, ErrWinnerNotFound
+		}
+		return db.Winner{}, fmt.Errorf("error getting winner: %w", err)
+	}
+
+	// Don't tell viewers apart from winners they aren't
+	if winner.ViewerID != viewerID {
+		return db.Winner{}, ErrWinnerNotFound
+	}
+
+	if winner.Status != WinnerPendingClaim {
+		return db.Winner{}, ErrWinnerAlreadyClosed
+	}
+
+	if winner.ClaimDeadline.Valid && time.Now().After(winner.ClaimDeadline.Time) {
+		return db.Winner{}, ErrClaimExpired
+	}
+
+	if err := details.validate(); err != nil {
+		return db.Winner{}, err
+	}
+
+	plaintext, err := json.Marshal(details)
+	if err != nil {
+		return db.Winner{}, fmt.Errorf("error encoding delivery details: %w", err)
+	}
+
+	encrypted, err := util.Encrypt(s.claimKey, plaintext)
+	if err != nil {
+		return db.Winner{}, fmt.Errorf("error encrypting delivery details: %w", err)
+	}
+
+	claimed, err := s.db.ClaimWinner(ctx, db.ClaimWinnerParams{
+		ID:           winner.ID,
+		ClaimDetails: encrypted,
+	})
+	if err != nil {
+		// The winner was forfeited or claimed since we loaded it, or the deadline passed in the meantime
+		if errors.Is(err, pgx.ErrNoRows) {
+			if winner.ClaimDeadline.Valid && time.Now().After(winner.ClaimDeadline.Time) {
+				return db.Winner{}, ErrClaimExpired
+			}
+			return db.Winner{}, ErrWinnerAlreadyClosed
+		}
+		return db.Winner{}, fmt.Errorf("error saving claim: %w", err)
+	}
+
+	logging.FromContext(ctx).Info("Winner claimed their prize", "winner_id", winner.ID, "viewer_id", viewerID)
+	s.notifier.Send(ctx, fmt.Sprintf("Winner %d claimed their prize", winner.ID))
+
+	return claimed, nil
+}
+
+// GetClaimDetails decrypts the delivery details a winner submitted
+func (s *Service) GetClaimDetails(ctx context.Context, giveawayID int64, winnerID int64) (*ClaimDetails, error) {
+	if s.claimKey == nil {
+		return nil, ErrClaimsDisabled
+	}
+
+	winner, _, err := s.winnerInGiveaway(ctx, giveawayID, winnerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(winner.ClaimDetails) == 0 {
+		return nil, ErrClaimNotSubmitted
+	}
+
+	plaintext, err := util.Decrypt(s.claimKey, winner.ClaimDetails)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting delivery details: %w", err)
+	}
+
+	var details ClaimDetails
+	if err := json.Unmarshal(plaintext, &details); err != nil {
+		return nil, fmt.Errorf("error decoding delivery details: %w", err)
+	}
+
+	return &details, nil
+}
+
+// ExpireClaims forfeits every winner who missed their claim deadline and draws a replacement.
+// The winner is forfeited before the redraw so a failed redraw doesn't get retried forever.
+// Only a winner still pending is forfeited, one who claimed or was redrawn since keeps their status and isn't redrawn.
+func (s *Service) ExpireClaims(ctx context.Context) error {
+	expired, err := s.db.GetExpiredClaims(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting expired claims: %w", err)
+	}
+
+	for _, winner := range expired {
+		logger := logging.FromContext(ctx).With(
+			slog.Int64("giveaway_id", winner.GiveawayID),
+			slog.Int64("winner_id", winner.ID),
+			slog.String("viewer_id", winner.ViewerID),
+		)
+
+		_, err := s.db.SetWinnerStatus(ctx, db.SetWinnerStatusParams{
+			ID:             winner.ID,
+			Status:         WinnerForfeited,
+			ExpectedStatus: WinnerPendingClaim,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			logger.Info("Expired claim was closed in the meantime")
+			continue
+		}
+		if err != nil {
+			logger.Error("Error forfeiting an expired claim", "error", err)
+			continue
+		}
+
+		logger.Info("Winner missed the claim deadline")
+
+		result, err := s.Redraw(ctx, winner.GiveawayID, winner.ID, "")
+		if err != nil {
+			logger.Error("Error redrawing an expired claim", "error", err)
+			s.notifier.Send(ctx, fmt.Sprintf("Winner %d missed the claim deadline and couldn't be redrawn: %v", winner.ID, err))
+			continue
+		}
+
+		logger.Info("Redrew an expired claim", "new_winner_id", result.Winners[0].ID)
+		s.notifier.Send(ctx, fmt.Sprintf("Winner %d missed the claim deadline, %s was drawn instead", winner.ID, result.Winners[0].Username))
+
+		s.Announce(ctx, result, AnnounceParams{})
+	}
+
+	return nil
+}
+
+// RunClaimExpiry calls ExpireClaims every interval until ctx is cancelled
+func (s *Service) RunClaimExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.ExpireClaims(ctx); err != nil {
+			logging.FromContext(ctx).Error("Error expiring claims", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package giveaway
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"slices"
+)
+
+// ErrNoEntries is returned when there is nobody left to draw
+var ErrNoEntries = errors.New("there are no entries to draw from")
+
+// Candidate is a viewer in the draw pool, weighted by their number of entries
+type Candidate struct {
+	ViewerID string
+	Username string
+	Entries  int64
+}
+
+// pickWinners draws up to n distinct candidates without replacement.
+// Every entry is a ticket, so a viewer with 3 entries is 3 times as likely to be picked as a viewer with 1.
+func pickWinners(candidates []Candidate, n int) ([]Candidate, error) {
+	pool := slices.Clone(candidates)
+
+	var totalEntries int64
+	for _, candidate := range pool {
+		totalEntries += candidate.Entries
+	}
+
+	if totalEntries == 0 {
+		return nil, ErrNoEntries
+	}
+
+	winners := make([]Candidate, 0, n)
+	for len(winners) < n && totalEntries > 0 {
+		ticket, err := rand.Int(rand.Reader, big.NewInt(totalEntries))
+		if err != nil {
+			return nil, err
+		}
+
+		target := ticket.Int64()
+		for i, candidate := range pool {
+			if target < candidate.Entries {
+				winners = append(winners, candidate)
+				totalEntries -= candidate.Entries
+				pool = slices.Delete(pool, i, i+1)
+				break
+			}
+			target -= candidate.Entries
+		}
+	}
+
+	return winners, nil
+}
@@ -0,0 +1,89 @@
+package giveaway
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestPickWinners(t *testing.T) {
+	candidates := []Candidate{
+		{ViewerID: "1", Username: "one", Entries: 1},
+		{ViewerID: "2", Username: "two", Entries: 3},
+		{ViewerID: "3", Username: "none", Entries: 0},
+		{ViewerID: "4", Username: "four", Entries: 2},
+	}
+	original := slices.Clone(candidates)
+
+	tests := []struct {
+		name string
+		n    int
+		want int
+	}{
+		{"one winner", 1, 1},
+		{"several winners", 2, 2},
+		{"everyone with entries", 3, 3},
+		// The candidate without entries can't be picked, so there are fewer winners than asked for
+		{"more winners than candidates", 10, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			winners, err := pickWinners(candidates, tt.n)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(winners) != tt.want {
+				t.Fatalf("got %d winners, want %d", len(winners), tt.want)
+			}
+
+			seen := make(map[string]bool)
+			for _, winner := range winners {
+				if winner.Entries == 0 {
+					t.Errorf("%s won without entries", winner.Username)
+				}
+				if seen[winner.ViewerID] {
+					t.Errorf("%s won twice", winner.Username)
+				}
+				seen[winner.ViewerID] = true
+			}
+
+			if !slices.Equal(candidates, original) {
+				t.Errorf("the candidates were changed to %+v", candidates)
+			}
+		})
+	}
+}
+
+func TestPickWinnersNoEntries(t *testing.T) {
+	for _, candidates := range [][]Candidate{nil, {{ViewerID: "1", Username: "none", Entries: 0}}} {
+		if _, err := pickWinners(candidates, 1); !errors.Is(err, ErrNoEntries) {
+			t.Errorf("drawing from %+v: got %v, want ErrNoEntries", candidates, err)
+		}
+	}
+}
+
+// A viewer with 99 of the 100 entries should win almost every draw, a bound this loose fails by chance
+// far less often than one in a billion runs
+func TestPickWinnersWeighted(t *testing.T) {
+	candidates := []Candidate{
+		{ViewerID: "1", Username: "few", Entries: 1},
+		{ViewerID: "2", Username: "many", Entries: 99},
+	}
+
+	const draws = 1000
+	wins := 0
+	for range draws {
+		winners, err := pickWinners(candidates, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if winners[0].ViewerID == "2" {
+			wins++
+		}
+	}
+
+	if wins < 950 {
+		t.Errorf("the viewer with 99%% of the entries won %d of %d draws", wins, draws)
+	}
+}
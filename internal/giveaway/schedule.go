@@ -0,0 +1,358 @@
+package giveaway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/logging"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/teambition/rrule-go"
+)
+
+// ScheduleLookahead is how far ahead occurrences of a recurring giveaway get their draft giveaway,
+// so they show up in the giveaway list and the reward schedule before they open
+const ScheduleLookahead = 24 * time.Hour
+
+const uniqueViolation = "23505"
+
+var ErrInvalidSchedule = errors.New("invalid recurring giveaway")
+
+type RecurringParams struct {
+	Name          string
+	Rule          string // RRULE, e.g. FREQ=WEEKLY;BYDAY=FR
+	Timezone      string // IANA name, the rule keeps the wall clock time of StartsAt in it across DST changes
+	StartsAt      time.Time
+	Duration      time.Duration // how long each occurrence stays open
+	PrizeName     string        // empty creates no prize
+	PrizeQuantity int32
+	AutoDraw      bool
+	CreatedBy     string
+}
+
+// recurrence parses the rule with its first occurrence at startsAt in the timezone
+func recurrence(rule string, timezone string, startsAt time.Time) (*rrule.RRule, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" {
+		return nil, fmt.Errorf("%w: unknown timezone %q", ErrInvalidSchedule, timezone)
+	}
+
+	// DTSTART comes from startsAt, so only a bare rule is accepted
+	rule = strings.TrimSpace(rule)
+	if rule == "" || strings.Contains(rule, "\n") {
+		return nil, fmt.Errorf("%w: rule must be a single RRULE line", ErrInvalidSchedule)
+	}
+
+	option, err := rrule.StrToROptionInLocation(rule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+	option.Dtstart = startsAt.In(loc)
+
+	recurrence, err := rrule.NewRRule(*option)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+
+	return recurrence, nil
+}
+
+// NextOccurrence returns when the recurring giveaway opens next after t, false when the rule has ended
+func NextOccurrence(recurring db.RecurringGiveaway, t time.Time) (time.Time, bool) {
+	rule, err := recurrence(recurring.Rrule, recurring.Timezone, recurring.StartsAt.Time)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	next := rule.After(t, false)
+	return next, !next.IsZero()
+}
+
+func (s *Service) CreateRecurringGiveaway(ctx context.Context, params RecurringParams) (db.RecurringGiveaway, error) {
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		return db.RecurringGiveaway{}, fmt.Errorf("%w: name is required", ErrInvalidSchedule)
+	}
+
+	if params.StartsAt.IsZero() {
+		return db.RecurringGiveaway{}, fmt.Errorf("%w: starts_at is required", ErrInvalidSchedule)
+	}
+
+	if params.Duration < time.Minute || params.Duration%time.Second != 0 {
+		return db.RecurringGiveaway{}, fmt.Errorf("%w: duration must be whole seconds and at least a minute", ErrInvalidSchedule)
+	}
+
+	if params.PrizeQuantity <= 0 {
+		params.PrizeQuantity = 1
+	}
+
+	if params.AutoDraw && strings.TrimSpace(params.PrizeName) == "" {
+		return db.RecurringGiveaway{}, fmt.Errorf("%w: auto draw needs a prize", ErrInvalidSchedule)
+	}
+
+	rule, err := recurrence(params.Rule, params.Timezone, params.StartsAt)
+	if err != nil {
+		return db.RecurringGiveaway{}, err
+	}
+
+	// Occurrences can't overlap, only one giveaway is open at a time
+	first := rule.After(params.StartsAt, true)
+	if second := rule.After(first, false); !first.IsZero() && !second.IsZero() && second.Sub(first) < params.Duration {
+		return db.RecurringGiveaway{}, fmt.Errorf("%w: occurrences are closer together than the duration", ErrInvalidSchedule)
+	}
+
+	prizeName := strings.TrimSpace(params.PrizeName)
+
+	return s.db.CreateRecurringGiveaway(ctx, db.CreateRecurringGiveawayParams{
+		Name:            params.Name,
+		Rrule:           strings.TrimSpace(params.Rule),
+		Timezone:        params.Timezone,
+		StartsAt:        pgtype.Timestamptz{Time: params.StartsAt, Valid: true},
+		DurationSeconds: int32(params.Duration / time.Second),
+		PrizeName:       pgtype.Text{String: prizeName, Valid: prizeName != ""},
+		PrizeQuantity:   params.PrizeQuantity,
+		AutoDraw:        params.AutoDraw,
+		CreatedBy:       pgtype.Text{String: params.CreatedBy, Valid: params.CreatedBy != ""},
+	})
+}
+
+// ProcessSchedules creates the upcoming occurrences of every enabled recurring giveaway,
+// then opens and closes scheduled giveaways whose time has come, drawing the ones with auto draw.
+// Auto draws that failed or were interrupted are retried. It returns whether any giveaway changed status.
+func (s *Service) ProcessSchedules(ctx context.Context, now time.Time) (bool, error) {
+	recurring, err := s.db.GetEnabledRecurringGiveaways(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error getting recurring giveaways: %w", err)
+	}
+
+	for _, r := range recurring {
+		s.createOccurrences(ctx, r, now)
+	}
+
+	changed := false
+
+	// Closing first frees the open slot for an occurrence that starts as the previous one ends
+	toClose, err := s.db.GetScheduledGiveawaysToClose(ctx, pgtype.Timestamptz{Time: now, Valid: true})
+	if err != nil {
+		return changed, fmt.Errorf("error getting giveaways to close: %w", err)
+	}
+
+	closed := make(map[int64]bool, len(toClose))
+	for _, g := range toClose {
+		if s.closeScheduled(ctx, g) {
+			closed[g.ID] = true
+			changed = true
+		}
+	}
+
+	pending, err := s.db.GetGiveawaysPendingAutoDraw(ctx)
+	if err != nil {
+		return changed, fmt.Errorf("error getting giveaways to draw: %w", err)
+	}
+
+	for _, g := range pending {
+		s.autoDraw(ctx, g, closed[g.ID])
+	}
+
+	toOpen, err := s.db.GetScheduledGiveawaysToOpen(ctx, pgtype.Timestamptz{Time: now, Valid: true})
+	if err != nil {
+		return changed, fmt.Errorf("error getting giveaways to open: %w", err)
+	}
+
+	for _, g := range toOpen {
+		if s.openScheduled(ctx, g) {
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+func (s *Service) createOccurrences(ctx context.Context, recurring db.RecurringGiveaway, now time.Time) {
+	logger := logging.FromContext(ctx).With(slog.Int64("recurring_giveaway_id", recurring.ID))
+
+	rule, err := recurrence(recurring.Rrule, recurring.Timezone, recurring.StartsAt.Time)
+	if err != nil {
+		logger.Error("Error parsing recurring giveaway", "error", err)
+		return
+	}
+
+	duration := time.Duration(recurring.DurationSeconds) * time.Second
+
+	for _, occurrence := range rule.Between(now.Add(-duration), now.Add(ScheduleLookahead), true) {
+		closesAt := occurrence.Add(duration)
+		if !closesAt.After(now) {
+			continue
+		}
+
+		// The giveaway and its prize are created together, an occurrence without its prize would be drawn empty
+		var created db.Giveaway
+		err := s.db.InOccurrenceTx(ctx, func(tx db.OccurrenceTx) error {
+			var err error
+			created, err = tx.CreateScheduledGiveaway(ctx, db.CreateScheduledGiveawayParams{
+				Name:                recurring.Name + " " + occurrence.Format("2006-01-02"),
+				CreatedBy:           recurring.CreatedBy,
+				OpensAt:             pgtype.Timestamptz{Time: occurrence, Valid: true},
+				ClosesAt:            pgtype.Timestamptz{Time: closesAt, Valid: true},
+				RecurringGiveawayID: pgtype.Int8{Int64: recurring.ID, Valid: true},
+				ScheduledFor:        pgtype.Timestamptz{Time: occurrence, Valid: true},
+				AutoDraw:            recurring.AutoDraw,
+			})
+			if err != nil {
+				return err
+			}
+
+			if !recurring.PrizeName.Valid {
+				return nil
+			}
+
+			_, err = tx.CreatePrize(ctx, db.CreatePrizeParams{
+				GiveawayID: created.ID,
+				Name:       recurring.PrizeName.String,
+				Quantity:   recurring.PrizeQuantity,
+				Tier:       1,
+			})
+			if err != nil {
+				return fmt.Errorf("error creating the prize: %w", err)
+			}
+			return nil
+		})
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+
+		if err != nil {
+			logger.Error("Error creating scheduled giveaway", "error", err, "scheduled_for", occurrence)
+			continue
+		}
+
+		logger.Info("Scheduled giveaway created", "giveaway_id", created.ID, "opens_at", occurrence)
+	}
+}
+
+func (s *Service) openScheduled(ctx context.Context, g db.Giveaway) bool {
+	logger := logging.FromContext(ctx).With(slog.Int64("giveaway_id", g.ID))
+
+	_, err := s.db.SetGiveawayStatus(ctx, db.SetGiveawayStatusParams{
+		ID:     g.ID,
+		Status: StatusOpen,
+	})
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		// Retried on the next run until the other giveaway closes or this one's window passes
+		logger.Warn("Scheduled giveaway can't open while another giveaway is open")
+		return false
+	}
+
+	if err != nil {
+		logger.Error("Error opening scheduled giveaway", "error", err)
+		return false
+	}
+
+	logger.Info("Scheduled giveaway opened")
+	return true
+}
+
+// closeScheduled closes the giveaway, marking its auto draw pending if it has one and took entries
+func (s *Service) closeScheduled(ctx context.Context, g db.Giveaway) bool {
+	logger := logging.FromContext(ctx).With(slog.Int64("giveaway_id", g.ID))
+
+	_, err := s.db.CloseScheduledGiveaway(ctx, g.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Info("Scheduled giveaway was closed in the meantime")
+		return false
+	}
+	if err != nil {
+		logger.Error("Error closing scheduled giveaway", "error", err)
+		return false
+	}
+
+	logger.Info("Scheduled giveaway closed")
+	return true
+}
+
+// autoDraw draws every prize of the giveaway on behalf of the streamer who scheduled it and announces the winners.
+// The draw stays pending until every prize is drawn, drawn prizes are skipped when it is retried.
+// Failures are only sent to the notifier on the first attempt, retries log them.
+func (s *Service) autoDraw(ctx context.Context, g db.Giveaway, firstAttempt bool) {
+	logger := logging.FromContext(ctx).With(slog.Int64("giveaway_id", g.ID))
+
+	prizes, err := s.db.GetPrizesByGiveaway(ctx, g.ID)
+	if err != nil {
+		logger.Error("Error getting prizes to draw", "error", err)
+		return
+	}
+
+	done := true
+
+	for _, prize := range prizes {
+		result, err := s.Draw(ctx, DrawParams{
+			GiveawayID:             g.ID,
+			PrizeID:                prize.ID,
+			ExcludePreviousWinners: true,
+			DrawnBy:                g.CreatedBy.String,
+		})
+
+		if errors.Is(err, ErrNoEntries) || errors.Is(err, ErrPrizeAwarded) {
+			logger.Info("Nothing to draw for the prize", "prize_id", prize.ID, "reason", err)
+			continue
+		}
+
+		if err != nil {
+			logger.Error("Error drawing scheduled giveaway, retrying on the next run", "error", err, "prize_id", prize.ID)
+			if firstAttempt {
+				s.notifier.Send(ctx, fmt.Sprintf("Giveaway %q closed but %s couldn't be drawn yet: %v", g.Name, prize.Name, err))
+			}
+			done = false
+			continue
+		}
+
+		usernames := make([]string, 0, len(result.Winners))
+		for _, winner := range result.Winners {
+			usernames = append(usernames, winner.Username)
+		}
+
+		logger.Info("Scheduled giveaway drawn", "draw_id", result.Draw.ID, "prize_id", prize.ID)
+		s.notifier.Send(ctx, fmt.Sprintf("Giveaway %q closed, %s won %s", g.Name, strings.Join(usernames, ", "), prize.Name))
+
+		s.Announce(ctx, result, AnnounceParams{})
+	}
+
+	if !done {
+		return
+	}
+	if err := s.db.FinishAutoDraw(ctx, g.ID); err != nil {
+		logger.Error("Error marking the auto draw as done", "error", err)
+	}
+}
+
+// RunSchedules calls ProcessSchedules every interval until ctx is cancelled, changed is called after a giveaway opens or closes
+func (s *Service) RunSchedules(ctx context.Context, interval time.Duration, changed func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		updated, err := s.ProcessSchedules(ctx, time.Now())
+		if err != nil {
+			logging.FromContext(ctx).Error("Error processing giveaway schedules", "error", err)
+		}
+
+		if updated && changed != nil {
+			changed(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
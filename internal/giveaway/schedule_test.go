@@ -0,0 +1,124 @@
+package giveaway
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db/memstore"
+)
+
+func TestRecurrence(t *testing.T) {
+	startsAt := time.Date(2026, time.October, 23, 18, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		rule     string
+		timezone string
+		wantErr  bool
+	}{
+		{"weekly", "FREQ=WEEKLY;BYDAY=FR", "Europe/Berlin", false},
+		{"with the RRULE prefix", "RRULE:FREQ=DAILY", "UTC", false},
+		{"unknown timezone", "FREQ=DAILY", "Mars/Olympus_Mons", true},
+		{"no timezone", "FREQ=DAILY", "", true},
+		{"empty rule", "  ", "UTC", true},
+		{"several lines", "DTSTART:20261023T180000Z\nRRULE:FREQ=DAILY", "UTC", true},
+		{"invalid rule", "FREQ=SOMETIMES", "UTC", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := recurrence(tt.rule, tt.timezone, startsAt)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSchedule) {
+					t.Fatalf("got %v, want ErrInvalidSchedule", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// The rule keeps the wall clock time in its timezone, so the UTC time moves when daylight saving time ends
+func TestRecurrenceAcrossDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("error loading the timezone: %v", err)
+	}
+
+	rule, err := recurrence("FREQ=WEEKLY;BYDAY=FR", "Europe/Berlin", time.Date(2026, time.October, 23, 20, 0, 0, 0, berlin))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := rule.Between(time.Date(2026, time.October, 20, 0, 0, 0, 0, time.UTC), time.Date(2026, time.November, 3, 0, 0, 0, 0, time.UTC), true)
+	want := []time.Time{
+		time.Date(2026, time.October, 23, 18, 0, 0, 0, time.UTC),
+		time.Date(2026, time.October, 30, 19, 0, 0, 0, time.UTC),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got occurrences %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("occurrence %d at %s, want %s", i, got[i].UTC(), want[i])
+		}
+	}
+}
+
+func TestCreateRecurringGiveawayValidation(t *testing.T) {
+	valid := RecurringParams{
+		Name:      "Friday giveaway",
+		Rule:      "FREQ=WEEKLY;BYDAY=FR",
+		Timezone:  "Europe/Berlin",
+		StartsAt:  time.Date(2026, time.October, 23, 18, 0, 0, 0, time.UTC),
+		Duration:  2 * time.Hour,
+		PrizeName: "Game key",
+		AutoDraw:  true,
+	}
+
+	tests := []struct {
+		name    string
+		change  func(p *RecurringParams)
+		wantErr bool
+	}{
+		{"valid", func(p *RecurringParams) {}, false},
+		{"no name", func(p *RecurringParams) { p.Name = " " }, true},
+		{"no start", func(p *RecurringParams) { p.StartsAt = time.Time{} }, true},
+		{"shorter than a minute", func(p *RecurringParams) { p.Duration = 30 * time.Second }, true},
+		{"fractional seconds", func(p *RecurringParams) { p.Duration = time.Hour + time.Millisecond }, true},
+		{"auto draw without a prize", func(p *RecurringParams) { p.PrizeName = "" }, true},
+		{"no prize without auto draw", func(p *RecurringParams) { p.PrizeName = ""; p.AutoDraw = false }, false},
+		{"unknown timezone", func(p *RecurringParams) { p.Timezone = "Europe/Atlantis" }, true},
+		{"several lines", func(p *RecurringParams) { p.Rule = "FREQ=WEEKLY\nFREQ=DAILY" }, true},
+		{"overlapping occurrences", func(p *RecurringParams) { p.Rule = "FREQ=DAILY"; p.Duration = 25 * time.Hour }, true},
+		{"back to back occurrences", func(p *RecurringParams) { p.Rule = "FREQ=DAILY"; p.Duration = 24 * time.Hour }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(memstore.New(), nil, nil, nil)
+
+			params := valid
+			tt.change(&params)
+
+			recurring, err := service.CreateRecurringGiveaway(context.Background(), params)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSchedule) {
+					t.Fatalf("got %v, want ErrInvalidSchedule", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if recurring.DurationSeconds != int32(params.Duration/time.Second) || recurring.PrizeQuantity != 1 {
+				t.Errorf("saved %+v, want the duration in seconds and one prize", recurring)
+			}
+		})
+	}
+}
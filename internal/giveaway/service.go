@@ -0,0 +1,359 @@
+package giveaway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/logging"
+	"github.com/gamis65/twitch-points/internal/notify"
+	"github.com/gamis65/twitch-points/internal/twitch"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Giveaway statuses, stored in giveaways.status
+const (
+	StatusDraft  = "draft"
+	StatusOpen   = "open"
+	StatusClosed = "closed"
+)
+
+// Winner statuses, stored in winners.status
+const (
+	WinnerPendingClaim = "pending_claim"
+	WinnerClaimed      = "claimed"
+	WinnerForfeited    = "forfeited"
+	WinnerRedrawn      = "redrawn"
+)
+
+var (
+	ErrPrizeNotFound        = errors.New("prize not found in this giveaway")
+	ErrPrizeAwarded         = errors.New("every unit of this prize has already been awarded")
+	ErrWinnerNotFound       = errors.New("winner not found in this giveaway")
+	ErrWinnerNotRedrawable  = errors.New("only unclaimed prizes can be redrawn")
+	ErrInvalidWinnerStatus  = errors.New("invalid winner status")
+	ErrWinnerStatusConflict = errors.New("the winner can't be moved to this status")
+)
+
+// Store is the data draws, claims and schedules read and write
+type Store interface {
+	db.StreamerStore
+	db.GiveawayStore
+	db.ScheduleStore
+	db.WinnerStore
+}
+
+type Service struct {
+	db       Store
+	twitch   *twitch.API
+	claimKey []byte // AES-256 key for delivery details, claims are disabled without it
+	notifier *notify.Notifier
+
+	announcing sync.WaitGroup // announcements running in the background
+}
+
+type DrawParams struct {
+	GiveawayID             int64
+	PrizeID                int64
+	Count                  int // 0 draws the remaining quantity of the prize
+	ExcludePreviousWinners bool
+	DrawnBy                string        // Twitch ID of the streamer running the draw
+	ClaimWindow            time.Duration // 0 uses DefaultClaimWindow
+}
+
+type DrawnWinner struct {
+	db.Winner
+	Username string
+}
+
+type DrawResult struct {
+	Draw    db.Draw
+	Prize   db.Prize
+	Winners []DrawnWinner
+}
+
+func NewService(dbStore Store, twitchAPI *twitch.API, claimKey []byte, notifier *notify.Notifier) *Service {
+	return &Service{
+		db:       dbStore,
+		twitch:   twitchAPI,
+		claimKey: claimKey,
+		notifier: notifier,
+	}
+}
+
+// Draw picks winners for a prize from the giveaway's entries, without replacement.
+// The prize is locked for the whole draw, so concurrent draws can't award more than its quantity.
+func (s *Service) Draw(ctx context.Context, params DrawParams) (*DrawResult, error) {
+	claimWindow := params.ClaimWindow
+	if claimWindow <= 0 {
+		claimWindow = DefaultClaimWindow
+	}
+
+	var result *DrawResult
+	err := s.db.InDrawTx(ctx, func(tx db.DrawTx) error {
+		prize, err := lockPrizeInGiveaway(ctx, tx, params.GiveawayID, params.PrizeID)
+		if err != nil {
+			return err
+		}
+
+		activeWinners, err := tx.CountActiveWinnersByPrize(ctx, pgtype.Int8{Int64: prize.ID, Valid: true})
+		if err != nil {
+			return fmt.Errorf("error counting prize winners: %w", err)
+		}
+
+		remaining := int(int64(prize.Quantity) - activeWinners)
+		if remaining <= 0 {
+			return ErrPrizeAwarded
+		}
+
+		count := params.Count
+		if count <= 0 || count > remaining {
+			count = remaining
+		}
+
+		var exclude []string
+		if params.ExcludePreviousWinners {
+			exclude, err = tx.GetWinnerViewerIDsByGiveaway(ctx, params.GiveawayID)
+			if err != nil {
+				return fmt.Errorf("error getting previous winners: %w", err)
+			}
+		}
+
+		result, err = drawWinners(ctx, tx, prize, count, exclude, params.DrawnBy, claimWindow, pgtype.Int8{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Redraw replaces an unclaimed winner with someone who hasn't won anything in the giveaway yet.
+// The winner is only replaced while it still has the status it was read with, so it can't be replaced twice.
+func (s *Service) Redraw(ctx context.Context, giveawayID int64, winnerID int64, drawnBy string) (*DrawResult, error) {
+	_, prize, err := s.winnerInGiveaway(ctx, giveawayID, winnerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *DrawResult
+	err = s.db.InDrawTx(ctx, func(tx db.DrawTx) error {
+		prize, err := lockPrizeInGiveaway(ctx, tx, giveawayID, prize.ID)
+		if err != nil {
+			return err
+		}
+
+		// Read again under the lock, another redraw may have replaced the winner in the meantime
+		winner, err := tx.GetWinnerByID(ctx, winnerID)
+		if err != nil {
+			return fmt.Errorf("error getting winner: %w", err)
+		}
+
+		if winner.Status != WinnerPendingClaim && winner.Status != WinnerForfeited {
+			return ErrWinnerNotRedrawable
+		}
+
+		_, err = tx.SetWinnerStatus(ctx, db.SetWinnerStatusParams{
+			ID:             winner.ID,
+			Status:         WinnerRedrawn,
+			ExpectedStatus: winner.Status,
+		})
+		if err != nil {
+			// Claimed since it was read
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrWinnerNotRedrawable
+			}
+			return fmt.Errorf("error marking winner as redrawn: %w", err)
+		}
+
+		exclude, err := tx.GetWinnerViewerIDsByGiveaway(ctx, giveawayID)
+		if err != nil {
+			return fmt.Errorf("error getting previous winners: %w", err)
+		}
+
+		result, err = drawWinners(ctx, tx, prize, 1, exclude, drawnBy, DefaultClaimWindow, pgtype.Int8{Int64: winner.ID, Valid: true})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// UpdateWinnerStatus marks a pending winner as claimed or forfeited
+func (s *Service) UpdateWinnerStatus(ctx context.Context, giveawayID int64, winnerID int64, status string) (db.Winner, error) {
+	if status != WinnerClaimed && status != WinnerForfeited {
+		return db.Winner{}, ErrInvalidWinnerStatus
+	}
+
+	winner, _, err := s.winnerInGiveaway(ctx, giveawayID, winnerID)
+	if err != nil {
+		return db.Winner{}, err
+	}
+
+	if winner.Status != WinnerPendingClaim {
+		return db.Winner{}, ErrWinnerStatusConflict
+	}
+
+	updated, err := s.db.SetWinnerStatus(ctx, db.SetWinnerStatusParams{
+		ID:             winner.ID,
+		Status:         status,
+		ExpectedStatus: WinnerPendingClaim,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.Winner{}, ErrWinnerStatusConflict
+	}
+	return updated, err
+}
+
+// drawWinners picks the winners and saves the draw with them in the transaction of the caller
+func drawWinners(ctx context.Context, tx db.DrawTx, prize db.Prize, count int, exclude []string, drawnBy string, claimWindow time.Duration, redrawnFrom pgtype.Int8) (*DrawResult, error) {
+	entryCounts, err := tx.GetViewerEntryCounts(ctx, prize.GiveawayID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting entry counts: %w", err)
+	}
+
+	candidates := make([]Candidate, 0, len(entryCounts))
+	var totalEntries int64
+	for _, entryCount := range entryCounts {
+		if slices.Contains(exclude, entryCount.ViewerID) {
+			continue
+		}
+
+		candidates = append(candidates, Candidate{
+			ViewerID: entryCount.ViewerID,
+			Username: entryCount.Username,
+			Entries:  entryCount.Entries,
+		})
+		totalEntries += entryCount.Entries
+	}
+
+	picked, err := pickWinners(candidates, count)
+	if err != nil {
+		return nil, err
+	}
+
+	draw, err := tx.CreateDraw(ctx, db.CreateDrawParams{
+		GiveawayID:   prize.GiveawayID,
+		Prize:        prize.Name,
+		DrawnBy:      pgtype.Text{String: drawnBy, Valid: drawnBy != ""},
+		TotalEntries: totalEntries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error saving draw: %w", err)
+	}
+
+	logger := logging.FromContext(ctx).With(
+		slog.Int64("giveaway_id", prize.GiveawayID),
+		slog.Int64("draw_id", draw.ID),
+		slog.Int64("prize_id", prize.ID),
+	)
+
+	result := &DrawResult{
+		Draw:  draw,
+		Prize: prize,
+	}
+
+	claimDeadline := time.Now().Add(claimWindow)
+
+	for _, candidate := range picked {
+		winner, err := tx.CreateWinner(ctx, db.CreateWinnerParams{
+			DrawID:        draw.ID,
+			ViewerID:      candidate.ViewerID,
+			Entries:       candidate.Entries,
+			PrizeID:       pgtype.Int8{Int64: prize.ID, Valid: true},
+			Status:        WinnerPendingClaim,
+			RedrawnFrom:   redrawnFrom,
+			ClaimDeadline: pgtype.Timestamptz{Time: claimDeadline, Valid: true},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error saving winner: %w", err)
+		}
+
+		logger.Info("Drew a winner",
+			"viewer_id", candidate.ViewerID,
+			"viewer_username", candidate.Username,
+			"entries", candidate.Entries,
+			"total_entries", totalEntries,
+		)
+
+		result.Winners = append(result.Winners, DrawnWinner{
+			Winner:   winner,
+			Username: candidate.Username,
+		})
+	}
+
+	return result, nil
+}
+
+// lockPrizeInGiveaway locks the prize for the rest of the transaction, making sure it belongs to the giveaway
+func lockPrizeInGiveaway(ctx context.Context, tx db.DrawTx, giveawayID int64, prizeID int64) (db.Prize, error) {
+	prize, err := tx.LockPrize(ctx, prizeID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return db.Prize{}, ErrPrizeNotFound
+		}
+		return db.Prize{}, fmt.Errorf("error getting prize: %w", err)
+	}
+
+	if prize.GiveawayID != giveawayID {
+		return db.Prize{}, ErrPrizeNotFound
+	}
+
+	return prize, nil
+}
+
+func (s *Service) prizeInGiveaway(ctx context.Context, giveawayID int64, prizeID int64) (db.Prize, error) {
+	prize, err := s.db.GetPrizeByID(ctx, prizeID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return db.Prize{}, ErrPrizeNotFound
+		}
+		return db.Prize{}, fmt.Errorf("error getting prize: %w", err)
+	}
+
+	if prize.GiveawayID != giveawayID {
+		return db.Prize{}, ErrPrizeNotFound
+	}
+
+	return prize, nil
+}
+
+// winnerInGiveaway loads a winner and the prize they won, making sure both belong to the giveaway
+func (s *Service) winnerInGiveaway(ctx context.Context, giveawayID int64, winnerID int64) (db.Winner, db.Prize, error) {
+	winner, err := s.db.GetWinnerByID(ctx, winnerID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return db.Winner{}, db.Prize{}, ErrWinnerNotFound
+		}
+		return db.Winner{}, db.Prize{}, fmt.Errorf("error getting winner: %w", err)
+	}
+
+	// Winners drawn before the prize catalog existed aren't tied to a giveaway's prizes
+	if !winner.PrizeID.Valid {
+		return db.Winner{}, db.Prize{}, ErrWinnerNotFound
+	}
+
+	prize, err := s.prizeInGiveaway(ctx, giveawayID, winner.PrizeID.Int64)
+	if err != nil {
+		if errors.Is(err, ErrPrizeNotFound) {
+			return db.Winner{}, db.Prize{}, ErrWinnerNotFound
+		}
+		return db.Winner{}, db.Prize{}, err
+	}
+
+	return winner, prize, nil
+}
+
+// withStreamerToken calls fn with the streamer's access token, refreshing it once if Twitch rejects it
+func (s *Service) withStreamerToken(ctx context.Context, streamer db.Streamer, fn func(accessToken string) error) error {
+	return twitch.WithStreamerToken(ctx, s.db, s.twitch, streamer, fn)
+}
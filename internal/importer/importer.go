@@ -0,0 +1,297 @@
+package importer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/twitch"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// maxReportedErrors caps the per-row errors in a result so a broken file doesn't produce a huge response
+const maxReportedErrors = 1000
+
+var ErrGiveawayNotFound = errors.New("giveaway not found")
+
+// Row is a single entry to import
+type Row struct {
+	MessageID   string `json:"message_id"` // Optional, derived from the other fields when empty
+	ViewerID    string `json:"viewer_id"`
+	ViewerLogin string `json:"viewer_login"`
+	Streamer    string `json:"streamer"` // Streamer login or Twitch ID
+	Timestamp   string `json:"timestamp"`
+	EntryMethod string `json:"entry_method"` // Optional, defaults to import
+}
+
+type RowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+type Result struct {
+	DryRun         bool       `json:"dry_run"`
+	Rows           int        `json:"rows"`
+	Imported       int        `json:"imported"`
+	Duplicates     int        `json:"duplicates"`
+	Failed         int        `json:"failed"`
+	ViewersCreated int        `json:"viewers_created"`
+	Errors         []RowError `json:"errors"`
+}
+
+type Options struct {
+	GiveawayID int64
+	DryRun     bool // Validate and count everything without writing
+}
+
+// Store is the data an import reads and writes
+type Store interface {
+	db.StreamerStore
+	db.GiveawayStore
+	db.EntryStore
+}
+
+type Importer struct {
+	db     Store
+	logger *slog.Logger
+}
+
+func New(dbStore Store) *Importer {
+	return &Importer{
+		db:     dbStore,
+		logger: slog.Default(),
+	}
+}
+
+// Import reads rows in the given format and adds them to a giveaway.
+// Rows are handled one by one, a bad row is reported and skipped without stopping the import.
+func (i *Importer) Import(ctx context.Context, r io.Reader, format Format, opts Options) (*Result, error) {
+	if _, err := i.db.GetGiveawayByID(ctx, opts.GiveawayID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrGiveawayNotFound
+		}
+		return nil, fmt.Errorf("error getting giveaway: %w", err)
+	}
+
+	reader, err := newReader(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &importRun{
+		Importer:  i,
+		opts:      opts,
+		result:    &Result{DryRun: opts.DryRun, Errors: make([]RowError, 0)},
+		streamers: make(map[string]db.Streamer),
+		viewers:   make(map[string]bool),
+		seen:      make(map[string]bool),
+	}
+
+	for {
+		row, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		run.result.Rows++
+		line := run.result.Rows
+
+		if err == nil {
+			err = run.importRow(ctx, row)
+		}
+
+		// A row that can't even be parsed is reported like any other bad row
+		if err != nil {
+			if errors.Is(err, errDuplicate) {
+				run.result.Duplicates++
+				continue
+			}
+
+			if ctx.Err() != nil {
+				return run.result, ctx.Err()
+			}
+
+			run.result.Failed++
+			if len(run.result.Errors) < maxReportedErrors {
+				run.result.Errors = append(run.result.Errors, RowError{Row: line, Error: err.Error()})
+			}
+			continue
+		}
+
+		run.result.Imported++
+	}
+
+	i.logger.Info("Imported entries",
+		"giveaway_id", opts.GiveawayID,
+		"dry_run", opts.DryRun,
+		"rows", run.result.Rows,
+		"imported", run.result.Imported,
+		"duplicates", run.result.Duplicates,
+		"failed", run.result.Failed,
+	)
+
+	return run.result, nil
+}
+
+var (
+	errDuplicate = errors.New("duplicate entry")
+	errDryRun    = errors.New("dry run") // rolls back the writes of a dry run
+)
+
+// importRun holds the state of one import, lookups are cached since files tend to repeat streamers and viewers
+type importRun struct {
+	*Importer
+	opts      Options
+	result    *Result
+	streamers map[string]db.Streamer
+	viewers   map[string]bool // Viewers known to exist, or that the dry run would create
+	seen      map[string]bool // Message IDs earlier in the file
+}
+
+func (run *importRun) importRow(ctx context.Context, row Row) error {
+	row.ViewerID = strings.TrimSpace(row.ViewerID)
+	row.ViewerLogin = strings.ToLower(strings.TrimSpace(row.ViewerLogin))
+	row.Streamer = strings.ToLower(strings.TrimSpace(row.Streamer))
+	row.MessageID = strings.TrimSpace(row.MessageID)
+
+	if row.ViewerID == "" || row.ViewerLogin == "" || row.Streamer == "" || row.Timestamp == "" {
+		return errors.New("viewer_id, viewer_login, streamer and timestamp are required")
+	}
+
+	redeemedAt, err := time.Parse(time.RFC3339, strings.TrimSpace(row.Timestamp))
+	if err != nil {
+		return fmt.Errorf("timestamp %q is not an RFC 3339 time", row.Timestamp)
+	}
+
+	entryMethod := row.EntryMethod
+	if entryMethod == "" {
+		entryMethod = twitch.EntryMethodImport
+	}
+
+	switch entryMethod {
+	case twitch.EntryMethodChannelPoints, twitch.EntryMethodChatCommand, twitch.EntryMethodImport:
+	default:
+		return fmt.Errorf("unknown entry_method %q", entryMethod)
+	}
+
+	streamer, err := run.getStreamer(ctx, row.Streamer)
+	if err != nil {
+		return err
+	}
+
+	messageID := row.MessageID
+	if messageID == "" {
+		messageID = deriveMessageID(row.ViewerID, streamer.TwitchID, redeemedAt)
+	}
+
+	if run.seen[messageID] {
+		return errDuplicate
+	}
+
+	// The same transaction as live entries, so an opt-out can't slip in between the check and the insert.
+	// A dry run makes the writes and rolls them back.
+	var viewerCreated bool
+	err = run.db.InEntryTx(ctx, func(tx db.EntryTx) error {
+		optedOut, err := tx.IsViewerOptedOut(ctx, row.ViewerID)
+		if err != nil {
+			return fmt.Errorf("error checking viewer opt-out: %w", err)
+		}
+		if optedOut {
+			return errors.New("viewer opted out of giveaways")
+		}
+
+		viewerCreated, err = run.ensureViewer(ctx, tx, row, streamer)
+		if err != nil {
+			return err
+		}
+
+		inserted, err := tx.ImportRedemption(ctx, db.ImportRedemptionParams{
+			MessageID:   messageID,
+			StreamerID:  pgtype.Text{String: streamer.TwitchID, Valid: true},
+			ViewerID:    pgtype.Text{String: row.ViewerID, Valid: true},
+			EntryMethod: entryMethod,
+			GiveawayID:  run.opts.GiveawayID,
+			RedeemedAt:  pgtype.Timestamptz{Time: redeemedAt, Valid: true},
+		})
+		if err != nil {
+			return fmt.Errorf("error saving entry: %w", err)
+		}
+		if inserted == 0 {
+			return errDuplicate
+		}
+
+		if run.opts.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return err
+	}
+
+	// Only a row that made it in makes later copies duplicates, a failed one is tried again
+	run.seen[messageID] = true
+	run.viewers[row.ViewerID] = true
+	if viewerCreated {
+		run.result.ViewersCreated++
+	}
+	return nil
+}
+
+func (run *importRun) getStreamer(ctx context.Context, loginOrID string) (db.Streamer, error) {
+	if streamer, ok := run.streamers[loginOrID]; ok {
+		return streamer, nil
+	}
+
+	streamer, err := run.db.GetStreamerByUsername(ctx, loginOrID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		streamer, err = run.db.GetStreamerByID(ctx, loginOrID)
+	}
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return db.Streamer{}, fmt.Errorf("unknown streamer %q", loginOrID)
+		}
+		return db.Streamer{}, fmt.Errorf("error getting streamer: %w", err)
+	}
+
+	run.streamers[loginOrID] = streamer
+	return streamer, nil
+}
+
+// ensureViewer creates the viewer on their first entry like entries coming from Twitch do, reporting whether it's new.
+// The upsert doesn't fail when a live entry creates the viewer at the same time.
+func (run *importRun) ensureViewer(ctx context.Context, tx db.EntryTx, row Row, streamer db.Streamer) (bool, error) {
+	created := false
+	if !run.viewers[row.ViewerID] {
+		_, err := tx.GetViewerByID(ctx, row.ViewerID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return false, fmt.Errorf("error getting viewer: %w", err)
+		}
+		created = err != nil
+	}
+
+	err := tx.UpsertViewer(ctx, db.UpsertViewerParams{
+		TwitchID:     row.ViewerID,
+		Username:     row.ViewerLogin,
+		RegisteredIn: pgtype.Text{String: streamer.TwitchID, Valid: true},
+	})
+	if err != nil {
+		return false, fmt.Errorf("error saving viewer %s: %w", row.ViewerLogin, err)
+	}
+	return created, nil
+}
+
+// deriveMessageID gives rows without an ID a stable one, so importing the same file twice doesn't double count
+func deriveMessageID(viewerID string, streamerID string, redeemedAt time.Time) string {
+	sum := sha256.Sum256([]byte(viewerID + "|" + streamerID + "|" + redeemedAt.UTC().Format(time.RFC3339Nano)))
+	return "import-" + hex.EncodeToString(sum[:16])
+}
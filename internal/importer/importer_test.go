@@ -0,0 +1,166 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/db/memstore"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const importCSV = "viewer_id,viewer_login,streamer,timestamp\n" +
+	"1001,ann,streamer,2026-10-01T18:00:00Z\n" +
+	"1001,ann,streamer,2026-10-01T18:05:00Z\n" +
+	"1002,bob,streamer,2026-10-01T18:10:00Z\n"
+
+// flakyStore fails the next entry transactions, like a dropped connection would
+type flakyStore struct {
+	*memstore.Store
+	failures atomic.Int32
+}
+
+func (s *flakyStore) InEntryTx(ctx context.Context, fn func(tx db.EntryTx) error) error {
+	if s.failures.Add(-1) >= 0 {
+		return errors.New("connection lost")
+	}
+	return s.Store.InEntryTx(ctx, fn)
+}
+
+func newImportStore(t *testing.T) (*flakyStore, int64) {
+	t.Helper()
+	ctx := context.Background()
+	store := &flakyStore{Store: memstore.New()}
+
+	if _, err := store.CreateStreamer(ctx, db.CreateStreamerParams{TwitchID: "42", Username: "streamer"}); err != nil {
+		t.Fatalf("error creating the streamer: %v", err)
+	}
+	giveaway, err := store.CreateGiveaway(ctx, db.CreateGiveawayParams{Name: "Imported", Status: "closed"})
+	if err != nil {
+		t.Fatalf("error creating the giveaway: %v", err)
+	}
+	return store, giveaway.ID
+}
+
+func entries(t *testing.T, store *flakyStore, giveawayID int64, viewerID string) int64 {
+	t.Helper()
+	stats, err := store.GetViewerEntryStats(context.Background(), db.GetViewerEntryStatsParams{
+		StreamerID:  pgtype.Text{String: "42", Valid: true},
+		ViewerID:    pgtype.Text{String: viewerID, Valid: true},
+		EntryMethod: "import",
+		GiveawayID:  giveawayID,
+	})
+	if err != nil {
+		t.Fatalf("error counting entries: %v", err)
+	}
+	return stats.TotalEntries
+}
+
+func TestImport(t *testing.T) {
+	store, giveawayID := newImportStore(t)
+	importer := New(store)
+
+	result, err := importer.Import(context.Background(), strings.NewReader(importCSV), FormatCSV, Options{GiveawayID: giveawayID})
+	if err != nil {
+		t.Fatalf("error importing: %v", err)
+	}
+	if result.Rows != 3 || result.Imported != 3 || result.ViewersCreated != 2 || result.Failed != 0 {
+		t.Fatalf("got %+v, want 3 rows imported and 2 viewers created", result)
+	}
+	if got := entries(t, store, giveawayID, "1001"); got != 2 {
+		t.Errorf("got %d entries of ann, want 2", got)
+	}
+
+	// Importing the file again adds nothing
+	result, err = importer.Import(context.Background(), strings.NewReader(importCSV), FormatCSV, Options{GiveawayID: giveawayID})
+	if err != nil {
+		t.Fatalf("error importing again: %v", err)
+	}
+	if result.Duplicates != 3 || result.Imported != 0 || result.ViewersCreated != 0 {
+		t.Errorf("got %+v, want every row a duplicate", result)
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	store, giveawayID := newImportStore(t)
+
+	result, err := New(store).Import(context.Background(), strings.NewReader(importCSV), FormatCSV, Options{GiveawayID: giveawayID, DryRun: true})
+	if err != nil {
+		t.Fatalf("error importing: %v", err)
+	}
+	if !result.DryRun || result.Imported != 3 || result.ViewersCreated != 2 {
+		t.Fatalf("got %+v, want the counts of a real import", result)
+	}
+
+	if _, err := store.GetViewerByID(context.Background(), "1001"); err == nil {
+		t.Error("the dry run created a viewer")
+	}
+	if got := entries(t, store, giveawayID, "1001"); got != 0 {
+		t.Errorf("the dry run added %d entries", got)
+	}
+}
+
+func TestImportOptedOut(t *testing.T) {
+	store, giveawayID := newImportStore(t)
+	if err := store.OptOutViewer(context.Background(), "1001"); err != nil {
+		t.Fatalf("error opting out: %v", err)
+	}
+
+	result, err := New(store).Import(context.Background(), strings.NewReader(importCSV), FormatCSV, Options{GiveawayID: giveawayID})
+	if err != nil {
+		t.Fatalf("error importing: %v", err)
+	}
+	if result.Failed != 2 || result.Imported != 1 || result.ViewersCreated != 1 {
+		t.Errorf("got %+v, want the rows of the viewer who opted out rejected", result)
+	}
+	if _, err := store.GetViewerByID(context.Background(), "1001"); err == nil {
+		t.Error("the viewer who opted out was created again")
+	}
+}
+
+// A live entry may have created the viewer already, importing their entries works the same
+func TestImportExistingViewer(t *testing.T) {
+	store, giveawayID := newImportStore(t)
+	err := store.InEntryTx(context.Background(), func(tx db.EntryTx) error {
+		return tx.UpsertViewer(context.Background(), db.UpsertViewerParams{
+			TwitchID:     "1001",
+			Username:     "ann",
+			RegisteredIn: pgtype.Text{String: "42", Valid: true},
+		})
+	})
+	if err != nil {
+		t.Fatalf("error creating the viewer: %v", err)
+	}
+
+	result, err := New(store).Import(context.Background(), strings.NewReader(importCSV), FormatCSV, Options{GiveawayID: giveawayID})
+	if err != nil {
+		t.Fatalf("error importing: %v", err)
+	}
+	if result.Imported != 3 || result.ViewersCreated != 1 {
+		t.Errorf("got %+v, want 3 rows imported and only bob created", result)
+	}
+}
+
+// A row that failed isn't remembered, the same row later in the file is imported instead of reported as a duplicate
+func TestImportRetriesFailedRow(t *testing.T) {
+	store, giveawayID := newImportStore(t)
+	store.failures.Store(1)
+
+	input := "viewer_id,viewer_login,streamer,timestamp\n" +
+		"1001,ann,streamer,2026-10-01T18:00:00Z\n" +
+		"1001,ann,streamer,2026-10-01T18:00:00Z\n"
+
+	result, err := New(store).Import(context.Background(), strings.NewReader(input), FormatCSV, Options{GiveawayID: giveawayID})
+	if err != nil {
+		t.Fatalf("error importing: %v", err)
+	}
+	if result.Failed != 1 || result.Imported != 1 || result.Duplicates != 0 {
+		t.Errorf("got %+v, want the first copy failed and the second imported", result)
+	}
+	if got := entries(t, store, giveawayID, "1001"); got != 1 {
+		t.Errorf("got %d entries, want 1", got)
+	}
+}
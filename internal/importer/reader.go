@@ -0,0 +1,141 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+var ErrUnknownFormat = errors.New("format must be csv or ndjson")
+
+func ParseFormat(value string) (Format, error) {
+	switch format := Format(strings.ToLower(value)); format {
+	case FormatCSV, FormatNDJSON:
+		return format, nil
+	case "":
+		return FormatCSV, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+// rowReader returns rows one at a time and io.EOF once the input is done.
+// Any other error only concerns the current row, reading can go on.
+type rowReader interface {
+	Next() (Row, error)
+}
+
+func newReader(r io.Reader, format Format) (rowReader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatNDJSON:
+		return &ndjsonReader{scanner: newLineScanner(r)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+var csvColumns = []string{"message_id", "viewer_id", "viewer_login", "streamer", "timestamp", "entry_method"}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the CSV header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, required := range []string{"viewer_id", "viewer_login", "streamer", "timestamp"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("the CSV header is missing the %s column, expected %s", required, strings.Join(csvColumns, ","))
+		}
+	}
+
+	return &csvReader{r: reader, columns: columns}, nil
+}
+
+func (c *csvReader) Next() (Row, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		return Row{}, err
+	}
+
+	field := func(name string) string {
+		i, ok := c.columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	return Row{
+		MessageID:   field("message_id"),
+		ViewerID:    field("viewer_id"),
+		ViewerLogin: field("viewer_login"),
+		Streamer:    field("streamer"),
+		Timestamp:   field("timestamp"),
+		EntryMethod: field("entry_method"),
+	}, nil
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	done    bool
+}
+
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
+
+func (n *ndjsonReader) Next() (Row, error) {
+	// After an error the scanner hands out what's left of its buffer, the rest of the broken line
+	if n.done {
+		return Row{}, io.EOF
+	}
+
+	for n.scanner.Scan() {
+		line := strings.TrimSpace(n.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var row Row
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			return Row{}, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return row, nil
+	}
+
+	// The scanner can't go on after an error, report it once and stop
+	n.done = true
+	if err := n.scanner.Err(); err != nil {
+		return Row{}, err
+	}
+	return Row{}, io.EOF
+}
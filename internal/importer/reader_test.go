@@ -0,0 +1,176 @@
+package importer
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Format
+		wantErr bool
+	}{
+		{"csv", FormatCSV, false},
+		{"NDJSON", FormatNDJSON, false},
+		{"", FormatCSV, false},
+		{"json", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.value)
+		if tt.wantErr {
+			if !errors.Is(err, ErrUnknownFormat) {
+				t.Errorf("ParseFormat(%q): got %v, want ErrUnknownFormat", tt.value, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+// readAll reads every row, the errors of bad rows are kept in place of the row
+func readAll(t *testing.T, input string, format Format) ([]Row, []error) {
+	t.Helper()
+	reader, err := newReader(strings.NewReader(input), format)
+	if err != nil {
+		t.Fatalf("error creating the reader: %v", err)
+	}
+
+	var rows []Row
+	var errs []error
+	for {
+		row, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return rows, errs
+		}
+		rows = append(rows, row)
+		errs = append(errs, err)
+		if len(rows) > 100 {
+			t.Fatal("the reader doesn't stop")
+		}
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	// Columns can come in any order and case, the optional ones can be left out
+	input := "Timestamp, viewer_login,VIEWER_ID,streamer\n" +
+		"2026-10-01T18:00:00Z,ann,1001,streamer\n" +
+		"2026-10-01T18:05:00Z,bob\n"
+
+	rows, errs := readAll(t, input, FormatCSV)
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("row %d: unexpected error: %v", i+1, err)
+		}
+	}
+
+	want := Row{ViewerID: "1001", ViewerLogin: "ann", Streamer: "streamer", Timestamp: "2026-10-01T18:00:00Z"}
+	if rows[0] != want {
+		t.Errorf("got %+v, want %+v", rows[0], want)
+	}
+	// A short record leaves the missing fields empty for the import to reject
+	if rows[1].ViewerLogin != "bob" || rows[1].ViewerID != "" || rows[1].Streamer != "" {
+		t.Errorf("got %+v for the short record", rows[1])
+	}
+}
+
+func TestCSVReaderAllColumns(t *testing.T) {
+	input := "message_id,viewer_id,viewer_login,streamer,timestamp,entry_method\n" +
+		`msg-1,1001,ann,"streamer",2026-10-01T18:00:00Z,chat_command` + "\n"
+
+	rows, errs := readAll(t, input, FormatCSV)
+	if len(rows) != 1 || errs[0] != nil {
+		t.Fatalf("got rows %+v and errors %v", rows, errs)
+	}
+
+	want := Row{MessageID: "msg-1", ViewerID: "1001", ViewerLogin: "ann", Streamer: "streamer", Timestamp: "2026-10-01T18:00:00Z", EntryMethod: "chat_command"}
+	if rows[0] != want {
+		t.Errorf("got %+v, want %+v", rows[0], want)
+	}
+}
+
+func TestCSVReaderHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty file", ""},
+		{"missing column", "viewer_id,viewer_login,timestamp\n1001,ann,2026-10-01T18:00:00Z\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newReader(strings.NewReader(tt.input), FormatCSV); err == nil {
+				t.Fatal("the header was accepted")
+			}
+		})
+	}
+}
+
+func TestCSVReaderBadRow(t *testing.T) {
+	input := "viewer_id,viewer_login,streamer,timestamp\n" +
+		`1001,"ann,streamer,2026-10-01T18:00:00Z` + "\n"
+
+	rows, errs := readAll(t, input, FormatCSV)
+	if len(rows) == 0 || errs[0] == nil {
+		t.Fatalf("got rows %+v and errors %v, want the unterminated quote reported", rows, errs)
+	}
+}
+
+func TestNDJSONReader(t *testing.T) {
+	input := `{"viewer_id":"1001","viewer_login":"ann","streamer":"streamer","timestamp":"2026-10-01T18:00:00Z"}` + "\n" +
+		"\n" +
+		"   \n" +
+		`{"viewer_id":"1002",` + "\n" +
+		`{"message_id":"msg-3","viewer_id":"1003","viewer_login":"cid","streamer":"streamer","timestamp":"2026-10-01T18:10:00Z","entry_method":"import"}`
+
+	rows, errs := readAll(t, input, FormatNDJSON)
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3 without the blank lines", len(rows))
+	}
+
+	if errs[0] != nil || rows[0].ViewerID != "1001" || rows[0].ViewerLogin != "ann" {
+		t.Errorf("got %+v, %v for the first row", rows[0], errs[0])
+	}
+	// A broken line is reported and reading goes on
+	if errs[1] == nil {
+		t.Error("the invalid JSON was accepted")
+	}
+	if errs[2] != nil || rows[2].MessageID != "msg-3" || rows[2].EntryMethod != "import" {
+		t.Errorf("got %+v, %v for the last row", rows[2], errs[2])
+	}
+}
+
+func TestNDJSONReaderLineTooLong(t *testing.T) {
+	input := `{"viewer_id":"` + strings.Repeat("1", 2*1024*1024) + `"}` + "\n"
+
+	rows, errs := readAll(t, input, FormatNDJSON)
+	if len(rows) != 1 || errs[0] == nil {
+		t.Fatalf("got %d rows and errors %v, want the scanner error reported once", len(rows), errs)
+	}
+}
+
+func TestDeriveMessageID(t *testing.T) {
+	redeemedAt := time.Date(2026, time.October, 1, 18, 0, 0, 0, time.UTC)
+	id := deriveMessageID("1001", "42", redeemedAt)
+
+	// The same entry in another timezone is the same entry
+	if got := deriveMessageID("1001", "42", redeemedAt.In(time.FixedZone("CEST", 2*60*60))); got != id {
+		t.Errorf("got %s in another timezone, want %s", got, id)
+	}
+	if deriveMessageID("1002", "42", redeemedAt) == id || deriveMessageID("1001", "42", redeemedAt.Add(time.Second)) == id {
+		t.Error("different entries got the same message ID")
+	}
+	if !strings.HasPrefix(id, "import-") {
+		t.Errorf("got %s, want the import- prefix", id)
+	}
+}
@@ -0,0 +1,69 @@
+// Package leader runs work on one replica at a time, elected through a lock every replica competes for
+package leader
+
+import (
+	"context"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/logging"
+)
+
+// Run tries to take the named lock every interval and calls fn while it holds it, until ctx is cancelled.
+// The lock is checked every interval too, fn's context is cancelled as soon as it is lost and Run waits
+// for fn to return before it competes again.
+func Run(ctx context.Context, locker db.Locker, name string, interval time.Duration, fn func(ctx context.Context)) error {
+	logger := logging.FromContext(ctx).With("lock", name)
+
+	for {
+		lock, acquired, err := locker.TryLock(ctx, name)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("Error taking the leader lock", "error", err)
+		}
+
+		if acquired {
+			logger.Info("Became leader")
+			lead(ctx, lock, interval, fn)
+			logger.Info("Stopped leading")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+func lead(ctx context.Context, lock db.Lock, interval time.Duration, fn func(ctx context.Context)) {
+	defer lock.Release()
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(leaderCtx)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			<-done
+			return
+		case <-ticker.C:
+			if err := lock.Check(ctx); err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx).Warn("Lost the leader lock", "error", err)
+				cancel()
+				<-done
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Manager runs the background workers of the process and shuts everything down in order on SIGINT or SIGTERM
+type Manager struct {
+	ctx             context.Context // cancelled on the first signal or when a worker fails
+	cancel          context.CancelFunc
+	workers         sync.WaitGroup
+	hooks           []hook
+	draining        atomic.Bool
+	drainPeriod     time.Duration
+	shutdownTimeout time.Duration
+	logger          *slog.Logger
+
+	errMu sync.Mutex
+	err   error
+}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// New returns a manager listening for signals, drainPeriod is how long Draining reports true before the
+// shutdown hooks run and shutdownTimeout bounds the hooks and the workers together
+func New(drainPeriod time.Duration, shutdownTimeout time.Duration) *Manager {
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(signalCtx)
+
+	return &Manager{
+		ctx: ctx,
+		cancel: func() {
+			cancel()
+			stop()
+		},
+		drainPeriod:     drainPeriod,
+		shutdownTimeout: shutdownTimeout,
+		logger:          slog.Default(),
+	}
+}
+
+// Context is cancelled when the shutdown starts, workers should return once it is done
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Draining reports whether the shutdown started, readiness checks fail from then on
+func (m *Manager) Draining() bool {
+	return m.draining.Load()
+}
+
+// Go runs fn in a tracked goroutine, an error from fn shuts the process down
+func (m *Manager) Go(name string, fn func(ctx context.Context) error) {
+	m.workers.Add(1)
+	go func() {
+		defer m.workers.Done()
+
+		err := fn(m.ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			m.logger.Error("Worker failed, shutting down", "worker", name, "error", err)
+			m.setErr(err)
+			m.cancel()
+		}
+	}()
+}
+
+// OnShutdown registers fn to run after the drain period, hooks run in the order they were registered
+func (m *Manager) OnShutdown(name string, fn func(ctx context.Context) error) {
+	m.hooks = append(m.hooks, hook{name: name, fn: fn})
+}
+
+// Wait blocks until a signal arrives or a worker fails, then drains, runs the hooks and waits for the workers.
+// It returns the error of the failed worker, if any.
+func (m *Manager) Wait() error {
+	<-m.ctx.Done()
+	m.cancel()
+	m.draining.Store(true)
+
+	// A failed worker means we aren't serving properly anyway, so there is nothing to drain
+	if m.failed() {
+		m.logger.Info("Shutting down")
+	} else {
+		m.logger.Info("Shutting down", "drain_period", m.drainPeriod.String())
+		time.Sleep(m.drainPeriod)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.shutdownTimeout)
+	defer cancel()
+
+	for _, hook := range m.hooks {
+		if err := hook.fn(ctx); err != nil {
+			m.logger.Error("Shutdown hook failed", "hook", hook.name, "error", err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		m.logger.Info("Shutdown complete")
+	case <-ctx.Done():
+		m.logger.Warn("Workers didn't stop before the shutdown timeout")
+	}
+
+	m.errMu.Lock()
+	defer m.errMu.Unlock()
+	return m.err
+}
+
+func (m *Manager) failed() bool {
+	m.errMu.Lock()
+	defer m.errMu.Unlock()
+	return m.err != nil
+}
+
+func (m *Manager) setErr(err error) {
+	m.errMu.Lock()
+	defer m.errMu.Unlock()
+	if m.err == nil {
+		m.err = err
+	}
+}
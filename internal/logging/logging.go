@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/gorilla/sessions"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+)
+
+type contextKey struct{}
+
+// WithLogger returns a copy of ctx carrying logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger when there is none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// With returns a copy of ctx whose logger has the extra attributes
+func With(ctx context.Context, args ...any) context.Context {
+	return WithLogger(ctx, FromContext(ctx).With(args...))
+}
+
+// Middleware stores a logger tagged with the request ID in the request context.
+// It has to run after middleware.RequestID, the ID is echoed back in the X-Request-Id header.
+func Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := middleware.GetReqID(r.Context())
+			w.Header().Set(middleware.RequestIDHeader, requestID)
+
+			requestLogger := logger.With(
+				slog.String("request_id", requestID),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+			)
+			if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+				requestLogger = requestLogger.With(slog.String("trace_id", spanContext.TraceID().String()))
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithLogger(r.Context(), requestLogger)))
+		})
+	}
+}
+
+// Keys whose values are never written to the logs, compared without case, dashes or underscores against the end
+// of the key, so access_token is hidden but an identifier like eventsub_session_id isn't
+var sensitiveKeys = []string{"token", "secret", "password", "authorization", "cookie", "session"}
+
+const redacted = "[redacted]"
+
+// Redact is a slog ReplaceAttr function hiding credentials, by the key they are logged under or by their type
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if isSensitiveKey(a.Key) || strings.HasPrefix(a.Value.String(), "Bearer ") {
+			return slog.String(a.Key, redacted)
+		}
+	case slog.KindAny:
+		switch a.Value.Any().(type) {
+		case *oauth2.Token, oauth2.Token, *sessions.Session, sessions.Session, map[interface{}]interface{}:
+			return slog.String(a.Key, redacted)
+		}
+		if isSensitiveKey(a.Key) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	return a
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	for _, sensitive := range sensitiveKeys {
+		if strings.HasSuffix(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
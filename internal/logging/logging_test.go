@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"log/slog"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name   string
+		attr   slog.Attr
+		hidden bool
+	}{
+		{"session", slog.String("session", "abc"), true},
+		{"session token", slog.String("session_token", "abc"), true},
+		{"access token", slog.String("access_token", "abc"), true},
+		{"camel case", slog.String("refreshToken", "abc"), true},
+		{"client secret", slog.String("client-secret", "abc"), true},
+		{"cookie", slog.String("Cookie", "abc"), true},
+		{"bearer value", slog.String("header", "Bearer abc"), true},
+		{"token by type", slog.Any("result", &oauth2.Token{AccessToken: "abc"}), true},
+		// Identifiers that only mention a sensitive word are kept, they're what tracing a problem needs
+		{"eventsub session ID", slog.String("eventsub_session_id", "abc"), false},
+		{"session ID", slog.String("session_id", "abc"), false},
+		{"token type", slog.String("token_type", "bearer"), false},
+		{"other key", slog.String("streamer_id", "42"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Redact(nil, tt.attr)
+			if got.Key != tt.attr.Key {
+				t.Fatalf("the key changed to %q", got.Key)
+			}
+			if hidden := got.Value.String() == redacted; hidden != tt.hidden {
+				t.Errorf("got %q, want hidden %v", got.Value, tt.hidden)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/jackc/pgx/v5"
+)
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	name string
+	at   time.Time
+}
+
+// QueryTracer times every query, set it as the Tracer of the pgx connection config
+type QueryTracer struct{}
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	name, ok := db.QueryName(data.SQL)
+	if !ok {
+		name = "other"
+	}
+
+	return context.WithValue(ctx, queryStartKey{}, queryStart{name: name, at: time.Now()})
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+
+	DBQueryDuration.WithLabelValues(start.name, Outcome(data.Err)).Observe(time.Since(start.at).Seconds())
+}
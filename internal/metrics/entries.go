@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	giveawayEntriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "giveaway", "entries"),
+		"Entries in each open giveaway.",
+		[]string{"giveaway_id", "giveaway_name"}, nil,
+	)
+
+	giveawayParticipantsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "giveaway", "participants"),
+		"Viewers with at least one entry in each open giveaway.",
+		[]string{"giveaway_id", "giveaway_name"}, nil,
+	)
+)
+
+// entriesCollector reads the entry totals of the open giveaways on every scrape, so they survive restarts
+type entriesCollector struct {
+	db db.StatsStore
+}
+
+// RegisterEntryTotals exports the entry and participant totals of the open giveaways
+func RegisterEntryTotals(dbStore db.StatsStore) error {
+	return prometheus.Register(&entriesCollector{db: dbStore})
+}
+
+func (c *entriesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- giveawayEntriesDesc
+	ch <- giveawayParticipantsDesc
+}
+
+func (c *entriesCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	totals, err := c.db.GetOpenGiveawayEntryTotals(ctx)
+	if err != nil {
+		slog.Error("Error collecting giveaway entry totals", "error", err)
+		ch <- prometheus.NewInvalidMetric(giveawayEntriesDesc, err)
+		return
+	}
+
+	for _, total := range totals {
+		id := strconv.FormatInt(total.GiveawayID, 10)
+		ch <- prometheus.MustNewConstMetric(giveawayEntriesDesc, prometheus.GaugeValue, float64(total.TotalEntries), id, total.Name)
+		ch <- prometheus.MustNewConstMetric(giveawayParticipantsDesc, prometheus.GaugeValue, float64(total.TotalParticipants), id, total.Name)
+	}
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "twitch_points"
+
+// EventSub
+var (
+	EventSubRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "eventsub",
+		Name:      "requests_total",
+		Help:      "EventSub webhook requests by message type and response status, 403 means the signature didn't match.",
+	}, []string{"message_type", "status"})
+
+	EventSubNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "eventsub",
+		Name:      "notifications_total",
+		Help:      "EventSub notifications handled by event type and outcome: success, error or invalid.",
+	}, []string{"type", "outcome"})
+
+	EventSubHandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "eventsub",
+		Name:      "handler_duration_seconds",
+		Help:      "Time spent handling an EventSub notification.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type"})
+)
+
+// Helix and the Twitch OAuth endpoints
+var (
+	HelixRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "helix",
+		Name:      "requests_total",
+		Help:      "Requests to the Twitch API by endpoint and status, status is \"error\" when no response arrived.",
+	}, []string{"endpoint", "status"})
+
+	HelixRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "helix",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests to the Twitch API.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	HelixRateLimitRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "helix",
+		Name:      "ratelimit_remaining",
+		Help:      "Points left in the rate limit bucket of the last Helix response.",
+	})
+
+	HelixRateLimitLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "helix",
+		Name:      "ratelimit_limit",
+		Help:      "Size of the rate limit bucket of the last Helix response.",
+	})
+
+	TokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "twitch",
+		Name:      "token_refreshes_total",
+		Help:      "Streamer token refreshes by outcome: success, rejected or error.",
+	}, []string{"outcome"})
+)
+
+// Database
+var (
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Latency of database queries by sqlc query name and outcome.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"query", "outcome"})
+)
+
+// Entries and the live feed
+var (
+	EntriesAdded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entries_added_total",
+		Help:      "Entries added from EventSub notifications and backfills by entry method.",
+	}, []string{"method"})
+
+	SSEClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sse",
+		Name:      "clients",
+		Help:      "Clients connected to the live entries feed.",
+	})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Outcome maps an error to the outcome label
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type transport struct {
+	next http.RoundTripper
+}
+
+// InstrumentTransport counts and times the requests made through next and records the Helix rate limit headers.
+// Helix paths carry no IDs, so the path is used as the endpoint label as is.
+func InstrumentTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{next: next}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := req.URL.Path
+	start := time.Now()
+
+	resp, err := t.next.RoundTrip(req)
+
+	HelixRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		HelixRequests.WithLabelValues(endpoint, "error").Inc()
+		return nil, err
+	}
+
+	HelixRequests.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+
+	if remaining, err := strconv.ParseFloat(resp.Header.Get("Ratelimit-Remaining"), 64); err == nil {
+		HelixRateLimitRemaining.Set(remaining)
+	}
+	if limit, err := strconv.ParseFloat(resp.Header.Get("Ratelimit-Limit"), 64); err == nil {
+		HelixRateLimitLimit.Set(limit)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/telemetry"
+)
+
+// Notifier posts messages to a Discord webhook
+type Notifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// New returns a notifier for the webhook, messages are dropped when the URL is empty
+func New(webhookURL string) *Notifier {
+	return &Notifier{
+		webhookURL: webhookURL,
+		client:     &http.Client{Transport: telemetry.Transport(http.DefaultTransport), Timeout: 10 * time.Second},
+	}
+}
+
+func (n *Notifier) Send(ctx context.Context, text string) error {
+	if n == nil || n.webhookURL == "" {
+		return nil
+	}
+
+	payload := map[string]string{
+		"content": text,
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return errors.New("error encoding JSON: " + err.Error())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.webhookURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return errors.New("error creating request: " + err.Error())
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return errors.New("error sending webhook request: " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return errors.New("failed to send webhook, status: " + resp.Status)
+	}
+
+	return nil
+}
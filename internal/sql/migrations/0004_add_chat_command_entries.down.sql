@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_redemptions_streamer_viewer;
+
+ALTER TABLE redemptions DROP COLUMN entry_method;
+
+DROP TRIGGER IF EXISTS update_chat_commands_modtime ON chat_commands;
+DROP TABLE IF EXISTS chat_commands;
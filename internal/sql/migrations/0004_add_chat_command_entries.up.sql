@@ -0,0 +1,19 @@
+CREATE TABLE chat_commands(
+	streamer_id TEXT PRIMARY KEY REFERENCES streamers(twitch_id) ON DELETE CASCADE,
+	command TEXT NOT NULL DEFAULT '!enter',
+	enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	cooldown_seconds INTEGER NOT NULL DEFAULT 30,
+	max_entries_per_viewer INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TRIGGER update_chat_commands_modtime
+BEFORE UPDATE ON chat_commands
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
+
+-- Every existing redemption came from a channel point reward
+ALTER TABLE redemptions ADD COLUMN entry_method TEXT NOT NULL DEFAULT 'channel_points';
+
+CREATE INDEX idx_redemptions_streamer_viewer ON redemptions (streamer_id, viewer_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_announcements_winner_id;
+DROP TABLE IF EXISTS announcements;
+DROP INDEX IF EXISTS idx_winners_draw_id;
+DROP TABLE IF EXISTS winners;
+DROP TABLE IF EXISTS draws;
@@ -0,0 +1,31 @@
+CREATE TABLE draws(
+	id BIGSERIAL PRIMARY KEY,
+	prize TEXT NOT NULL,
+	drawn_by TEXT REFERENCES streamers(twitch_id),
+	total_entries BIGINT NOT NULL,
+	drawn_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE winners(
+	id BIGSERIAL PRIMARY KEY,
+	draw_id BIGINT NOT NULL REFERENCES draws(id) ON DELETE CASCADE,
+	viewer_id TEXT NOT NULL REFERENCES viewers(twitch_id),
+	entries BIGINT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_winners_draw_id ON winners (draw_id);
+
+-- One row per chat message or whisper sent for a winner, including failed ones
+CREATE TABLE announcements(
+	id BIGSERIAL PRIMARY KEY,
+	winner_id BIGINT NOT NULL REFERENCES winners(id) ON DELETE CASCADE,
+	sender_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	message TEXT NOT NULL,
+	sent BOOLEAN NOT NULL,
+	error TEXT,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_announcements_winner_id ON announcements (winner_id);
@@ -0,0 +1,18 @@
+DROP TRIGGER IF EXISTS update_winners_modtime ON winners;
+DROP INDEX IF EXISTS idx_winners_prize_id;
+ALTER TABLE winners DROP COLUMN updated_at;
+ALTER TABLE winners DROP COLUMN redrawn_from;
+ALTER TABLE winners DROP COLUMN status;
+ALTER TABLE winners DROP COLUMN prize_id;
+
+DROP INDEX IF EXISTS idx_prizes_giveaway_id;
+DROP TABLE IF EXISTS prizes;
+
+ALTER TABLE draws DROP COLUMN giveaway_id;
+
+DROP INDEX IF EXISTS idx_redemptions_giveaway_id;
+ALTER TABLE redemptions DROP COLUMN giveaway_id;
+
+DROP TRIGGER IF EXISTS update_giveaways_modtime ON giveaways;
+DROP INDEX IF EXISTS idx_giveaways_one_open;
+DROP TABLE IF EXISTS giveaways;
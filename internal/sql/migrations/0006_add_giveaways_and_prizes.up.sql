@@ -0,0 +1,52 @@
+CREATE TABLE giveaways(
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'draft',
+	created_by TEXT REFERENCES streamers(twitch_id),
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- New entries go to the open giveaway, so there can only be one
+CREATE UNIQUE INDEX idx_giveaways_one_open ON giveaways (status) WHERE status = 'open';
+
+CREATE TRIGGER update_giveaways_modtime
+BEFORE UPDATE ON giveaways
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
+
+-- Everything so far belonged to a single implicit giveaway, keep it open so entries keep counting
+INSERT INTO giveaways (name, status) VALUES ('Giveaway', 'open');
+
+ALTER TABLE redemptions ADD COLUMN giveaway_id BIGINT REFERENCES giveaways(id);
+UPDATE redemptions SET giveaway_id = (SELECT MIN(id) FROM giveaways);
+ALTER TABLE redemptions ALTER COLUMN giveaway_id SET NOT NULL;
+CREATE INDEX idx_redemptions_giveaway_id ON redemptions (giveaway_id);
+
+ALTER TABLE draws ADD COLUMN giveaway_id BIGINT REFERENCES giveaways(id);
+UPDATE draws SET giveaway_id = (SELECT MIN(id) FROM giveaways);
+ALTER TABLE draws ALTER COLUMN giveaway_id SET NOT NULL;
+
+CREATE TABLE prizes(
+	id BIGSERIAL PRIMARY KEY,
+	giveaway_id BIGINT NOT NULL REFERENCES giveaways(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	quantity INTEGER NOT NULL CHECK (quantity > 0),
+	tier INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_prizes_giveaway_id ON prizes (giveaway_id);
+
+-- Winners drawn before prizes existed have no prize_id
+ALTER TABLE winners ADD COLUMN prize_id BIGINT REFERENCES prizes(id);
+ALTER TABLE winners ADD COLUMN status TEXT NOT NULL DEFAULT 'pending_claim';
+ALTER TABLE winners ADD COLUMN redrawn_from BIGINT REFERENCES winners(id);
+ALTER TABLE winners ADD COLUMN updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
+
+CREATE INDEX idx_winners_prize_id ON winners (prize_id);
+
+CREATE TRIGGER update_winners_modtime
+BEFORE UPDATE ON winners
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
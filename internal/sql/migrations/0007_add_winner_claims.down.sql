@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_winners_viewer_id;
+DROP INDEX IF EXISTS idx_winners_pending_claims;
+
+ALTER TABLE winners DROP COLUMN claimed_at;
+ALTER TABLE winners DROP COLUMN claim_details;
+ALTER TABLE winners DROP COLUMN claim_deadline;
@@ -0,0 +1,7 @@
+ALTER TABLE winners ADD COLUMN claim_deadline TIMESTAMP WITH TIME ZONE;
+-- Delivery details, encrypted by the application before they get here
+ALTER TABLE winners ADD COLUMN claim_details BYTEA;
+ALTER TABLE winners ADD COLUMN claimed_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_winners_pending_claims ON winners (claim_deadline) WHERE status = 'pending_claim';
+CREATE INDEX idx_winners_viewer_id ON winners (viewer_id);
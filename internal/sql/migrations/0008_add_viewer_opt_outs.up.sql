@@ -0,0 +1,5 @@
+-- Viewers who asked for their data to be deleted, only the Twitch ID is kept so their entries can be refused
+CREATE TABLE viewer_opt_outs(
+	twitch_id TEXT PRIMARY KEY,
+	opted_out_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_rewards_one_active;
+
+-- Only the active rewards were kept before
+DELETE FROM rewards WHERE status = 'retired';
+
+ALTER TABLE rewards DROP COLUMN retired_at;
+ALTER TABLE rewards DROP COLUMN giveaway_id;
+ALTER TABLE rewards DROP COLUMN status;
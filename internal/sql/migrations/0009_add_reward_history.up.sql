@@ -0,0 +1,8 @@
+-- Rewards are retired instead of deleted when a streamer replaces theirs, so late redemptions can still be matched
+ALTER TABLE rewards ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+-- The giveaway open when the reward was created, or when it was retired. Retired rewards keep counting for it
+ALTER TABLE rewards ADD COLUMN giveaway_id BIGINT REFERENCES giveaways(id);
+ALTER TABLE rewards ADD COLUMN retired_at TIMESTAMP WITH TIME ZONE;
+
+-- Replacing a reward deleted the old rows, so every streamer has at most one
+CREATE UNIQUE INDEX idx_rewards_one_active ON rewards (streamer_id) WHERE status = 'active';
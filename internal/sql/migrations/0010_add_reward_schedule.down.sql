@@ -0,0 +1,4 @@
+ALTER TABLE streamers DROP COLUMN reward_live_only;
+
+ALTER TABLE giveaways DROP COLUMN closes_at;
+ALTER TABLE giveaways DROP COLUMN opens_at;
@@ -0,0 +1,6 @@
+-- When viewers can redeem the rewards for the giveaway, they are paused outside of it. Either end can be left open
+ALTER TABLE giveaways ADD COLUMN opens_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE giveaways ADD COLUMN closes_at TIMESTAMP WITH TIME ZONE;
+
+-- Streamers who only want their reward redeemable while they are live
+ALTER TABLE streamers ADD COLUMN reward_live_only BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_giveaways_scheduled_closes_at;
+DROP INDEX IF EXISTS idx_giveaways_recurring_occurrence;
+
+ALTER TABLE giveaways DROP COLUMN auto_draw;
+ALTER TABLE giveaways DROP COLUMN scheduled_for;
+ALTER TABLE giveaways DROP COLUMN recurring_giveaway_id;
+
+DROP TABLE IF EXISTS recurring_giveaways;
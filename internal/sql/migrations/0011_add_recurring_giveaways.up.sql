@@ -0,0 +1,36 @@
+-- Recurring giveaways, every occurrence of the rule opens a giveaway of its own
+CREATE TABLE recurring_giveaways(
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	-- RFC 5545 recurrence rule of the opening times, such as FREQ=WEEKLY;BYDAY=FR;BYHOUR=20;BYMINUTE=0
+	rrule TEXT NOT NULL,
+	-- IANA time zone the rule is read in, so 20:00 stays 20:00 when daylight saving time changes
+	timezone TEXT NOT NULL,
+	-- First occurrence, its time of day is used when the rule doesn't set one
+	starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	duration_seconds INTEGER NOT NULL CHECK (duration_seconds > 0),
+	-- Prize added to every giveaway, none when empty
+	prize_name TEXT,
+	prize_quantity INTEGER NOT NULL DEFAULT 1 CHECK (prize_quantity > 0),
+	auto_draw BOOLEAN NOT NULL DEFAULT FALSE,
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	created_by TEXT REFERENCES streamers(twitch_id),
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TRIGGER update_recurring_giveaways_modtime
+BEFORE UPDATE ON recurring_giveaways
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
+
+ALTER TABLE giveaways ADD COLUMN recurring_giveaway_id BIGINT REFERENCES recurring_giveaways(id) ON DELETE SET NULL;
+-- The occurrence the giveaway was created for, opens_at can still be moved by hand
+ALTER TABLE giveaways ADD COLUMN scheduled_for TIMESTAMP WITH TIME ZONE;
+-- Draw every prize when the giveaway closes at its scheduled time
+ALTER TABLE giveaways ADD COLUMN auto_draw BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- One giveaway per occurrence, so restarts and replicas can't create it twice
+CREATE UNIQUE INDEX idx_giveaways_recurring_occurrence ON giveaways (recurring_giveaway_id, scheduled_for) WHERE recurring_giveaway_id IS NOT NULL;
+-- The scheduled giveaways still waiting to be opened or closed
+CREATE INDEX idx_giveaways_scheduled_closes_at ON giveaways (closes_at) WHERE scheduled_for IS NOT NULL AND status IN ('draft', 'open');
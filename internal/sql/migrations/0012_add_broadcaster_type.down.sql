@@ -0,0 +1 @@
+ALTER TABLE streamers DROP COLUMN broadcaster_type;
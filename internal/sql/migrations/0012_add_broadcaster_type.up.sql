@@ -0,0 +1,2 @@
+-- affiliate, partner or empty for channels without channel points, NULL until the streamer signs in again
+ALTER TABLE streamers ADD COLUMN broadcaster_type TEXT;
@@ -0,0 +1 @@
+ALTER TABLE giveaways DROP COLUMN auto_draw_pending;
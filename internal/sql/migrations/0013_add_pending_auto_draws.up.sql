@@ -0,0 +1,3 @@
+-- Set when a scheduled giveaway with auto draw closes and cleared once its prizes are drawn,
+-- so a draw interrupted by a crash or an error is retried on the next run
+ALTER TABLE giveaways ADD COLUMN auto_draw_pending BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+ALTER TABLE prizes DROP COLUMN opted_out_winners;
@@ -0,0 +1,3 @@
+-- Winners of a prize who opted out are deleted with everything else about them,
+-- the prize still went to them so it keeps counting them as awarded
+ALTER TABLE prizes ADD COLUMN opted_out_winners INTEGER NOT NULL DEFAULT 0;
@@ -1,6 +1,6 @@
 -- name: CreateStreamer :one
-INSERT INTO streamers (twitch_id, username, verified, access_token, refresh_token, profile_image_url, is_live)
-VALUES ($1, $2, $3, $4, $5, $6, $7)
+INSERT INTO streamers (twitch_id, username, verified, access_token, refresh_token, profile_image_url, is_live, broadcaster_type)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 RETURNING *;
 
 -- name: CreateViewer :one
@@ -28,20 +28,38 @@ WHERE twitch_id = $1
 RETURNING *;
 
 -- name: CreateReward :one
-INSERT INTO rewards (reward_id, streamer_id)
-VALUES ($1, $2)
+INSERT INTO rewards (reward_id, streamer_id, giveaway_id)
+VALUES ($1, $2, $3)
 RETURNING *;
 
 -- name: GetRewardsByStreamer :many
 SELECT * FROM rewards WHERE streamer_id = $1 ORDER BY created_at DESC;
 
--- name: DeleteRewardsByStreamerID :exec
-DELETE FROM rewards
-WHERE streamer_id = $1;
+-- name: GetActiveRewardByStreamer :one
+SELECT * FROM rewards WHERE streamer_id = $1 AND status = 'active';
+
+-- name: RetireReward :one
+-- Links the reward to the giveaway open now, if there is one, so its redemptions keep counting there.
+UPDATE rewards
+SET status = 'retired', retired_at = NOW(), giveaway_id = COALESCE(sqlc.narg(giveaway_id), giveaway_id)
+WHERE reward_id = sqlc.arg(reward_id)
+RETURNING *;
+
+-- name: GetEntryRewardsByStreamer :many
+-- Rewards whose redemptions are entries in the giveaway: the active one and the ones retired while it was open.
+SELECT * FROM rewards
+WHERE streamer_id = $1 AND (status = 'active' OR giveaway_id = sqlc.arg(giveaway_id))
+ORDER BY created_at DESC;
+
+-- name: IsEntryReward :one
+SELECT EXISTS(
+    SELECT 1 FROM rewards
+    WHERE reward_id = $1 AND streamer_id = $2 AND (status = 'active' OR giveaway_id = sqlc.arg(giveaway_id))
+);
 
 -- name: CreateRedemption :one
-INSERT INTO redemptions (message_id, streamer_id, viewer_id)
-VALUES ($1, $2, $3)
+INSERT INTO redemptions (message_id, streamer_id, viewer_id, entry_method, giveaway_id)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
 -- name: GetViewerLeaderboard :many
@@ -87,4 +105,377 @@ UPDATE streamers
 SET is_live = $1
 WHERE twitch_id = $2;
 
+-- name: SetStreamerBroadcasterType :exec
+UPDATE streamers
+SET broadcaster_type = $2
+WHERE twitch_id = $1;
+
+-- name: SetStreamerRewardLiveOnly :one
+UPDATE streamers
+SET reward_live_only = $2
+WHERE twitch_id = $1
+RETURNING *;
+
+
+-- name: GetChatCommandByStreamer :one
+SELECT * FROM chat_commands WHERE streamer_id = $1;
+
+-- name: UpsertChatCommand :one
+INSERT INTO chat_commands (streamer_id, command, enabled, cooldown_seconds, max_entries_per_viewer)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (streamer_id) DO UPDATE
+SET command = EXCLUDED.command,
+    enabled = EXCLUDED.enabled,
+    cooldown_seconds = EXCLUDED.cooldown_seconds,
+    max_entries_per_viewer = EXCLUDED.max_entries_per_viewer
+RETURNING *;
+
+-- name: GetViewerEntryStats :one
+SELECT
+    COUNT(*) AS total_entries,
+    COALESCE(MAX(redeemed_at), 'epoch')::timestamptz AS last_entry_at
+FROM
+    redemptions
+WHERE
+    streamer_id = $1 AND viewer_id = $2 AND entry_method = $3 AND giveaway_id = $4;
+
+-- name: GetViewerEntryCounts :many
+SELECT
+    v.twitch_id AS viewer_id,
+    v.username,
+    COUNT(r.*) AS entries
+FROM
+    redemptions r
+JOIN
+    viewers v ON r.viewer_id = v.twitch_id
+WHERE
+    r.giveaway_id = $1
+GROUP BY
+    v.twitch_id, v.username;
+
+-- name: GetParticipatingStreamers :many
+SELECT * FROM streamers
+WHERE twitch_id IN (SELECT DISTINCT streamer_id FROM redemptions WHERE giveaway_id = $1);
+
+-- name: CreateDraw :one
+INSERT INTO draws (giveaway_id, prize, drawn_by, total_entries)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: CreateWinner :one
+INSERT INTO winners (draw_id, viewer_id, entries, prize_id, status, redrawn_from, claim_deadline)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- name: CreateAnnouncement :one
+INSERT INTO announcements (winner_id, sender_id, kind, message, sent, error)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: CreateGiveaway :one
+INSERT INTO giveaways (name, status, created_by, opens_at, closes_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetGiveawayByID :one
+SELECT * FROM giveaways WHERE id = $1;
+
+-- name: GetGiveaways :many
+SELECT * FROM giveaways ORDER BY created_at DESC;
+
+-- name: GetOpenGiveaway :one
+SELECT * FROM giveaways WHERE status = 'open';
+
+-- name: LockOpenGiveaway :one
+-- Returns the open giveaway if it takes entries now, inside its schedule, and keeps it from being closed until the transaction ends.
+SELECT * FROM giveaways
+WHERE status = 'open'
+  AND (opens_at IS NULL OR opens_at <= NOW())
+  AND (closes_at IS NULL OR closes_at > NOW())
+FOR SHARE;
+
+-- name: SetGiveawayStatus :one
+UPDATE giveaways
+SET status = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: SetGiveawaySchedule :one
+UPDATE giveaways
+SET opens_at = $2, closes_at = $3
+WHERE id = $1
+RETURNING *;
+
+-- name: CreateRecurringGiveaway :one
+INSERT INTO recurring_giveaways (name, rrule, timezone, starts_at, duration_seconds, prize_name, prize_quantity, auto_draw, created_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING *;
+
+-- name: GetRecurringGiveaways :many
+SELECT * FROM recurring_giveaways ORDER BY created_at DESC;
+
+-- name: GetEnabledRecurringGiveaways :many
+SELECT * FROM recurring_giveaways WHERE enabled = TRUE ORDER BY id;
+
+-- name: SetRecurringGiveawayEnabled :one
+UPDATE recurring_giveaways
+SET enabled = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteRecurringGiveaway :execrows
+DELETE FROM recurring_giveaways WHERE id = $1;
+
+-- name: CreateScheduledGiveaway :one
+-- Returns no row when the occurrence already has its giveaway.
+INSERT INTO giveaways (name, status, created_by, opens_at, closes_at, recurring_giveaway_id, scheduled_for, auto_draw)
+VALUES ($1, 'draft', $2, $3, $4, $5, $6, $7)
+ON CONFLICT (recurring_giveaway_id, scheduled_for) WHERE recurring_giveaway_id IS NOT NULL DO NOTHING
+RETURNING *;
+
+-- name: GetScheduledGiveawaysToOpen :many
+SELECT * FROM giveaways
+WHERE scheduled_for IS NOT NULL AND status = 'draft' AND opens_at <= $1 AND closes_at > $1
+ORDER BY opens_at;
+
+-- name: GetScheduledGiveawaysToClose :many
+-- Draft ones too, when their time passed before they could be opened.
+SELECT * FROM giveaways
+WHERE scheduled_for IS NOT NULL AND status IN ('draft', 'open') AND closes_at <= $1
+ORDER BY closes_at;
+
+-- name: CloseScheduledGiveaway :one
+-- Closes a scheduled giveaway, its auto draw is marked pending in the same statement so a crash can't lose it.
+-- Drafts never took entries and aren't drawn.
+UPDATE giveaways
+SET status = 'closed', auto_draw_pending = (auto_draw AND status = 'open')
+WHERE id = $1 AND status IN ('draft', 'open')
+RETURNING *;
+
+-- name: GetGiveawaysPendingAutoDraw :many
+SELECT * FROM giveaways WHERE auto_draw_pending ORDER BY closes_at;
+
+-- name: FinishAutoDraw :exec
+UPDATE giveaways SET auto_draw_pending = FALSE WHERE id = $1;
+
+-- name: CreatePrize :one
+INSERT INTO prizes (giveaway_id, name, quantity, tier)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetPrizeByID :one
+SELECT * FROM prizes WHERE id = $1;
+
+-- name: LockPrize :one
+-- Returns the prize and keeps other draws of it waiting until the transaction ends.
+SELECT * FROM prizes WHERE id = $1 FOR UPDATE;
+
+-- name: GetPrizesByGiveaway :many
+SELECT * FROM prizes WHERE giveaway_id = $1 ORDER BY tier, id;
+
+-- name: DeletePrize :exec
+DELETE FROM prizes
+WHERE id = $1 AND giveaway_id = $2;
+
+-- name: CountActiveWinnersByPrize :one
+-- Counts how much of the prize is awarded, including the winners who opted out since.
+SELECT (
+    SELECT COUNT(*) FROM winners
+    WHERE prize_id = $1 AND status IN ('pending_claim', 'claimed')
+) + COALESCE((SELECT opted_out_winners FROM prizes WHERE id = $1), 0) AS active_winners;
+
+-- name: GetWinnerViewerIDsByGiveaway :many
+SELECT DISTINCT w.viewer_id
+FROM winners w
+JOIN draws d ON w.draw_id = d.id
+WHERE d.giveaway_id = $1;
+
+-- name: GetWinnerByID :one
+SELECT * FROM winners WHERE id = $1;
+
+-- name: SetWinnerStatus :one
+-- Only moves a winner who still has the expected status, returns no row when someone else changed it first.
+UPDATE winners
+SET status = sqlc.arg(status)
+WHERE id = sqlc.arg(id) AND status = sqlc.arg(expected_status)
+RETURNING *;
+
+-- name: GetWinnersByGiveaway :many
+SELECT
+    w.id,
+    w.draw_id,
+    w.viewer_id,
+    v.username AS viewer_username,
+    w.entries,
+    w.prize_id,
+    p.name AS prize_name,
+    p.tier AS prize_tier,
+    w.status,
+    w.redrawn_from,
+    w.claim_deadline,
+    w.claimed_at,
+    w.created_at
+FROM
+    winners w
+JOIN
+    draws d ON w.draw_id = d.id
+JOIN
+    viewers v ON w.viewer_id = v.twitch_id
+LEFT JOIN
+    prizes p ON w.prize_id = p.id
+WHERE
+    d.giveaway_id = $1
+ORDER BY
+    w.created_at, w.id;
+
+-- name: GetWinnersByViewer :many
+SELECT
+    w.id,
+    d.giveaway_id,
+    g.name AS giveaway_name,
+    p.name AS prize_name,
+    w.status,
+    w.claim_deadline,
+    w.claimed_at,
+    w.created_at
+FROM
+    winners w
+JOIN
+    draws d ON w.draw_id = d.id
+JOIN
+    giveaways g ON d.giveaway_id = g.id
+LEFT JOIN
+    prizes p ON w.prize_id = p.id
+WHERE
+    w.viewer_id = $1
+ORDER BY
+    w.created_at DESC;
+
+-- name: ClaimWinner :one
+-- Returns no row once the winner was forfeited, claimed or redrawn, or when the deadline passed.
+UPDATE winners
+SET status = 'claimed',
+    claim_details = $2,
+    claimed_at = NOW()
+WHERE id = $1 AND status = 'pending_claim' AND (claim_deadline IS NULL OR claim_deadline > NOW())
+RETURNING *;
+
+-- name: GetExpiredClaims :many
+SELECT w.*, d.giveaway_id
+FROM winners w
+JOIN draws d ON w.draw_id = d.id
+WHERE w.status = 'pending_claim' AND w.claim_deadline < NOW()
+ORDER BY w.claim_deadline;
+
+-- name: GetViewerByUsername :one
+SELECT * FROM viewers WHERE username = $1;
+
+-- name: GetViewerEntriesByStreamer :many
+SELECT
+    s.twitch_id AS streamer_id,
+    s.username AS streamer_username,
+    COUNT(r.*) AS entries
+FROM
+    redemptions r
+JOIN
+    streamers s ON r.streamer_id = s.twitch_id
+WHERE
+    r.viewer_id = $1 AND r.giveaway_id = $2
+GROUP BY
+    s.twitch_id, s.username
+ORDER BY
+    entries DESC;
+
+-- name: GetGiveawayEntryTotals :one
+SELECT
+    COUNT(*) AS total_entries,
+    COUNT(DISTINCT viewer_id) AS total_participants
+FROM
+    redemptions
+WHERE
+    giveaway_id = $1;
+
+-- name: GetOpenGiveawayEntryTotals :many
+SELECT
+    g.id AS giveaway_id,
+    g.name,
+    COUNT(r.message_id) AS total_entries,
+    COUNT(DISTINCT r.viewer_id) AS total_participants
+FROM
+    giveaways g
+    LEFT JOIN redemptions r ON r.giveaway_id = g.id
+WHERE
+    g.status = 'open'
+GROUP BY
+    g.id, g.name;
+
+-- name: GetViewerEntryHistory :many
+SELECT
+    r.redeemed_at,
+    r.entry_method,
+    r.giveaway_id,
+    g.name AS giveaway_name,
+    s.username AS streamer_username
+FROM
+    redemptions r
+JOIN
+    giveaways g ON r.giveaway_id = g.id
+JOIN
+    streamers s ON r.streamer_id = s.twitch_id
+WHERE
+    r.viewer_id = $1
+ORDER BY
+    r.redeemed_at DESC
+LIMIT $2;
+
+-- name: IsViewerOptedOut :one
+SELECT EXISTS(SELECT 1 FROM viewer_opt_outs WHERE twitch_id = $1);
+
+-- name: OptOutViewer :exec
+-- Deletes everything stored about a viewer in one statement so a failure can't leave half of it behind.
+-- Redraws of the viewer's wins are kept, only the link back to the deleted win is cleared.
+-- The prizes the viewer won keep counting them as awarded.
+WITH opted_out AS (
+    INSERT INTO viewer_opt_outs (twitch_id) VALUES ($1)
+    ON CONFLICT (twitch_id) DO NOTHING
+), counted_wins AS (
+    UPDATE prizes p SET opted_out_winners = p.opted_out_winners + won.awarded
+    FROM (
+        SELECT prize_id, COUNT(*) AS awarded FROM winners
+        WHERE viewer_id = $1 AND prize_id IS NOT NULL AND status IN ('pending_claim', 'claimed')
+        GROUP BY prize_id
+    ) won
+    WHERE p.id = won.prize_id
+), unlinked_redraws AS (
+    UPDATE winners SET redrawn_from = NULL
+    WHERE redrawn_from IN (SELECT id FROM winners WHERE viewer_id = $1)
+), deleted_winners AS (
+    DELETE FROM winners WHERE viewer_id = $1
+), deleted_redemptions AS (
+    DELETE FROM redemptions WHERE viewer_id = $1
+)
+DELETE FROM viewers WHERE twitch_id = $1;
+
+-- name: GetStreamerByUsername :one
+SELECT * FROM streamers WHERE username = $1;
+
+-- name: RedemptionExists :one
+SELECT EXISTS(SELECT 1 FROM redemptions WHERE message_id = $1);
+
+-- name: ImportRedemption :execrows
+INSERT INTO redemptions (message_id, streamer_id, viewer_id, entry_method, giveaway_id, redeemed_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (message_id) DO NOTHING;
+
+-- name: UpsertViewer :exec
+-- Keeps the viewer's login up to date. Their row stays locked until the transaction ends,
+-- so concurrent entries of the same viewer are added one after the other.
+INSERT INTO viewers (twitch_id, username, registered_in)
+VALUES ($1, $2, $3)
+ON CONFLICT (twitch_id) DO UPDATE SET username = EXCLUDED.username;
 
+-- name: AddRedemption :execrows
+-- Adds an entry unless it was added before, redeemed_at defaults to now.
+INSERT INTO redemptions (message_id, streamer_id, viewer_id, entry_method, giveaway_id, redeemed_at)
+VALUES ($1, $2, $3, $4, $5, COALESCE(sqlc.narg(redeemed_at)::timestamptz, NOW()))
+ON CONFLICT (message_id) DO NOTHING;
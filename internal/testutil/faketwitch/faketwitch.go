@@ -0,0 +1,1386 @@
+// Package faketwitch runs a fake Twitch for tests. It answers the OAuth endpoints, the Helix endpoints
+// the app calls for users, streams and channel point rewards, and manages EventSub subscriptions and conduits.
+// Webhook notifications are signed like Twitch signs them and delivered to the subscription's callback,
+// WebSocket ones are sent on the subscription's session of the EventSub WebSocket server at /ws.
+// Notifications of conduit subscriptions go to the conduit's shards in turn.
+package faketwitch
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/gamis65/twitch-points/internal/config"
+)
+
+const (
+	ClientID     = "fake-client-id"
+	ClientSecret = "fake-client-secret"
+)
+
+type User struct {
+	ID              string
+	Login           string
+	DisplayName     string
+	BroadcasterType string // "", "affiliate" or "partner", channel points need one of the last two
+	ProfileImageURL string
+	Live            bool
+}
+
+type Reward struct {
+	ID            string `json:"id"`
+	BroadcasterID string `json:"broadcaster_id"`
+	Title         string `json:"title"`
+	Cost          int    `json:"cost"`
+	IsEnabled     bool   `json:"is_enabled"`
+	IsPaused      bool   `json:"is_paused"`
+}
+
+type ChatMessage struct {
+	BroadcasterID string `json:"broadcaster_id"`
+	SenderID      string `json:"sender_id"`
+	Message       string `json:"message"`
+}
+
+type Subscription struct {
+	ID        string            `json:"id"`
+	Status    string            `json:"status"` // webhook_callback_verification_pending until the callback answered the challenge
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+	Transport struct {
+		Method    string `json:"method"`
+		Callback  string `json:"callback,omitempty"`
+		SessionID string `json:"session_id,omitempty"`
+		ConduitID string `json:"conduit_id,omitempty"`
+	} `json:"transport"`
+	CreatedAt time.Time `json:"created_at"`
+
+	secret string
+}
+
+type Conduit struct {
+	ID     string  `json:"id"`
+	Shards []Shard `json:"-"`
+
+	next int // shard the next notification goes to
+}
+
+type Shard struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"` // enabled, webhook_callback_verification_pending or websocket_disconnected once assigned
+	Transport struct {
+		Method    string `json:"method"`
+		Callback  string `json:"callback,omitempty"`
+		SessionID string `json:"session_id,omitempty"`
+	} `json:"transport"`
+
+	secret string
+}
+
+type Server struct {
+	URL string
+
+	server *httptest.Server
+
+	mu            sync.Mutex
+	nextID        int
+	users         map[string]*User  // by ID
+	codes         map[string]string // authorization code -> user ID
+	tokens        map[string]string // access token -> user ID, empty for app tokens
+	refreshTokens map[string]string // refresh token -> user ID
+	rewards       []Reward
+	subscriptions []*Subscription
+	conduits      []*Conduit
+	sessions      map[string]*session // WebSocket sessions by ID
+	keepalive     time.Duration       // keepalive timeout of new sessions
+	chat          []ChatMessage
+	chatHeld      chan struct{} // chat messages wait until it's closed, nil when they're sent right away
+}
+
+// session is a connected EventSub WebSocket session
+type session struct {
+	id     string
+	conn   *websocket.Conn // moves to the new connection on a reconnect
+	silent bool            // stopped sending keepalives
+}
+
+// New starts a fake Twitch that is closed when the test ends
+func New(t testing.TB) *Server {
+	s := &Server{
+		users:         make(map[string]*User),
+		codes:         make(map[string]string),
+		tokens:        make(map[string]string),
+		refreshTokens: make(map[string]string),
+		sessions:      make(map[string]*session),
+		keepalive:     10 * time.Second,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth2/token", s.tokenHandler)
+	mux.HandleFunc("GET /oauth2/validate", s.validateHandler)
+	mux.HandleFunc("GET /helix/users", s.usersHandler)
+	mux.HandleFunc("GET /helix/streams", s.streamsHandler)
+	mux.HandleFunc("POST /helix/channel_points/custom_rewards", s.createRewardHandler)
+	mux.HandleFunc("GET /helix/channel_points/custom_rewards", s.getRewardsHandler)
+	mux.HandleFunc("PATCH /helix/channel_points/custom_rewards", s.updateRewardHandler)
+	mux.HandleFunc("DELETE /helix/channel_points/custom_rewards", s.deleteRewardHandler)
+	mux.HandleFunc("GET /helix/channel_points/custom_rewards/redemptions", s.redemptionsHandler)
+	mux.HandleFunc("POST /helix/chat/messages", s.chatHandler)
+	mux.HandleFunc("POST /helix/eventsub/subscriptions", s.createSubscriptionHandler)
+	mux.HandleFunc("GET /helix/eventsub/subscriptions", s.getSubscriptionsHandler)
+	mux.HandleFunc("DELETE /helix/eventsub/subscriptions", s.deleteSubscriptionHandler)
+	mux.HandleFunc("GET /helix/eventsub/conduits", s.getConduitsHandler)
+	mux.HandleFunc("POST /helix/eventsub/conduits", s.saveConduitHandler)
+	mux.HandleFunc("PATCH /helix/eventsub/conduits", s.saveConduitHandler)
+	mux.HandleFunc("PATCH /helix/eventsub/conduits/shards", s.updateShardsHandler)
+	mux.HandleFunc("GET /ws", s.webSocketHandler)
+
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+	t.Cleanup(func() {
+		s.closeSessions()
+		s.server.Close()
+	})
+
+	return s
+}
+
+// Config returns the Twitch settings of an app using the fake, EventSub notifications go to webhookURL.
+// Set EventSubTransport to config.TransportWebSocket to receive them over the fake's WebSocket server instead.
+func (s *Server) Config(webhookURL string) config.Twitch {
+	return config.Twitch{
+		ClientID:          ClientID,
+		ClientSecret:      ClientSecret,
+		EventSubTransport: config.TransportWebhook,
+		WebhookSecret:     "fake-webhook-secret",
+		WebhookURL:        webhookURL,
+		WebSocketURL:      s.webSocketURL(),
+		APIURL:            s.URL + "/helix",
+		AuthURL:           s.URL + "/oauth2",
+		APITimeout:        5 * time.Second,
+	}
+}
+
+// AddUser makes a Twitch account that can sign in
+func (s *Server) AddUser(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.ID] = &user
+}
+
+// SetLive changes whether the user is streaming
+func (s *Server) SetLive(userID string, live bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.Live = live
+	}
+}
+
+// Code returns an authorization code for the user, as if they approved the app on Twitch
+func (s *Server) Code(userID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code := s.newID("code")
+	s.codes[code] = userID
+	return code
+}
+
+// Rewards returns the channel point rewards on the broadcaster's channel, deleted ones are gone
+func (s *Server) Rewards(broadcasterID string) []Reward {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rewards []Reward
+	for _, reward := range s.rewards {
+		if reward.BroadcasterID == broadcasterID {
+			rewards = append(rewards, reward)
+		}
+	}
+	return rewards
+}
+
+// PauseReward pauses or resumes a reward like the streamer does by hand on their dashboard
+func (s *Server) PauseReward(rewardID string, paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.rewards {
+		if s.rewards[i].ID == rewardID {
+			s.rewards[i].IsPaused = paused
+		}
+	}
+}
+
+// ChatMessages returns the messages sent to the broadcaster's chat so far
+func (s *Server) ChatMessages(broadcasterID string) []ChatMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []ChatMessage
+	for _, message := range s.chat {
+		if message.BroadcasterID == broadcasterID {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// HoldChat makes chat messages wait until release is called, like a slow Twitch would
+func (s *Server) HoldChat() (release func()) {
+	held := make(chan struct{})
+
+	s.mu.Lock()
+	s.chatHeld = held
+	s.mu.Unlock()
+
+	return sync.OnceFunc(func() {
+		s.mu.Lock()
+		s.chatHeld = nil
+		s.mu.Unlock()
+		close(held)
+	})
+}
+
+// Subscriptions returns the EventSub subscriptions created so far
+func (s *Server) Subscriptions() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriptions := make([]Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subscriptions = append(subscriptions, *sub)
+	}
+	return subscriptions
+}
+
+// AddWebhookSubscription makes an enabled webhook subscription to the event on the broadcaster's channel,
+// like one an earlier deployment left behind
+func (s *Server) AddWebhookSubscription(event, broadcasterID, callback, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub := &Subscription{
+		ID:        s.newID("subscription"),
+		Status:    "enabled",
+		Type:      event,
+		Version:   "1",
+		Condition: map[string]string{"broadcaster_user_id": broadcasterID},
+		CreatedAt: time.Now(),
+		secret:    secret,
+	}
+	sub.Transport.Method = "webhook"
+	sub.Transport.Callback = callback
+	s.subscriptions = append(s.subscriptions, sub)
+}
+
+// Conduits returns the app's conduits with their shards
+func (s *Server) Conduits() []Conduit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conduits := make([]Conduit, 0, len(s.conduits))
+	for _, conduit := range s.conduits {
+		found := *conduit
+		found.Shards = slices.Clone(conduit.Shards)
+		conduits = append(conduits, found)
+	}
+	return conduits
+}
+
+// SetKeepaliveTimeout changes the keepalive timeout of the WebSocket sessions started from now on, 10 seconds by default
+func (s *Server) SetKeepaliveTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keepalive = timeout
+}
+
+// Sessions returns the IDs of the connected WebSocket sessions
+func (s *Server) Sessions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(s.sessions))
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Silence stops the keepalives of the connected WebSocket sessions, like a connection that died without closing
+func (s *Server) Silence() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sess := range s.sessions {
+		sess.silent = true
+	}
+}
+
+// Reconnect asks every WebSocket session to move to a new connection, like Twitch does before maintenance.
+// It returns once the sessions moved, their subscriptions come along.
+func (s *Server) Reconnect() error {
+	s.mu.Lock()
+	moving := make(map[*session]*websocket.Conn)
+	for _, sess := range s.sessions {
+		moving[sess] = sess.conn
+	}
+	s.mu.Unlock()
+
+	for sess, conn := range moving {
+		message, err := s.message("session_reconnect", "", map[string]any{
+			"session": map[string]any{
+				"id":                        sess.id,
+				"status":                    "reconnecting",
+				"keepalive_timeout_seconds": nil,
+				"reconnect_url":             s.webSocketURL() + "?reconnect=" + sess.id,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if err := write(conn, message); err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sess, conn := range moving {
+		for {
+			s.mu.Lock()
+			moved := sess.conn != conn
+			s.mu.Unlock()
+			if moved {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("session %s didn't reconnect", sess.id)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// Deliver sends a notification for the event to the subscription of its type on the event's broadcaster.
+// Webhook notifications are signed with the subscription's secret, and Deliver returns the status the callback
+// answered with. WebSocket ones are sent on the subscription's session, and Deliver returns 204 once they're sent.
+// Conduit subscriptions deliver through the next shard of the conduit, which has to be enabled.
+// Sending the same message ID twice is how Twitch retries a notification.
+func (s *Server) Deliver(messageID string, subscriptionType string, event any) (int, error) {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+
+	var broadcaster struct {
+		BroadcasterUserID string `json:"broadcaster_user_id"`
+	}
+	json.Unmarshal(eventJSON, &broadcaster)
+
+	s.mu.Lock()
+	var sub *Subscription
+	for _, candidate := range s.subscriptions {
+		if candidate.Type == subscriptionType && active(candidate) &&
+			candidate.Condition["broadcaster_user_id"] == broadcaster.BroadcasterUserID {
+			found := *candidate
+			sub = &found
+			break
+		}
+	}
+
+	var method, callback, secret, sessionID string
+	if sub != nil {
+		method, callback, secret, sessionID = sub.Transport.Method, sub.Transport.Callback, sub.secret, sub.Transport.SessionID
+		if method == "conduit" {
+			method, callback, secret, sessionID, err = s.nextShard(sub.Transport.ConduitID)
+		}
+	}
+	s.mu.Unlock()
+
+	if sub == nil {
+		return 0, fmt.Errorf("no %s subscription for broadcaster %q", subscriptionType, broadcaster.BroadcasterUserID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if method == "websocket" {
+		return s.deliverWebSocket(sessionID, sub, messageID, eventJSON)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"subscription": sub,
+		"event":        json.RawMessage(eventJSON),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := s.send(callback, secret, sub, messageID, "notification", body)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+// nextShard returns the transport of the conduit's shard whose turn it is, s.mu has to be held
+func (s *Server) nextShard(conduitID string) (method, callback, secret, sessionID string, err error) {
+	i := s.conduitIndex(conduitID)
+	if i < 0 {
+		return "", "", "", "", fmt.Errorf("conduit %s is gone", conduitID)
+	}
+
+	conduit := s.conduits[i]
+	shard := conduit.Shards[conduit.next%len(conduit.Shards)]
+	conduit.next++
+	if shard.Status != "enabled" {
+		return "", "", "", "", fmt.Errorf("shard %s of conduit %s is %q", shard.ID, conduitID, shard.Status)
+	}
+	return shard.Transport.Method, shard.Transport.Callback, shard.secret, shard.Transport.SessionID, nil
+}
+
+// deliverWebSocket sends a notification of the subscription on the session
+func (s *Server) deliverWebSocket(sessionID string, sub *Subscription, messageID string, eventJSON []byte) (int, error) {
+	s.mu.Lock()
+	sess, ok := s.sessions[sessionID]
+	var conn *websocket.Conn
+	if ok {
+		conn = sess.conn
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return 0, fmt.Errorf("session %s of the %s subscription is gone", sessionID, sub.Type)
+	}
+
+	message, err := s.message("notification", messageID, map[string]any{
+		"subscription": sub,
+		"event":        json.RawMessage(eventJSON),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := write(conn, message); err != nil {
+		return 0, err
+	}
+
+	return http.StatusNoContent, nil
+}
+
+// send posts an EventSub message about the subscription to the callback, signed with the secret
+func (s *Server) send(callback, secret string, sub *Subscription, messageID, messageType string, body []byte) (*http.Response, error) {
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(messageID + timestamp))
+	mac.Write(body)
+
+	req, err := http.NewRequest(http.MethodPost, callback, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Twitch-Eventsub-Message-Id", messageID)
+	req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
+	req.Header.Set("Twitch-Eventsub-Message-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("Twitch-Eventsub-Message-Type", messageType)
+	req.Header.Set("Twitch-Eventsub-Subscription-Type", sub.Type)
+	req.Header.Set("Twitch-Eventsub-Subscription-Version", sub.Version)
+
+	return http.DefaultClient.Do(req)
+}
+
+// verify sends the challenge Twitch sends to new webhook subscriptions, enabling the subscription when it's echoed
+func (s *Server) verify(sub *Subscription) {
+	s.mu.Lock()
+	snapshot := *sub
+	challenge := s.newID("challenge")
+	messageID := s.newID("message")
+	s.mu.Unlock()
+
+	body, err := json.Marshal(map[string]any{
+		"challenge":    challenge,
+		"subscription": snapshot,
+	})
+	if err != nil {
+		return
+	}
+
+	verified := s.challenge(snapshot.Transport.Callback, snapshot.secret, &snapshot, challenge, messageID, body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if verified {
+		sub.Status = "enabled"
+	} else {
+		sub.Status = "webhook_callback_verification_failed"
+	}
+}
+
+// verifyShard sends the challenge Twitch sends when a shard is pointed at a callback, enabling the shard when it's echoed
+func (s *Server) verifyShard(shard *Shard) {
+	s.mu.Lock()
+	snapshot := *shard
+	challenge := s.newID("challenge")
+	messageID := s.newID("message")
+	s.mu.Unlock()
+
+	body, err := json.Marshal(map[string]any{
+		"challenge":    challenge,
+		"subscription": map[string]any{"id": snapshot.ID, "status": snapshot.Status, "transport": snapshot.Transport},
+	})
+	if err != nil {
+		return
+	}
+
+	verified := s.challenge(snapshot.Transport.Callback, snapshot.secret, &Subscription{}, challenge, messageID, body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if verified {
+		shard.Status = "enabled"
+	} else {
+		shard.Status = "webhook_callback_verification_failed"
+	}
+}
+
+// challenge sends a verification message and reports whether the callback echoed the challenge
+func (s *Server) challenge(callback, secret string, sub *Subscription, challenge, messageID string, body []byte) bool {
+	resp, err := s.send(callback, secret, sub, messageID, "webhook_callback_verification", body)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	answer, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode == http.StatusOK && string(answer) == challenge
+}
+
+// webSocketHandler starts a session, or moves one to the new connection when reconnect names it, and sends the welcome.
+// The session's subscriptions are disabled when its connection closes without moving.
+func (s *Server) webSocketHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	s.mu.Lock()
+	sess, ok := s.sessions[r.URL.Query().Get("reconnect")]
+	if !ok {
+		sess = &session{id: s.newID("session")}
+		s.sessions[sess.id] = sess
+	}
+	sess.conn = conn
+	keepalive := s.keepalive
+	s.mu.Unlock()
+
+	welcome, err := s.message("session_welcome", "", map[string]any{
+		"session": map[string]any{
+			"id":                        sess.id,
+			"status":                    "connected",
+			"keepalive_timeout_seconds": int(keepalive.Seconds()),
+			"reconnect_url":             nil,
+		},
+	})
+	if err == nil {
+		err = write(conn, welcome)
+	}
+
+	ctx := conn.CloseRead(context.Background())
+	ticker := time.NewTicker(keepalive / 2)
+	defer ticker.Stop()
+
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+			s.mu.Lock()
+			silent := sess.silent || sess.conn != conn
+			s.mu.Unlock()
+			if silent {
+				continue
+			}
+
+			var keepaliveMessage []byte
+			keepaliveMessage, err = s.message("session_keepalive", "", map[string]any{})
+			if err == nil {
+				err = write(conn, keepaliveMessage)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess.conn != conn {
+		return
+	}
+	delete(s.sessions, sess.id)
+	for _, sub := range s.subscriptions {
+		if sub.Transport.SessionID == sess.id {
+			sub.Status = "websocket_disconnected"
+		}
+	}
+	for _, conduit := range s.conduits {
+		for i := range conduit.Shards {
+			if conduit.Shards[i].Transport.SessionID == sess.id {
+				conduit.Shards[i].Status = "websocket_disconnected"
+			}
+		}
+	}
+}
+
+// closeSessions closes the connections of the WebSocket sessions
+func (s *Server) closeSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sess := range s.sessions {
+		sess.conn.CloseNow()
+	}
+}
+
+// message builds an EventSub WebSocket message, a new message ID is made up when messageID is empty
+func (s *Server) message(messageType, messageID string, payload map[string]any) ([]byte, error) {
+	metadata := map[string]any{
+		"message_id":        messageID,
+		"message_type":      messageType,
+		"message_timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	s.mu.Lock()
+	if messageID == "" {
+		metadata["message_id"] = s.newID("message")
+	}
+	s.mu.Unlock()
+
+	if sub, ok := payload["subscription"].(*Subscription); ok {
+		metadata["subscription_type"] = sub.Type
+		metadata["subscription_version"] = sub.Version
+	}
+
+	return json.Marshal(map[string]any{"metadata": metadata, "payload": payload})
+}
+
+func (s *Server) webSocketURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+}
+
+func write(conn *websocket.Conn, message []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return conn.Write(ctx, websocket.MessageText, message)
+}
+
+func (s *Server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if r.PostForm.Get("client_id") != ClientID || r.PostForm.Get("client_secret") != ClientSecret {
+		writeError(w, http.StatusForbidden, "invalid client secret")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var userID string
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+		token := s.newID("app-token")
+		s.tokens[token] = ""
+		writeJSON(w, map[string]any{"access_token": token, "expires_in": 3600, "token_type": "bearer"})
+		return
+	case "authorization_code":
+		var ok bool
+		userID, ok = s.codes[r.PostForm.Get("code")]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Invalid authorization code")
+			return
+		}
+		delete(s.codes, r.PostForm.Get("code"))
+	case "refresh_token":
+		var ok bool
+		userID, ok = s.refreshTokens[r.PostForm.Get("refresh_token")]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Invalid refresh token")
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported grant type")
+		return
+	}
+
+	accessToken := s.newID("user-token")
+	refreshToken := s.newID("refresh-token")
+	s.tokens[accessToken] = userID
+	s.refreshTokens[refreshToken] = userID
+
+	writeJSON(w, map[string]any{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    14400,
+		"scope":         []string{},
+		"token_type":    "bearer",
+	})
+}
+
+func (s *Server) validateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid access token")
+		return
+	}
+
+	answer := map[string]any{"client_id": ClientID, "scopes": []string{}, "expires_in": 3600}
+	if user := s.user(userID); user != nil {
+		answer["login"] = user.Login
+		answer["user_id"] = user.ID
+	}
+	writeJSON(w, answer)
+}
+
+func (s *Server) usersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid OAuth token")
+		return
+	}
+
+	// Without ids or logins Helix answers with the owner of the user token
+	ids := r.URL.Query()["id"]
+	logins := r.URL.Query()["login"]
+	if len(ids) == 0 && len(logins) == 0 && userID != "" {
+		ids = []string{userID}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := []map[string]any{}
+	for _, user := range s.users {
+		if slices.Contains(ids, user.ID) || slices.Contains(logins, user.Login) {
+			data = append(data, map[string]any{
+				"id":                user.ID,
+				"login":             user.Login,
+				"display_name":      user.DisplayName,
+				"broadcaster_type":  user.BroadcasterType,
+				"profile_image_url": user.ProfileImageURL,
+				"type":              "",
+			})
+		}
+	}
+	writeJSON(w, map[string]any{"data": data})
+}
+
+func (s *Server) streamsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.authenticate(r); !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid OAuth token")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := []map[string]any{}
+	for _, id := range r.URL.Query()["user_id"] {
+		if user, ok := s.users[id]; ok && user.Live {
+			data = append(data, map[string]any{
+				"id":         s.newID("stream"),
+				"user_id":    user.ID,
+				"user_login": user.Login,
+				"user_name":  user.DisplayName,
+				"type":       "live",
+			})
+		}
+	}
+	writeJSON(w, map[string]any{"data": data, "pagination": map[string]any{}})
+}
+
+func (s *Server) createRewardHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid OAuth token")
+		return
+	}
+
+	broadcasterID := r.URL.Query().Get("broadcaster_id")
+	if userID == "" || userID != broadcasterID {
+		writeError(w, http.StatusForbidden, "The ID in broadcaster_id must match the user ID found in the request's OAuth token.")
+		return
+	}
+
+	var reward Reward
+	if err := json.NewDecoder(r.Body).Decode(&reward); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user := s.users[userID]; user == nil || user.BroadcasterType == "" {
+		writeError(w, http.StatusForbidden, "The broadcaster must be a partner or affiliate.")
+		return
+	}
+
+	for _, existing := range s.rewards {
+		if existing.BroadcasterID == broadcasterID && strings.EqualFold(existing.Title, reward.Title) {
+			writeError(w, http.StatusBadRequest, "CREATE_CUSTOM_REWARD_DUPLICATE_REWARD")
+			return
+		}
+	}
+
+	reward.ID = s.newID("reward")
+	reward.BroadcasterID = broadcasterID
+	s.rewards = append(s.rewards, reward)
+
+	writeJSON(w, map[string]any{"data": []Reward{reward}})
+}
+
+// getRewardsHandler answers with the reward in the id parameter, or every reward of the channel without one
+func (s *Server) getRewardsHandler(w http.ResponseWriter, r *http.Request) {
+	broadcasterID, ok := s.rewardOwner(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.URL.Query().Get("id")
+	rewards := []Reward{}
+	for _, reward := range s.rewards {
+		if reward.BroadcasterID == broadcasterID && (id == "" || reward.ID == id) {
+			rewards = append(rewards, reward)
+		}
+	}
+
+	if id != "" && len(rewards) == 0 {
+		writeError(w, http.StatusNotFound, "Custom Reward not found")
+		return
+	}
+	writeJSON(w, map[string]any{"data": rewards})
+}
+
+// updateRewardHandler changes the title of the reward and whether it is enabled and paused, the settings the app changes
+func (s *Server) updateRewardHandler(w http.ResponseWriter, r *http.Request) {
+	broadcasterID, ok := s.rewardOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var update struct {
+		Title     *string `json:"title"`
+		IsEnabled *bool   `json:"is_enabled"`
+		IsPaused  *bool   `json:"is_paused"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.rewardIndex(broadcasterID, r.URL.Query().Get("id"))
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "Custom Reward not found")
+		return
+	}
+
+	if update.Title != nil {
+		for j, existing := range s.rewards {
+			if j != i && existing.BroadcasterID == broadcasterID && strings.EqualFold(existing.Title, *update.Title) {
+				writeError(w, http.StatusBadRequest, "CREATE_CUSTOM_REWARD_DUPLICATE_REWARD")
+				return
+			}
+		}
+		s.rewards[i].Title = *update.Title
+	}
+	if update.IsEnabled != nil {
+		s.rewards[i].IsEnabled = *update.IsEnabled
+	}
+	if update.IsPaused != nil {
+		s.rewards[i].IsPaused = *update.IsPaused
+	}
+	writeJSON(w, map[string]any{"data": []Reward{s.rewards[i]}})
+}
+
+func (s *Server) deleteRewardHandler(w http.ResponseWriter, r *http.Request) {
+	broadcasterID, ok := s.rewardOwner(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.rewardIndex(broadcasterID, r.URL.Query().Get("id"))
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "Custom Reward not found")
+		return
+	}
+
+	s.rewards = slices.Delete(s.rewards, i, i+1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rewardOwner checks the token belongs to the broadcaster whose rewards are asked for, answering the error when it doesn't
+func (s *Server) rewardOwner(w http.ResponseWriter, r *http.Request) (broadcasterID string, ok bool) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid OAuth token")
+		return "", false
+	}
+
+	broadcasterID = r.URL.Query().Get("broadcaster_id")
+	if userID == "" || userID != broadcasterID {
+		writeError(w, http.StatusForbidden, "The ID in broadcaster_id must match the user ID found in the request's OAuth token.")
+		return "", false
+	}
+	return broadcasterID, true
+}
+
+// rewardIndex finds a reward of the broadcaster, -1 when there is none. The caller holds s.mu
+func (s *Server) rewardIndex(broadcasterID, id string) int {
+	return slices.IndexFunc(s.rewards, func(reward Reward) bool {
+		return reward.ID == id && reward.BroadcasterID == broadcasterID
+	})
+}
+
+// redemptionsHandler answers that there are no unfulfilled redemptions, notifications are delivered with Deliver
+func (s *Server) redemptionsHandler(w http.ResponseWriter, r *http.Request) {
+	broadcasterID, ok := s.rewardOwner(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	found := s.rewardIndex(broadcasterID, r.URL.Query().Get("reward_id")) >= 0
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, "Custom Reward not found")
+		return
+	}
+
+	writeJSON(w, map[string]any{"data": []any{}, "pagination": map[string]any{}})
+}
+
+// chatHandler sends a message as the broadcaster to their own chat, once the chat isn't held
+func (s *Server) chatHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid OAuth token")
+		return
+	}
+
+	var message ChatMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if userID != message.SenderID {
+		writeError(w, http.StatusForbidden, "The sender must match the user ID in the access token.")
+		return
+	}
+
+	s.mu.Lock()
+	held := s.chatHeld
+	s.mu.Unlock()
+	if held != nil {
+		select {
+		case <-held:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.chat = append(s.chat, message)
+	id := s.newID("message")
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]any{"data": []map[string]any{{"message_id": id, "is_sent": true}}})
+}
+
+// createSubscriptionHandler creates webhook subscriptions with an app token, they're enabled once the callback
+// answered the challenge. WebSocket subscriptions take the token of the broadcaster and are enabled right away.
+func (s *Server) createSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid OAuth token")
+		return
+	}
+
+	var request struct {
+		Type      string            `json:"type"`
+		Version   string            `json:"version"`
+		Condition map[string]string `json:"condition"`
+		Transport struct {
+			Method    string `json:"method"`
+			Callback  string `json:"callback"`
+			Secret    string `json:"secret"`
+			SessionID string `json:"session_id"`
+			ConduitID string `json:"conduit_id"`
+		} `json:"transport"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch request.Transport.Method {
+	case "webhook", "conduit":
+		if userID != "" {
+			writeError(w, http.StatusUnauthorized, "An app access token is required")
+			return
+		}
+	case "websocket":
+		if userID == "" {
+			writeError(w, http.StatusUnauthorized, "A user access token is required")
+			return
+		}
+		if userID != request.Condition["broadcaster_user_id"] {
+			writeError(w, http.StatusForbidden, "subscription missing proper authorization")
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported transport method")
+		return
+	}
+
+	s.mu.Lock()
+	if _, ok := s.sessions[request.Transport.SessionID]; request.Transport.Method == "websocket" && !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "websocket transport session does not exist or has already disconnected")
+		return
+	}
+	if request.Transport.Method == "conduit" && s.conduitIndex(request.Transport.ConduitID) < 0 {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "conduit does not exist")
+		return
+	}
+
+	if strings.HasPrefix(request.Type, "channel.channel_points_") {
+		if user := s.users[request.Condition["broadcaster_user_id"]]; user == nil || user.BroadcasterType == "" {
+			s.mu.Unlock()
+			writeError(w, http.StatusForbidden, "The broadcaster must be a partner or affiliate.")
+			return
+		}
+	}
+
+	for _, existing := range s.subscriptions {
+		// The same subscription may exist once per transport
+		sameTransport := existing.Transport.Method == request.Transport.Method && existing.Transport.Callback == request.Transport.Callback &&
+			existing.Transport.SessionID == request.Transport.SessionID && existing.Transport.ConduitID == request.Transport.ConduitID
+		if existing.Type == request.Type && existing.Condition["broadcaster_user_id"] == request.Condition["broadcaster_user_id"] && active(existing) && sameTransport {
+			s.mu.Unlock()
+			writeError(w, http.StatusConflict, "subscription already exists")
+			return
+		}
+	}
+
+	sub := &Subscription{
+		ID:        s.newID("subscription"),
+		Status:    "webhook_callback_verification_pending",
+		Type:      request.Type,
+		Version:   request.Version,
+		Condition: request.Condition,
+		CreatedAt: time.Now(),
+		secret:    request.Transport.Secret,
+	}
+	sub.Transport.Method = request.Transport.Method
+	sub.Transport.Callback = request.Transport.Callback
+	sub.Transport.SessionID = request.Transport.SessionID
+	sub.Transport.ConduitID = request.Transport.ConduitID
+	if sub.Transport.Method != "webhook" {
+		sub.Status = "enabled"
+	}
+	s.subscriptions = append(s.subscriptions, sub)
+	answer := *sub
+	total := len(s.subscriptions)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{"data": []Subscription{answer}, "total": total})
+
+	// Twitch sends the challenge once it answered the request
+	if sub.Transport.Method == "webhook" {
+		go s.verify(sub)
+	}
+}
+
+// getSubscriptionsHandler lists every subscription of the app on one page
+func (s *Server) getSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.appAuthenticated(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := make([]Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		data = append(data, *sub)
+	}
+	writeJSON(w, map[string]any{"data": data, "total": len(data), "pagination": map[string]any{}})
+}
+
+func (s *Server) deleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.appAuthenticated(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.subscriptions, func(sub *Subscription) bool { return sub.ID == r.URL.Query().Get("id") })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "subscription not found")
+		return
+	}
+
+	s.subscriptions = slices.Delete(s.subscriptions, i, i+1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getConduitsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.appAuthenticated(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := []map[string]any{}
+	for _, conduit := range s.conduits {
+		data = append(data, conduitJSON(conduit))
+	}
+	writeJSON(w, map[string]any{"data": data})
+}
+
+// saveConduitHandler creates a conduit on POST and changes its shard count on PATCH, new shards start unassigned
+func (s *Server) saveConduitHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.appAuthenticated(w, r) {
+		return
+	}
+
+	var request struct {
+		ID         string `json:"id"`
+		ShardCount int    `json:"shard_count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if request.ShardCount < 1 || request.ShardCount > 20000 {
+		writeError(w, http.StatusBadRequest, "shard_count must be between 1 and 20000")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var conduit *Conduit
+	if r.Method == http.MethodPost {
+		if len(s.conduits) >= 5 {
+			writeError(w, http.StatusTooManyRequests, "conduit limit reached")
+			return
+		}
+		conduit = &Conduit{ID: s.newID("conduit")}
+		s.conduits = append(s.conduits, conduit)
+	} else {
+		i := s.conduitIndex(request.ID)
+		if i < 0 {
+			writeError(w, http.StatusNotFound, "conduit not found")
+			return
+		}
+		conduit = s.conduits[i]
+	}
+
+	for len(conduit.Shards) < request.ShardCount {
+		conduit.Shards = append(conduit.Shards, Shard{ID: strconv.Itoa(len(conduit.Shards)), Status: "disabled"})
+	}
+	conduit.Shards = conduit.Shards[:request.ShardCount]
+
+	writeJSON(w, map[string]any{"data": []map[string]any{conduitJSON(conduit)}})
+}
+
+// updateShardsHandler points shards at webhooks, verified like subscriptions, or at connected WebSocket sessions
+func (s *Server) updateShardsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.appAuthenticated(w, r) {
+		return
+	}
+
+	var request struct {
+		ConduitID string `json:"conduit_id"`
+		Shards    []struct {
+			ID        string `json:"id"`
+			Transport struct {
+				Method    string `json:"method"`
+				Callback  string `json:"callback"`
+				Secret    string `json:"secret"`
+				SessionID string `json:"session_id"`
+			} `json:"transport"`
+		} `json:"shards"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	i := s.conduitIndex(request.ConduitID)
+	if i < 0 {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "conduit not found")
+		return
+	}
+	conduit := s.conduits[i]
+
+	data := []Shard{}
+	errs := []map[string]any{}
+	var verify []*Shard
+	for _, update := range request.Shards {
+		index, err := strconv.Atoi(update.ID)
+		if err != nil || index < 0 || index >= len(conduit.Shards) {
+			errs = append(errs, map[string]any{"id": update.ID, "message": "shard not found", "code": ""})
+			continue
+		}
+
+		shard := &conduit.Shards[index]
+		shard.Transport.Method = update.Transport.Method
+		shard.Transport.Callback = update.Transport.Callback
+		shard.Transport.SessionID = update.Transport.SessionID
+		shard.secret = update.Transport.Secret
+
+		switch update.Transport.Method {
+		case "webhook":
+			shard.Status = "webhook_callback_verification_pending"
+			verify = append(verify, shard)
+		case "websocket":
+			if _, ok := s.sessions[update.Transport.SessionID]; !ok {
+				shard.Status = "websocket_disconnected"
+				errs = append(errs, map[string]any{"id": update.ID, "message": "The websocket session does not exist or has already disconnected", "code": "websocket_session_not_found"})
+				continue
+			}
+			shard.Status = "enabled"
+		default:
+			errs = append(errs, map[string]any{"id": update.ID, "message": "unsupported transport method", "code": ""})
+			continue
+		}
+		data = append(data, *shard)
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]any{"data": data, "errors": errs})
+
+	// Twitch sends the challenges once it answered the request
+	for _, shard := range verify {
+		go s.verifyShard(shard)
+	}
+}
+
+// appAuthenticated checks the request carries an app access token, answering the error when it doesn't
+func (s *Server) appAuthenticated(w http.ResponseWriter, r *http.Request) bool {
+	userID, ok := s.authenticate(r)
+	if !ok || userID != "" {
+		writeError(w, http.StatusUnauthorized, "An app access token is required")
+		return false
+	}
+	return true
+}
+
+// conduitIndex finds a conduit, -1 when there is none. The caller holds s.mu
+func (s *Server) conduitIndex(id string) int {
+	return slices.IndexFunc(s.conduits, func(conduit *Conduit) bool { return conduit.ID == id })
+}
+
+func conduitJSON(conduit *Conduit) map[string]any {
+	return map[string]any{"id": conduit.ID, "shard_count": len(conduit.Shards)}
+}
+
+// active reports whether the subscription delivers or will deliver notifications, s.mu has to be held
+func active(sub *Subscription) bool {
+	return sub.Status == "enabled" || sub.Status == "webhook_callback_verification_pending"
+}
+
+// authenticate returns the user owning the request's access token, empty for an app token
+func (s *Server) authenticate(r *http.Request) (userID string, ok bool) {
+	authorization := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(strings.TrimPrefix(authorization, "Bearer "), "OAuth ")
+	if token == authorization {
+		return "", false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userID, ok = s.tokens[token]
+	return userID, ok
+}
+
+func (s *Server) user(id string) *User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	found := *user
+	return &found
+}
+
+// newID returns a unique ID, s.mu has to be held
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return prefix + "-" + strconv.Itoa(s.nextID)
+}
+
+func writeJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError answers like Helix does: {"error": "Unauthorized", "status": 401, "message": "..."}
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":   http.StatusText(status),
+		"status":  status,
+		"message": message,
+	})
+}
@@ -0,0 +1,189 @@
+// Package pgtest gives tests a disposable Postgres database with the migrations applied.
+//
+// Tests use the server in TEST_DATABASE_URL when it is set, a CI service container for example.
+// Otherwise an embedded Postgres is started once per test binary, its download is cached in ~/.go-embedded-postgres.
+// The embedded Postgres can't run as root.
+// Tests are skipped when neither is available, with a warning on stderr, and with -short.
+// In CI, where the CI variable is set, a missing Postgres fails the tests instead.
+package pgtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/gamis65/twitch-points/internal/migrate"
+	"github.com/gamis65/twitch-points/internal/sql/migrations"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// EnvDatabaseURL names the variable pointing the tests at an existing server, the user has to be able to create databases
+const EnvDatabaseURL = "TEST_DATABASE_URL"
+
+var (
+	serverOnce sync.Once
+	serverURL  string
+	serverErr  error
+	warnOnce   sync.Once
+	embedded   *embeddedpostgres.EmbeddedPostgres
+	runtimeDir string // data directory of the embedded Postgres
+)
+
+// Run runs the tests of a package and stops the embedded Postgres afterwards, call it from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(pgtest.Run(m))
+//	}
+func Run(m *testing.M) int {
+	code := m.Run()
+
+	if embedded != nil {
+		if err := embedded.Stop(); err != nil {
+			fmt.Fprintln(os.Stderr, "pgtest: error stopping the embedded Postgres:", err)
+		}
+		os.RemoveAll(runtimeDir)
+	}
+	return code
+}
+
+// New creates an empty database with the migrations applied and returns a pool connected to it.
+// The database is dropped when the test ends.
+func New(t testing.TB) *pgxpool.Pool {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping the database test in short mode")
+	}
+
+	serverOnce.Do(func() {
+		serverURL, serverErr = startServer()
+	})
+	if serverErr != nil {
+		if os.Getenv("CI") != "" {
+			t.Fatalf("no Postgres to test with, set %s: %v", EnvDatabaseURL, serverErr)
+		}
+		// A skip only shows up with -v, this doesn't go unnoticed
+		warnOnce.Do(func() {
+			fmt.Fprintf(os.Stderr, "pgtest: WARNING: skipping every Postgres test, set %s: %v\n", EnvDatabaseURL, serverErr)
+		})
+		t.Skipf("no Postgres to test with, set %s: %v", EnvDatabaseURL, serverErr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	admin, err := pgx.Connect(ctx, serverURL)
+	if err != nil {
+		t.Fatalf("error connecting to the test server: %v", err)
+	}
+	defer admin.Close(ctx)
+
+	name := "test_" + randomSuffix()
+	if _, err := admin.Exec(ctx, "CREATE DATABASE "+name); err != nil {
+		t.Fatalf("error creating the test database: %v", err)
+	}
+
+	config, err := pgxpool.ParseConfig(serverURL)
+	if err != nil {
+		t.Fatalf("invalid test database URL: %v", err)
+	}
+	config.ConnConfig.Database = name
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("error connecting to the test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		pool.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		admin, err := pgx.Connect(ctx, serverURL)
+		if err != nil {
+			t.Logf("error connecting to drop the test database: %v", err)
+			return
+		}
+		defer admin.Close(ctx)
+
+		if _, err := admin.Exec(ctx, "DROP DATABASE IF EXISTS "+name+" WITH (FORCE)"); err != nil {
+			t.Logf("error dropping the test database: %v", err)
+		}
+	})
+
+	all, err := migrate.Load(migrations.FS)
+	if err != nil {
+		t.Fatalf("error loading the migrations: %v", err)
+	}
+	if _, err := migrate.New(pool, all).Up(ctx); err != nil {
+		t.Fatalf("error migrating the test database: %v", err)
+	}
+
+	return pool
+}
+
+// startServer returns the URL of the server the test databases are created on
+func startServer() (string, error) {
+	if url := os.Getenv(EnvDatabaseURL); url != "" {
+		return url, nil
+	}
+
+	// initdb refuses to run as root
+	if os.Geteuid() == 0 {
+		return "", errors.New("the embedded Postgres can't run as root")
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return "", err
+	}
+
+	runtimePath, err := os.MkdirTemp("", "pgtest")
+	if err != nil {
+		return "", err
+	}
+
+	config := embeddedpostgres.DefaultConfig().
+		Version(embeddedpostgres.V16).
+		Port(port).
+		RuntimePath(runtimePath).
+		StartTimeout(time.Minute).
+		Logger(io.Discard)
+
+	db := embeddedpostgres.NewDatabase(config)
+	if err := db.Start(); err != nil {
+		os.RemoveAll(runtimePath)
+		return "", fmt.Errorf("error starting the embedded Postgres: %w", err)
+	}
+	embedded = db
+	runtimeDir = runtimePath
+
+	return config.GetConnectionURL() + "?sslmode=disable", nil
+}
+
+func freePort() (uint32, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("error finding a free port: %w", err)
+	}
+	defer listener.Close()
+
+	return uint32(listener.Addr().(*net.TCPAddr).Port), nil
+}
+
+func randomSuffix() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
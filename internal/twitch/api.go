@@ -0,0 +1,321 @@
+package twitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/metrics"
+	"github.com/gamis65/twitch-points/internal/telemetry"
+	"github.com/nicklaw5/helix/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// ErrTokenRejected is returned when Twitch rejects a user access token, usually because it expired
+	ErrTokenRejected = errors.New("twitch rejected the access token")
+	// ErrRateLimited is returned when a token ran out of Helix points and waiting for the reset would take too long
+	ErrRateLimited = errors.New("twitch rate limit exceeded")
+)
+
+// APIError is an error answer from the Twitch API
+type APIError struct {
+	Endpoint   string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("twitch API error on %s: %d %s", e.Endpoint, e.StatusCode, e.Message)
+}
+
+// Is lets callers check the status with errors.Is(err, ErrTokenRejected) and errors.Is(err, ErrRateLimited)
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrTokenRejected:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// HelixError turns an error answer of the helix library into an APIError, nil when the request succeeded
+func HelixError(endpoint string, resp helix.ResponseCommon) error {
+	if resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+
+	message := resp.ErrorMessage
+	if message == "" {
+		message = resp.Error
+	}
+	return &APIError{Endpoint: endpoint, StatusCode: resp.StatusCode, Message: message}
+}
+
+// API is the client every request to Twitch goes through. It waits out the rate limit of each token,
+// retries rate limited and failed requests, and shows them in the metrics and traces.
+type API struct {
+	clientID     string
+	clientSecret string
+	helixURL     string
+	authURL      string
+	httpClient   *http.Client
+
+	appTokenMu      sync.Mutex
+	appToken        string
+	appTokenExpires time.Time
+	appTokenFetch   singleflight.Group // callers finding the app token expired share one request for a new one
+}
+
+func NewAPI(cfg config.Twitch) *API {
+	helixURL := strings.TrimSuffix(cfg.APIURL, "/")
+	if helixURL == "" {
+		helixURL = helix.DefaultAPIBaseURL
+	}
+	authURL := strings.TrimSuffix(cfg.AuthURL, "/")
+	if authURL == "" {
+		authURL = helix.AuthBaseURL
+	}
+
+	var transport http.RoundTripper = newRetryTransport(metrics.InstrumentTransport(http.DefaultTransport), cfg.APITimeout)
+	// The helix library always sends the OAuth requests to id.twitch.tv
+	if authURL != helix.AuthBaseURL {
+		transport = &rewriteTransport{next: transport, from: helix.AuthBaseURL, to: authURL}
+	}
+
+	return &API{
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+		helixURL:     helixURL,
+		authURL:      authURL,
+		httpClient:   &http.Client{Transport: telemetry.Transport(transport)},
+	}
+}
+
+// HTTPClient returns the client the API sends its requests with, for libraries that talk to Twitch themselves
+func (a *API) HTTPClient() *http.Client {
+	return a.httpClient
+}
+
+// UserClient creates a Helix client acting as the owner of the user access token
+func (a *API) UserClient(ctx context.Context, accessToken string) (*helix.Client, error) {
+	client, err := helix.NewClientWithContext(ctx, &helix.Options{
+		ClientID:        a.clientID,
+		UserAccessToken: accessToken,
+		HTTPClient:      a.httpClient,
+		APIBaseURL:      a.helixURL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Twitch client: %w", err)
+	}
+	return client, nil
+}
+
+// AppClient creates a Helix client authenticated with an app access token, which EventSub webhooks require.
+// The token is reused until shortly before it expires.
+func (a *API) AppClient(ctx context.Context) (*helix.Client, error) {
+	token, err := a.getAppToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := helix.NewClientWithContext(ctx, &helix.Options{
+		ClientID:       a.clientID,
+		AppAccessToken: token,
+		HTTPClient:     a.httpClient,
+		APIBaseURL:     a.helixURL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Twitch client: %w", err)
+	}
+	return client, nil
+}
+
+func (a *API) getAppToken(ctx context.Context) (string, error) {
+	a.appTokenMu.Lock()
+	token, expires := a.appToken, a.appTokenExpires
+	a.appTokenMu.Unlock()
+
+	if token != "" && time.Now().Before(expires) {
+		return token, nil
+	}
+
+	// The request isn't cancelled with the caller that happened to start it, the others still wait for it
+	fetched := a.appTokenFetch.DoChan("app-token", func() (any, error) {
+		return a.fetchAppToken(context.WithoutCancel(ctx))
+	})
+	select {
+	case result := <-fetched:
+		if result.Err != nil {
+			return "", result.Err
+		}
+		return result.Val.(string), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// fetchAppToken gets a new app access token from Twitch and keeps it until shortly before it expires
+func (a *API) fetchAppToken(ctx context.Context) (string, error) {
+	var token TwitchTokenResponse
+	err := a.postForm(ctx, "/token", url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {a.clientID},
+		"client_secret": {a.clientSecret},
+	}, &token)
+	if err != nil {
+		return "", fmt.Errorf("failed to get an app access token: %w", err)
+	}
+
+	a.appTokenMu.Lock()
+	defer a.appTokenMu.Unlock()
+
+	a.appToken = token.AccessToken
+	a.appTokenExpires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return a.appToken, nil
+}
+
+// ValidateToken asks Twitch whether the access token is still valid, Twitch wants this checked once an hour
+func (a *API) ValidateToken(ctx context.Context, accessToken string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.authURL+"/validate", nil)
+	if err != nil {
+		return false, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("error validating token: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return true, nil
+	case resp.StatusCode == http.StatusUnauthorized:
+		return false, nil
+	default:
+		return false, &APIError{Endpoint: "/validate", StatusCode: resp.StatusCode, Message: resp.Status}
+	}
+}
+
+// postForm sends a form to the OAuth API and decodes the JSON answer into out
+func (a *API) postForm(ctx context.Context, path string, form url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.authURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return a.do(req, path, out)
+}
+
+// appRequest builds a Helix request authenticated with the app access token, body is encoded as JSON when it isn't nil
+func (a *API) appRequest(ctx context.Context, method, endpoint string, query url.Values, body any) (*http.Request, error) {
+	token, err := a.getAppToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload []byte
+	if body != nil {
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding the request: %w", err)
+		}
+	}
+
+	return a.helixRequest(ctx, method, token, endpoint, query, payload)
+}
+
+// helixRequest builds a request to a Helix endpoint with the access token and the JSON body, if any
+func (a *API) helixRequest(ctx context.Context, method, accessToken, endpoint string, query url.Values, body []byte) (*http.Request, error) {
+	target := a.helixURL + endpoint
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Client-Id", a.clientID)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do sends a request and decodes the JSON answer into out, error answers become an APIError. The answer is ignored when out is nil
+func (a *API) do(req *http.Request, endpoint string, out any) error {
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var answer struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &answer) != nil || answer.Message == "" {
+			answer.Message = string(body)
+		}
+		return &APIError{Endpoint: endpoint, StatusCode: resp.StatusCode, Message: answer.Message}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
+
+// rewriteTransport sends the requests for one base URL to another
+type rewriteTransport struct {
+	next     http.RoundTripper
+	from, to string
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := req.URL.String()
+	if !strings.HasPrefix(target, t.from) {
+		return t.next.RoundTrip(req)
+	}
+
+	rewritten, err := url.Parse(t.to + strings.TrimPrefix(target, t.from))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.URL = rewritten
+	req.Host = rewritten.Host
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,71 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/config"
+)
+
+func TestAppTokenFetchedOnce(t *testing.T) {
+	release := make(chan struct{})
+	var requested atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"app-token","expires_in":3600,"token_type":"bearer"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	api := NewAPI(config.Twitch{ClientID: "client-id", ClientSecret: "client-secret", AuthURL: server.URL, APIURL: server.URL})
+
+	// A caller that gives up doesn't take the request of the others down with it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := api.getAppToken(ctx)
+		cancelled <- err
+	}()
+	for requested.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v for the cancelled caller, want context.Canceled", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := api.getAppToken(context.Background())
+			if err != nil || token != "app-token" {
+				t.Errorf("got %q, %v, want the app token", token, err)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := requested.Load(); got != 1 {
+		t.Errorf("asked Twitch %d times for an app token, want once", got)
+	}
+
+	// The token is reused until it expires
+	if token, err := api.getAppToken(context.Background()); err != nil || token != "app-token" {
+		t.Errorf("got %q, %v, want the cached token", token, err)
+	}
+	if got := requested.Load(); got != 1 {
+		t.Errorf("asked Twitch %d times, want the token cached", got)
+	}
+}
@@ -0,0 +1,238 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/logging"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var ErrBackfillRunning = errors.New("a backfill is already running")
+
+// Redemption is a channel point redemption as returned by Helix
+type Redemption struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	UserLogin  string    `json:"user_login"`
+	Status     string    `json:"status"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+	Reward     struct {
+		ID string `json:"id"`
+	} `json:"reward"`
+}
+
+type redemptionsResponse struct {
+	Data       []Redemption `json:"data"`
+	Pagination struct {
+		Cursor string `json:"cursor"`
+	} `json:"pagination"`
+}
+
+// GetUnfulfilledRedemptions returns one page of a reward's redemptions that are still waiting in the request queue.
+// The helix library doesn't cover this endpoint. An empty cursor means there are no more pages.
+func (a *API) GetUnfulfilledRedemptions(ctx context.Context, accessToken, broadcasterID, rewardID, after string) ([]Redemption, string, error) {
+	query := url.Values{}
+	query.Set("broadcaster_id", broadcasterID)
+	query.Set("reward_id", rewardID)
+	query.Set("status", "UNFULFILLED")
+	query.Set("sort", "OLDEST")
+	query.Set("first", "50")
+	if after != "" {
+		query.Set("after", after)
+	}
+
+	const endpoint = "/channel_points/custom_rewards/redemptions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.helixURL+endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Client-Id", a.clientID)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var body redemptionsResponse
+	if err := a.do(req, endpoint, &body); err != nil {
+		return nil, "", fmt.Errorf("error getting redemptions: %w", err)
+	}
+
+	return body.Data, body.Pagination.Cursor, nil
+}
+
+type BackfillResult struct {
+	Streamers int `json:"streamers"`
+	Rewards   int `json:"rewards"`
+	Checked   int `json:"checked"`
+	Added     int `json:"added"`
+	Errors    int `json:"errors"`
+}
+
+// backfillLock keeps the startup and on demand backfills from running over each other, on any replica
+const backfillLock = "backfill"
+
+// Backfill looks for redemptions that never reached us, for example while the server was down and EventSub gave up.
+// Entries are never fulfilled, so every one of them is still in the reward's queue and missing IDs go through addEntry.
+// streamerID limits it to that streamer's rewards, an empty one backfills every streamer.
+func (tc *TwitchWebhookClient) Backfill(ctx context.Context, streamerID string) (*BackfillResult, error) {
+	lock, acquired, err := tc.db.TryLock(ctx, backfillLock)
+	if err != nil {
+		return nil, fmt.Errorf("error taking the backfill lock: %w", err)
+	}
+	if !acquired {
+		return nil, ErrBackfillRunning
+	}
+	defer lock.Release()
+
+	result := &BackfillResult{}
+
+	giveaway, ok := tc.getOpenGiveaway(ctx, logging.FromContext(ctx))
+	if !ok {
+		return result, nil
+	}
+
+	streamers, err := tc.db.GetAllStreamersWithTokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting streamers: %w", err)
+	}
+	if streamerID != "" {
+		streamers = slices.DeleteFunc(streamers, func(streamer db.Streamer) bool { return streamer.TwitchID != streamerID })
+	}
+
+	for _, streamer := range streamers {
+		logger := logging.FromContext(ctx).With(
+			slog.String("streamer_id", streamer.TwitchID),
+			slog.String("streamer_username", streamer.Username),
+		)
+
+		rewards, err := tc.db.GetEntryRewardsByStreamer(ctx, db.GetEntryRewardsByStreamerParams{
+			StreamerID: pgtype.Text{String: streamer.TwitchID, Valid: true},
+			GiveawayID: pgtype.Int8{Int64: giveaway.ID, Valid: true},
+		})
+		if err != nil {
+			logger.Error("Error getting rewards for backfill", "error", err)
+			result.Errors++
+			continue
+		}
+
+		if len(rewards) == 0 {
+			continue
+		}
+		result.Streamers++
+
+		for _, reward := range rewards {
+			result.Rewards++
+			errorsBefore := result.Errors
+			if err := tc.backfillReward(ctx, logger, streamer, reward.RewardID, giveaway.ID, result); err != nil {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+
+				// Retired rewards are deleted from the channel once their queue was backfilled
+				if reward.Status == RewardRetired && IsNotFound(err) {
+					continue
+				}
+
+				logger.Error("Error backfilling reward", "error", err, "reward_id", reward.RewardID)
+				result.Errors++
+				continue
+			}
+
+			if reward.Status == RewardRetired && result.Errors == errorsBefore {
+				tc.deleteRetiredReward(ctx, logger, streamer, reward.RewardID)
+			}
+		}
+	}
+
+	logging.FromContext(ctx).Info("Backfill finished",
+		"giveaway_id", giveaway.ID,
+		"streamers", result.Streamers,
+		"checked", result.Checked,
+		"added", result.Added,
+		"errors", result.Errors,
+	)
+
+	if result.Added > 0 {
+		tc.notifier.Send(ctx, fmt.Sprintf("Backfill added %d missed entries", result.Added))
+	}
+
+	return result, nil
+}
+
+// deleteRetiredReward takes a retired reward off the channel, deleting it marks what is left in its queue as fulfilled
+func (tc *TwitchWebhookClient) deleteRetiredReward(ctx context.Context, logger *slog.Logger, streamer db.Streamer, rewardID string) {
+	err := WithStreamerToken(ctx, tc.db, tc.api, streamer, func(accessToken string) error {
+		return tc.api.DeleteCustomReward(ctx, accessToken, streamer.TwitchID, rewardID)
+	})
+	if err != nil && !IsNotFound(err) {
+		logger.Error("Error deleting the retired reward", "error", err, "reward_id", rewardID)
+		return
+	}
+	logger.Info("Deleted the retired reward after backfilling its queue", "reward_id", rewardID)
+}
+
+func (tc *TwitchWebhookClient) backfillReward(ctx context.Context, logger *slog.Logger, streamer db.Streamer, rewardID string, giveawayID int64, result *BackfillResult) error {
+	cursor := ""
+
+	for {
+		var redemptions []Redemption
+		var next string
+
+		err := WithStreamerToken(ctx, tc.db, tc.api, streamer, func(accessToken string) error {
+			var err error
+			redemptions, next, err = tc.api.GetUnfulfilledRedemptions(ctx, accessToken, streamer.TwitchID, rewardID, cursor)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, redemption := range redemptions {
+			result.Checked++
+
+			exists, err := tc.db.RedemptionExists(ctx, redemption.ID)
+			if err != nil {
+				return fmt.Errorf("error checking redemption: %w", err)
+			}
+			if exists {
+				continue
+			}
+
+			entryLogger := logger.With(
+				slog.String("viewer_id", redemption.UserID),
+				slog.String("viewer_username", redemption.UserLogin),
+				slog.String("redemption_id", redemption.ID),
+			)
+			entryLogger.Info("Backfilling a missed redemption", "redeemed_at", redemption.RedeemedAt)
+
+			added, err := tc.addEntry(ctx, entryLogger, Entry{
+				ID:            redemption.ID,
+				Method:        EntryMethodChannelPoints,
+				StreamerID:    streamer.TwitchID,
+				StreamerLogin: streamer.Username,
+				ViewerID:      redemption.UserID,
+				ViewerLogin:   redemption.UserLogin,
+				GiveawayID:    giveawayID,
+				RedeemedAt:    redemption.RedeemedAt,
+			}, entryRules{RewardID: rewardID})
+			if err != nil {
+				result.Errors++
+				continue
+			}
+			if added {
+				result.Added++
+			}
+		}
+
+		if next == "" || len(redemptions) == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
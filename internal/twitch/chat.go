@@ -0,0 +1,63 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// SendChatMessage posts a message in the broadcaster's chat as the broadcaster.
+// The access token must belong to the broadcaster and have the user:write:chat scope.
+func (a *API) SendChatMessage(ctx context.Context, accessToken, broadcasterID, message string) error {
+	client, err := a.UserClient(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.SendChatMessage(&helix.SendChatMessageParams{
+		BroadcasterID: broadcasterID,
+		SenderID:      broadcasterID,
+		Message:       message,
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to send chat message: %w", err)
+	}
+
+	if err := HelixError("/chat/messages", resp.ResponseCommon); err != nil {
+		return err
+	}
+
+	if len(resp.Data.Messages) > 0 && !resp.Data.Messages[0].IsSent {
+		return fmt.Errorf("chat message was dropped: %s", resp.Data.Messages[0].DropReasons.Data.Message)
+	}
+
+	return nil
+}
+
+// SendWhisper sends a whisper from one user to another.
+// The access token must belong to the sender and have the user:manage:whispers scope.
+// Twitch only lets accounts with a verified phone number send whispers.
+func (a *API) SendWhisper(ctx context.Context, accessToken, fromUserID, toUserID, message string) error {
+	client, err := a.UserClient(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.SendUserWhisper(&helix.SendUserWhisperParams{
+		FromUserID: fromUserID,
+		ToUserID:   toUserID,
+		Message:    message,
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to send whisper: %w", err)
+	}
+
+	if err := HelixError("/whispers", resp.ResponseCommon); err != nil {
+		return err
+	}
+
+	return nil
+}
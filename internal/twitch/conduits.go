@@ -0,0 +1,291 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/logging"
+)
+
+const (
+	conduitsEndpoint      = "/eventsub/conduits"
+	conduitShardsEndpoint = "/eventsub/conduits/shards"
+)
+
+// Conduit spreads the notifications of the subscriptions placed on it over its shards.
+// The subscriptions stay when a shard goes down, only its notifications are lost until it's assigned again.
+type Conduit struct {
+	ID         string `json:"id"`
+	ShardCount int    `json:"shard_count"`
+}
+
+// ConduitShard is where a conduit sends a share of its notifications, a webhook or a WebSocket session
+type ConduitShard struct {
+	ID        string            `json:"id"` // index of the shard, starting at 0
+	Transport EventSubTransport `json:"transport"`
+}
+
+type conduitsResponse struct {
+	Data []Conduit `json:"data"`
+}
+
+// GetConduits returns the conduits of the app
+func (a *API) GetConduits(ctx context.Context) ([]Conduit, error) {
+	req, err := a.appRequest(ctx, http.MethodGet, conduitsEndpoint, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var body conduitsResponse
+	if err := a.do(req, conduitsEndpoint, &body); err != nil {
+		return nil, fmt.Errorf("error getting conduits: %w", err)
+	}
+	return body.Data, nil
+}
+
+// CreateConduit creates a conduit with shardCount shards, an app may have up to 5
+func (a *API) CreateConduit(ctx context.Context, shardCount int) (Conduit, error) {
+	return a.conduitRequest(ctx, http.MethodPost, map[string]any{"shard_count": shardCount})
+}
+
+// UpdateConduit changes the number of shards of a conduit, the removed shards' notifications go to the others
+func (a *API) UpdateConduit(ctx context.Context, id string, shardCount int) (Conduit, error) {
+	return a.conduitRequest(ctx, http.MethodPatch, map[string]any{"id": id, "shard_count": shardCount})
+}
+
+func (a *API) conduitRequest(ctx context.Context, method string, body map[string]any) (Conduit, error) {
+	req, err := a.appRequest(ctx, method, conduitsEndpoint, nil, body)
+	if err != nil {
+		return Conduit{}, err
+	}
+
+	var answer conduitsResponse
+	if err := a.do(req, conduitsEndpoint, &answer); err != nil {
+		return Conduit{}, fmt.Errorf("error saving the conduit: %w", err)
+	}
+
+	if len(answer.Data) == 0 {
+		return Conduit{}, errors.New("error saving the conduit: no conduit in the response")
+	}
+	return answer.Data[0], nil
+}
+
+// UpdateConduitShards points the shards at their transports. Twitch verifies webhook shards against the callback
+// before it sends them notifications. The shards Twitch refused are returned as one error.
+func (a *API) UpdateConduitShards(ctx context.Context, conduitID string, shards []ConduitShard) error {
+	req, err := a.appRequest(ctx, http.MethodPatch, conduitShardsEndpoint, nil, map[string]any{
+		"conduit_id": conduitID,
+		"shards":     shards,
+	})
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Errors []struct {
+			ID      string `json:"id"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := a.do(req, conduitShardsEndpoint, &body); err != nil {
+		return fmt.Errorf("error updating shards: %w", err)
+	}
+
+	var errs []error
+	for _, shardErr := range body.Errors {
+		errs = append(errs, fmt.Errorf("shard %s: %s", shardErr.ID, shardErr.Message))
+	}
+	return errors.Join(errs...)
+}
+
+// conduitTransport places the subscriptions on a conduit instead of making one per transport.
+// They're created once with the app access token and survive the shards going down, so a new WebSocket
+// session only has to be assigned to its shard. The shards are webhooks or WebSocket sessions.
+type conduitTransport struct {
+	api           *API
+	configuredID  string // TWITCH_EVENTSUB_CONDUIT_ID
+	shardCount    int
+	webhookURL    string
+	webhookSecret string
+
+	webhook  *webhookTransport     // every shard's callback, nil with WebSocket shards
+	sessions []*webSocketTransport // the session of each shard, with WebSocket shards
+
+	mu        sync.Mutex
+	conduitID string
+}
+
+func newConduitTransport(cfg config.Twitch, api *API, dbStore db.StreamerStore, handlers *handlerGroup) (*conduitTransport, error) {
+	t := &conduitTransport{
+		api:           api,
+		configuredID:  cfg.ConduitID,
+		shardCount:    cfg.ConduitShards,
+		webhookURL:    cfg.WebhookURL,
+		webhookSecret: cfg.WebhookSecret,
+		conduitID:     cfg.ConduitID,
+	}
+
+	if cfg.EventSubTransport == config.TransportWebSocket {
+		for range cfg.ConduitShards {
+			t.sessions = append(t.sessions, newWebSocketTransport(cfg.WebSocketURL, api, dbStore, handlers))
+		}
+		return t, nil
+	}
+
+	t.webhook = newWebhookTransport(cfg, api, handlers)
+	return t, nil
+}
+
+func (t *conduitTransport) On(eventType string, handler func(ctx context.Context, event json.RawMessage)) {
+	if t.webhook != nil {
+		t.webhook.On(eventType, handler)
+	}
+	for _, session := range t.sessions {
+		session.On(eventType, handler)
+	}
+}
+
+// Subscribe places the subscription on the conduit. It returns errNoSession while the conduit isn't known,
+// which is until Run found it unless TWITCH_EVENTSUB_CONDUIT_ID names it.
+func (t *conduitTransport) Subscribe(ctx context.Context, streamer db.Streamer, event string) error {
+	conduitID := t.conduit()
+	if conduitID == "" {
+		return errNoSession
+	}
+
+	token, err := t.api.getAppToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = t.api.CreateEventSubSubscription(ctx, token, event, subscriptionCondition(event, streamer.TwitchID),
+		EventSubTransport{Method: "conduit", ConduitID: conduitID})
+
+	// Already on the conduit
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+		return nil
+	}
+	return err
+}
+
+func (t *conduitTransport) conduit() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conduitID
+}
+
+// Run finds or creates the conduit and assigns the shards, calling ready once subscriptions can be placed on it.
+// WebSocket shards are assigned again whenever their session is replaced.
+func (t *conduitTransport) Run(ctx context.Context, ready func(ctx context.Context)) {
+	var conduit Conduit
+	for {
+		var err error
+		conduit, err = t.ensureConduit(ctx, true)
+		if err == nil {
+			break
+		}
+		logging.FromContext(ctx).Error("Error setting up the EventSub conduit", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sessionRetryDelay):
+		}
+	}
+
+	t.mu.Lock()
+	t.conduitID = conduit.ID
+	t.mu.Unlock()
+
+	ctx = logging.With(ctx, slog.String("conduit_id", conduit.ID))
+
+	if t.webhook != nil {
+		shards := make([]ConduitShard, t.shardCount)
+		for i := range shards {
+			shards[i] = ConduitShard{
+				ID:        strconv.Itoa(i),
+				Transport: EventSubTransport{Method: "webhook", Callback: t.webhookURL, Secret: t.webhookSecret},
+			}
+		}
+		if err := t.api.UpdateConduitShards(ctx, conduit.ID, shards); err != nil {
+			logging.FromContext(ctx).Error("Error assigning the conduit shards to the callback", "error", err)
+		}
+
+		ready(ctx)
+		<-ctx.Done()
+		return
+	}
+
+	var sessions sync.WaitGroup
+	for i, session := range t.sessions {
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			session.Run(ctx, func(ctx context.Context) { t.assignSession(ctx, conduit.ID, i, session.session()) })
+		}()
+	}
+
+	ready(ctx)
+	sessions.Wait()
+}
+
+// assignSession points a shard at a WebSocket session, Twitch closes sessions that aren't assigned within 10 seconds
+func (t *conduitTransport) assignSession(ctx context.Context, conduitID string, shard int, sessionID string) {
+	err := t.api.UpdateConduitShards(ctx, conduitID, []ConduitShard{{
+		ID:        strconv.Itoa(shard),
+		Transport: EventSubTransport{Method: "websocket", SessionID: sessionID},
+	}})
+
+	logger := logging.FromContext(ctx).With(slog.Int("shard", shard), slog.String("eventsub_session_id", sessionID))
+	if err != nil {
+		logger.Error("Error assigning the conduit shard to the session", "error", err)
+		return
+	}
+	logger.Info("Assigned the conduit shard to the session")
+}
+
+// ensureConduit returns the configured conduit, or the app's first one. It's created when there is none and create
+// is true, otherwise a zero Conduit is returned. The shard count is brought to the configured one.
+func (t *conduitTransport) ensureConduit(ctx context.Context, create bool) (Conduit, error) {
+	conduits, err := t.api.GetConduits(ctx)
+	if err != nil {
+		return Conduit{}, err
+	}
+
+	for _, conduit := range conduits {
+		if t.configuredID != "" && conduit.ID != t.configuredID {
+			continue
+		}
+
+		if conduit.ShardCount == t.shardCount || !create {
+			return conduit, nil
+		}
+
+		logging.FromContext(ctx).Info("Resizing the EventSub conduit", "conduit_id", conduit.ID, "from", conduit.ShardCount, "to", t.shardCount)
+		return t.api.UpdateConduit(ctx, conduit.ID, t.shardCount)
+	}
+
+	if t.configuredID != "" {
+		return Conduit{}, fmt.Errorf("the EventSub conduit %s doesn't exist", t.configuredID)
+	}
+	if !create {
+		return Conduit{}, nil
+	}
+
+	conduit, err := t.api.CreateConduit(ctx, t.shardCount)
+	if err != nil {
+		return Conduit{}, err
+	}
+	logging.FromContext(ctx).Info("Created an EventSub conduit", "conduit_id", conduit.ID, "shards", conduit.ShardCount)
+	return conduit, nil
+}
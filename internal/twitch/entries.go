@@ -0,0 +1,197 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/feed"
+	"github.com/gamis65/twitch-points/internal/metrics"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Ways a viewer can enter a giveaway, stored in redemptions.entry_method
+const (
+	EntryMethodChannelPoints = "channel_points"
+	EntryMethodChatCommand   = "chat_command"
+	EntryMethodImport        = "import" // Added by hand through the bulk import
+)
+
+// Entry is a single giveaway entry, no matter how the viewer entered
+type Entry struct {
+	ID            string // Redemption ID or chat message ID, used to dedupe entries
+	Method        string
+	StreamerID    string
+	StreamerLogin string
+	ViewerID      string
+	ViewerLogin   string
+	GiveawayID    int64     // Giveaway the entry was made for, zero for whichever is open
+	RedeemedAt    time.Time // When the viewer entered, zero for entries that just happened
+}
+
+// getOpenGiveaway returns the giveaway new entries go to.
+// ok is false when no giveaway is open and the entry should be ignored.
+func (tc *TwitchWebhookClient) getOpenGiveaway(ctx context.Context, logger *slog.Logger) (giveaway db.Giveaway, ok bool) {
+	giveaway, err := tc.db.GetOpenGiveaway(ctx)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			logger.Info("No giveaway is open, ignoring entry")
+		} else {
+			logger.Error("Error getting the open giveaway", "error", err)
+		}
+		return db.Giveaway{}, false
+	}
+
+	return giveaway, true
+}
+
+// entryRules are the checks an entry has to pass, made in the transaction adding it
+type entryRules struct {
+	RewardID   string        // Reward the viewer redeemed, the streamer's active reward or one retired during the giveaway. Empty when not a redemption
+	MaxEntries int32         // Entries a viewer can have in the giveaway with the entry method, 0 for no limit
+	Cooldown   time.Duration // Time a viewer waits between entries with the entry method
+}
+
+// addEntry stores an entry in one transaction with the checks deciding whether it counts, so concurrent entries can't race.
+// Entries go to the open giveaway, one with a GiveawayID is ignored when that giveaway isn't open anymore.
+// added is false when the entry was ignored or already stored.
+func (tc *TwitchWebhookClient) addEntry(ctx context.Context, logger *slog.Logger, entry Entry, rules entryRules) (added bool, err error) {
+	err = tc.db.InEntryTx(ctx, func(tx db.EntryTx) error {
+		var err error
+		added, err = insertEntry(ctx, tx, logger, &entry, rules)
+		return err
+	})
+	if err != nil {
+		logger.Error("Error adding an entry to db", "error", err)
+		tc.notifier.Send(ctx, "Error adding a redemption to db "+entry.StreamerLogin)
+		return false, err
+	}
+
+	if !added {
+		return false, nil
+	}
+
+	logger.Info("User entered the giveaway", "entry_method", entry.Method, "giveaway_id", entry.GiveawayID)
+	tc.notifier.Send(ctx, entry.ViewerLogin+" redeemed an entry in "+entry.StreamerLogin)
+	metrics.EntriesAdded.WithLabelValues(entry.Method).Inc()
+
+	redeemedAt := entry.RedeemedAt
+	if redeemedAt.IsZero() {
+		redeemedAt = time.Now()
+	}
+
+	tc.feed.Publish(ctx, feed.Entry{
+		GiveawayID:       entry.GiveawayID,
+		StreamerID:       entry.StreamerID,
+		StreamerUsername: entry.StreamerLogin,
+		ViewerUsername:   entry.ViewerLogin,
+		EntryMethod:      entry.Method,
+		RedeemedAt:       redeemedAt,
+	})
+
+	return true, nil
+}
+
+// insertEntry checks and stores an entry inside its transaction, setting the giveaway it went to
+func insertEntry(ctx context.Context, tx db.EntryTx, logger *slog.Logger, entry *Entry, rules entryRules) (bool, error) {
+	// Closing the giveaway waits for the transaction, so the entry can't land in a closed one.
+	// Outside its schedule the open giveaway doesn't take entries, the reward may not have been paused yet.
+	giveaway, err := tx.LockOpenGiveaway(ctx)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			logger.Info("No giveaway is taking entries, ignoring entry")
+			return false, nil
+		}
+		return false, fmt.Errorf("error getting the open giveaway: %w", err)
+	}
+
+	if entry.GiveawayID != 0 && entry.GiveawayID != giveaway.ID {
+		logger.Info("The giveaway was closed, ignoring entry", "giveaway_id", entry.GiveawayID)
+		return false, nil
+	}
+	entry.GiveawayID = giveaway.ID
+
+	streamerID := pgtype.Text{String: entry.StreamerID, Valid: true}
+	viewerID := pgtype.Text{String: entry.ViewerID, Valid: true}
+
+	if rules.RewardID != "" {
+		isEntry, err := tx.IsEntryReward(ctx, db.IsEntryRewardParams{
+			RewardID:   rules.RewardID,
+			StreamerID: streamerID,
+			GiveawayID: pgtype.Int8{Int64: giveaway.ID, Valid: true},
+		})
+		if err != nil {
+			return false, fmt.Errorf("error checking the reward: %w", err)
+		}
+
+		// Other rewards of the channel aren't entries
+		if !isEntry {
+			return false, nil
+		}
+	}
+
+	optedOut, err := tx.IsViewerOptedOut(ctx, entry.ViewerID)
+	if err != nil {
+		return false, fmt.Errorf("error checking if the viewer opted out: %w", err)
+	}
+
+	if optedOut {
+		logger.Info("Viewer opted out, ignoring entry")
+		return false, nil
+	}
+
+	// Locks the viewer, so the limits below see their other entries
+	err = tx.UpsertViewer(ctx, db.UpsertViewerParams{
+		TwitchID:     entry.ViewerID,
+		Username:     entry.ViewerLogin,
+		RegisteredIn: streamerID,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error saving the viewer: %w", err)
+	}
+
+	if rules.MaxEntries > 0 || rules.Cooldown > 0 {
+		stats, err := tx.GetViewerEntryStats(ctx, db.GetViewerEntryStatsParams{
+			StreamerID:  streamerID,
+			ViewerID:    viewerID,
+			EntryMethod: entry.Method,
+			GiveawayID:  entry.GiveawayID,
+		})
+		if err != nil {
+			return false, fmt.Errorf("error getting viewer entry stats: %w", err)
+		}
+
+		if rules.MaxEntries > 0 && stats.TotalEntries >= int64(rules.MaxEntries) {
+			logger.Debug("Viewer reached the entry limit", "total_entries", stats.TotalEntries)
+			return false, nil
+		}
+
+		if stats.TotalEntries > 0 && time.Since(stats.LastEntryAt.Time) < rules.Cooldown {
+			logger.Debug("Viewer is on cooldown", "last_entry_at", stats.LastEntryAt.Time)
+			return false, nil
+		}
+	}
+
+	// Late entries keep the time they were made
+	inserted, err := tx.AddRedemption(ctx, db.AddRedemptionParams{
+		MessageID:   entry.ID,
+		StreamerID:  streamerID,
+		ViewerID:    viewerID,
+		EntryMethod: entry.Method,
+		GiveawayID:  entry.GiveawayID,
+		RedeemedAt:  pgtype.Timestamptz{Time: entry.RedeemedAt, Valid: !entry.RedeemedAt.IsZero()},
+	})
+	if err != nil {
+		return false, fmt.Errorf("error adding the redemption: %w", err)
+	}
+
+	if inserted == 0 {
+		logger.Debug("Entry was already added")
+		return false, nil
+	}
+	return true, nil
+}
@@ -4,27 +4,65 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/LinneB/twitchwh"
+	"github.com/gamis65/twitch-points/internal/config"
 	"github.com/gamis65/twitch-points/internal/db"
-	"github.com/gamis65/twitch-points/internal/util"
+	"github.com/gamis65/twitch-points/internal/feed"
+	"github.com/gamis65/twitch-points/internal/logging"
+	"github.com/gamis65/twitch-points/internal/metrics"
+	"github.com/gamis65/twitch-points/internal/notify"
+	"github.com/gamis65/twitch-points/internal/telemetry"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Store is the data the EventSub client reads and writes, and how it reaches the other replicas
+type Store interface {
+	db.StreamerStore
+	db.RewardStore
+	db.ChatCommandStore
+	db.EntryStore
+	db.Locker
+	db.PubSub
+}
+
 type TwitchWebhookClient struct {
-	client        *twitchwh.Client
-	clientId      string
-	clientSecret  string
+	transport     Transport
+	api           *API
 	webhookSecret string
 	webhookURL    string
-	db            *db.DBStore
+	db            Store
 	events        []string
-	logger        *slog.Logger
+	notifier      *notify.Notifier
+	feed          *feed.Broker
+
+	handlers          *handlerGroup // event handlers the transport started in their own goroutines
+	rewardWake        chan struct{} // wakes RunRewardSchedule up
+	subscriptionState atomic.Value
 }
 
+// errInvalidEvent marks notifications whose payload couldn't be parsed
+var errInvalidEvent = errors.New("invalid event payload")
+
+// Subscription states reported by SubscriptionState
+const (
+	SubscriptionsPending = "pending"
+	SubscriptionsReady   = "ready"
+	SubscriptionsFailed  = "failed"
+)
+
 type StreamEvent struct {
 	BroadcasterUserID    string `json:"broadcaster_user_id"`
 	BroadcasterUserName  string `json:"broadcaster_user_name"`
@@ -40,6 +78,7 @@ type ChannelUpdateEvent struct {
 type RewardUpdateEvent struct {
 	BroadcasterUserID    string `json:"broadcaster_user_id"`
 	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	ID                   string `json:"id"` // Reward ID
 	Title                string `json:"title"`
 	Cost                 int    `json:"cost"`
 }
@@ -64,34 +103,76 @@ type RewardRedemptionEvent struct {
 	} `json:"user"`
 }
 
-func NewTwitchClient(clientId string, clientSecret string, webhookSecret string, webhookURL string, dbStore *db.DBStore, eventsToSubscribeTo []string) (*TwitchWebhookClient, error) {
-	client, err := twitchwh.New(twitchwh.ClientConfig{
-		ClientID:      clientId,
-		ClientSecret:  clientSecret,
-		WebhookSecret: webhookSecret,
-		WebhookURL:    webhookURL,
-		Debug:         false,
-	})
+type ChatMessageEvent struct {
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	ChatterUserID        string `json:"chatter_user_id"`
+	ChatterUserName      string `json:"chatter_user_name"`
+	ChatterUserLogin     string `json:"chatter_user_login"`
+	MessageID            string `json:"message_id"`
+	Message              struct {
+		Text string `json:"text"`
+	} `json:"message"`
+}
 
-	if err != nil {
-		return nil, err
+func NewTwitchClient(cfg config.Twitch, api *API, dbStore Store, eventsToSubscribeTo []string, notifier *notify.Notifier, entryFeed *feed.Broker) (*TwitchWebhookClient, error) {
+	handlers := &handlerGroup{}
+
+	var transport Transport
+	switch {
+	case cfg.ConduitShards > 0:
+		conduit, err := newConduitTransport(cfg, api, dbStore, handlers)
+		if err != nil {
+			return nil, err
+		}
+		transport = conduit
+	case cfg.EventSubTransport == config.TransportWebSocket:
+		transport = newWebSocketTransport(cfg.WebSocketURL, api, dbStore, handlers)
+	case cfg.EventSubTransport == config.TransportWebhook || cfg.EventSubTransport == "":
+		transport = newWebhookTransport(cfg, api, handlers)
+	default:
+		return nil, fmt.Errorf("unknown EventSub transport %q", cfg.EventSubTransport)
 	}
 
-	return &TwitchWebhookClient{
-		client:        client,
-		clientId:      clientId,
-		clientSecret:  clientSecret,
-		webhookSecret: webhookSecret,
-		webhookURL:    webhookURL,
+	tc := &TwitchWebhookClient{
+		transport:     transport,
+		api:           api,
+		webhookSecret: cfg.WebhookSecret,
+		webhookURL:    cfg.WebhookURL,
 		db:            dbStore,
 		events:        eventsToSubscribeTo,
-		logger:        slog.Default(),
-	}, nil
+		notifier:      notifier,
+		feed:          entryFeed,
+		handlers:      handlers,
+		rewardWake:    make(chan struct{}, 1),
+	}
+	tc.subscriptionState.Store(SubscriptionsPending)
+
+	// Twitch delivers each webhook notification to one replica, so every replica handles every event
+	tc.on("stream.online", tc.handleStreamOnline)
+	tc.on("stream.offline", tc.handleStreamOffline)
+
+	// Channel points
+	tc.on("channel.channel_points_custom_reward_redemption.add", tc.handleRewardRedemption)
+	tc.on("channel.update", tc.handleChannelUpdate)
+	tc.on("channel.channel_points_custom_reward.update", tc.handleRewardUpdate)
+
+	// Chat command entries
+	tc.on("channel.chat.message", tc.handleChatMessage)
+
+	// Subs and cheers
+	// TODO: Add a giveaway config
+	// tc.on("channel.subscribe", tc.handleSubscription)
+	// tc.on("channel.subscription.gift")
+	// tc.on("channel.cheer")
+
+	return tc, nil
 }
 
-// getEventLogger creates a contextualized logger with event specific fields
-func (tc *TwitchWebhookClient) getEventLogger(eventType string, eventData any) *slog.Logger {
-	logger := tc.logger.With(slog.String("eventType", eventType))
+// getEventLogger creates a contextualized logger with event specific fields on top of the notification's logger in ctx
+func (tc *TwitchWebhookClient) getEventLogger(ctx context.Context, eventType string, eventData any) *slog.Logger {
+	logger := logging.FromContext(ctx).With(slog.String("eventType", eventType))
 
 	// Add common fields based on event data structure
 	switch data := eventData.(type) {
@@ -109,47 +190,142 @@ func (tc *TwitchWebhookClient) getEventLogger(eventType string, eventData any) *
 			slog.String("reward_id", data.Reward.ID),
 			slog.String("reward_title", data.Reward.Title),
 		)
+	case ChatMessageEvent:
+		logger = logger.With(
+			slog.String("streamer_id", data.BroadcasterUserID),
+			slog.String("streamer_username", data.BroadcasterUserLogin),
+			slog.String("viewer_id", data.ChatterUserID),
+			slog.String("viewer_username", data.ChatterUserLogin),
+			slog.String("chat_message_id", data.MessageID),
+		)
+	case ChannelUpdateEvent:
+		logger = logger.With(
+			slog.String("streamer_id", data.BroadcasterUserID),
+			slog.String("streamer_username", data.BroadcasterUserLogin),
+		)
+	case RewardUpdateEvent:
+		logger = logger.With(
+			slog.String("streamer_id", data.BroadcasterUserID),
+			slog.String("streamer_username", data.BroadcasterUserLogin),
+			slog.String("reward_id", data.ID),
+			slog.String("reward_title", data.Title),
+		)
+	default:
+		logger.Warn("No log fields for event data", "type", fmt.Sprintf("%T", eventData))
 	}
 
 	return logger
 }
 
-func (tc *TwitchWebhookClient) Initialize() {
-	// Stream live status
-	tc.client.On("stream.online", tc.handleStreamOnline)
-	tc.client.On("stream.offline", tc.handleStreamOffline)
+// Initialize refreshes the streamers' tokens, subscribes every streamer to the events and backfills missed redemptions.
+// Only one replica should run it at a time, refreshing a token invalidates the one the other replicas hold.
+func (tc *TwitchWebhookClient) Initialize(ctx context.Context) {
+	streamers, err := tc.RefreshStreamerTokens(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error getting streamers from the database", "error", err)
+		tc.subscriptionState.Store(SubscriptionsFailed)
+		return
+	}
 
-	// Channel points
-	tc.client.On("channel.channel_points_custom_reward_redemption.add", tc.handleRewardRedemption)
-	tc.client.On("channel.update", tc.handleChannelUpdate)
-	tc.client.On("channel.channel_points_custom_reward.update", tc.handleRewardUpdate)
+	tc.SubscribeToEvents(ctx, streamers)
+	tc.subscriptionState.Store(SubscriptionsReady)
 
-	// Subs and cheers
-	// TODO: Add a giveaway config
-	// tc.client.On("channel.subscribe", tc.handleSubscription)
-	// tc.client.On("channel.subscription.gift")
-	// tc.client.On("channel.cheer")
+	// Pick up redemptions made while the server was down
+	if _, err := tc.Backfill(ctx, ""); err != nil {
+		logging.FromContext(ctx).Error("Error backfilling redemptions", "error", err)
+	}
+}
 
-	streamers, err := tc.db.GetAllStreamersWithTokens(context.Background())
+// Run keeps the EventSub transport up and calls Initialize whenever it can take subscriptions, until ctx is cancelled.
+// Only one replica should run it at a time, it also subscribes the streamers that sign in on the other replicas.
+func (tc *TwitchWebhookClient) Run(ctx context.Context) {
+	listening := make(chan struct{})
+	go func() {
+		defer close(listening)
+		tc.listen(ctx, subscribeChannel, func(streamerID string) { tc.subscribeRelayed(ctx, streamerID) })
+	}()
+	defer func() { <-listening }()
+
+	tc.transport.Run(ctx, func(ctx context.Context) {
+		tc.Initialize(ctx)
+		// The subscriptions are gone with a WebSocket session, until the next one is ready
+		<-ctx.Done()
+		tc.subscriptionState.Store(SubscriptionsPending)
+	})
+}
+
+// listen calls fn with the messages the other replicas publish on channel until ctx is cancelled, listening again when it stops
+func (tc *TwitchWebhookClient) listen(ctx context.Context, channel string, fn func(payload string)) {
+	for {
+		err := tc.db.Listen(ctx, channel, fn)
+		if ctx.Err() != nil {
+			return
+		}
+		logging.FromContext(ctx).Warn("Stopped listening to the other replicas, listening again", "channel", channel, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// SubscriptionState reports whether Initialize finished subscribing the streamers, it stays pending on the replicas that don't run it
+func (tc *TwitchWebhookClient) SubscriptionState() string {
+	return tc.subscriptionState.Load().(string)
+}
+
+// on registers the handler for an event, recording its outcome. The transport counts it so Shutdown can wait for it.
+func (tc *TwitchWebhookClient) on(eventType string, handler func(ctx context.Context, event json.RawMessage) error) {
+	tc.transport.On(eventType, func(ctx context.Context, event json.RawMessage) {
+		ctx, span := telemetry.Tracer().Start(ctx, "eventsub "+eventType,
+			trace.WithAttributes(attribute.String("eventsub.subscription_type", eventType)))
+		defer span.End()
+
+		start := time.Now()
+		err := handler(ctx, event)
+		metrics.EventSubHandlerDuration.WithLabelValues(eventType).Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		outcome := metrics.Outcome(err)
+		if errors.Is(err, errInvalidEvent) {
+			outcome = "invalid"
+		}
+		metrics.EventSubNotifications.WithLabelValues(eventType, outcome).Inc()
+	})
+}
+
+// Shutdown waits for the running event handlers to finish their writes, stop the HTTP server first so no new ones start
+func (tc *TwitchWebhookClient) Shutdown(ctx context.Context) error {
+	return tc.handlers.Wait(ctx)
+}
+
+// RefreshStreamerTokens refreshes and saves the tokens of every streamer.
+// It returns all streamers with their new tokens, a streamer whose refresh failed keeps the old ones.
+func (tc *TwitchWebhookClient) RefreshStreamerTokens(ctx context.Context) ([]db.Streamer, error) {
+	streamers, err := tc.db.GetAllStreamersWithTokens(ctx)
 	if err != nil {
-		tc.logger.Error("Error getting streamers from the database", "error", err)
-		return
+		return nil, err
 	}
 
-	// Refresh tokens for all streamers
 	for i, streamer := range streamers {
-		streamerLogger := tc.logger.With(
+		streamerLogger := logging.FromContext(ctx).With(
 			slog.String("streamer_id", streamer.TwitchID),
 			slog.String("streamer_username", streamer.Username),
 		)
 
-		newToken, err := GetRefreshTwitchToken(streamer.RefreshToken.String, tc.clientId, tc.clientSecret)
+		newToken, err := tc.api.RefreshToken(ctx, streamer.RefreshToken.String)
 		if err != nil {
 			streamerLogger.Error("Error refreshing token", "error", err)
 			continue
 		}
 
-		_, err = tc.db.UpdateStreamerTokens(context.Background(), db.UpdateStreamerTokensParams{
+		_, err = tc.db.UpdateStreamerTokens(ctx, db.UpdateStreamerTokensParams{
 			TwitchID:     streamer.TwitchID,
 			AccessToken:  pgtype.Text{String: newToken.AccessToken, Valid: true},
 			RefreshToken: pgtype.Text{String: newToken.RefreshToken, Valid: true},
@@ -166,163 +342,272 @@ func (tc *TwitchWebhookClient) Initialize() {
 		streamerLogger.Info("Refreshed streamer token")
 	}
 
-	tc.SubscribeToEvents(streamers)
+	return streamers, nil
 }
 
-func (tc *TwitchWebhookClient) SubscribeToEvents(streamers []db.Streamer) {
+// subscribeChannel passes the streamers to subscribe on to the replica running Run, which holds the WebSocket session or knows the conduit
+const subscribeChannel = "eventsub_subscribe"
+
+// SubscribeToEvents subscribes the streamers to the events. Without a WebSocket session or conduit in this replica
+// the streamers are passed on to the replica running Run.
+func (tc *TwitchWebhookClient) SubscribeToEvents(ctx context.Context, streamers []db.Streamer) {
 	for _, streamer := range streamers {
-		streamerLogger := tc.logger.With(
-			slog.String("streamer_id", streamer.TwitchID),
-			slog.String("streamer_username", streamer.Username),
-		)
+		err := tc.subscribeStreamer(ctx, streamer)
+		if !errors.Is(err, errNoSession) {
+			continue
+		}
+
+		if err := tc.db.Publish(ctx, subscribeChannel, streamer.TwitchID); err != nil {
+			logging.FromContext(ctx).Error("Error passing a streamer on to subscribe", "streamer_id", streamer.TwitchID, "error", err)
+		}
+	}
+}
 
-		for _, event := range tc.events {
-			streamerLogger.Info("Subscribing to an event", "event", event)
+// subscribeStreamer subscribes the streamer to the events, failed subscriptions are logged.
+// It stops with errNoSession when the transport has no session to subscribe on.
+func (tc *TwitchWebhookClient) subscribeStreamer(ctx context.Context, streamer db.Streamer) error {
+	streamerLogger := logging.FromContext(ctx).With(
+		slog.String("streamer_id", streamer.TwitchID),
+		slog.String("streamer_username", streamer.Username),
+	)
 
-			err := tc.client.AddSubscription(event, "1", twitchwh.Condition{
-				BroadcasterUserID: streamer.TwitchID,
-			})
+	for _, event := range tc.streamerEvents(streamer) {
+		streamerLogger.Info("Subscribing to an event", "event", event)
 
-			if err != nil {
-				streamerLogger.Error("Error subscribing to an event", "event", event, "error", err)
-				continue
-			}
+		err := tc.transport.Subscribe(ctx, streamer, event)
+		if errors.Is(err, errNoSession) {
+			return err
+		}
+
+		if err != nil {
+			streamerLogger.Error("Error subscribing to an event", "event", event, "error", err)
+			continue
 		}
 	}
+
+	return nil
+}
+
+// streamerEvents returns the events the streamer can be subscribed to, the channel point ones need channel points
+func (tc *TwitchWebhookClient) streamerEvents(streamer db.Streamer) []string {
+	if HasChannelPoints(streamer) {
+		return tc.events
+	}
+
+	var events []string
+	for _, event := range tc.events {
+		if !needsChannelPoints(event) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// subscribeRelayed subscribes a streamer another replica passed on
+func (tc *TwitchWebhookClient) subscribeRelayed(ctx context.Context, streamerID string) {
+	streamer, err := tc.db.GetStreamerByID(ctx, streamerID)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error getting a streamer to subscribe", "streamer_id", streamerID, "error", err)
+		return
+	}
+
+	// Lost the session in the meantime, the next one subscribes every streamer
+	if err := tc.subscribeStreamer(ctx, streamer); err != nil {
+		logging.FromContext(ctx).Warn("No EventSub session to subscribe a streamer on", "streamer_id", streamerID)
+	}
+}
+
+// subscriptionCondition builds the condition for an event subscription on a streamer's channel
+func subscriptionCondition(event string, streamerID string) twitchwh.Condition {
+	condition := twitchwh.Condition{
+		BroadcasterUserID: streamerID,
+	}
+
+	// Chat messages are read as the streamer, so the streamer's token needs the user:read:chat scope
+	if event == "channel.chat.message" {
+		condition.UserID = streamerID
+	}
+
+	return condition
 }
 
-func (tc *TwitchWebhookClient) handleStreamOnline(event json.RawMessage) {
+func (tc *TwitchWebhookClient) handleStreamOnline(ctx context.Context, event json.RawMessage) error {
 	var eventData StreamEvent
 
 	if err := json.Unmarshal(event, &eventData); err != nil {
-		tc.logger.Error("Error parsing stream online event", "error", err)
-		return
+		logging.FromContext(ctx).Error("Error parsing stream online event", "error", err)
+		return fmt.Errorf("%w: %w", errInvalidEvent, err)
 	}
 
-	logger := tc.getEventLogger("stream.online", eventData)
+	logger := tc.getEventLogger(ctx, "stream.online", eventData)
 	logger.Info("Streamer went live")
 
-	err := tc.db.SetStreamerLiveStatus(context.Background(), db.SetStreamerLiveStatusParams{
-		IsLive: pgtype.Bool{Bool: true, Valid: true},
+	err := tc.db.SetStreamerLiveStatus(ctx, db.SetStreamerLiveStatusParams{
+		IsLive:   pgtype.Bool{Bool: true, Valid: true},
+		TwitchID: eventData.BroadcasterUserID,
 	})
 
 	if err != nil {
 		logger.Error("Error setting streamer live status", "error", err)
 	}
 
-	util.SendWebHook(eventData.BroadcasterUserLogin + " went live")
+	tc.notifier.Send(ctx, eventData.BroadcasterUserLogin+" went live")
+	tc.WakeRewardSchedule(ctx)
+
+	return err
 }
 
-func (tc *TwitchWebhookClient) handleStreamOffline(event json.RawMessage) {
+func (tc *TwitchWebhookClient) handleStreamOffline(ctx context.Context, event json.RawMessage) error {
 	var eventData StreamEvent
 
 	if err := json.Unmarshal(event, &eventData); err != nil {
-		tc.logger.Error("Error parsing stream offline event", "error", err)
-		return
+		logging.FromContext(ctx).Error("Error parsing stream offline event", "error", err)
+		return fmt.Errorf("%w: %w", errInvalidEvent, err)
 	}
 
-	logger := tc.getEventLogger("stream.offline", eventData)
+	logger := tc.getEventLogger(ctx, "stream.offline", eventData)
 	logger.Info("Streamer went offline")
 
-	err := tc.db.SetStreamerLiveStatus(context.Background(), db.SetStreamerLiveStatusParams{
-		IsLive: pgtype.Bool{Bool: false, Valid: true},
+	err := tc.db.SetStreamerLiveStatus(ctx, db.SetStreamerLiveStatusParams{
+		IsLive:   pgtype.Bool{Bool: false, Valid: true},
+		TwitchID: eventData.BroadcasterUserID,
 	})
 
 	if err != nil {
 		logger.Error("Error setting streamer live status", "error", err)
+		return err
 	}
+
+	tc.WakeRewardSchedule(ctx)
+	return nil
 }
 
-func (tc *TwitchWebhookClient) handleRewardRedemption(event json.RawMessage) {
+func (tc *TwitchWebhookClient) handleRewardRedemption(ctx context.Context, event json.RawMessage) error {
 	var eventData RewardRedemptionEvent
 
 	if err := json.Unmarshal(event, &eventData); err != nil {
-		tc.logger.Error("Error parsing reward redemption event", "error", err)
-		return
+		logging.FromContext(ctx).Error("Error parsing reward redemption event", "error", err)
+		return fmt.Errorf("%w: %w", errInvalidEvent, err)
 	}
 
-	logger := tc.getEventLogger("reward.redemption", eventData)
+	logger := tc.getEventLogger(ctx, "reward.redemption", eventData)
+
+	_, err := tc.addEntry(ctx, logger, Entry{
+		ID:            eventData.ID,
+		Method:        EntryMethodChannelPoints,
+		StreamerID:    eventData.BroadcasterUserID,
+		StreamerLogin: eventData.BroadcasterUserLogin,
+		ViewerID:      eventData.UserID,
+		ViewerLogin:   eventData.UserLogin,
+	}, entryRules{RewardID: eventData.Reward.ID})
+	return err
+}
 
-	reward, err := tc.db.GetRewardsByStreamer(context.Background(), pgtype.Text{String: eventData.BroadcasterUserID, Valid: true})
-	if err != nil {
-		logger.Error("Error getting a reward for a streamer from db", "error", err)
-		util.SendWebHook("Error getting a reward for a streamer from db " + eventData.BroadcasterUserLogin)
-		return
-	}
+func (tc *TwitchWebhookClient) handleChatMessage(ctx context.Context, event json.RawMessage) error {
+	var eventData ChatMessageEvent
 
-	if len(reward) == 0 {
-		logger.Warn("No rewards found for streamer")
-		return
+	if err := json.Unmarshal(event, &eventData); err != nil {
+		logging.FromContext(ctx).Error("Error parsing chat message event", "error", err)
+		return fmt.Errorf("%w: %w", errInvalidEvent, err)
 	}
 
-	// Check if the reward has the right ID
-	if eventData.Reward.ID != reward[0].RewardID {
-		return
+	// Chatters entering with a command are the only messages we care about,
+	// so bail out before touching the database for anything else
+	fields := strings.Fields(eventData.Message.Text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "!") {
+		return nil
 	}
 
-	viewer, err := tc.db.GetViewerByID(context.Background(), eventData.UserID)
-	if err != nil {
-		if !errors.Is(err, pgx.ErrNoRows) {
-			logger.Error("Error getting viewer by id", "error", err)
-			return
-		}
+	// The streamer's own messages are delivered too, they can't enter their own giveaway
+	if eventData.ChatterUserID == eventData.BroadcasterUserID {
+		return nil
 	}
 
-	emptyViewer := db.Viewer{}
-	if viewer == emptyViewer {
-		_, err = tc.db.CreateViewer(context.Background(), db.CreateViewerParams{
-			TwitchID:     eventData.UserID,
-			Username:     eventData.UserLogin,
-			RegisteredIn: pgtype.Text{String: eventData.BroadcasterUserID, Valid: true},
-		})
+	logger := tc.getEventLogger(ctx, "chat.message", eventData)
 
-		if err != nil {
-			logger.Error("Error creating a new viewer", "error", err)
-			return
+	chatCommand, err := tc.db.GetChatCommandByStreamer(ctx, eventData.BroadcasterUserID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
 		}
+		logger.Error("Error getting chat command for a streamer from db", "error", err)
+		return err
 	}
 
-	_, err = tc.db.CreateRedemption(context.Background(), db.CreateRedemptionParams{
-		MessageID:  eventData.ID,
-		ViewerID:   pgtype.Text{String: eventData.UserID, Valid: true},
-		StreamerID: pgtype.Text{String: eventData.BroadcasterUserID, Valid: true},
-	})
-
-	if err != nil {
-		logger.Error("Error adding a redemption to db", "error", err)
-		util.SendWebHook("Error adding a redemption to db " + eventData.BroadcasterUserLogin)
-		return
+	if !chatCommand.Enabled || !strings.EqualFold(fields[0], chatCommand.Command) {
+		return nil
 	}
 
-	logger.Info("User redeemed a reward")
-	util.SendWebHook(eventData.UserLogin + " redeemed an entry in " + eventData.BroadcasterUserLogin)
+	_, err = tc.addEntry(ctx, logger, Entry{
+		ID:            eventData.MessageID,
+		Method:        EntryMethodChatCommand,
+		StreamerID:    eventData.BroadcasterUserID,
+		StreamerLogin: eventData.BroadcasterUserLogin,
+		ViewerID:      eventData.ChatterUserID,
+		ViewerLogin:   eventData.ChatterUserLogin,
+	}, entryRules{
+		MaxEntries: chatCommand.MaxEntriesPerViewer,
+		Cooldown:   time.Duration(chatCommand.CooldownSeconds) * time.Second,
+	})
+	return err
 }
 
-func (tc *TwitchWebhookClient) handleChannelUpdate(event json.RawMessage) {
+func (tc *TwitchWebhookClient) handleChannelUpdate(ctx context.Context, event json.RawMessage) error {
 	var eventData ChannelUpdateEvent
 
 	if err := json.Unmarshal(event, &eventData); err != nil {
-		tc.logger.Error("Error parsing channel update event", "error", err)
-		return
+		logging.FromContext(ctx).Error("Error parsing channel update event", "error", err)
+		return fmt.Errorf("%w: %w", errInvalidEvent, err)
 	}
 
-	logger := tc.getEventLogger("channel.update", eventData)
+	logger := tc.getEventLogger(ctx, "channel.update", eventData)
 	logger.Info("Channel updated", "title", eventData.Title)
+
+	return nil
 }
 
-func (tc *TwitchWebhookClient) handleRewardUpdate(event json.RawMessage) {
+func (tc *TwitchWebhookClient) handleRewardUpdate(ctx context.Context, event json.RawMessage) error {
 	var eventData RewardUpdateEvent
 
 	if err := json.Unmarshal(event, &eventData); err != nil {
-		tc.logger.Error("Error parsing reward update event", "error", err)
-		return
+		logging.FromContext(ctx).Error("Error parsing reward update event", "error", err)
+		return fmt.Errorf("%w: %w", errInvalidEvent, err)
 	}
 
-	logger := tc.getEventLogger("reward.update", eventData)
+	logger := tc.getEventLogger(ctx, "reward.update", eventData)
 	logger.Warn("Streamer updated a channel point reward", "reward", eventData.Title, "cost", eventData.Cost)
+
+	// Pausing or resuming it by hand is undone when it doesn't match the schedule
+	tc.WakeRewardSchedule(ctx)
+	return nil
+}
+
+// webhook returns the transport receiving the notifications Twitch posts to the callback, nil when they come over a WebSocket
+func (tc *TwitchWebhookClient) webhook() *webhookTransport {
+	switch transport := tc.transport.(type) {
+	case *webhookTransport:
+		return transport
+	case *conduitTransport:
+		return transport.webhook
+	}
+	return nil
 }
 
-// GetHandler returns the HTTP handler for webhook events
+// GetHandler returns the HTTP handler for webhook events, it answers 404 when the events come over a WebSocket
 func (tc *TwitchWebhookClient) GetHandler() func(http.ResponseWriter, *http.Request) {
-	return tc.client.Handler
+	webhook := tc.webhook()
+	if webhook == nil {
+		return http.NotFound
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(logging.With(r.Context(),
+			slog.String("eventsub_message_id", r.Header.Get("Twitch-Eventsub-Message-Id")),
+			slog.String("eventsub_message_type", r.Header.Get("Twitch-Eventsub-Message-Type")),
+		))
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		webhook.ServeHTTP(ww, r)
+		metrics.EventSubRequests.WithLabelValues(r.Header.Get("Twitch-Eventsub-Message-Type"), strconv.Itoa(ww.Status())).Inc()
+	}
 }
@@ -0,0 +1,92 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/LinneB/twitchwh"
+)
+
+const subscriptionsEndpoint = "/eventsub/subscriptions"
+
+// EventSubTransport is where Twitch sends the notifications of a subscription or a conduit shard
+type EventSubTransport struct {
+	Method    string `json:"method"` // webhook, websocket or conduit, shards can't be conduits
+	Callback  string `json:"callback,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	ConduitID string `json:"conduit_id,omitempty"`
+}
+
+// EventSubSubscription is a subscription as returned by Helix, with the fields the app reads
+type EventSubSubscription struct {
+	ID        string             `json:"id"`
+	Status    string             `json:"status"`
+	Type      string             `json:"type"`
+	Condition twitchwh.Condition `json:"condition"`
+	Transport EventSubTransport  `json:"transport"`
+}
+
+// GetEventSubSubscriptions returns a page of the app's subscriptions and the cursor of the next one, empty on the last page
+func (a *API) GetEventSubSubscriptions(ctx context.Context, after string) ([]EventSubSubscription, string, error) {
+	query := url.Values{}
+	if after != "" {
+		query.Set("after", after)
+	}
+
+	req, err := a.appRequest(ctx, http.MethodGet, subscriptionsEndpoint, query, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var body struct {
+		Data       []EventSubSubscription `json:"data"`
+		Pagination struct {
+			Cursor string `json:"cursor"`
+		} `json:"pagination"`
+	}
+	if err := a.do(req, subscriptionsEndpoint, &body); err != nil {
+		return nil, "", fmt.Errorf("error listing subscriptions: %w", err)
+	}
+	return body.Data, body.Pagination.Cursor, nil
+}
+
+// CreateEventSubSubscription subscribes to the event. Webhook and conduit subscriptions take the app access token,
+// WebSocket ones the token of the user the condition is about. An APIError with status 409 means it already exists.
+func (a *API) CreateEventSubSubscription(ctx context.Context, accessToken, event string, condition twitchwh.Condition, transport EventSubTransport) error {
+	payload, err := json.Marshal(map[string]any{
+		"type":      event,
+		"version":   "1",
+		"condition": condition,
+		"transport": transport,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding the subscription: %w", err)
+	}
+
+	req, err := a.helixRequest(ctx, http.MethodPost, accessToken, subscriptionsEndpoint, nil, payload)
+	if err != nil {
+		return err
+	}
+
+	if err := a.do(req, subscriptionsEndpoint, nil); err != nil {
+		return fmt.Errorf("error creating subscription: %w", err)
+	}
+	return nil
+}
+
+// DeleteEventSubSubscription removes one of the app's subscriptions
+func (a *API) DeleteEventSubSubscription(ctx context.Context, id string) error {
+	req, err := a.appRequest(ctx, http.MethodDelete, subscriptionsEndpoint, url.Values{"id": {id}}, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := a.do(req, subscriptionsEndpoint, nil); err != nil {
+		return fmt.Errorf("error removing subscription: %w", err)
+	}
+	return nil
+}
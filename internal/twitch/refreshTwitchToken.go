@@ -1,14 +1,17 @@
 package twitch
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+
+	"github.com/gamis65/twitch-points/internal/metrics"
 )
 
+var errRefreshRejected = errors.New("twitch rejected the refresh token")
+
 type TwitchTokenResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
@@ -16,38 +19,35 @@ type TwitchTokenResponse struct {
 	TokenType    string `json:"token_type"`
 }
 
-func GetRefreshTwitchToken(refreshToken, clientID, clientSecret string) (TwitchTokenResponse, error) {
-	endpoint := "https://id.twitch.tv/oauth2/token"
-
-	data := url.Values{}
-	data.Set("grant_type", "refresh_token")
-	data.Set("refresh_token", refreshToken)
-	data.Set("client_id", clientID)
-	data.Set("client_secret", clientSecret)
-
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBufferString(data.Encode()))
-	if err != nil {
-		return TwitchTokenResponse{}, fmt.Errorf("error creating request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+// RefreshToken trades a refresh token for a new pair of tokens
+func (a *API) RefreshToken(ctx context.Context, refreshToken string) (TwitchTokenResponse, error) {
+	tokenResponse, err := a.refreshToken(ctx, refreshToken)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return TwitchTokenResponse{}, fmt.Errorf("error sending request: %w", err)
+	outcome := metrics.Outcome(err)
+	if errors.Is(err, errRefreshRejected) {
+		outcome = "rejected"
 	}
-	defer resp.Body.Close()
+	metrics.TokenRefreshes.WithLabelValues(outcome).Inc()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return TwitchTokenResponse{}, fmt.Errorf("error reading response body: %w", err)
-	}
+	return tokenResponse, err
+}
 
+func (a *API) refreshToken(ctx context.Context, refreshToken string) (TwitchTokenResponse, error) {
 	var tokenResponse TwitchTokenResponse
-	err = json.Unmarshal(body, &tokenResponse)
+	err := a.postForm(ctx, "/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {a.clientID},
+		"client_secret": {a.clientSecret},
+	}, &tokenResponse)
+
+	// An expired or revoked refresh token comes back as a 400 with an error message instead of tokens
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest {
+		return TwitchTokenResponse{}, fmt.Errorf("%w: %w", errRefreshRejected, err)
+	}
 	if err != nil {
-		return TwitchTokenResponse{}, fmt.Errorf("error unmarshalling JSON: %w", err)
+		return TwitchTokenResponse{}, err
 	}
 
 	return tokenResponse, nil
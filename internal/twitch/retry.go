@@ -0,0 +1,259 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	maxRetries       = 3
+	defaultTimeout   = 10 * time.Second
+	baseBackoff      = 250 * time.Millisecond
+	maxBackoff       = 4 * time.Second
+	maxRateLimitWait = 10 * time.Second // longer waits fail with ErrRateLimited instead of holding up the caller
+	maxBuckets       = 1000
+)
+
+// rateLimitBucket is what Helix last reported about the points left to one token
+type rateLimitBucket struct {
+	remaining int
+	reset     time.Time
+	probing   chan struct{} // closed once the first request of a new token finds out its points
+}
+
+// retryTransport tracks the Helix rate limit of every token from the Ratelimit-* headers and waits for the
+// reset before a token runs dry. Rate limited requests are retried after the reset, server errors and
+// network failures with an exponential backoff, but only for idempotent methods so a chat message isn't sent twice.
+type retryTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration // per attempt
+
+	mu      sync.Mutex
+	buckets map[string]*rateLimitBucket // by Authorization header, app and user tokens have separate limits
+}
+
+func newRetryTransport(next http.RoundTripper, timeout time.Duration) *retryTransport {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &retryTransport{
+		next:    next,
+		timeout: timeout,
+		buckets: make(map[string]*rateLimitBucket),
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.Header.Get("Authorization")
+	// Requests without a token, like the OAuth ones, don't spend Helix points
+	limited := key != ""
+
+	for attempt := 0; ; attempt++ {
+		if limited {
+			if err := t.waitForPoints(req.Context(), key); err != nil {
+				return nil, err
+			}
+		}
+
+		attemptReq, cancel, err := t.attemptRequest(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.next.RoundTrip(attemptReq)
+		var header http.Header
+		if err == nil {
+			header = resp.Header
+		}
+		if limited {
+			t.update(key, header)
+		}
+
+		delay, retry := t.retryDelay(req, resp, err, attempt)
+		if !retry {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		cancel()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// attemptRequest copies the request with a fresh body and the per attempt timeout
+func (t *retryTransport) attemptRequest(req *http.Request, attempt int) (*http.Request, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	attemptReq := req.Clone(ctx)
+
+	if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, nil, fmt.Errorf("error rewinding request body: %w", err)
+		}
+		attemptReq.Body = body
+	}
+
+	return attemptReq, cancel, nil
+}
+
+// retryDelay decides whether an attempt is worth repeating and how long to wait before it
+func (t *retryTransport) retryDelay(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if attempt >= maxRetries || req.Context().Err() != nil {
+		return 0, false
+	}
+	// A body that can't be sent again can't be retried
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return 0, false
+	}
+
+	switch {
+	case err != nil:
+		return backoff(attempt), isIdempotent(req.Method)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		// Twitch didn't act on the request, so any method can be sent again
+		wait := time.Until(resetTime(resp.Header))
+		if wait > maxRateLimitWait {
+			return 0, false
+		}
+		return max(wait, backoff(attempt)), true
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return backoff(attempt), isIdempotent(req.Method)
+	}
+
+	return 0, false
+}
+
+// waitForPoints blocks until the token has points left, or fails if the reset is too far away.
+// The points of a token seen for the first time are unknown, so only one request goes out until Helix reports them.
+func (t *retryTransport) waitForPoints(ctx context.Context, key string) error {
+	t.mu.Lock()
+	bucket, ok := t.buckets[key]
+	for ok && bucket.probing != nil {
+		probing := bucket.probing
+		t.mu.Unlock()
+		select {
+		case <-probing:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		t.mu.Lock()
+		bucket, ok = t.buckets[key]
+	}
+
+	var wait time.Duration
+	switch {
+	case !ok:
+		t.buckets[key] = &rateLimitBucket{probing: make(chan struct{})}
+	case bucket.remaining > 0:
+		// Concurrent requests share the points until the next answer reports the real count
+		bucket.remaining--
+	default:
+		wait = time.Until(bucket.reset)
+	}
+	t.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	if wait > maxRateLimitWait {
+		return fmt.Errorf("%w, the points reset in %s", ErrRateLimited, wait.Round(time.Second))
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// update records the rate limit Helix reported for the token, header is nil when the request failed.
+// Requests waiting for the first answer of a new token are let go either way, without a count the next one probes again.
+func (t *retryTransport) update(key string, header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("Ratelimit-Remaining"))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket, ok := t.buckets[key]
+	if ok && bucket.probing != nil {
+		close(bucket.probing)
+		if err != nil {
+			delete(t.buckets, key)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	// Tokens get refreshed, so the buckets of old ones are dropped once they reset
+	if len(t.buckets) >= maxBuckets {
+		now := time.Now()
+		for k, b := range t.buckets {
+			if b.probing == nil && b.reset.Before(now) {
+				delete(t.buckets, k)
+			}
+		}
+	}
+
+	t.buckets[key] = &rateLimitBucket{remaining: remaining, reset: resetTime(header)}
+}
+
+// resetTime reads the Ratelimit-Reset header, a Unix timestamp of when the bucket is full again
+func resetTime(header http.Header) time.Time {
+	reset, err := strconv.ParseInt(header.Get("Ratelimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(reset, 0)
+}
+
+// backoff doubles the wait with every attempt and adds up to 50% jitter
+func backoff(attempt int) time.Duration {
+	wait := min(baseBackoff<<attempt, maxBackoff)
+	return wait + rand.N(wait/2)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// cancelOnClose ends the attempt's timeout once the caller is done reading the body
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,277 @@
+package twitch
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeHelix is a Helix stand-in that answers every request with the next of its responses
+type fakeHelix struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	responses []func(w http.ResponseWriter)
+	bodies    []string
+}
+
+func newFakeHelix(t *testing.T, responses ...func(w http.ResponseWriter)) *fakeHelix {
+	h := &fakeHelix{responses: responses}
+	h.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		h.mu.Lock()
+		h.bodies = append(h.bodies, string(body))
+		respond := h.responses[min(len(h.bodies), len(h.responses))-1]
+		h.mu.Unlock()
+
+		respond(w)
+	}))
+	t.Cleanup(h.server.Close)
+	return h
+}
+
+func (h *fakeHelix) requests() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.bodies...)
+}
+
+func status(code int) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.WriteHeader(code)
+	}
+}
+
+func rateLimited(remaining int, reset time.Time) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Ratelimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("Ratelimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+}
+
+func (h *fakeHelix) send(t *testing.T, client *http.Client, method, body string) (*http.Response, error) {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, h.server.URL, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+
+	resp, err := client.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func newRetryClient() *http.Client {
+	return &http.Client{Transport: newRetryTransport(http.DefaultTransport, time.Second)}
+}
+
+func TestRetryRateLimited(t *testing.T) {
+	reset := time.Now().Add(time.Second).Truncate(time.Second)
+	h := newFakeHelix(t, rateLimited(0, reset), status(http.StatusOK))
+
+	start := time.Now()
+	resp, err := h.send(t, newRetryClient(), http.MethodGet, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 after the reset, got %d", resp.StatusCode)
+	}
+	if got := len(h.requests()); got != 2 {
+		t.Fatalf("expected 2 attempts, got %d", got)
+	}
+	if time.Now().Before(reset) {
+		t.Fatalf("retried %s after the first attempt, before the reset", time.Since(start))
+	}
+}
+
+func TestRetryRateLimitTooFarAway(t *testing.T) {
+	h := newFakeHelix(t, rateLimited(0, time.Now().Add(maxRateLimitWait+time.Minute)))
+	client := newRetryClient()
+
+	resp, err := h.send(t, client, http.MethodGet, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the 429 to be returned, got %d", resp.StatusCode)
+	}
+	if got := len(h.requests()); got != 1 {
+		t.Fatalf("expected 1 attempt, got %d", got)
+	}
+
+	// The token has no points left until the reset, so the next request doesn't go out
+	if _, err := h.send(t, client, http.MethodGet, ""); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if got := len(h.requests()); got != 1 {
+		t.Fatalf("expected no new attempt, got %d", got)
+	}
+}
+
+func TestRetryServerErrors(t *testing.T) {
+	tests := []struct {
+		method   string
+		status   int
+		attempts int
+	}{
+		{http.MethodGet, http.StatusOK, 2},
+		{http.MethodDelete, http.StatusOK, 2},
+		// A POST may have been acted on, a chat message must not be sent twice
+		{http.MethodPost, http.StatusBadGateway, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			h := newFakeHelix(t, status(http.StatusBadGateway), status(http.StatusOK))
+
+			resp, err := h.send(t, newRetryClient(), tt.method, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, resp.StatusCode)
+			}
+			if got := len(h.requests()); got != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, got)
+			}
+		})
+	}
+}
+
+func TestRetryRewindsBody(t *testing.T) {
+	h := newFakeHelix(t, rateLimited(0, time.Now()), status(http.StatusOK))
+
+	resp, err := h.send(t, newRetryClient(), http.MethodPost, `{"message":"hi"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	bodies := h.requests()
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(bodies))
+	}
+	for i, body := range bodies {
+		if body != `{"message":"hi"}` {
+			t.Errorf("attempt %d sent body %q", i+1, body)
+		}
+	}
+}
+
+func TestRetryNewTokenProbes(t *testing.T) {
+	release := make(chan struct{})
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if received.Add(1) == 1 {
+			<-release
+		}
+		w.Header().Set("Ratelimit-Remaining", "5")
+		w.Header().Set("Ratelimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+	}))
+	t.Cleanup(server.Close)
+
+	client := newRetryClient()
+	get := func() {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req.Header.Set("Authorization", "Bearer token")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		get()
+	}()
+	for received.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get()
+		}()
+	}
+
+	// The other requests wait until the first one reports how many points the token has
+	time.Sleep(100 * time.Millisecond)
+	if got := received.Load(); got != 1 {
+		t.Errorf("expected only the first request to go out, got %d", got)
+	}
+
+	close(release)
+	wg.Wait()
+	if got := received.Load(); got != 4 {
+		t.Fatalf("expected 4 requests, got %d", got)
+	}
+}
+
+// Requests without a token, like the OAuth ones, have no points to find out, none of them waits for another
+func TestRetryWithoutTokenNotHeld(t *testing.T) {
+	release := make(chan struct{})
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		<-release
+	}))
+	t.Cleanup(server.Close)
+
+	client := newRetryClient()
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Post(server.URL, "application/x-www-form-urlencoded", strings.NewReader("grant_type=client_credentials"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for received.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	got := received.Load()
+	close(release)
+	wg.Wait()
+
+	if got != 3 {
+		t.Fatalf("got %d requests while the first was held, want all 3", got)
+	}
+}
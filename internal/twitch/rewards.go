@@ -0,0 +1,152 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gamis65/twitch-points/internal/db"
+)
+
+// Reward states, stored in rewards.status
+const (
+	RewardActive  = "active"
+	RewardRetired = "retired" // Replaced by a newer reward, its redemptions still count for the giveaway it's linked to
+)
+
+const customRewardsEndpoint = "/channel_points/custom_rewards"
+
+// HasChannelPoints reports whether the streamer's channel has channel points, only affiliates and partners have them.
+// Streamers who haven't signed in since the broadcaster type was recorded are assumed to have them.
+func HasChannelPoints(streamer db.Streamer) bool {
+	return !streamer.BroadcasterType.Valid || streamer.BroadcasterType.String != ""
+}
+
+// needsChannelPoints reports whether Twitch rejects subscriptions to the event on channels without channel points
+func needsChannelPoints(event string) bool {
+	return strings.HasPrefix(event, "channel.channel_points_")
+}
+
+// CustomReward is a channel point reward as returned by Helix, with the fields the app reads
+type CustomReward struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Cost      int    `json:"cost"`
+	IsEnabled bool   `json:"is_enabled"` // Disabled rewards are hidden from viewers
+	IsPaused  bool   `json:"is_paused"`  // Paused rewards are shown but can't be redeemed
+}
+
+// CustomRewardUpdate is the settings UpdateCustomReward changes, the nil ones are left as they are
+type CustomRewardUpdate struct {
+	Title     *string `json:"title,omitempty"`
+	IsEnabled *bool   `json:"is_enabled,omitempty"`
+	IsPaused  *bool   `json:"is_paused,omitempty"`
+}
+
+type customRewardsResponse struct {
+	Data []CustomReward `json:"data"`
+}
+
+// RemoveCustomReward takes a reward off the broadcaster's channel so viewers can't spend points on it anymore.
+// The reward is deleted, or disabled when Twitch won't delete it. A reward that is already gone counts as removed.
+// Deleting marks the redemptions still in its queue as fulfilled, disabling keeps them.
+func (a *API) RemoveCustomReward(ctx context.Context, accessToken, broadcasterID, rewardID string) error {
+	err := a.DeleteCustomReward(ctx, accessToken, broadcasterID, rewardID)
+	if err == nil || IsNotFound(err) {
+		return nil
+	}
+
+	if disableErr := a.DisableCustomReward(ctx, accessToken, broadcasterID, rewardID); disableErr != nil {
+		return fmt.Errorf("error deleting the reward: %w, and disabling it: %w", err, disableErr)
+	}
+	return nil
+}
+
+// DeleteCustomReward deletes a reward, only the app that created it can
+func (a *API) DeleteCustomReward(ctx context.Context, accessToken, broadcasterID, rewardID string) error {
+	req, err := a.customRewardRequest(ctx, http.MethodDelete, accessToken, broadcasterID, rewardID, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := a.do(req, customRewardsEndpoint, nil); err != nil {
+		return fmt.Errorf("error deleting reward: %w", err)
+	}
+	return nil
+}
+
+// RenameCustomReward changes the title of a reward, an APIError with status 404 when it doesn't exist
+func (a *API) RenameCustomReward(ctx context.Context, accessToken, broadcasterID, rewardID, title string) error {
+	if _, err := a.UpdateCustomReward(ctx, accessToken, broadcasterID, rewardID, CustomRewardUpdate{Title: &title}); err != nil {
+		return fmt.Errorf("error renaming reward: %w", err)
+	}
+	return nil
+}
+
+// DisableCustomReward hides a reward from viewers, it stays on the channel
+func (a *API) DisableCustomReward(ctx context.Context, accessToken, broadcasterID, rewardID string) error {
+	disabled := false
+	if _, err := a.UpdateCustomReward(ctx, accessToken, broadcasterID, rewardID, CustomRewardUpdate{IsEnabled: &disabled}); err != nil {
+		return fmt.Errorf("error disabling reward: %w", err)
+	}
+	return nil
+}
+
+// GetCustomReward returns a reward of the broadcaster's channel, an APIError with status 404 when it doesn't exist
+func (a *API) GetCustomReward(ctx context.Context, accessToken, broadcasterID, rewardID string) (CustomReward, error) {
+	req, err := a.customRewardRequest(ctx, http.MethodGet, accessToken, broadcasterID, rewardID, nil)
+	if err != nil {
+		return CustomReward{}, err
+	}
+
+	var body customRewardsResponse
+	if err := a.do(req, customRewardsEndpoint, &body); err != nil {
+		return CustomReward{}, fmt.Errorf("error getting reward: %w", err)
+	}
+
+	if len(body.Data) == 0 {
+		return CustomReward{}, &APIError{Endpoint: customRewardsEndpoint, StatusCode: http.StatusNotFound, Message: "reward not found"}
+	}
+	return body.Data[0], nil
+}
+
+// UpdateCustomReward changes the settings of a reward and returns it, only the app that created it can
+func (a *API) UpdateCustomReward(ctx context.Context, accessToken, broadcasterID, rewardID string, update CustomRewardUpdate) (CustomReward, error) {
+	payload, err := json.Marshal(update)
+	if err != nil {
+		return CustomReward{}, fmt.Errorf("error encoding the update: %w", err)
+	}
+
+	req, err := a.customRewardRequest(ctx, http.MethodPatch, accessToken, broadcasterID, rewardID, payload)
+	if err != nil {
+		return CustomReward{}, err
+	}
+
+	var body customRewardsResponse
+	if err := a.do(req, customRewardsEndpoint, &body); err != nil {
+		return CustomReward{}, fmt.Errorf("error updating reward: %w", err)
+	}
+
+	if len(body.Data) == 0 {
+		return CustomReward{}, errors.New("error updating reward: no reward in the response")
+	}
+	return body.Data[0], nil
+}
+
+func (a *API) customRewardRequest(ctx context.Context, method, accessToken, broadcasterID, rewardID string, body []byte) (*http.Request, error) {
+	query := url.Values{}
+	query.Set("broadcaster_id", broadcasterID)
+	query.Set("id", rewardID)
+
+	return a.helixRequest(ctx, method, accessToken, customRewardsEndpoint, query, body)
+}
+
+// IsNotFound reports whether Twitch answered that the resource doesn't exist
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
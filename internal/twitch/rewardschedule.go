@@ -0,0 +1,190 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/logging"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// rewardAccepting reports whether viewers should be able to redeem the streamer's reward at now.
+// A giveaway has to be open and inside its schedule, and the streamer live if they only want entries while live.
+func rewardAccepting(giveaway *db.Giveaway, streamer db.Streamer, now time.Time) bool {
+	if giveaway == nil {
+		return false
+	}
+	if giveaway.OpensAt.Valid && now.Before(giveaway.OpensAt.Time) {
+		return false
+	}
+	if giveaway.ClosesAt.Valid && !now.Before(giveaway.ClosesAt.Time) {
+		return false
+	}
+	return !streamer.RewardLiveOnly || streamer.IsLive.Bool
+}
+
+// nextScheduleChange returns the next opening or closing time of the giveaway after now, zero when there is none
+func nextScheduleChange(giveaway *db.Giveaway, now time.Time) time.Time {
+	var next time.Time
+	if giveaway == nil {
+		return next
+	}
+
+	for _, at := range []pgtype.Timestamptz{giveaway.OpensAt, giveaway.ClosesAt} {
+		if at.Valid && at.Time.After(now) && (next.IsZero() || at.Time.Before(next)) {
+			next = at.Time
+		}
+	}
+	return next
+}
+
+// rewardScheduleChannel wakes RunRewardSchedule up on whichever replica runs it
+const rewardScheduleChannel = "reward_schedule"
+
+// WakeRewardSchedule makes RunRewardSchedule reconcile the rewards now, call it when something they depend on changed.
+// It may run on another replica, a wake that doesn't reach it is caught up on its next periodic run.
+func (tc *TwitchWebhookClient) WakeRewardSchedule(ctx context.Context) {
+	tc.wakeRewardSchedule()
+
+	if err := tc.db.Publish(ctx, rewardScheduleChannel, ""); err != nil {
+		logging.FromContext(ctx).Warn("Error waking the reward schedule up on the other replicas", "error", err)
+	}
+}
+
+func (tc *TwitchWebhookClient) wakeRewardSchedule() {
+	select {
+	case tc.rewardWake <- struct{}{}:
+	default:
+		// A reconcile is already due
+	}
+}
+
+// RunRewardSchedule resumes the streamers' rewards while the giveaway takes entries and pauses them otherwise.
+// It reconciles every interval, at the giveaway's opening and closing times and when woken up,
+// so a reward changed by hand on Twitch is put back too.
+func (tc *TwitchWebhookClient) RunRewardSchedule(ctx context.Context, interval time.Duration) {
+	listening := make(chan struct{})
+	go func() {
+		defer close(listening)
+		tc.listenForRewardWakes(ctx)
+	}()
+	defer func() { <-listening }()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-tc.rewardWake:
+		}
+
+		next, err := tc.ReconcileRewards(ctx)
+		if err != nil {
+			logging.FromContext(ctx).Error("Error reconciling the rewards", "error", err)
+		}
+
+		wait := interval
+		if !next.IsZero() {
+			wait = min(wait, time.Until(next))
+		}
+		timer.Reset(wait)
+	}
+}
+
+// listenForRewardWakes passes the wakes of the other replicas on to RunRewardSchedule until ctx is cancelled
+func (tc *TwitchWebhookClient) listenForRewardWakes(ctx context.Context) {
+	tc.listen(ctx, rewardScheduleChannel, func(string) { tc.wakeRewardSchedule() })
+}
+
+// ReconcileRewards pauses or resumes the active reward of every streamer on Twitch to match the open giveaway.
+// It returns when the giveaway's schedule changes next, zero when it doesn't.
+// A streamer whose reward can't be updated is logged and skipped.
+func (tc *TwitchWebhookClient) ReconcileRewards(ctx context.Context) (time.Time, error) {
+	now := time.Now()
+
+	var open *db.Giveaway
+	giveaway, err := tc.db.GetOpenGiveaway(ctx)
+	if err == nil {
+		open = &giveaway
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("error getting the open giveaway: %w", err)
+	}
+
+	streamers, err := tc.db.GetAllStreamersWithTokens(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error getting streamers: %w", err)
+	}
+
+	for _, streamer := range streamers {
+		logger := logging.FromContext(ctx).With(
+			slog.String("streamer_id", streamer.TwitchID),
+			slog.String("streamer_username", streamer.Username),
+		)
+
+		reward, err := tc.db.GetActiveRewardByStreamer(ctx, pgtype.Text{String: streamer.TwitchID, Valid: true})
+		if err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				logger.Error("Error getting the active reward", "error", err)
+			}
+			continue
+		}
+
+		accepting := rewardAccepting(open, streamer, now)
+		if err := tc.syncReward(ctx, logger, streamer, reward.RewardID, accepting); err != nil {
+			if ctx.Err() != nil {
+				return time.Time{}, ctx.Err()
+			}
+
+			if IsNotFound(err) {
+				logger.Warn("The active reward is gone from the channel", "reward_id", reward.RewardID)
+				continue
+			}
+			logger.Error("Error updating the reward on Twitch", "error", err, "reward_id", reward.RewardID)
+		}
+	}
+
+	return nextScheduleChange(open, now), nil
+}
+
+// syncReward makes the reward on Twitch enabled and redeemable when accepting is true and paused when it isn't.
+// Twitch is only asked to change it when it drifted.
+func (tc *TwitchWebhookClient) syncReward(ctx context.Context, logger *slog.Logger, streamer db.Streamer, rewardID string, accepting bool) error {
+	return WithStreamerToken(ctx, tc.db, tc.api, streamer, func(accessToken string) error {
+		current, err := tc.api.GetCustomReward(ctx, accessToken, streamer.TwitchID, rewardID)
+		if err != nil {
+			return err
+		}
+
+		var update CustomRewardUpdate
+		paused := !accepting
+		if current.IsPaused != paused {
+			update.IsPaused = &paused
+		}
+		// A paused reward stays visible so viewers see it comes back, a resumed one has to be enabled to be redeemed
+		if accepting && !current.IsEnabled {
+			update.IsEnabled = &accepting
+		}
+		if update.IsPaused == nil && update.IsEnabled == nil {
+			return nil
+		}
+
+		if _, err := tc.api.UpdateCustomReward(ctx, accessToken, streamer.TwitchID, rewardID, update); err != nil {
+			return err
+		}
+
+		if accepting {
+			logger.Info("Resumed the reward", "reward_id", rewardID)
+		} else {
+			logger.Info("Paused the reward", "reward_id", rewardID)
+		}
+		return nil
+	})
+}
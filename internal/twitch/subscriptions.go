@@ -0,0 +1,167 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/gamis65/twitch-points/internal/logging"
+)
+
+// Subscription statuses that still deliver events, anything else was revoked or failed
+const (
+	subscriptionEnabled             = "enabled"
+	subscriptionVerificationPending = "webhook_callback_verification_pending"
+)
+
+type ReconcileResult struct {
+	Expected int `json:"expected"`
+	Existing int `json:"existing"`
+	Created  int `json:"created"`
+	Removed  int `json:"removed"`
+	Errors   int `json:"errors"`
+}
+
+type subscriptionKey struct {
+	event       string
+	broadcaster string
+}
+
+// ReconcileSubscriptions makes the EventSub subscriptions on our callback or conduit match the streamers in the database.
+// Failed or revoked subscriptions and the ones of removed streamers are deleted, missing ones are created.
+// Twitch verifies new webhook subscriptions against the callback, so the server has to be up to answer it.
+// With a conduit the per-streamer subscriptions on our callback are deleted too, which moves an existing
+// deployment to the conduit. Missing subscriptions are created before any are deleted so no notification is missed.
+// WebSocket subscriptions end with their session, so there is nothing to reconcile with that transport.
+func (tc *TwitchWebhookClient) ReconcileSubscriptions(ctx context.Context, dryRun bool) (*ReconcileResult, error) {
+	conduit, onConduit := tc.transport.(*conduitTransport)
+	if _, ok := tc.transport.(*webhookTransport); !ok && !onConduit {
+		return nil, errors.New("only webhook and conduit subscriptions can be reconciled")
+	}
+
+	var conduitID string
+	if onConduit {
+		found, err := conduit.ensureConduit(ctx, !dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("error getting the conduit: %w", err)
+		}
+		conduitID = found.ID
+	}
+
+	streamers, err := tc.db.GetAllStreamersWithTokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting streamers: %w", err)
+	}
+
+	expected := make(map[subscriptionKey]bool)
+	for _, streamer := range streamers {
+		for _, event := range tc.streamerEvents(streamer) {
+			expected[subscriptionKey{event, streamer.TwitchID}] = true
+		}
+	}
+
+	result := &ReconcileResult{Expected: len(expected)}
+	healthy := make(map[subscriptionKey]bool)
+	var stale []EventSubSubscription
+
+	cursor := ""
+	for {
+		subscriptions, next, err := tc.api.GetEventSubSubscriptions(ctx, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, subscription := range subscriptions {
+			onCallback := subscription.Transport.Method == "webhook" && subscription.Transport.Callback == tc.webhookURL
+			ours := onCallback
+			if onConduit {
+				ours = conduitID != "" && subscription.Transport.Method == "conduit" && subscription.Transport.ConduitID == conduitID
+			}
+
+			// Subscriptions of other deployments sharing the client ID aren't ours to touch
+			if !ours && !onCallback {
+				continue
+			}
+			result.Existing++
+
+			key := subscriptionKey{subscription.Type, subscription.Condition.BroadcasterUserID}
+			isHealthy := subscription.Status == subscriptionEnabled || subscription.Status == subscriptionVerificationPending
+
+			if ours && expected[key] && isHealthy && !healthy[key] {
+				healthy[key] = true
+				continue
+			}
+			stale = append(stale, subscription)
+		}
+
+		cursor = next
+		if cursor == "" {
+			break
+		}
+	}
+
+	for key := range expected {
+		if healthy[key] {
+			continue
+		}
+
+		logger := logging.FromContext(ctx).With(
+			slog.String("event", key.event),
+			slog.String("streamer_id", key.broadcaster),
+		)
+		logger.Info("Creating missing subscription", "dry_run", dryRun)
+
+		if dryRun {
+			result.Created++
+			continue
+		}
+
+		if err := tc.createSubscription(ctx, key, conduitID); err != nil {
+			logger.Error("Error creating subscription", "error", err)
+			result.Errors++
+			continue
+		}
+		result.Created++
+	}
+
+	for _, subscription := range stale {
+		logger := logging.FromContext(ctx).With(
+			slog.String("subscription_id", subscription.ID),
+			slog.String("event", subscription.Type),
+			slog.String("streamer_id", subscription.Condition.BroadcasterUserID),
+			slog.String("status", subscription.Status),
+			slog.String("transport", subscription.Transport.Method),
+		)
+		logger.Info("Removing subscription", "dry_run", dryRun)
+
+		if dryRun {
+			result.Removed++
+			continue
+		}
+
+		if err := tc.api.DeleteEventSubSubscription(ctx, subscription.ID); err != nil {
+			logger.Error("Error removing subscription", "error", err)
+			result.Errors++
+			continue
+		}
+		result.Removed++
+	}
+
+	return result, nil
+}
+
+// createSubscription creates a subscription on the conduit, or on our callback when conduitID is empty
+func (tc *TwitchWebhookClient) createSubscription(ctx context.Context, key subscriptionKey, conduitID string) error {
+	token, err := tc.api.getAppToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	transport := EventSubTransport{Method: "webhook", Callback: tc.webhookURL, Secret: tc.webhookSecret}
+	if conduitID != "" {
+		transport = EventSubTransport{Method: "conduit", ConduitID: conduitID}
+	}
+
+	return tc.api.CreateEventSubSubscription(ctx, token, key.event, subscriptionCondition(key.event, key.broadcaster), transport)
+}
@@ -0,0 +1,49 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// WithStreamerToken calls fn with the streamer's access token.
+// If Twitch rejects the token it gets refreshed, saved and fn is retried once.
+// Another replica may have refreshed it already, which invalidates the refresh token we hold,
+// so the stored tokens are used when they changed.
+func WithStreamerToken(ctx context.Context, dbStore db.StreamerStore, api *API, streamer db.Streamer, fn func(accessToken string) error) error {
+	err := fn(streamer.AccessToken.String)
+	if !errors.Is(err, ErrTokenRejected) {
+		return err
+	}
+
+	stored, err := dbStore.GetStreamerByID(ctx, streamer.TwitchID)
+	if err != nil {
+		return fmt.Errorf("error getting the stored token: %w", err)
+	}
+	if stored.AccessToken.Valid && stored.AccessToken.String != streamer.AccessToken.String {
+		return fn(stored.AccessToken.String)
+	}
+
+	newToken, err := api.RefreshToken(ctx, stored.RefreshToken.String)
+	if err != nil {
+		return fmt.Errorf("error refreshing token: %w", err)
+	}
+
+	if newToken.AccessToken == "" {
+		return fmt.Errorf("twitch didn't return a new access token")
+	}
+
+	_, err = dbStore.UpdateStreamerTokens(ctx, db.UpdateStreamerTokensParams{
+		TwitchID:     streamer.TwitchID,
+		AccessToken:  pgtype.Text{String: newToken.AccessToken, Valid: true},
+		RefreshToken: pgtype.Text{String: newToken.RefreshToken, Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("error saving refreshed token: %w", err)
+	}
+
+	return fn(newToken.AccessToken)
+}
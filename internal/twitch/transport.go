@@ -0,0 +1,260 @@
+package twitch
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gamis65/twitch-points/internal/config"
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errNoSession is returned when subscribing through a transport that has no WebSocket session or conduit in this replica
+var errNoSession = errors.New("no EventSub WebSocket session")
+
+// Transport is how EventSub notifications reach the app, subscriptions are created for one transport
+type Transport interface {
+	// On registers the handler of a subscription type, it runs in a goroutine of its own for every notification
+	On(eventType string, handler func(ctx context.Context, event json.RawMessage))
+	// Subscribe creates the subscription to the event on the streamer's channel
+	Subscribe(ctx context.Context, streamer db.Streamer, event string) error
+	// Run keeps the transport up until ctx is cancelled, calling ready once it can take subscriptions.
+	// ready is called again when the subscriptions were lost and have to be created again.
+	Run(ctx context.Context, ready func(ctx context.Context))
+}
+
+// handlerGroup runs the event handlers of the transports in goroutines of their own.
+// A handler is counted before the notification is acknowledged, so Wait can't miss one Twitch was told is handled.
+type handlerGroup struct {
+	wg sync.WaitGroup
+}
+
+// start counts the handler and runs it in a goroutine of its own
+func (g *handlerGroup) start(ctx context.Context, handler func(ctx context.Context, event json.RawMessage), event json.RawMessage) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		handler(ctx, event)
+	}()
+}
+
+// Wait returns once every handler started so far returned, or with the error of ctx when it is done first
+func (g *handlerGroup) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// seenMessages remembers the IDs of recent notifications, Twitch may send a notification more than once
+type seenMessages struct {
+	mu    sync.Mutex
+	ids   map[string]time.Time
+	order []seenMessage // the IDs in ids, oldest first, so expired ones are dropped without going through them all
+}
+
+type seenMessage struct {
+	id     string
+	seenAt time.Time
+}
+
+// first records the message ID and reports whether it is the first time it was seen
+func (s *seenMessages) first(messageID string) bool {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ids == nil {
+		s.ids = make(map[string]time.Time)
+	}
+
+	expired := 0
+	for expired < len(s.order) && now.Sub(s.order[expired].seenAt) > seenMessagesTTL {
+		delete(s.ids, s.order[expired].id)
+		expired++
+	}
+	clear(s.order[:expired])
+	s.order = s.order[expired:]
+
+	if _, ok := s.ids[messageID]; ok {
+		return false
+	}
+	s.ids[messageID] = now
+	s.order = append(s.order, seenMessage{id: messageID, seenAt: now})
+	return true
+}
+
+// Webhook message types, sent in the Twitch-Eventsub-Message-Type header
+const (
+	webhookNotification = "notification"
+	webhookVerification = "webhook_callback_verification"
+	webhookRevocation   = "revocation"
+)
+
+// webhookTransport receives the messages Twitch posts to the callback. Every replica answers them,
+// including the challenges of subscriptions another replica created, so the load balancer can pick any.
+type webhookTransport struct {
+	api      *API
+	callback string
+	secret   string
+	handlers *handlerGroup
+
+	mu       sync.Mutex
+	handling map[string]func(ctx context.Context, event json.RawMessage)
+	seen     seenMessages
+}
+
+func newWebhookTransport(cfg config.Twitch, api *API, handlers *handlerGroup) *webhookTransport {
+	return &webhookTransport{
+		api:      api,
+		callback: cfg.WebhookURL,
+		secret:   cfg.WebhookSecret,
+		handlers: handlers,
+		handling: make(map[string]func(ctx context.Context, event json.RawMessage)),
+	}
+}
+
+func (t *webhookTransport) On(eventType string, handler func(ctx context.Context, event json.RawMessage)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handling[eventType] = handler
+}
+
+// Subscribe creates the subscription with the app access token. Twitch enables it once the callback answered
+// the challenge, which may reach any replica, so it doesn't wait for that.
+func (t *webhookTransport) Subscribe(ctx context.Context, streamer db.Streamer, event string) error {
+	token, err := t.api.getAppToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = t.api.CreateEventSubSubscription(ctx, token, event, subscriptionCondition(event, streamer.TwitchID),
+		EventSubTransport{Method: "webhook", Callback: t.callback, Secret: t.secret})
+
+	// Already subscribed
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+		return nil
+	}
+	return err
+}
+
+// Run only calls ready, the subscriptions outlive the process
+func (t *webhookTransport) Run(ctx context.Context, ready func(ctx context.Context)) {
+	ready(ctx)
+	<-ctx.Done()
+}
+
+// webhookMessage is the part of a message to the callback the webhook transport reads
+type webhookMessage struct {
+	Challenge    string `json:"challenge"`
+	Subscription struct {
+		ID     string `json:"id"`
+		Type   string `json:"type"`
+		Status string `json:"status"`
+	} `json:"subscription"`
+	Event json.RawMessage `json:"event"`
+}
+
+// ServeHTTP verifies and answers a request Twitch sent to the callback.
+// The handler of a notification is started before Twitch gets its answer.
+func (t *webhookTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	messageID := r.Header.Get("Twitch-Eventsub-Message-Id")
+	messageType := r.Header.Get("Twitch-Eventsub-Message-Type")
+
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(
+		attribute.String("eventsub.message_id", messageID),
+		attribute.String("eventsub.message_type", messageType),
+	)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !validSignature(t.secret, r, body) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var payload webhookMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(attribute.String("eventsub.subscription_type", payload.Subscription.Type))
+
+	logger := logging.FromContext(r.Context())
+	switch messageType {
+	case webhookNotification:
+		// Handled below
+	case webhookVerification:
+		logger.Info("Answering the EventSub challenge", "subscription_id", payload.Subscription.ID, "event", payload.Subscription.Type)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, payload.Challenge)
+		return
+	case webhookRevocation:
+		logger.Warn("Twitch revoked a subscription",
+			"subscription_id", payload.Subscription.ID,
+			"event", payload.Subscription.Type,
+			"status", payload.Subscription.Status,
+		)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		logger.Warn("Unknown EventSub webhook message", "message_type", messageType)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Twitch retries notifications it didn't get an answer for in time
+	if !t.seen.first(messageID) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	t.mu.Lock()
+	handler, ok := t.handling[payload.Subscription.Type]
+	t.mu.Unlock()
+
+	if !ok {
+		logger.Warn("No handler for event", "event", payload.Subscription.Type)
+	} else {
+		// The handler outlives the request, so it keeps the trace and the logger but not the cancellation
+		t.handlers.start(context.WithoutCancel(r.Context()), handler, payload.Event)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validSignature checks the HMAC Twitch signs every message to the callback with
+func validSignature(secret string, r *http.Request, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(r.Header.Get("Twitch-Eventsub-Message-Id") + r.Header.Get("Twitch-Eventsub-Message-Timestamp")))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("Twitch-Eventsub-Message-Signature")))
+}
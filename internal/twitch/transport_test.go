@@ -0,0 +1,49 @@
+package twitch
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSeenMessages(t *testing.T) {
+	var seen seenMessages
+
+	if !seen.first("message-1") {
+		t.Fatal("a new message was reported as seen")
+	}
+	if seen.first("message-1") {
+		t.Fatal("a message delivered again was reported as new")
+	}
+
+	// Backdate the first message past the TTL, the next notification forgets it
+	seen.mu.Lock()
+	old := time.Now().Add(-seenMessagesTTL - time.Second)
+	seen.ids["message-1"] = old
+	seen.order[0].seenAt = old
+	seen.mu.Unlock()
+
+	if !seen.first("message-2") {
+		t.Fatal("a new message was reported as seen")
+	}
+	if _, ok := seen.ids["message-1"]; ok || len(seen.order) != 1 {
+		t.Fatalf("got %d remembered IDs, want the expired one dropped", len(seen.order))
+	}
+	if !seen.first("message-1") {
+		t.Error("an expired message was still reported as seen")
+	}
+}
+
+func TestSeenMessagesKeepsRecent(t *testing.T) {
+	var seen seenMessages
+	for i := range 100 {
+		seen.first("message-" + strconv.Itoa(i))
+	}
+
+	if len(seen.ids) != 100 || len(seen.order) != 100 {
+		t.Fatalf("got %d IDs and %d in order, want every recent message remembered", len(seen.ids), len(seen.order))
+	}
+	if seen.first("message-0") {
+		t.Error("the oldest recent message was reported as new")
+	}
+}
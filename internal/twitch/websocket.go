@@ -0,0 +1,283 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/LinneB/twitchwh"
+	"github.com/coder/websocket"
+	"github.com/gamis65/twitch-points/internal/db"
+	"github.com/gamis65/twitch-points/internal/logging"
+)
+
+// EventSub WebSocket message types
+const (
+	messageSessionWelcome   = "session_welcome"
+	messageSessionKeepalive = "session_keepalive"
+	messageSessionReconnect = "session_reconnect"
+	messageNotification     = "notification"
+	messageRevocation       = "revocation"
+)
+
+const (
+	// welcomeTimeout bounds connecting and waiting for the welcome message, Twitch sends it right away
+	welcomeTimeout = 10 * time.Second
+	// keepaliveGrace is how long past the keepalive timeout a silent session is kept before it counts as lost
+	keepaliveGrace = 2 * time.Second
+	// sessionRetryDelay is the wait before trying again after connecting failed
+	sessionRetryDelay = 5 * time.Second
+	// seenMessagesTTL is how long message IDs are remembered, Twitch may send a notification more than once
+	seenMessagesTTL = 10 * time.Minute
+	// webSocketReadLimit is the largest message accepted, chat notifications can be a few KB
+	webSocketReadLimit = 1 << 20
+)
+
+type webSocketMessage struct {
+	Metadata struct {
+		MessageID   string `json:"message_id"`
+		MessageType string `json:"message_type"`
+	} `json:"metadata"`
+	Payload struct {
+		Session struct {
+			ID                      string `json:"id"`
+			KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+			ReconnectURL            string `json:"reconnect_url"`
+		} `json:"session"`
+		Subscription struct {
+			ID     string `json:"id"`
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"subscription"`
+		Event json.RawMessage `json:"event"`
+	} `json:"payload"`
+}
+
+// keepaliveTimeout is how long the session may stay silent, Twitch sends a keepalive when there is nothing else
+func (m *webSocketMessage) keepaliveTimeout() time.Duration {
+	seconds := m.Payload.Session.KeepaliveTimeoutSeconds
+	if seconds <= 0 {
+		seconds = 10
+	}
+	return time.Duration(seconds)*time.Second + keepaliveGrace
+}
+
+// webSocketTransport holds an EventSub WebSocket session, for hosts Twitch can't send webhooks to.
+// Its subscriptions only live as long as the session, so they are created again for every new one.
+type webSocketTransport struct {
+	url      string
+	api      *API
+	db       db.StreamerStore
+	handlers *handlerGroup
+
+	mu        sync.Mutex
+	handling  map[string]func(ctx context.Context, event json.RawMessage)
+	sessionID string
+	seen      seenMessages
+}
+
+func newWebSocketTransport(url string, api *API, dbStore db.StreamerStore, handlers *handlerGroup) *webSocketTransport {
+	return &webSocketTransport{
+		url:      url,
+		api:      api,
+		db:       dbStore,
+		handlers: handlers,
+		handling: make(map[string]func(ctx context.Context, event json.RawMessage)),
+	}
+}
+
+func (t *webSocketTransport) On(eventType string, handler func(ctx context.Context, event json.RawMessage)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handling[eventType] = handler
+}
+
+// Subscribe creates the subscription on the current session with the streamer's token, Twitch doesn't take
+// app tokens for WebSocket subscriptions. It returns errNoSession while there is no session.
+func (t *webSocketTransport) Subscribe(ctx context.Context, streamer db.Streamer, event string) error {
+	sessionID := t.session()
+	if sessionID == "" {
+		return errNoSession
+	}
+
+	err := WithStreamerToken(ctx, t.db, t.api, streamer, func(accessToken string) error {
+		return t.api.CreateWebSocketSubscription(ctx, accessToken, sessionID, event, subscriptionCondition(event, streamer.TwitchID))
+	})
+
+	// Already subscribed on this session
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+		return nil
+	}
+	return err
+}
+
+func (t *webSocketTransport) session() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sessionID
+}
+
+func (t *webSocketTransport) setSession(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sessionID = id
+}
+
+// Run holds a session until ctx is cancelled, starting a new one whenever it is lost.
+// A lost session is replaced right away, connecting again after a failed attempt waits a bit.
+func (t *webSocketTransport) Run(ctx context.Context, ready func(ctx context.Context)) {
+	for {
+		started, err := t.runSession(ctx, ready)
+		t.setSession("")
+		if ctx.Err() != nil {
+			return
+		}
+		if started {
+			logging.FromContext(ctx).Warn("EventSub WebSocket session lost, starting a new one", "error", err)
+			continue
+		}
+		logging.FromContext(ctx).Error("Error starting an EventSub WebSocket session", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sessionRetryDelay):
+		}
+	}
+}
+
+// runSession opens a session, has ready subscribe on it and reads it until it fails, started tells whether it opened.
+// A reconnect request moves the session to a new connection, which keeps its subscriptions.
+func (t *webSocketTransport) runSession(ctx context.Context, ready func(ctx context.Context)) (started bool, err error) {
+	conn, welcome, err := t.dial(ctx, t.url)
+	if err != nil {
+		return false, err
+	}
+	defer func() { conn.CloseNow() }()
+
+	logger := logging.FromContext(ctx).With(slog.String("eventsub_session_id", welcome.Payload.Session.ID))
+	logger.Info("EventSub WebSocket session started")
+	t.setSession(welcome.Payload.Session.ID)
+
+	// Twitch closes a session without subscriptions after a few seconds, so subscribing can't wait for reads
+	sessionCtx, cancel := context.WithCancel(ctx)
+	var subscribing sync.WaitGroup
+	subscribing.Add(1)
+	go func() {
+		defer subscribing.Done()
+		ready(sessionCtx)
+	}()
+	defer subscribing.Wait()
+	defer cancel()
+
+	keepalive := welcome.keepaliveTimeout()
+	for {
+		readCtx, cancelRead := context.WithTimeout(ctx, keepalive)
+		message, err := readMessage(readCtx, conn)
+		cancelRead()
+		if err != nil {
+			if ctx.Err() == nil && errors.Is(readCtx.Err(), context.DeadlineExceeded) {
+				return true, fmt.Errorf("nothing received within the keepalive timeout of %s", keepalive)
+			}
+			return true, err
+		}
+
+		switch message.Metadata.MessageType {
+		case messageNotification:
+			t.dispatch(ctx, message)
+		case messageSessionReconnect:
+			next, nextWelcome, err := t.dial(ctx, message.Payload.Session.ReconnectURL)
+			if err != nil {
+				return true, fmt.Errorf("error following the reconnect request: %w", err)
+			}
+			conn.Close(websocket.StatusNormalClosure, "")
+			conn = next
+			keepalive = nextWelcome.keepaliveTimeout()
+			t.setSession(nextWelcome.Payload.Session.ID)
+			logger.Info("EventSub WebSocket session moved to a new connection")
+		case messageRevocation:
+			logger.Warn("Twitch revoked a subscription",
+				"subscription_id", message.Payload.Subscription.ID,
+				"event", message.Payload.Subscription.Type,
+				"status", message.Payload.Subscription.Status,
+			)
+		case messageSessionKeepalive:
+		default:
+			logger.Warn("Unknown EventSub WebSocket message", "message_type", message.Metadata.MessageType)
+		}
+	}
+}
+
+// dial connects and waits for the welcome message
+func (t *webSocketTransport) dial(ctx context.Context, url string) (*websocket.Conn, *webSocketMessage, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, welcomeTimeout)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(dialCtx, url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to the EventSub WebSocket: %w", err)
+	}
+	conn.SetReadLimit(webSocketReadLimit)
+
+	welcome, err := readMessage(dialCtx, conn)
+	if err != nil {
+		conn.CloseNow()
+		return nil, nil, fmt.Errorf("error waiting for the welcome message: %w", err)
+	}
+	if welcome.Metadata.MessageType != messageSessionWelcome {
+		conn.CloseNow()
+		return nil, nil, fmt.Errorf("expected a welcome message, got %s", welcome.Metadata.MessageType)
+	}
+
+	return conn, welcome, nil
+}
+
+// readMessage reads the next message, the connection is closed when ctx is done first
+func readMessage(ctx context.Context, conn *websocket.Conn) (*webSocketMessage, error) {
+	_, data, err := conn.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var message webSocketMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, fmt.Errorf("error decoding the message: %w", err)
+	}
+	return &message, nil
+}
+
+// dispatch runs the handler of a notification in its own goroutine, once per message ID
+func (t *webSocketTransport) dispatch(ctx context.Context, message *webSocketMessage) {
+	if !t.seen.first(message.Metadata.MessageID) {
+		return
+	}
+
+	t.mu.Lock()
+	handler, ok := t.handling[message.Payload.Subscription.Type]
+	t.mu.Unlock()
+
+	// The handler outlives the session, like it outlives the request with webhooks
+	handlerCtx := logging.With(context.WithoutCancel(ctx),
+		slog.String("eventsub_message_id", message.Metadata.MessageID),
+		slog.String("eventsub_message_type", message.Metadata.MessageType),
+	)
+
+	if !ok {
+		logging.FromContext(handlerCtx).Warn("No handler for event", "event", message.Payload.Subscription.Type)
+		return
+	}
+
+	t.handlers.start(handlerCtx, handler, message.Payload.Event)
+}
+
+// CreateWebSocketSubscription subscribes the WebSocket session to the event.
+// The access token must belong to the user the condition is about, usually the broadcaster.
+func (a *API) CreateWebSocketSubscription(ctx context.Context, accessToken, sessionID, event string, condition twitchwh.Condition) error {
+	return a.CreateEventSubSubscription(ctx, accessToken, event, condition, EventSubTransport{Method: "websocket", SessionID: sessionID})
+}
@@ -0,0 +1,114 @@
+package util
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func newKey(t *testing.T, b byte) []byte {
+	t.Helper()
+	key, err := ParseEncryptionKey(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32)))
+	if err != nil {
+		t.Fatalf("error parsing the key: %v", err)
+	}
+	return key
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key := newKey(t, 1)
+
+	for _, plaintext := range []string{"", `{"name":"Ann","address":"Main St 1"}`, "a note\nwith a second line"} {
+		sealed, err := Encrypt(key, []byte(plaintext))
+		if err != nil {
+			t.Fatalf("error encrypting %q: %v", plaintext, err)
+		}
+		if plaintext != "" && bytes.Contains(sealed, []byte(plaintext)) {
+			t.Errorf("the ciphertext of %q contains the plaintext", plaintext)
+		}
+
+		opened, err := Decrypt(key, sealed)
+		if err != nil {
+			t.Fatalf("error decrypting %q: %v", plaintext, err)
+		}
+		if string(opened) != plaintext {
+			t.Errorf("got %q back, want %q", opened, plaintext)
+		}
+	}
+}
+
+func TestEncryptUsesNewNonce(t *testing.T) {
+	key := newKey(t, 1)
+
+	first, err := Encrypt(key, []byte(`{"name":"Ann","address":"Main St 1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Encrypt(key, []byte(`{"name":"Ann","address":"Main St 1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("encrypting the same data twice gave the same ciphertext")
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	key := newKey(t, 1)
+	sealed, err := Encrypt(key, []byte(`{"name":"Ann","address":"Main St 1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(i int) []byte {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 0x01
+		return tampered
+	}
+
+	tests := []struct {
+		name string
+		key  []byte
+		data []byte
+	}{
+		{"nonce changed", key, flip(0)},
+		{"ciphertext changed", key, flip(len(sealed) / 2)},
+		{"tag changed", key, flip(len(sealed) - 1)},
+		{"truncated", key, sealed[:len(sealed)-1]},
+		{"shorter than the nonce", key, sealed[:4]},
+		{"wrong key", newKey(t, 2), sealed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if opened, err := Decrypt(tt.key, tt.data); err == nil {
+				t.Fatalf("decrypted to %q, want an error", opened)
+			}
+		})
+	}
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr bool
+	}{
+		{"32 bytes", base64.StdEncoding.EncodeToString(make([]byte, 32)), false},
+		{"16 bytes", base64.StdEncoding.EncodeToString(make([]byte, 16)), true},
+		{"not base64", "not base64!", true},
+		{"empty", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseEncryptionKey(tt.encoded)
+			if tt.wantErr && err == nil {
+				t.Fatal("the key was accepted")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}